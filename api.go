@@ -1,16 +1,31 @@
 package dpcc
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
 	"errors"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
 
 	"github.com/si-co/dpcc/lib"
+	"github.com/si-co/dpcc/protocol"
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/eventlog"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+	"go.dedis.ch/protobuf"
 )
 
 // ServiceName is used for registration on the onet.
-const ServiceName = "DPPC"
+const ServiceName = lib.ServiceName
 
 // Client is a structure to communicate with the DWC
 // service
@@ -23,20 +38,62 @@ func NewClient() *Client {
 	return &Client{Client: onet.NewClient(cothority.Suite, ServiceName)}
 }
 
-// PublicHashRequest sends a request for a public hash protocol to the roster
-func (c *Client) PublicHashRequest(r *onet.Roster, URL string) (*HashPublicResponse, error) {
+// PublicHashOptions are the options of a public hash request
+type PublicHashOptions struct {
+	// limits for the download of the resource by the conodes, can be nil
+	Fetch *lib.FetchOptions
+	// asks for the signed versions of the resource and their diffs if the
+	// conodes disagree
+	Diff bool
+	// asks the leader to keep the content of the resource if the conodes
+	// agree on its SHA-256 hash
+	Archive bool
+	// asks the leader to keep the content of the style sheets and images
+	// of an HTML page as well, implies Archive
+	Subresources bool
+}
+
+// PublicHashRequest sends a request for a public hash protocol to the roster.
+// The conodes compute a digest for each of the given algorithms, or for the
+// default one if none is given. The options can be nil. The diff report is nil
+// if the conodes agree or the leader couldn't get their versions. The capture
+// is nil if the conodes disagreed or the resource changed before the leader
+// fetched it again.
+func (c *Client) PublicHashRequest(r *onet.Roster, URL string, algorithms []string, opts *PublicHashOptions) (*HashPublicResponse, error) {
+	if opts == nil {
+		opts = &PublicHashOptions{}
+	}
+	resp, err := c.publicHash(&HashPublicRequest{
+		Roster:       r,
+		URL:          URL,
+		Nonce:        lib.GenNonce(),
+		Algorithms:   algorithms,
+		Options:      opts.Fetch,
+		Diff:         opts.Diff,
+		Archive:      opts.Archive || opts.Subresources,
+		Subresources: opts.Subresources,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if resp.Capture != nil {
+		g := agreedResponse(resp, len(r.List)-1, lib.SHA256)
+		if g == nil || !bytes.Equal(g.Digest, resp.Capture.Digest) {
+			return nil, errors.New("the conodes didn't agree on the captured content")
+		}
+	}
+	return resp, nil
+}
+
+// publicHash sends a request for a public hash protocol to a random conode of
+// the roster and verifies the signatures of the response
+func (c *Client) publicHash(req *HashPublicRequest) (*HashPublicResponse, error) {
+	r, URL := req.Roster, req.URL
 	// verify the roster
 	if len(r.List) == 0 {
 		return nil, errors.New("got an empty roster list")
 	}
 
-	// prepare request for the leader
-	req := &HashPublicRequest{
-		Roster: r,
-		URL:    URL,
-		Nonce:  lib.GenNonce(),
-	}
-
 	// send request to a random conode in the roster, acting as the leader
 	// of the protocol
 	dst := r.RandomServerIdentity()
@@ -46,11 +103,44 @@ func (c *Client) PublicHashRequest(r *onet.Roster, URL string) (*HashPublicRespo
 	if err != nil {
 		return nil, err
 	}
+
+	// every conode other than the leader must have answered
+	if len(resp.Responses) != len(r.List)-1 {
+		return nil, errors.New("missing responses from conodes")
+	}
+
+	// verify that the digests have been signed by conodes of the roster
+	// for this request
+	for pk, v := range resp.Responses {
+		if v.PubliKey == nil || v.PubliKey.String() != pk || !inRoster(r, v.PubliKey) ||
+			v.PubliKey.Equal(lib.ServicePublic(dst)) {
+			return nil, errors.New("response from an unexpected conode")
+		}
+		st := statement(protocol.NameHashPublic, URL, req.Nonce, v.PubliKey, v.Timestamp)
+		st.Digests = v.Digests
+		if err := lib.VerifyStatement(st, v.Signature); err != nil {
+			return nil, errors.New("invalid signature of response of " + pk + ": " + err.Error())
+		}
+	}
+
+	// don't trust the verdict of the leader, compute it again from the
+	// verified digests
+	resp.Verdict = NewVerdict(resp.Responses)
+
+	// verify that the versions really come from the representatives
+	if resp.Diff != nil {
+		if err := verifyDiffReport(r, URL, resp.Diff, req.Nonce, resp.Responses); err != nil {
+			return nil, err
+		}
+	}
 	return resp, nil
 }
 
-// PrivateHashRequest sends a request for a private hash protocol to the roster
-func (c *Client) PrivateHashRequest(r *onet.Roster, URL string) (*HashPrivateResponse, error) {
+// PrivateHashRequest sends a request for a private hash protocol to the
+// roster. The conodes compute a digest for each of the given algorithms, or
+// for the default one if none is given. The download of the resource by the
+// conodes is bounded by opts, which can be nil.
+func (c *Client) PrivateHashRequest(r *onet.Roster, URL string, algorithms []string, opts *lib.FetchOptions) (*HashPrivateResponse, error) {
 	// verify the roster
 	if len(r.List) == 0 {
 		return nil, errors.New("got an empty roster list")
@@ -59,13 +149,19 @@ func (c *Client) PrivateHashRequest(r *onet.Roster, URL string) (*HashPrivateRes
 	// generate ephemeral keys. Note: this has to be done here on the
 	// client, because in this setting the leader is considered to be HBC
 	// and therefore we don't want him to be able to decrypt the hashes
-	privateKeys, publicKeys := lib.GenEphemeralKeys(r)
+	privateKeys, publicKeys, err := lib.GenEphemeralKeys(r)
+	if err != nil {
+		return nil, err
+	}
 
 	// prepare request for the leader
 	req := &HashPrivateRequest{
 		Roster:           r,
 		URL:              URL,
+		Nonce:            lib.GenNonce(),
 		ClientPublicKeys: publicKeys,
+		Algorithms:       algorithms,
+		Options:          opts,
 	}
 
 	// send request to a random conode in the roster, acting as the leader
@@ -73,34 +169,1200 @@ func (c *Client) PrivateHashRequest(r *onet.Roster, URL string) (*HashPrivateRes
 	dst := r.RandomServerIdentity()
 	log.Lvl4("sending message to leader", dst)
 	resp := &HashPrivateResponse{}
-	err := c.SendProtobuf(dst, req, resp)
+	err = c.SendProtobuf(dst, req, resp)
 	if err != nil {
 		return nil, err
 	}
 
-	// decrypt the received hashes
-	hashes := make(map[string][]byte)
+	// every conode other than the leader must have answered
+	if len(resp.Responses) != len(r.List)-1 {
+		return nil, errors.New("missing responses from conodes")
+	}
+
+	// verify and decrypt the received digests
+	digests := make(map[string]*HashPrivateDigests)
 	for pk, v := range resp.Responses {
-		// compute previsously shared key
-		pre := lib.DhExchange(privateKeys[pk], v.PublicKey)
-		// determine context for this AEAD scheme
-		ctx := lib.Context(publicKeys[pk], v.PublicKey)
-		// instantiate AEAD scheme (AES128-GCM)
-		gcm, err := lib.NewAEAD(cothority.Suite.Hash, pre, ctx)
+		// the response must be signed by the conode it is attributed to,
+		// for the nonce of this request
+		if v.PublicKey == nil || v.PublicKey.String() != pk || !inRoster(r, v.PublicKey) ||
+			v.PublicKey.Equal(lib.ServicePublic(dst)) {
+			return nil, errors.New("response from an unexpected conode")
+		}
+		st := statement(protocol.NameHashPrivate, URL, req.Nonce, v.PublicKey, v.Timestamp)
+		st.Flags = lib.StatementEncrypted
+		st.Payload = lib.PrivateResponsePayload(v.Encapsulation, v.EncryptedHash)
+		if err := lib.VerifyStatement(st, v.Signature); err != nil {
+			return nil, err
+		}
+
+		// decrypt with HPKE (lib.HPKESuite) and the ephemeral key
+		// generated for this conode
+		info, err := lib.PrivateResponseInfo(v.PublicKey)
 		if err != nil {
 			return nil, err
 		}
-		// encrypt hash with AES128-GCM
-		decrypted, err := gcm.Open(nil, v.Nonce, v.EncryptedHash, nil)
+		decrypted, err := lib.HPKEOpen(privateKeys[pk], v.Encapsulation, info,
+			req.Nonce, v.EncryptedHash)
 		if err != nil {
 			return nil, err
 		}
 
-		// store decrypted hash
-		hashes[pk] = decrypted
+		// parse and store decrypted digests
+		d, err := lib.DecodeDigests(decrypted)
+		if err != nil {
+			return nil, err
+		}
+		digests[pk] = &HashPrivateDigests{Digests: d}
 	}
 
-	// send decrypted hashes back to the app
-	resp.Hashes = hashes
+	// send decrypted digests back to the app
+	resp.Digests = digests
 	return resp, nil
 }
+
+// DOMConsensusRequest asks the roster for a consensus version of the HTML
+// page, containing only the nodes that threshold conodes observed, or two
+// thirds of the conodes if threshold is 0. The mode tells what the leader
+// learns of the versions of the conodes. The collective signature of the
+// document is verified before returning it.
+func (c *Client) DOMConsensusRequest(r *onet.Roster, URL string, threshold int, opts *lib.FetchOptions, mode ConsensusMode) (*DOMConsensusResponse, error) {
+	// verify the roster
+	if len(r.List) == 0 {
+		return nil, errors.New("got an empty roster list")
+	}
+	if !mode.Valid() {
+		return nil, errors.New("unknown consensus mode")
+	}
+
+	// prepare request for the leader
+	req := &DOMConsensusRequest{
+		Roster:    r,
+		URL:       URL,
+		Nonce:     lib.GenNonce(),
+		Threshold: threshold,
+		Options:   opts,
+		Mode:      mode,
+	}
+
+	// send request to a random conode in the roster, acting as the leader
+	// of the protocol
+	dst := r.RandomServerIdentity()
+	log.Lvl4("sending message to leader", dst)
+	resp := &DOMConsensusResponse{}
+	err := c.SendProtobuf(dst, req, resp)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := verifyConsensus(r, URL, req.Nonce, mode.Flags(), resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// verifyConsensus checks that the consensus document has been collectively
+// signed by distinct conodes of the roster, at least two thirds of them
+func verifyConsensus(r *onet.Roster, URL string, nonce []byte, flags uint32, resp *DOMConsensusResponse) error {
+	if len(resp.Signers) < lib.CosiThreshold(len(r.List)) {
+		return errors.New("consensus document signed by too few conodes")
+	}
+	signers := make(map[string]bool)
+	for _, pk := range resp.Signers {
+		if !inRoster(r, pk) {
+			return errors.New("consensus document signed by a conode outside the roster")
+		}
+		if signers[pk.String()] {
+			return errors.New("repeated signer of consensus document")
+		}
+		signers[pk.String()] = true
+	}
+	public, err := lib.CosiPublic(resp.Signers)
+	if err != nil {
+		return err
+	}
+	st := statement(protocol.NameDOMConsensus, URL, nonce, public, resp.Timestamp)
+	st.Flags = flags
+	st.Payload = resp.Document
+	msg, err := st.Encode()
+	if err != nil {
+		return err
+	}
+	return lib.CosiVerify(resp.Signers, msg, resp.Signature)
+}
+
+// verifyDiffReport checks that every version of the diff report has been
+// signed by a conode of the roster, and that its digest is the one the conode
+// signed in the verified responses
+func verifyDiffReport(r *onet.Roster, URL string, report *DiffReport, nonce []byte, responses map[string]*HashPublicSingleResponse) error {
+	for _, v := range report.Versions {
+		if v.PublicKey == nil || v.PublicKey.String() != v.Node || !inRoster(r, v.PublicKey) {
+			return errors.New("version signed by a conode outside the roster")
+		}
+		var signed []byte
+		if resp, ok := responses[v.Node]; ok {
+			signed = lib.FindDigest(resp.Digests, report.Algorithm)
+		}
+		if signed == nil || v.Digest == nil || v.Digest.Algorithm != report.Algorithm ||
+			!bytes.Equal(v.Digest.Value, signed) {
+			return errors.New("version of " + v.Node + " doesn't match its response")
+		}
+		st := statement(protocol.NameHashContent, URL, nonce, v.PublicKey, v.Timestamp)
+		st.Digests = []*lib.Digest{v.Digest}
+		st.Payload = lib.ContentPayload(v.Format, v.Content)
+		if err := lib.VerifyStatement(st, v.Signature); err != nil {
+			return errors.New("invalid signature of version of " + v.Node + ": " + err.Error())
+		}
+	}
+	return nil
+}
+
+// statement rebuilds the statement that a conode signed for a request of the
+// client, at the time given in its response
+func statement(protocol, URL string, nonce []byte, node kyber.Point, timestamp int64) *lib.Statement {
+	st := lib.NewStatement(protocol, URL, nonce, node)
+	st.Timestamp = timestamp
+	return st
+}
+
+// inRoster returns true if the public key is the service key of a conode of
+// the roster
+func inRoster(r *onet.Roster, pk kyber.Point) bool {
+	for _, si := range r.List {
+		if lib.ServicePublic(si).Equal(pk) {
+			return true
+		}
+	}
+	return false
+}
+
+// DKGRequest asks the roster for its distributed key, which is generated
+// with a threshold of conodes needed to decrypt, two thirds of the conodes
+// if threshold is 0, if the roster has none yet
+func (c *Client) DKGRequest(r *onet.Roster, threshold int) (*DKGResponse, error) {
+	// verify the roster
+	if len(r.List) == 0 {
+		return nil, errors.New("got an empty roster list")
+	}
+
+	// prepare request for the leader
+	req := &DKGRequest{
+		Roster:    r,
+		Threshold: threshold,
+	}
+
+	// send request to a random conode in the roster, acting as the leader
+	// of the protocol
+	dst := r.RandomServerIdentity()
+	log.Lvl4("sending message to leader", dst)
+	return c.dkg(dst, req)
+}
+
+// RefreshDKG asks the leader to renew the shares of the distributed key of
+// the roster with a threshold of conodes needed to decrypt, two thirds of the
+// conodes if threshold is 0. The distributed key stays the same. Every conode
+// takes part only if one of the requests is signed by its operator, see
+// SignRefreshDKG.
+func (c *Client) RefreshDKG(r *onet.Roster, leader *network.ServerIdentity, threshold int, operators []*lib.OperatorRequest) (*DKGResponse, error) {
+	req := &DKGRequest{
+		Roster:    r,
+		Threshold: threshold,
+		Refresh:   true,
+		Operators: operators,
+	}
+	return c.dkg(leader, req)
+}
+
+// SignRefreshDKG returns the requests of an operator to refresh the
+// distributed key of the roster with the threshold, one for every conode of
+// the roster
+func SignRefreshDKG(r *onet.Roster, threshold int, operator kyber.Scalar) ([]*lib.OperatorRequest, error) {
+	payload, err := DKGPayload(r, threshold)
+	if err != nil {
+		return nil, err
+	}
+	operators := make([]*lib.OperatorRequest, len(r.List))
+	for i, si := range r.List {
+		operators[i], err = lib.SignOperatorRequest(operator, lib.ServicePublic(si), ActionRefreshDKG, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return operators, nil
+}
+
+// dkg sends the request for the distributed key to the leader
+func (c *Client) dkg(dst *network.ServerIdentity, req *DKGRequest) (*DKGResponse, error) {
+	resp := &DKGResponse{}
+	if err := c.SendProtobuf(dst, req, resp); err != nil {
+		return nil, err
+	}
+	if resp.Public == nil {
+		return nil, errors.New("no distributed key in response")
+	}
+	return resp, nil
+}
+
+// DKGPayload identifies the roster and the threshold of a refresh of its
+// distributed key in the operator requests, two thirds of the conodes if
+// threshold is 0. The conodes see the roster with the leader first, so the
+// payload doesn't depend on the order of the roster.
+func DKGPayload(r *onet.Roster, threshold int) ([]byte, error) {
+	list := append([]*network.ServerIdentity{}, r.List...)
+	sort.Slice(list, func(i, j int) bool {
+		return lib.ServicePublic(list[i]).String() < lib.ServicePublic(list[j]).String()
+	})
+	payload, err := lib.RosterPayload(onet.NewRoster(list))
+	if err != nil {
+		return nil, err
+	}
+	if threshold == 0 {
+		threshold = lib.CosiThreshold(len(r.List))
+	}
+	return append(payload, []byte(strconv.Itoa(threshold))...), nil
+}
+
+// PrivateEqualityRequest asks the roster whether its conodes see the same
+// version of the resource. The digests are compared under encryption with the
+// distributed key of the roster, so that only the groups of conodes that
+// agree with each other are revealed.
+func (c *Client) PrivateEqualityRequest(r *onet.Roster, URL string, algorithm string, opts *lib.FetchOptions) (*HashEqualityResponse, error) {
+	// verify the roster
+	if len(r.List) == 0 {
+		return nil, errors.New("got an empty roster list")
+	}
+
+	// prepare request for the leader
+	req := &HashEqualityRequest{
+		Roster:    r,
+		URL:       URL,
+		Nonce:     lib.GenNonce(),
+		Algorithm: algorithm,
+		Options:   opts,
+	}
+
+	// send request to a random conode in the roster, acting as the leader
+	// of the protocol
+	dst := r.RandomServerIdentity()
+	log.Lvl4("sending message to leader", dst)
+	resp := &HashEqualityResponse{}
+	if err := c.SendProtobuf(dst, req, resp); err != nil {
+		return nil, err
+	}
+
+	if err := verifyEquality(r, dst, req.Nonce, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// verifyEquality recomputes the groups of conodes from the transcript of the
+// equality test: the ciphertexts signed by every conode other than the
+// leader, the proven blindings of every conode and enough proven partial
+// decryptions with the shares of the distributed key signed by the roster
+func verifyEquality(r *onet.Roster, leader *network.ServerIdentity, nonce []byte, resp *HashEqualityResponse) error {
+	if err := lib.VerifyDistKey(r, resp.Commits, resp.KeySignatures); err != nil {
+		return err
+	}
+
+	conodes := []kyber.Point{}
+	for _, si := range r.List {
+		if !si.Equal(leader) {
+			conodes = append(conodes, lib.ServicePublic(si))
+		}
+	}
+	if err := lib.VerifySignedCiphertexts(protocol.NameHashEquality, nonce,
+		conodes, resp.Inputs); err != nil {
+		return err
+	}
+	inputs := lib.SortSignedCiphertexts(resp.Inputs)
+	nodes := make([]string, len(inputs))
+	cts := make([]*lib.ElGamalCiphertext, len(inputs))
+	for i, input := range inputs {
+		nodes[i] = input.PublicKey.String()
+		cts[i] = input.Ciphertext
+	}
+	differences := lib.EqualityDifferences(cts)
+
+	members := make(map[string]bool)
+	for _, p := range lib.ServicePublics(r) {
+		members[p.String()] = true
+	}
+	blinders := make(map[string]bool)
+	for _, b := range resp.Blindings {
+		if b == nil || b.PublicKey == nil || !members[b.PublicKey.String()] ||
+			blinders[b.PublicKey.String()] {
+			return errors.New("blinding of an unexpected conode")
+		}
+		blinders[b.PublicKey.String()] = true
+		if err := b.Verify(differences); err != nil {
+			return err
+		}
+	}
+	if len(blinders) != len(r.List) {
+		return errors.New("not one blinding per conode")
+	}
+	sums, err := lib.SumBlindings(resp.Blindings)
+	if err != nil {
+		return err
+	}
+
+	decrypters := make(map[string]bool)
+	indexes := make(map[int]bool)
+	for _, p := range resp.Partials {
+		if p == nil || p.PublicKey == nil || !members[p.PublicKey.String()] ||
+			decrypters[p.PublicKey.String()] || indexes[p.Index] || p.Index >= len(r.List) {
+			return errors.New("partial decryption of an unexpected conode")
+		}
+		decrypters[p.PublicKey.String()] = true
+		indexes[p.Index] = true
+		if err := p.Verify(resp.Commits, sums); err != nil {
+			return err
+		}
+	}
+	equal, err := lib.EqualityResults(sums, resp.Partials, len(resp.Commits), len(r.List))
+	if err != nil {
+		return err
+	}
+	groups, err := lib.EqualityGroups(nodes, equal)
+	if err != nil {
+		return err
+	}
+
+	if resp.Nodes != len(nodes) || len(resp.Groups) != len(groups) {
+		return errors.New("agreement groups do not match the transcript")
+	}
+	for i, g := range groups {
+		if resp.Groups[i] == nil || len(resp.Groups[i].Nodes) != len(g) {
+			return errors.New("agreement groups do not match the transcript")
+		}
+		for j, pk := range g {
+			if resp.Groups[i].Nodes[j] != pk {
+				return errors.New("agreement groups do not match the transcript")
+			}
+		}
+	}
+	return nil
+}
+
+// ShuffledHashRequest asks the roster for the digests of the resource
+// without learning which conode computed which digest: the encrypted digests
+// are shuffled by every conode before being decrypted. The shuffles are
+// verified, and the digests are truncated as by lib.TruncateDigest.
+func (c *Client) ShuffledHashRequest(r *onet.Roster, URL string, algorithm string, opts *lib.FetchOptions) (*HashShuffleResponse, error) {
+	// verify the roster
+	if len(r.List) < 3 {
+		return nil, errors.New("at least three conodes are needed to shuffle")
+	}
+
+	// prepare request for the leader
+	req := &HashShuffleRequest{
+		Roster:    r,
+		URL:       URL,
+		Nonce:     lib.GenNonce(),
+		Algorithm: algorithm,
+		Options:   opts,
+	}
+
+	// send request to a random conode in the roster, acting as the leader
+	// of the protocol
+	dst := r.RandomServerIdentity()
+	log.Lvl4("sending message to leader", dst)
+	resp := &HashShuffleResponse{}
+	if err := c.SendProtobuf(dst, req, resp); err != nil {
+		return nil, err
+	}
+
+	if err := verifyShuffle(r, dst, req.Nonce, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// verifyShuffle checks the transcript of the shuffle: the digests of every
+// conode other than the leader encrypted under the distributed key signed by
+// the roster, the signed shuffles of every conode and enough proven partial
+// decryptions of the output of the last shuffle to recover the digests
+func verifyShuffle(r *onet.Roster, leader *network.ServerIdentity, nonce []byte, resp *HashShuffleResponse) error {
+	if err := lib.VerifyDistKey(r, resp.Commits, resp.KeySignatures); err != nil {
+		return err
+	}
+	if resp.DistKey == nil || !resp.DistKey.Equal(resp.Commits[0]) {
+		return errors.New("shuffle under another key than the distributed key")
+	}
+
+	// every conode of the roster shuffled the digests of the conodes
+	// other than the leader
+	conodes := []kyber.Point{}
+	for _, si := range r.List {
+		if !si.Equal(leader) {
+			conodes = append(conodes, lib.ServicePublic(si))
+		}
+	}
+	if err := lib.VerifySignedCiphertexts(protocol.NameHashShuffle, nonce,
+		conodes, resp.Inputs); err != nil {
+		return err
+	}
+	if len(resp.Steps) != len(r.List) {
+		return errors.New("incomplete shuffle")
+	}
+	shufflers := make(map[string]bool)
+	for _, s := range resp.Steps {
+		if s == nil || s.PublicKey == nil || !inRoster(r, s.PublicKey) || shufflers[s.PublicKey.String()] {
+			return errors.New("shuffle by an unexpected conode")
+		}
+		shufflers[s.PublicKey.String()] = true
+	}
+	cts := make([]*lib.ElGamalCiphertext, len(resp.Inputs))
+	for i, input := range resp.Inputs {
+		cts[i] = input.Ciphertext
+	}
+	if err := lib.VerifyShuffles(resp.DistKey, nonce, cts, resp.Steps); err != nil {
+		return err
+	}
+
+	// the digests are the decryption of the output of the last shuffle
+	output := resp.Steps[len(resp.Steps)-1].Ciphertexts
+	decrypters := make(map[string]bool)
+	indexes := make(map[int]bool)
+	for _, p := range resp.Partials {
+		if p == nil || p.PublicKey == nil || !inRoster(r, p.PublicKey) ||
+			decrypters[p.PublicKey.String()] || indexes[p.Index] || p.Index >= len(r.List) {
+			return errors.New("partial decryption of an unexpected conode")
+		}
+		decrypters[p.PublicKey.String()] = true
+		indexes[p.Index] = true
+		if err := p.Verify(resp.Commits, output); err != nil {
+			return err
+		}
+	}
+	digests, err := lib.DecryptDigests(output, resp.Partials, len(resp.Commits), len(r.List))
+	if err != nil {
+		return err
+	}
+	if len(digests) != len(resp.Digests) {
+		return errors.New("digests do not match the shuffle")
+	}
+	for i, d := range digests {
+		if !bytes.Equal(d, resp.Digests[i]) {
+			return errors.New("digests do not match the shuffle")
+		}
+	}
+	return nil
+}
+
+// History lists the runs for the URL led by the conode si, in chronological
+// order. The runs are bounded by since and until if they are not zero, and
+// only the limit most recent ones are kept if limit is not 0.
+func (c *Client) History(si *network.ServerIdentity, URL string, since, until time.Time, limit int) (*HistoryResponse, error) {
+	req := &HistoryRequest{
+		URL:   URL,
+		Since: unixTime(since),
+		Until: unixTime(until),
+		Limit: limit,
+	}
+	resp := &HistoryResponse{}
+	if err := c.SendProtobuf(si, req, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+// HistoryRun fetches a run led by the conode si and verifies the signatures
+// of the conodes of the roster in it
+func (c *Client) HistoryRun(r *onet.Roster, si *network.ServerIdentity, ID string) (*Run, error) {
+	resp := &HistoryRunResponse{}
+	if err := c.SendProtobuf(si, &HistoryRunRequest{ID: ID}, resp); err != nil {
+		return nil, err
+	}
+	if resp.Run == nil || resp.Run.ID != ID {
+		return nil, errors.New("conode sent another run")
+	}
+	if err := VerifyRun(r, resp.Run); err != nil {
+		return nil, err
+	}
+	return resp.Run, nil
+}
+
+// AgreedHash asks the conode si for the last digest of the URL, computed with
+// the algorithm or lib.DefaultAlgorithm if empty, on which the conodes agreed
+// at the given time, or now if it is zero. The agreement is checked against
+// the signatures of the conodes of the roster.
+func (c *Client) AgreedHash(r *onet.Roster, si *network.ServerIdentity, URL string, at time.Time, algorithm string) (*AgreedHashResponse, error) {
+	req := &AgreedHashRequest{
+		URL:       URL,
+		Time:      unixTime(at),
+		Algorithm: algorithm,
+	}
+	resp := &AgreedHashResponse{}
+	if err := c.SendProtobuf(si, req, resp); err != nil {
+		return nil, err
+	}
+
+	// the run must be a hash public run for the URL
+	run := resp.Run
+	if run == nil || run.URL != URL || run.Mode != protocol.NameHashPublic {
+		return nil, errors.New("conode sent an unexpected run")
+	}
+	if err := VerifyRun(r, run); err != nil {
+		return nil, err
+	}
+
+	// the agreement is computed again from the signed digests, and the run
+	// must have been signed before the time
+	group := AgreedGroup(run, len(r.List)-1, algorithm)
+	if group == nil || resp.Group == nil || group.Algorithm != resp.Group.Algorithm ||
+		!bytes.Equal(group.Digest, resp.Group.Digest) {
+		return nil, errors.New("digest not agreed by the conodes")
+	}
+	if req.Time != 0 && AgreedTime(run, group) > req.Time {
+		return nil, errors.New("conode sent a run signed after the time")
+	}
+	resp.Group = group
+	return resp, nil
+}
+
+// WatchAdd asks the conode si to run the hash public protocol for the URL
+// with the roster on the given schedule, see lib.ParseSchedule, and to
+// record the changes of the verdict of the conodes. The request is signed
+// with the key of the operator of the conode.
+func (c *Client) WatchAdd(si *network.ServerIdentity, r *onet.Roster, URL, schedule string, algorithms []string, opts *lib.FetchOptions, operator kyber.Scalar) (*Watch, error) {
+	// check the schedule before sending it
+	if _, err := lib.ParseSchedule(schedule); err != nil {
+		return nil, err
+	}
+	req := &WatchAddRequest{
+		Roster:     r,
+		URL:        URL,
+		Schedule:   schedule,
+		Algorithms: algorithms,
+		Options:    opts,
+	}
+	payload, err := WatchAddPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	req.Operator, err = lib.SignOperatorRequest(operator, lib.ServicePublic(si), ActionWatchAdd, payload)
+	if err != nil {
+		return nil, err
+	}
+	resp := &WatchAddResponse{}
+	if err := c.SendProtobuf(si, req, resp); err != nil {
+		return nil, err
+	}
+	if resp.Watch == nil {
+		return nil, errors.New("conode sent no watch")
+	}
+	return resp.Watch, nil
+}
+
+// WatchAddPayload identifies the watch added by a request in the operator
+// requests: the hash of the request without the signature
+func WatchAddPayload(req *WatchAddRequest) ([]byte, error) {
+	unsigned := *req
+	unsigned.Operator = nil
+	buf, err := protobuf.Encode(&unsigned)
+	if err != nil {
+		return nil, err
+	}
+	h := sha256.Sum256(buf)
+	return h[:], nil
+}
+
+// WatchList lists the watches of the conode si
+func (c *Client) WatchList(si *network.ServerIdentity) ([]*Watch, error) {
+	resp := &WatchListResponse{}
+	if err := c.SendProtobuf(si, &WatchListRequest{}, resp); err != nil {
+		return nil, err
+	}
+	return resp.Watches, nil
+}
+
+// WatchRemove removes a watch of the conode si, with a request signed by
+// the owner of the watch or the operator of the conode
+func (c *Client) WatchRemove(si *network.ServerIdentity, ID string, operator kyber.Scalar) error {
+	o, err := lib.SignOperatorRequest(operator, lib.ServicePublic(si), ActionWatchRemove, []byte(ID))
+	if err != nil {
+		return err
+	}
+	return c.SendProtobuf(si, &WatchRemoveRequest{ID: ID, Operator: o}, &WatchRemoveResponse{})
+}
+
+// WatchEvents lists the events of the watch of the conode si with the given
+// ID, or of all its watches if ID is empty, since the given time if it is not
+// zero
+func (c *Client) WatchEvents(si *network.ServerIdentity, ID string, since time.Time) ([]*WatchEvent, error) {
+	resp := &WatchEventsResponse{}
+	req := &WatchEventsRequest{WatchID: ID, Since: unixTime(since)}
+	if err := c.SendProtobuf(si, req, resp); err != nil {
+		return nil, err
+	}
+	return resp.Events, nil
+}
+
+// Subscribe streams the new events of the watches of the conode si, only
+// those of the watch with the given ID or about the URL if they are not
+// empty. The events are sent on the returned channel, which is closed when
+// the stream ends; the error that ended it, if any, is then sent on the error
+// channel. Calling stop ends the stream.
+func (c *Client) Subscribe(si *network.ServerIdentity, ID, URL string) (events <-chan *WatchEvent, errs <-chan error, stop func(), err error) {
+	conn, err := c.Stream(si, &SubscribeRequest{WatchID: ID, URL: URL})
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	out := make(chan *WatchEvent)
+	errOut := make(chan error, 1)
+	done := make(chan struct{})
+	var once sync.Once
+	stop = func() {
+		once.Do(func() {
+			close(done)
+			conn.Close()
+		})
+	}
+
+	go func() {
+		defer close(out)
+		for {
+			resp := &SubscribeResponse{}
+			if err := conn.ReadMessage(resp); err != nil {
+				select {
+				case <-done:
+				default:
+					errOut <- err
+				}
+				return
+			}
+			if resp.Event == nil {
+				continue
+			}
+			select {
+			case out <- resp.Event:
+			case <-done:
+				return
+			}
+		}
+	}()
+	return out, errOut, stop, nil
+}
+
+// VerifyRun checks the signatures of the statements of a run of the history
+// against the service keys of the conodes of the roster. The leader of the
+// run is trusted to keep the responses of all the conodes.
+func VerifyRun(r *onet.Roster, run *Run) error {
+	switch run.Mode {
+	case protocol.NameHashPublic, protocol.NameHashPrivate:
+		for pk, v := range run.Responses {
+			if v.PublicKey == nil || v.PublicKey.String() != pk || !inRoster(r, v.PublicKey) {
+				return errors.New("response from an unexpected conode")
+			}
+			st := statement(run.Mode, run.URL, run.Nonce, v.PublicKey, v.Timestamp)
+			st.Flags = run.Flags
+			if run.Mode == protocol.NameHashPublic {
+				st.Digests = v.Digests
+			} else {
+				st.Payload = lib.PrivateResponsePayload(v.Encapsulation, v.EncryptedHash)
+			}
+			if err := lib.VerifyStatement(st, v.Signature); err != nil {
+				return errors.New("invalid signature of response of " + pk + ": " + err.Error())
+			}
+		}
+		return nil
+	case protocol.NameDOMConsensus:
+		return verifyConsensus(r, run.URL, run.Nonce, run.Flags, &DOMConsensusResponse{
+			Document:  run.Document,
+			Signers:   run.Signers,
+			Timestamp: run.Timestamp,
+			Signature: run.Signature,
+		})
+	}
+	return errors.New("unknown mode of run: " + run.Mode)
+}
+
+// AgreedGroup returns the largest group of conodes of a hash public run that
+// signed the same digest for the algorithm, or lib.DefaultAlgorithm if
+// empty. It returns nil if the group does not gather at least two thirds of
+// the nodes conodes asked by the leader, so that a leader cannot reach the
+// threshold by dropping the responses of the conodes that disagree.
+func AgreedGroup(run *Run, nodes int, algorithm string) *DigestGroup {
+	if algorithm == "" {
+		algorithm = lib.DefaultAlgorithm
+	}
+	if run.Mode != protocol.NameHashPublic {
+		return nil
+	}
+	if nodes < len(run.Responses) {
+		nodes = len(run.Responses)
+	}
+
+	// iterate over the conodes always in the same order
+	pks := make([]string, 0, len(run.Responses))
+	for pk := range run.Responses {
+		pks = append(pks, pk)
+	}
+	sort.Strings(pks)
+
+	groups := make(map[string]*DigestGroup)
+	var best *DigestGroup
+	for _, pk := range pks {
+		d := lib.FindDigest(run.Responses[pk].Digests, algorithm)
+		if d == nil {
+			continue
+		}
+		g, ok := groups[string(d)]
+		if !ok {
+			g = &DigestGroup{Algorithm: algorithm, Digest: d}
+			groups[string(d)] = g
+		}
+		g.Nodes = append(g.Nodes, pk)
+		if best == nil || len(g.Nodes) > len(best.Nodes) ||
+			(len(g.Nodes) == len(best.Nodes) && bytes.Compare(g.Digest, best.Digest) < 0) {
+			best = g
+		}
+	}
+	if best == nil || 3*len(best.Nodes) < 2*nodes {
+		return nil
+	}
+	return best
+}
+
+// AgreedTime returns the time of a run as signed by the conodes: the median
+// of the timestamps of the statements of the group that agreed on the digest
+// of a hash public run, so that less than half of the group cannot move it,
+// or the collectively signed timestamp of a DOM consensus run. The time of
+// the run recorded by the leader is not signed and only used for the other
+// runs.
+func AgreedTime(run *Run, g *DigestGroup) int64 {
+	switch {
+	case run.Mode == protocol.NameDOMConsensus:
+		return run.Timestamp
+	case run.Mode == protocol.NameHashPublic && g != nil && len(g.Nodes) > 0:
+		ts := []int64{}
+		for _, pk := range g.Nodes {
+			if v, ok := run.Responses[pk]; ok {
+				ts = append(ts, v.Timestamp)
+			}
+		}
+		if len(ts) == 0 {
+			return run.Time
+		}
+		sort.Slice(ts, func(i, j int) bool { return ts[i] < ts[j] })
+		return ts[len(ts)/2]
+	}
+	return run.Time
+}
+
+// agreedResponse returns the group of conodes of a verified hash public
+// response that agreed on a digest computed with the algorithm, or nil
+func agreedResponse(resp *HashPublicResponse, nodes int, algorithm string) *DigestGroup {
+	run := &Run{Mode: protocol.NameHashPublic, Responses: make(map[string]*RunResponse)}
+	for pk, v := range resp.Responses {
+		run.Responses[pk] = &RunResponse{PublicKey: v.PubliKey, Digests: v.Digests}
+	}
+	return AgreedGroup(run, nodes, algorithm)
+}
+
+// Blob fetches an archived content from the conode si chunk by chunk and
+// checks it against its SHA-256 digest
+func (c *Client) Blob(si *network.ServerIdentity, digest []byte) ([]byte, error) {
+	var data []byte
+	size := int64(-1)
+	for size < 0 || int64(len(data)) < size {
+		resp := &BlobResponse{}
+		req := &BlobRequest{Digest: digest, Offset: int64(len(data))}
+		if err := c.SendProtobuf(si, req, resp); err != nil {
+			return nil, err
+		}
+		if size >= 0 && resp.Size != size {
+			return nil, errors.New("the size of the content changed")
+		}
+		size = resp.Size
+		if (len(resp.Data) == 0 && int64(len(data)) < size) ||
+			int64(len(data)+len(resp.Data)) > size {
+			return nil, errors.New("conode sent an invalid chunk")
+		}
+		data = append(data, resp.Data...)
+	}
+	h := sha256.Sum256(data)
+	if !bytes.Equal(h[:], digest) {
+		return nil, errors.New("the content doesn't match its digest")
+	}
+	return data, nil
+}
+
+// Capture fetches an archived run led by the conode si and the content of its
+// resource, which is checked against the SHA-256 hash the conodes of the
+// roster agreed on
+func (c *Client) Capture(r *onet.Roster, si *network.ServerIdentity, ID string) (*Run, []byte, error) {
+	run, err := c.HistoryRun(r, si, ID)
+	if err != nil {
+		return nil, nil, err
+	}
+	if run.Capture == nil {
+		return nil, nil, errors.New("run " + ID + " has not been archived")
+	}
+	g := AgreedGroup(run, len(r.List)-1, lib.SHA256)
+	if g == nil || !bytes.Equal(g.Digest, run.Capture.Digest) {
+		return nil, nil, errors.New("the conodes didn't agree on the captured content")
+	}
+	data, err := c.Blob(si, run.Capture.Digest)
+	if err != nil {
+		return nil, nil, err
+	}
+	return run, data, nil
+}
+
+// WARC exports archived runs led by the conode si to a WARC file, either the
+// run with the ID or all the archived runs of the URL. The runs are fetched
+// page by page, and every capture is verified against the signatures of the
+// conodes of the roster before it is written.
+func (c *Client) WARC(r *onet.Roster, si *network.ServerIdentity, ID, URL string, w *lib.WARCWriter) error {
+	seen := make(map[string]bool)
+	after := ""
+	for {
+		resp := &WARCResponse{}
+		if err := c.SendProtobuf(si, &WARCRequest{ID: ID, URL: URL, After: after}, resp); err != nil {
+			return err
+		}
+		captures, err := ReadWARC(bytes.NewReader(resp.Data))
+		if err != nil {
+			return err
+		}
+		if len(captures) == 0 && (len(seen) == 0 || resp.Next != "") {
+			return errors.New("conode sent no capture")
+		}
+		first := len(seen) == 0
+		for _, capture := range captures {
+			if (ID != "" && capture.Run.ID != ID) || (ID == "" && capture.Run.URL != URL) {
+				return errors.New("conode sent another run")
+			}
+			if seen[capture.Run.ID] {
+				return errors.New("conode sent run " + capture.Run.ID + " twice")
+			}
+			seen[capture.Run.ID] = true
+			if err := VerifyCapture(r, capture); err != nil {
+				return err
+			}
+		}
+		if err := CopyWARC(w, bytes.NewReader(resp.Data), first); err != nil {
+			return err
+		}
+		if resp.Next == "" {
+			return nil
+		}
+		if resp.Next != captures[len(captures)-1].Run.ID {
+			return errors.New("conode sent an invalid next page")
+		}
+		after = resp.Next
+	}
+}
+
+// NewLogEntry returns the entry appended to the tamper-evident log for a run,
+// or nil if the nodes conodes asked didn't agree on a digest computed with
+// the algorithm. Only hash public and DOM consensus runs are collectively
+// agreed.
+func NewLogEntry(run *Run, nodes int, algorithm string) *LogEntry {
+	e := &LogEntry{
+		RunID: run.ID,
+		URL:   run.URL,
+		Mode:  run.Mode,
+		Time:  run.Time,
+		Nonce: run.Nonce,
+	}
+	switch run.Mode {
+	case protocol.NameHashPublic:
+		g := AgreedGroup(run, nodes, algorithm)
+		if g == nil {
+			return nil
+		}
+		e.Algorithm = g.Algorithm
+		e.Digest = g.Digest
+		e.Responses = make(map[string]*RunResponse)
+		for _, pk := range g.Nodes {
+			e.Responses[pk] = run.Responses[pk]
+		}
+	case protocol.NameDOMConsensus:
+		h := sha256.Sum256(run.Document)
+		e.Algorithm = lib.SHA256
+		e.Digest = h[:]
+		e.Signers = run.Signers
+		e.Flags = run.Flags
+		e.Timestamp = run.Timestamp
+		e.Signature = run.Signature
+	default:
+		return nil
+	}
+	return e
+}
+
+// Encode returns the content of the event of the entry in the eventlog
+func (e *LogEntry) Encode() (string, error) {
+	buf, err := network.Marshal(e)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(buf), nil
+}
+
+// DecodeLogEntry decodes the content of an event of the eventlog
+func DecodeLogEntry(content string) (*LogEntry, error) {
+	buf, err := base64.StdEncoding.DecodeString(content)
+	if err != nil {
+		return nil, err
+	}
+	_, msg, err := network.Unmarshal(buf, cothority.Suite)
+	if err != nil {
+		return nil, err
+	}
+	e, ok := msg.(*LogEntry)
+	if !ok {
+		return nil, errors.New("event is not a log entry")
+	}
+	return e, nil
+}
+
+// VerifyLogEntry checks that the digest of the entry has been signed by
+// conodes of the roster. The consensus document is needed to verify the
+// collective signature of DOM consensus entries.
+func VerifyLogEntry(r *onet.Roster, e *LogEntry, document []byte) error {
+	switch e.Mode {
+	case protocol.NameHashPublic:
+		if len(e.Responses) == 0 {
+			return errors.New("log entry without responses")
+		}
+		for pk, v := range e.Responses {
+			if !bytes.Equal(lib.FindDigest(v.Digests, e.Algorithm), e.Digest) {
+				return errors.New("conode " + pk + " didn't sign the digest of the log entry")
+			}
+		}
+		return VerifyRun(r, &Run{
+			URL:       e.URL,
+			Mode:      e.Mode,
+			Nonce:     e.Nonce,
+			Responses: e.Responses,
+		})
+	case protocol.NameDOMConsensus:
+		h := sha256.Sum256(document)
+		if !bytes.Equal(h[:], e.Digest) {
+			return errors.New("consensus document doesn't match the log entry")
+		}
+		return verifyConsensus(r, e.URL, e.Nonce, e.Flags, &DOMConsensusResponse{
+			Document:  document,
+			Signers:   e.Signers,
+			Timestamp: e.Timestamp,
+			Signature: e.Signature,
+		})
+	}
+	return errors.New("unknown mode of log entry: " + e.Mode)
+}
+
+// VerifyLogProof checks that the proof shows the event with the given key in
+// the ledger, and returns the log entry of the event. It doesn't verify the
+// signatures of the entry.
+func VerifyLogProof(ledger skipchain.SkipBlockID, key []byte, proof *byzcoin.Proof) (*LogEntry, error) {
+	if proof == nil {
+		return nil, errors.New("missing proof")
+	}
+	if err := proof.Verify(ledger); err != nil {
+		return nil, errors.New("invalid proof: " + err.Error())
+	}
+	if !proof.InclusionProof.Match(key) {
+		return nil, errors.New("the event is not in the ledger")
+	}
+	_, value, _, _, err := proof.KeyValue()
+	if err != nil {
+		return nil, err
+	}
+	var ev eventlog.Event
+	if err := protobuf.Decode(value, &ev); err != nil {
+		return nil, err
+	}
+	if ev.Topic != LogTopic {
+		return nil, errors.New("event of another topic: " + ev.Topic)
+	}
+	return DecodeLogEntry(ev.Content)
+}
+
+// LogProof fetches a run led by the conode si with the proof that its result
+// is in the tamper-evident log of the ledger. The proof is verified against
+// the ID of the ledger, and the entry against the run and the signatures of
+// the conodes of the roster, so that neither the database of the conode nor
+// the conode itself has to be trusted.
+func (c *Client) LogProof(r *onet.Roster, si *network.ServerIdentity, ledger skipchain.SkipBlockID, ID string) (*Run, *LogEntry, error) {
+	resp := &LogProofResponse{}
+	if err := c.SendProtobuf(si, &LogProofRequest{ID: ID}, resp); err != nil {
+		return nil, nil, err
+	}
+	run := resp.Run
+	if run == nil || run.ID != ID {
+		return nil, nil, errors.New("conode sent another run")
+	}
+	if err := VerifyRun(r, run); err != nil {
+		return nil, nil, err
+	}
+	e, err := VerifyLogProof(ledger, run.LogID, resp.Proof)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := VerifyLogEntry(r, e, run.Document); err != nil {
+		return nil, nil, err
+	}
+	expected := NewLogEntry(run, len(r.List)-1, e.Algorithm)
+	if expected == nil || e.RunID != run.ID || e.URL != run.URL || e.Mode != run.Mode ||
+		e.Time != run.Time || !bytes.Equal(e.Digest, expected.Digest) {
+		return nil, nil, errors.New("the log entry doesn't match the run")
+	}
+	return run, e, nil
+}
+
+// RunLeaf returns the hash of the leaf recording a run in the transparency
+// log of its leader
+func RunLeaf(run *Run) []byte {
+	return lib.LeafHash(RunLeafData(run))
+}
+
+// RunLeafData returns the data of the leaf recording a run in the
+// transparency log of its leader. The leaf holds the identity of the run and
+// the signatures of the conodes, which cover what they attested.
+func RunLeafData(run *Run) []byte {
+	var t, flags, n [8]byte
+	binary.BigEndian.PutUint64(t[:], uint64(run.Time))
+	binary.BigEndian.PutUint64(flags[:], uint64(run.Flags))
+	binary.BigEndian.PutUint64(n[:], uint64(len(run.Responses)))
+	fields := [][]byte{[]byte(run.ID), []byte(run.URL), []byte(run.Mode), t[:],
+		run.Nonce, flags[:], n[:]}
+
+	nodes := make([]string, 0, len(run.Responses))
+	for pk := range run.Responses {
+		nodes = append(nodes, pk)
+	}
+	sort.Strings(nodes)
+	for _, pk := range nodes {
+		fields = append(fields, []byte(pk), run.Responses[pk].Signature)
+	}
+
+	var document []byte
+	if run.Document != nil {
+		h := sha256.Sum256(run.Document)
+		document = h[:]
+	}
+	fields = append(fields, document, run.Signature)
+	return lib.EncodeLeaf(fields...)
+}
+
+// LeafRun returns the run recorded by the data of a leaf, with its identity
+// and signature only: the responses of the conodes are not in the leaf
+func LeafRun(data []byte) (*Run, error) {
+	fields, err := lib.DecodeLeaf(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(fields) < 9 || len(fields[3]) != 8 || len(fields[5]) != 8 || len(fields[6]) != 8 {
+		return nil, errors.New("invalid leaf of run")
+	}
+	n := binary.BigEndian.Uint64(fields[6])
+	if n > uint64(len(fields)) || uint64(len(fields)) != 9+2*n {
+		return nil, errors.New("invalid leaf of run")
+	}
+	return &Run{
+		ID:        string(fields[0]),
+		URL:       string(fields[1]),
+		Mode:      string(fields[2]),
+		Time:      int64(binary.BigEndian.Uint64(fields[3])),
+		Nonce:     fields[4],
+		Flags:     uint32(binary.BigEndian.Uint64(fields[5])),
+		Signature: fields[len(fields)-1],
+	}, nil
+}
+
+// verifyTreeHead checks that the tree head has been signed by the conode si
+func verifyTreeHead(si *network.ServerIdentity, h *lib.TreeHead) error {
+	if h == nil || h.NodeKey == nil || !h.NodeKey.Equal(lib.ServicePublic(si)) {
+		return errors.New("tree head of another conode")
+	}
+	return lib.VerifyTreeHead(h)
+}
+
+// TreeHead fetches the current head of the transparency log of the conode si
+// and verifies its signature
+func (c *Client) TreeHead(si *network.ServerIdentity) (*lib.TreeHead, error) {
+	resp := &TreeHeadResponse{}
+	if err := c.SendProtobuf(si, &TreeHeadRequest{}, resp); err != nil {
+		return nil, err
+	}
+	if err := verifyTreeHead(si, resp.Head); err != nil {
+		return nil, err
+	}
+	return resp.Head, nil
+}
+
+// InclusionProof fetches a run led by the conode si with the proof that it is
+// in the transparency log of the conode, in the tree of the given size or the
+// current one if 0. It returns the run, verified against the signatures of
+// the conodes of the roster, and the signed head the proof leads to. A run
+// pruned from the history of the conode is rebuilt from its leaf, without
+// the responses of the conodes.
+func (c *Client) InclusionProof(r *onet.Roster, si *network.ServerIdentity, ID string, size uint64) (*Run, *lib.TreeHead, error) {
+	resp := &InclusionProofResponse{}
+	if err := c.SendProtobuf(si, &InclusionProofRequest{ID: ID, Size: size}, resp); err != nil {
+		return nil, nil, err
+	}
+	h := resp.Head
+	if err := verifyTreeHead(si, h); err != nil {
+		return nil, nil, err
+	}
+	if size != 0 && h.Size != size {
+		return nil, nil, errors.New("conode sent a tree head of another size")
+	}
+	if err := lib.VerifyInclusion(resp.Index, h.Size, lib.LeafHash(resp.Leaf), resp.Proof, h.Root); err != nil {
+		return nil, nil, err
+	}
+
+	run := resp.Run
+	if run == nil {
+		var err error
+		if run, err = LeafRun(resp.Leaf); err != nil {
+			return nil, nil, err
+		}
+		run.LeafIndex = resp.Index
+	} else {
+		if err := VerifyRun(r, run); err != nil {
+			return nil, nil, err
+		}
+		if run.LeafIndex != resp.Index || !bytes.Equal(RunLeafData(run), resp.Leaf) {
+			return nil, nil, errors.New("run doesn't match its leaf")
+		}
+	}
+	if run.ID != ID {
+		return nil, nil, errors.New("conode sent another run")
+	}
+	return run, h, nil
+}
+
+// ConsistencyProof fetches the current head of the transparency log of the
+// conode si with the proof that the log only grew since the old head. A
+// conode that rewrote its history cannot provide this proof.
+func (c *Client) ConsistencyProof(si *network.ServerIdentity, old *lib.TreeHead) (*lib.TreeHead, error) {
+	if err := verifyTreeHead(si, old); err != nil {
+		return nil, err
+	}
+	resp := &ConsistencyProofResponse{}
+	if err := c.SendProtobuf(si, &ConsistencyProofRequest{First: old.Size}, resp); err != nil {
+		return nil, err
+	}
+	if err := verifyTreeHead(si, resp.Head); err != nil {
+		return nil, err
+	}
+	if err := lib.VerifyConsistency(old.Size, resp.Head.Size, old.Root, resp.Head.Root, resp.Proof); err != nil {
+		return nil, err
+	}
+	return resp.Head, nil
+}
+
+// unixTime returns the Unix time in seconds, 0 for the zero time
+func unixTime(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
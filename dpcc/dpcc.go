@@ -1,14 +1,29 @@
 package main
 
 import (
+	"bytes"
 	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
 
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/encoding"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3/app"
 	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
 
 	"gopkg.in/urfave/cli.v1"
 )
@@ -18,6 +33,35 @@ const (
 	cachePath = "/tmp/dpcccache"
 )
 
+// envOperatorKey is the environment variable of the nodes holding the public
+// key of their operator
+const envOperatorKey = "DPCC_OPERATOR_KEY"
+
+// fetchFlags are used to choose the digest algorithms and to bound the
+// download of the resource by the conodes
+var fetchFlags = []cli.Flag{
+	cli.StringSliceFlag{
+		Name:  "algorithm, a",
+		Usage: "digest algorithm (sha256, sha512, blake2b-256, blake2b-512, simhash-64, dhash-64), can be repeated",
+	},
+	cli.Int64Flag{
+		Name:  "max-size",
+		Usage: "maximum size in bytes of the resource, 0 for the limit of the nodes",
+	},
+	cli.DurationFlag{
+		Name:  "progress-timeout",
+		Usage: "abort the download if no data is received for this duration",
+	},
+	cli.DurationFlag{
+		Name:  "timeout",
+		Usage: "abort the download if it is not completed within this duration, which the leader waits for besides a few seconds",
+	},
+	cli.BoolFlag{
+		Name:  "any-type",
+		Usage: "accept any content type, e.g. for release tarballs",
+	},
+}
+
 func main() {
 	cliApp := cli.NewApp()
 	cliApp.Name = "dpcc"
@@ -30,23 +74,395 @@ func main() {
 			Usage:     "execute hash public protocol",
 			ArgsUsage: groupsDef,
 			Action:    cmdHashPublic,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "url, u",
 					Usage: "provide URL for consensus",
 				},
-			},
+				cli.BoolFlag{
+					Name:  "diff",
+					Usage: "show the differences between the versions if the nodes disagree",
+				},
+				cli.BoolFlag{
+					Name:  "archive",
+					Usage: "ask the leader to keep the content if the nodes agree on it",
+				},
+				cli.BoolFlag{
+					Name:  "subresources",
+					Usage: "archive the style sheets and images of the page as well",
+				},
+			}, fetchFlags...),
 		},
 		{
 			Name:      "hashprivate",
 			Usage:     "execute hash private protocol",
 			ArgsUsage: groupsDef,
 			Action:    cmdHashPrivate,
-			Flags: []cli.Flag{
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "url, u",
+					Usage: "provide URL for consensus",
+				},
+			}, fetchFlags...),
+		},
+		{
+			Name:      "consensus",
+			Usage:     "build a collectively signed consensus version of an HTML page",
+			ArgsUsage: groupsDef,
+			Action:    cmdDOMConsensus,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "url, u",
+					Usage: "provide URL for consensus",
+				},
+				cli.IntFlag{
+					Name:  "threshold, t",
+					Usage: "number of nodes that must observe a node of the page, 0 for two thirds",
+				},
+				cli.BoolFlag{
+					Name:  "private",
+					Usage: "only reveal the combined Bloom filters of the nodes to the leader",
+				},
+				cli.BoolFlag{
+					Name:  "encrypted",
+					Usage: "add the Bloom filters of the nodes under encryption and only decrypt their sum",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Usage: "file where the consensus document is written, standard output by default",
+				},
+			}, fetchFlags...),
+		},
+		{
+			Name:      "equality",
+			Usage:     "check if the nodes agree on a resource without learning their hashes",
+			ArgsUsage: groupsDef,
+			Action:    cmdHashEquality,
+			Flags: append([]cli.Flag{
+				cli.StringFlag{
+					Name:  "url, u",
+					Usage: "provide URL for consensus",
+				},
+			}, fetchFlags...),
+		},
+		{
+			Name:      "shuffle",
+			Usage:     "get the hashes of the nodes without learning which node computed which",
+			ArgsUsage: groupsDef,
+			Action:    cmdHashShuffle,
+			Flags: append([]cli.Flag{
 				cli.StringFlag{
 					Name:  "url, u",
 					Usage: "provide URL for consensus",
 				},
+			}, fetchFlags...),
+		},
+		{
+			Name:      "dkg",
+			Usage:     "get the distributed key of the roster, generating it if needed",
+			ArgsUsage: groupsDef,
+			Action:    cmdDKG,
+			Flags: []cli.Flag{
+				cli.IntFlag{
+					Name:  "threshold, t",
+					Usage: "number of nodes needed to decrypt, 0 for two thirds",
+				},
+				cli.BoolFlag{
+					Name:  "refresh",
+					Usage: "deal new shares of the key of the roster, on behalf of the operators of the nodes",
+				},
+				cli.IntFlag{
+					Name:  "node, n",
+					Usage: "index in the group of the node leading the refresh",
+				},
+				cli.StringSliceFlag{
+					Name:  "operator",
+					Usage: "file with the hex encoded private key of an operator of nodes of the group, can be repeated",
+				},
+			},
+		},
+		{
+			Name:   "operator",
+			Usage:  "generate the key pair of the operator of a node, whose public key is set in " + envOperatorKey,
+			Action: cmdOperator,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "out, o",
+					Usage: "file where the private key is written",
+				},
+			},
+		},
+		{
+			Name:      "history",
+			Usage:     "list the past runs for an URL, show a run, or the hash the nodes agreed on at a given time",
+			ArgsUsage: groupsDef,
+			Action:    cmdHistory,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "url, u",
+					Usage: "URL of the runs",
+				},
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "show the run with this ID and verify its signatures",
+				},
+				cli.StringFlag{
+					Name:  "at",
+					Usage: "show the last hash the nodes agreed on at this time (RFC 3339 or YYYY-MM-DD)",
+				},
+				cli.StringFlag{
+					Name:  "algorithm, a",
+					Usage: "digest algorithm of the agreed hash",
+				},
+				cli.StringFlag{
+					Name:  "since",
+					Usage: "only list the runs made since this time (RFC 3339 or YYYY-MM-DD)",
+				},
+				cli.StringFlag{
+					Name:  "until",
+					Usage: "only list the runs made until this time (RFC 3339 or YYYY-MM-DD)",
+				},
+				cli.IntFlag{
+					Name:  "limit",
+					Usage: "only list this number of most recent runs, 0 for all of them",
+				},
+			},
+		},
+		{
+			Name:      "capture",
+			Usage:     "fetch the archived content of a run and verify it against the agreed hash",
+			ArgsUsage: groupsDef,
+			Action:    cmdCapture,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "ID of the archived run",
+				},
+				cli.StringFlag{
+					Name:  "out, o",
+					Usage: "file where the content is written, standard output by default",
+				},
+			},
+		},
+		{
+			Name:  "warc",
+			Usage: "exchange archived runs with web-archiving tools as WARC files",
+			Subcommands: []cli.Command{
+				{
+					Name:      "export",
+					Usage:     "export a run, or all the runs of an URL, archived by the nodes",
+					ArgsUsage: groupsDef,
+					Action:    cmdWARCExport,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "ID of the archived run",
+						},
+						cli.StringFlag{
+							Name:  "url, u",
+							Usage: "URL of the archived runs",
+						},
+						cli.StringFlag{
+							Name:  "out, o",
+							Usage: "WARC file to write, standard output by default",
+						},
+						cli.BoolFlag{
+							Name:  "gzip, z",
+							Usage: "compress every record with gzip, as in .warc.gz files, implied by an output file ending in .gz",
+						},
+					},
+				},
+				{
+					Name:      "import",
+					Usage:     "verify the runs of a WARC file against the attestations stored by the nodes",
+					ArgsUsage: groupsDef,
+					Action:    cmdWARCImport,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "in, i",
+							Usage: "WARC file to read, compressed with gzip or not",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "replay",
+			Usage:     "browse archived runs on a local HTTP server",
+			ArgsUsage: groupsDef,
+			Action:    cmdReplay,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "in, i",
+					Usage: "WARC file to replay, instead of the archives of the nodes",
+				},
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "ID of the archived run",
+				},
+				cli.StringFlag{
+					Name:  "url, u",
+					Usage: "URL of the archived runs, all the archives of the nodes are replayed if no URL, ID or file is given",
+				},
+				cli.StringFlag{
+					Name:  "listen, l",
+					Value: "localhost:8080",
+					Usage: "address of the HTTP server",
+				},
+			},
+		},
+		{
+			Name:      "proof",
+			Usage:     "prove that the result of a run is in the tamper-evident log of the nodes",
+			ArgsUsage: groupsDef,
+			Action:    cmdProof,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "ID of the run",
+				},
+				cli.StringFlag{
+					Name:  "ledger",
+					Usage: "hex encoded ID of the ledger holding the eventlog",
+				},
+			},
+		},
+		{
+			Name:  "transparency",
+			Usage: "audit the append-only logs of the runs led by the nodes",
+			Subcommands: []cli.Command{
+				{
+					Name:      "head",
+					Usage:     "show the signed head of the log of a node",
+					ArgsUsage: groupsDef,
+					Action:    cmdTreeHead,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "node, n",
+							Usage: "index of the node in the group",
+						},
+						cli.StringFlag{
+							Name:  "out, o",
+							Usage: "file where the head is saved for later audits",
+						},
+					},
+				},
+				{
+					Name:      "inclusion",
+					Usage:     "prove that a run is in the log of its leader",
+					ArgsUsage: groupsDef,
+					Action:    cmdInclusionProof,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "ID of the run",
+						},
+						cli.Uint64Flag{
+							Name:  "size",
+							Usage: "size of the tree of the proof, 0 for the current one",
+						},
+					},
+				},
+				{
+					Name:      "consistency",
+					Usage:     "prove that the log of a node only grew since a saved head, and save the new head",
+					ArgsUsage: groupsDef,
+					Action:    cmdConsistencyProof,
+					Flags: []cli.Flag{
+						cli.IntFlag{
+							Name:  "node, n",
+							Usage: "index of the node in the group",
+						},
+						cli.StringFlag{
+							Name:  "head",
+							Usage: "file of the head saved by a previous audit",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:  "watch",
+			Usage: "manage the URLs watched by the nodes on a schedule",
+			Subcommands: []cli.Command{
+				{
+					Name:      "add",
+					Usage:     "watch an URL with a node as leader",
+					ArgsUsage: groupsDef,
+					Action:    cmdWatchAdd,
+					Flags: append([]cli.Flag{
+						cli.StringFlag{
+							Name:  "url, u",
+							Usage: "URL to watch",
+						},
+						cli.StringFlag{
+							Name:  "schedule, s",
+							Usage: "crontab line such as \"*/30 * * * *\", @hourly, @daily or @every <duration>",
+						},
+						cli.IntFlag{
+							Name:  "node, n",
+							Usage: "index in the group of the node leading the runs",
+						},
+						cli.StringFlag{
+							Name:  "operator",
+							Usage: "file with the hex encoded private key of the operator of the node",
+						},
+					}, fetchFlags...),
+				},
+				{
+					Name:      "list",
+					Usage:     "list the watches of the nodes",
+					ArgsUsage: groupsDef,
+					Action:    cmdWatchList,
+				},
+				{
+					Name:      "remove",
+					Usage:     "remove a watch",
+					ArgsUsage: groupsDef,
+					Action:    cmdWatchRemove,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "ID of the watch",
+						},
+						cli.StringFlag{
+							Name:  "operator",
+							Usage: "file with the hex encoded private key of the owner of the watch",
+						},
+					},
+				},
+				{
+					Name:      "events",
+					Usage:     "list the changes seen by the watches",
+					ArgsUsage: groupsDef,
+					Action:    cmdWatchEvents,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "only list the events of this watch",
+						},
+						cli.StringFlag{
+							Name:  "since",
+							Usage: "only list the events since this time (RFC 3339 or YYYY-MM-DD)",
+						},
+					},
+				},
+			},
+		},
+		{
+			Name:      "subscribe",
+			Usage:     "print the events of the watches of the nodes as JSON lines as they happen",
+			ArgsUsage: groupsDef,
+			Action:    cmdSubscribe,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "id",
+					Usage: "only print the events of this watch",
+				},
+				cli.StringFlag{
+					Name:  "url, u",
+					Usage: "only print the events about this URL",
+				},
 			},
 		},
 	}
@@ -72,14 +488,52 @@ func cmdHashPublic(c *cli.Context) error {
 	}
 	group := readGroup(c)
 	client := dpcc.NewClient()
-	resp, err := client.PublicHashRequest(group.Roster, URL)
+	resp, err := client.PublicHashRequest(group.Roster, URL, c.StringSlice("algorithm"),
+		&dpcc.PublicHashOptions{
+			Fetch:        readFetchOptions(c),
+			Diff:         c.Bool("diff"),
+			Archive:      c.Bool("archive"),
+			Subresources: c.Bool("subresources"),
+		})
 	if err != nil {
 		log.Fatal("when asking for hash public protocol", err)
 	}
 
-	// print received hashes
+	// print received digests
 	for n, singleResp := range resp.Responses {
-		fmt.Println("Node", n, "sent hash", base64.StdEncoding.EncodeToString(singleResp.Hash))
+		printDigests(n, singleResp.Digests)
+	}
+
+	// print the agreement between the nodes
+	if resp.Verdict != nil {
+		for _, g := range resp.Verdict.Groups {
+			fmt.Println(len(g.Nodes), "nodes agree on", g.Algorithm, "hash",
+				base64.StdEncoding.EncodeToString(g.Digest))
+		}
+		for _, sc := range resp.Verdict.Similarities {
+			fmt.Printf("Nodes %s and %s are %.0f%% similar (%s)\n",
+				sc.NodeA, sc.NodeB, sc.Score*100, sc.Algorithm)
+		}
+	}
+
+	// print the differences between the versions
+	if resp.Diff != nil {
+		for _, d := range resp.Diff.Diffs {
+			fmt.Println("---", d.NodeA)
+			fmt.Println("+++", d.NodeB)
+			for _, e := range d.Edits {
+				fmt.Printf("%s%d: %s\n", e.Op, e.Line, e.Text)
+			}
+		}
+	}
+
+	if c.Bool("archive") || c.Bool("subresources") {
+		if resp.Capture == nil {
+			fmt.Println("The content was not archived")
+		} else {
+			fmt.Println("Archived", resp.Capture.Size, "bytes and", len(resp.Capture.Resources),
+				"subresources as run", resp.RunID)
+		}
 	}
 	return nil
 
@@ -93,17 +547,781 @@ func cmdHashPrivate(c *cli.Context) error {
 	}
 	group := readGroup(c)
 	client := dpcc.NewClient()
-	resp, err := client.PrivateHashRequest(group.Roster, URL)
+	resp, err := client.PrivateHashRequest(group.Roster, URL, c.StringSlice("algorithm"), readFetchOptions(c))
 	if err != nil {
 		log.Fatal("when asking for hash private protocol", err)
 	}
 
-	// print received hashes
-	for n, h := range resp.Hashes {
-		fmt.Println("Node", n, "sent hash", base64.StdEncoding.EncodeToString(h))
+	// print received digests
+	for n, d := range resp.Digests {
+		printDigests(n, d.Digests)
+	}
+	return nil
+
+}
+
+func cmdDOMConsensus(c *cli.Context) error {
+	log.Info("DOM consensus protocol request")
+	URL := c.String("url")
+	if URL == "" {
+		log.Fatal("please provide an URL")
+	}
+	group := readGroup(c)
+	mode := dpcc.ConsensusPlain
+	switch {
+	case c.Bool("private") && c.Bool("encrypted"):
+		log.Fatal("the filters cannot be both private and encrypted")
+	case c.Bool("private"):
+		mode = dpcc.ConsensusPrivate
+	case c.Bool("encrypted"):
+		mode = dpcc.ConsensusEncrypted
+	}
+	client := dpcc.NewClient()
+	resp, err := client.DOMConsensusRequest(group.Roster, URL, c.Int("threshold"),
+		readFetchOptions(c), mode)
+	if err != nil {
+		log.Fatal("when asking for DOM consensus protocol", err)
+	}
+	log.Info("consensus document signed by", len(resp.Signers), "nodes")
+
+	if out := c.String("out"); out != "" {
+		return ioutil.WriteFile(out, resp.Document, 0644)
+	}
+	_, err = os.Stdout.Write(resp.Document)
+	return err
+}
+
+func cmdHashEquality(c *cli.Context) error {
+	log.Info("hash equality protocol request")
+	URL := c.String("url")
+	if URL == "" {
+		log.Fatal("please provide an URL")
+	}
+	// only the first algorithm is used for the comparison
+	algorithm := ""
+	if algs := c.StringSlice("algorithm"); len(algs) > 0 {
+		algorithm = algs[0]
+	}
+	group := readGroup(c)
+	client := dpcc.NewClient()
+	resp, err := client.PrivateEqualityRequest(group.Roster, URL, algorithm, readFetchOptions(c))
+	if err != nil {
+		log.Fatal("when asking for hash equality protocol", err)
+	}
+
+	if len(resp.Groups) == 1 {
+		fmt.Println("All", resp.Nodes, "nodes agree")
+		return nil
+	}
+	for _, g := range resp.Groups {
+		fmt.Println(len(g.Nodes), "of", resp.Nodes, "nodes agree with each other:", g.Nodes)
+	}
+	return nil
+}
+
+func cmdHashShuffle(c *cli.Context) error {
+	log.Info("hash shuffle protocol request")
+	URL := c.String("url")
+	if URL == "" {
+		log.Fatal("please provide an URL")
+	}
+	// only the first algorithm is used
+	algorithm := ""
+	if algs := c.StringSlice("algorithm"); len(algs) > 0 {
+		algorithm = algs[0]
+	}
+	group := readGroup(c)
+	client := dpcc.NewClient()
+	resp, err := client.ShuffledHashRequest(group.Roster, URL, algorithm, readFetchOptions(c))
+	if err != nil {
+		log.Fatal("when asking for hash shuffle protocol", err)
+	}
+
+	// the digests are sorted, print how many nodes sent each of them
+	for i := 0; i < len(resp.Digests); {
+		j := i + 1
+		for j < len(resp.Digests) && bytes.Equal(resp.Digests[i], resp.Digests[j]) {
+			j++
+		}
+		fmt.Println(j-i, "nodes sent truncated", resp.Algorithm, "hash",
+			base64.StdEncoding.EncodeToString(resp.Digests[i]))
+		i = j
+	}
+	return nil
+}
+
+func cmdDKG(c *cli.Context) error {
+	log.Info("DKG protocol request")
+	group := readGroup(c)
+	client := dpcc.NewClient()
+	var resp *dpcc.DKGResponse
+	var err error
+	if c.Bool("refresh") {
+		n := c.Int("node")
+		if n < 0 || n >= len(group.Roster.List) {
+			log.Fatal("no node", n, "in the group")
+		}
+		files := c.StringSlice("operator")
+		if len(files) == 0 {
+			log.Fatal("please provide the files of the private keys of the operators")
+		}
+		var operators []*lib.OperatorRequest
+		for _, file := range files {
+			o, err := dpcc.SignRefreshDKG(group.Roster, c.Int("threshold"), readPrivateKey(file))
+			log.ErrFatal(err)
+			operators = append(operators, o...)
+		}
+		resp, err = client.RefreshDKG(group.Roster, group.Roster.List[n], c.Int("threshold"),
+			operators)
+	} else {
+		resp, err = client.DKGRequest(group.Roster, c.Int("threshold"))
+	}
+	if err != nil {
+		log.Fatal("when asking for DKG protocol", err)
+	}
+	fmt.Println("Distributed key", resp.Public, "with threshold", resp.Threshold)
+	return nil
+}
+
+func cmdOperator(c *cli.Context) error {
+	out := c.String("out")
+	if out == "" {
+		log.Fatal("please provide the file of the private key")
+	}
+	kp := key.NewKeyPair(cothority.Suite)
+	private, err := encoding.ScalarToStringHex(cothority.Suite, kp.Private)
+	log.ErrFatal(err)
+	public, err := encoding.PointToStringHex(cothority.Suite, kp.Public)
+	log.ErrFatal(err)
+	log.ErrFatal(ioutil.WriteFile(out, []byte(private+"\n"), 0600))
+	fmt.Println("Private key written to", out)
+	fmt.Println("Set", envOperatorKey+"="+public, "in the environment of the node")
+	return nil
+}
+
+func cmdHistory(c *cli.Context) error {
+	log.Info("history request")
+	group := readGroup(c)
+	client := dpcc.NewClient()
+
+	// every conode keeps the runs it led, so all of them are asked
+	if id := c.String("id"); id != "" {
+		for _, si := range group.Roster.List {
+			run, err := client.HistoryRun(group.Roster, si, id)
+			if err != nil {
+				log.Lvl2(si, "couldn't send run", id, ":", err)
+				continue
+			}
+			printRun(si, run)
+			return nil
+		}
+		log.Fatal("no node has the run", id)
+	}
+
+	URL := c.String("url")
+	if URL == "" {
+		log.Fatal("please provide an URL")
+	}
+
+	if at := c.String("at"); at != "" {
+		t, err := parseTime(at, true)
+		log.ErrFatal(err, "invalid time")
+		var last *dpcc.AgreedHashResponse
+		var leader *network.ServerIdentity
+		for _, si := range group.Roster.List {
+			resp, err := client.AgreedHash(group.Roster, si, URL, t, c.String("algorithm"))
+			if err != nil {
+				log.Lvl2(si, "couldn't send agreed hash:", err)
+				continue
+			}
+			if last == nil || dpcc.AgreedTime(resp.Run, resp.Group) >
+				dpcc.AgreedTime(last.Run, last.Group) {
+				last, leader = resp, si
+			}
+		}
+		if last == nil {
+			log.Fatal("the nodes didn't agree on a hash of", URL, "at", at)
+		}
+		fmt.Println(len(last.Group.Nodes), "of", len(group.Roster.List)-1, "nodes agreed on",
+			last.Group.Algorithm, "hash", base64.StdEncoding.EncodeToString(last.Group.Digest))
+		fmt.Println("Run", last.Run.ID, "led by", leader.Address, "signed at",
+			formatTime(dpcc.AgreedTime(last.Run, last.Group)))
+		return nil
+	}
+
+	var since, until time.Time
+	var err error
+	if v := c.String("since"); v != "" {
+		since, err = parseTime(v, false)
+		log.ErrFatal(err, "invalid time")
+	}
+	if v := c.String("until"); v != "" {
+		until, err = parseTime(v, true)
+		log.ErrFatal(err, "invalid time")
+	}
+	type entry struct {
+		leader *network.ServerIdentity
+		run    *dpcc.RunSummary
+	}
+	var entries []entry
+	for _, si := range group.Roster.List {
+		resp, err := client.History(si, URL, since, until, c.Int("limit"))
+		if err != nil {
+			log.Warn(si, "couldn't send its history:", err)
+			continue
+		}
+		for _, r := range resp.Runs {
+			entries = append(entries, entry{si, r})
+		}
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].run.Time < entries[j].run.Time
+	})
+	if limit := c.Int("limit"); limit > 0 && len(entries) > limit {
+		entries = entries[len(entries)-limit:]
+	}
+	for _, e := range entries {
+		fmt.Println(formatTime(e.run.Time), e.run.Mode, e.run.Nodes, "nodes",
+			"run", e.run.ID, "led by", e.leader.Address)
 	}
 	return nil
+}
+
+func cmdCapture(c *cli.Context) error {
+	id := c.String("id")
+	if id == "" {
+		log.Fatal("please provide the ID of a run")
+	}
+	group := readGroup(c)
+	client := dpcc.NewClient()
+
+	// only the leader of the run keeps its content
+	for _, si := range group.Roster.List {
+		run, data, err := client.Capture(group.Roster, si, id)
+		if err != nil {
+			log.Lvl2(si, "couldn't send capture", id, ":", err)
+			continue
+		}
+		log.Info("content of", run.URL, "archived by", si.Address, "at", formatTime(run.Time))
+		if out := c.String("out"); out != "" {
+			return ioutil.WriteFile(out, data, 0644)
+		}
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	log.Fatal("no node has the capture", id)
+	return nil
+}
+
+func cmdWARCExport(c *cli.Context) error {
+	id, URL := c.String("id"), c.String("url")
+	if id == "" && URL == "" {
+		log.Fatal("please provide the ID of a run or an URL")
+	}
+	group := readGroup(c)
+	client := dpcc.NewClient()
+
+	out := os.Stdout
+	file := c.String("out")
+	if file != "" {
+		f, err := os.Create(file)
+		log.ErrFatal(err, "couldn't create WARC file")
+		defer f.Close()
+		out = f
+	}
+	w := lib.NewWARCWriter(out, c.Bool("gzip") || strings.HasSuffix(file, ".gz"))
+
+	// every node exports the runs it led, each export starting with its
+	// own warcinfo record
+	exported := false
+	for _, si := range group.Roster.List {
+		if err := client.WARC(group.Roster, si, id, URL, w); err != nil {
+			log.Lvl2(si, "couldn't export its archive:", err)
+			continue
+		}
+		exported = true
+		if id != "" {
+			break
+		}
+	}
+	if !exported {
+		log.Fatal("no node has archived runs")
+	}
+	return nil
+}
+
+func cmdWARCImport(c *cli.Context) error {
+	file := c.String("in")
+	if file == "" {
+		log.Fatal("please provide a WARC file")
+	}
+	group := readGroup(c)
+	f, err := os.Open(file)
+	log.ErrFatal(err, "couldn't open WARC file")
+	defer f.Close()
+	captures, err := dpcc.ReadWARC(f)
+	log.ErrFatal(err, "invalid WARC file")
+	client := dpcc.NewClient()
+
+	failed := 0
+	for _, capture := range captures {
+		run := capture.Run
+		if err := dpcc.VerifyCapture(group.Roster, capture); err != nil {
+			fmt.Println("Run", run.ID, "of", run.URL, "is invalid:", err)
+			failed++
+			continue
+		}
+		// the attestation must be the one stored by the leader of the run
+		var leader *network.ServerIdentity
+		for _, si := range group.Roster.List {
+			stored, err := client.HistoryRun(group.Roster, si, run.ID)
+			if err == nil && bytes.Equal(dpcc.RunLeaf(stored), dpcc.RunLeaf(run)) {
+				leader = si
+				break
+			}
+		}
+		if leader == nil {
+			fmt.Println("Run", run.ID, "of", run.URL, "is signed but not stored by any node")
+			failed++
+			continue
+		}
+		fmt.Println("Run", run.ID, "of", run.URL, "at", formatTime(run.Time),
+			"matches the attestation stored by", leader.Address)
+	}
+	if failed > 0 {
+		log.Fatal(failed, "of", len(captures), "runs couldn't be verified")
+	}
+	return nil
+}
+
+func cmdReplay(c *cli.Context) error {
+	group := readGroup(c)
+	client := dpcc.NewClient()
+	// every node exports the archived runs it led
+	fetch := func(id, URL string) ([]*dpcc.WARCCapture, error) {
+		var captures []*dpcc.WARCCapture
+		for _, si := range group.Roster.List {
+			var buf bytes.Buffer
+			err := client.WARC(group.Roster, si, id, URL, lib.NewWARCWriter(&buf, false))
+			if err != nil {
+				log.Lvl2(si, "couldn't export its archive:", err)
+				continue
+			}
+			cs, err := dpcc.ReadWARC(&buf)
+			if err != nil {
+				return nil, err
+			}
+			captures = append(captures, cs...)
+			if id != "" {
+				break
+			}
+		}
+		return captures, nil
+	}
+
+	var replay *dpcc.Replay
+	id, URL, file := c.String("id"), c.String("url"), c.String("in")
+	switch {
+	case file != "":
+		f, err := os.Open(file)
+		log.ErrFatal(err, "couldn't open WARC file")
+		captures, err := dpcc.ReadWARC(f)
+		f.Close()
+		log.ErrFatal(err, "invalid WARC file")
+		replay, err = dpcc.NewReplay(group.Roster, captures)
+		log.ErrFatal(err, "couldn't replay the archived runs")
+	case id != "" || URL != "":
+		captures, err := fetch(id, URL)
+		log.ErrFatal(err, "couldn't fetch the archived runs")
+		replay, err = dpcc.NewReplay(group.Roster, captures)
+		log.ErrFatal(err, "couldn't replay the archived runs")
+	default:
+		// the runs are fetched as their URLs are requested, for instance
+		// by Memento clients
+		replay = dpcc.NewArchiveReplay(group.Roster, func(URL string) ([]*dpcc.WARCCapture, error) {
+			return fetch("", URL)
+		})
+	}
+	listen := c.String("listen")
+	log.Info("replaying archived runs at http://" + listen)
+	log.Info("Memento TimeGate at http://" + listen + "/timegate/<URL>" +
+		" and TimeMap at http://" + listen + "/timemap/link/<URL>")
+	return http.ListenAndServe(listen, replay)
+}
+
+func cmdProof(c *cli.Context) error {
+	log.Info("log proof request")
+	id := c.String("id")
+	if id == "" {
+		log.Fatal("please provide the ID of a run")
+	}
+	ledger, err := hex.DecodeString(c.String("ledger"))
+	if err != nil || len(ledger) == 0 {
+		log.Fatal("please provide the ID of the ledger")
+	}
+	group := readGroup(c)
+	client := dpcc.NewClient()
+
+	// only the leader of the run knows the key of its event
+	for _, si := range group.Roster.List {
+		run, entry, err := client.LogProof(group.Roster, si, ledger, id)
+		if err != nil {
+			log.Lvl2(si, "couldn't prove run", id, ":", err)
+			continue
+		}
+		fmt.Println("Run", run.ID, "of", run.URL, "led by", si.Address, "at", formatTime(run.Time))
+		fmt.Println(entry.Algorithm, "hash", base64.StdEncoding.EncodeToString(entry.Digest),
+			"is in the log under", hex.EncodeToString(run.LogID))
+		return nil
+	}
+	log.Fatal("no node could prove run", id)
+	return nil
+}
+
+func cmdTreeHead(c *cli.Context) error {
+	group := readGroup(c)
+	n := c.Int("node")
+	if n < 0 || n >= len(group.Roster.List) {
+		log.Fatal("no node", n, "in the group")
+	}
+	si := group.Roster.List[n]
+	h, err := dpcc.NewClient().TreeHead(si)
+	if err != nil {
+		log.Fatal("when asking for tree head", err)
+	}
+	printTreeHead(si, h)
+	if out := c.String("out"); out != "" {
+		log.ErrFatal(writeTreeHead(out, h), "couldn't save tree head")
+	}
+	return nil
+}
+
+func cmdInclusionProof(c *cli.Context) error {
+	id := c.String("id")
+	if id == "" {
+		log.Fatal("please provide the ID of a run")
+	}
+	group := readGroup(c)
+	client := dpcc.NewClient()
+
+	// only the leader of the run has it in its log
+	for _, si := range group.Roster.List {
+		run, h, err := client.InclusionProof(group.Roster, si, id, c.Uint64("size"))
+		if err != nil {
+			log.Lvl2(si, "couldn't prove run", id, ":", err)
+			continue
+		}
+		fmt.Println("Run", run.ID, "of", run.URL, "at", formatTime(run.Time), "is leaf", run.LeafIndex)
+		printTreeHead(si, h)
+		return nil
+	}
+	log.Fatal("no node could prove run", id)
+	return nil
+}
+
+func cmdConsistencyProof(c *cli.Context) error {
+	file := c.String("head")
+	if file == "" {
+		log.Fatal("please provide the file of a saved head")
+	}
+	group := readGroup(c)
+	n := c.Int("node")
+	if n < 0 || n >= len(group.Roster.List) {
+		log.Fatal("no node", n, "in the group")
+	}
+	si := group.Roster.List[n]
+	old, err := readTreeHead(file, si)
+	log.ErrFatal(err, "couldn't read tree head")
+
+	h, err := dpcc.NewClient().ConsistencyProof(si, old)
+	if err != nil {
+		log.Fatal("the log of", si.Address, "is not consistent with the saved head:", err)
+	}
+	fmt.Println("The log grew consistently from", old.Size, "to", h.Size, "runs")
+	printTreeHead(si, h)
+	log.ErrFatal(writeTreeHead(file, h), "couldn't save tree head")
+	return nil
+}
+
+// jsonTreeHead is a tree head as saved by the transparency commands. The key
+// of the node is taken from the group.
+type jsonTreeHead struct {
+	Size      uint64 `json:"size"`
+	Timestamp int64  `json:"timestamp"`
+	Root      []byte `json:"root"`
+	Signature []byte `json:"signature"`
+}
+
+// print a tree head of the log of a node
+func printTreeHead(si *network.ServerIdentity, h *lib.TreeHead) {
+	fmt.Println("Log of", si.Address, "has", h.Size, "runs with root",
+		base64.StdEncoding.EncodeToString(h.Root), "signed at", formatTime(h.Timestamp))
+}
+
+// save a tree head in a file
+func writeTreeHead(file string, h *lib.TreeHead) error {
+	buf, err := json.Marshal(&jsonTreeHead{
+		Size:      h.Size,
+		Timestamp: h.Timestamp,
+		Root:      h.Root,
+		Signature: h.Signature,
+	})
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(file, buf, 0644)
+}
+
+// read a tree head of the node si from a file
+func readTreeHead(file string, si *network.ServerIdentity) (*lib.TreeHead, error) {
+	buf, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	var h jsonTreeHead
+	if err := json.Unmarshal(buf, &h); err != nil {
+		return nil, err
+	}
+	return &lib.TreeHead{
+		Size:      h.Size,
+		Timestamp: h.Timestamp,
+		Root:      h.Root,
+		NodeKey:   lib.ServicePublic(si),
+		Signature: h.Signature,
+	}, nil
+}
+
+func cmdWatchAdd(c *cli.Context) error {
+	URL := c.String("url")
+	if URL == "" {
+		log.Fatal("please provide an URL")
+	}
+	group := readGroup(c)
+	n := c.Int("node")
+	if n < 0 || n >= len(group.Roster.List) {
+		log.Fatal("no node", n, "in the group")
+	}
+	operator := readOperatorKey(c)
+	client := dpcc.NewClient()
+	w, err := client.WatchAdd(group.Roster.List[n], group.Roster, URL, c.String("schedule"),
+		c.StringSlice("algorithm"), readFetchOptions(c), operator)
+	if err != nil {
+		log.Fatal("when adding watch", err)
+	}
+	fmt.Println("Watch", w.ID, "of", w.URL, "first runs at", formatTime(w.NextRun))
+	return nil
+}
+
+func cmdWatchList(c *cli.Context) error {
+	group := readGroup(c)
+	client := dpcc.NewClient()
+	for _, si := range group.Roster.List {
+		watches, err := client.WatchList(si)
+		if err != nil {
+			log.Warn(si, "couldn't send its watches:", err)
+			continue
+		}
+		for _, w := range watches {
+			state := "not run yet"
+			switch {
+			case w.LastRun == 0:
+			case !w.Reachable:
+				state = "unreachable"
+			case !w.Agreed:
+				state = "nodes disagree"
+			default:
+				state = "nodes agree on " + base64.StdEncoding.EncodeToString(w.Digest)
+			}
+			fmt.Printf("%s %s [%s] led by %s, next run at %s, %s\n", w.ID, w.URL,
+				w.Schedule, si.Address, formatTime(w.NextRun), state)
+		}
+	}
+	return nil
+}
+
+func cmdWatchRemove(c *cli.Context) error {
+	id := c.String("id")
+	if id == "" {
+		log.Fatal("please provide the ID of the watch")
+	}
+	group := readGroup(c)
+	operator := readOperatorKey(c)
+	client := dpcc.NewClient()
+	// only the leader of the watch knows it
+	for _, si := range group.Roster.List {
+		if err := client.WatchRemove(si, id, operator); err == nil {
+			fmt.Println("Removed watch", id, "led by", si.Address)
+			return nil
+		}
+	}
+	log.Fatal("no node has the watch", id)
+	return nil
+}
+
+func cmdWatchEvents(c *cli.Context) error {
+	var since time.Time
+	if v := c.String("since"); v != "" {
+		var err error
+		since, err = parseTime(v, false)
+		log.ErrFatal(err, "invalid time")
+	}
+	group := readGroup(c)
+	client := dpcc.NewClient()
+	var events []*dpcc.WatchEvent
+	for _, si := range group.Roster.List {
+		e, err := client.WatchEvents(si, c.String("id"), since)
+		if err != nil {
+			log.Warn(si, "couldn't send its events:", err)
+			continue
+		}
+		events = append(events, e...)
+	}
+	sort.SliceStable(events, func(i, j int) bool {
+		return events[i].Time < events[j].Time
+	})
+	for _, e := range events {
+		fmt.Println(formatTime(e.Time), e.URL, e.Kind, e.Details)
+	}
+	return nil
+}
+
+// jsonEvent is an event of a watch as printed by the subscribe command
+type jsonEvent struct {
+	Time    string `json:"time"`
+	Node    string `json:"node"`
+	Watch   string `json:"watch"`
+	URL     string `json:"url"`
+	Kind    string `json:"kind"`
+	Run     string `json:"run,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+func cmdSubscribe(c *cli.Context) error {
+	group := readGroup(c)
+	client := dpcc.NewClient()
+
+	// every node streams the events of the watches it leads
+	type nodeEvent struct {
+		node  *network.ServerIdentity
+		event *dpcc.WatchEvent
+	}
+	merged := make(chan nodeEvent)
+	var wg sync.WaitGroup
+	for _, si := range group.Roster.List {
+		events, errs, stop, err := client.Subscribe(si, c.String("id"), c.String("url"))
+		if err != nil {
+			log.Warn(si, "couldn't stream its events:", err)
+			continue
+		}
+		defer stop()
+		wg.Add(1)
+		go func(si *network.ServerIdentity) {
+			defer wg.Done()
+			for e := range events {
+				merged <- nodeEvent{si, e}
+			}
+			if err := <-errs; err != nil {
+				log.Warn(si, "stopped streaming its events:", err)
+			}
+		}(si)
+	}
+	go func() {
+		wg.Wait()
+		close(merged)
+	}()
+
+	enc := json.NewEncoder(os.Stdout)
+	for e := range merged {
+		err := enc.Encode(&jsonEvent{
+			Time:    formatTime(e.event.Time),
+			Node:    string(e.node.Address),
+			Watch:   e.event.WatchID,
+			URL:     e.event.URL,
+			Kind:    e.event.Kind,
+			Run:     e.event.RunID,
+			Details: e.event.Details,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// print a run of the history
+func printRun(leader *network.ServerIdentity, run *dpcc.Run) {
+	fmt.Println("Run", run.ID, "of", run.URL, "led by", leader.Address)
+	fmt.Println(run.Mode, "at", formatTime(run.Time))
+	switch {
+	case run.Document != nil:
+		fmt.Println("Consensus document of", len(run.Document), "bytes signed by",
+			len(run.Signers), "nodes")
+	default:
+		for n, r := range run.Responses {
+			if r.Digests == nil {
+				fmt.Println("Node", n, "sent encrypted hashes")
+				continue
+			}
+			printDigests(n, r.Digests)
+		}
+	}
+}
+
+// parse a time given as RFC 3339 or as a date, which stands for the start of
+// the day or for its end if end is set
+func parseTime(v string, end bool) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse("2006-01-02", v)
+	if err != nil {
+		return time.Time{}, err
+	}
+	if end {
+		t = t.Add(24*time.Hour - time.Second)
+	}
+	return t, nil
+}
+
+// format a Unix time in seconds
+func formatTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
+
+// print the labeled digests sent by a node
+func printDigests(node string, digests []*lib.Digest) {
+	for _, d := range digests {
+		fmt.Println("Node", node, "sent", d.Algorithm, "hash",
+			base64.StdEncoding.EncodeToString(d.Value))
+	}
+}
+
+// read the options bounding the download of the resource
+func readFetchOptions(c *cli.Context) *lib.FetchOptions {
+	return &lib.FetchOptions{
+		MaxSize:         c.Int64("max-size"),
+		ProgressTimeout: c.Duration("progress-timeout"),
+		Timeout:         c.Duration("timeout"),
+		AnyContentType:  c.Bool("any-type"),
+	}
+}
+
+// read the private key of the operator of a node
+func readOperatorKey(c *cli.Context) kyber.Scalar {
+	file := c.String("operator")
+	if file == "" {
+		log.Fatal("please provide the file of the private key of the operator")
+	}
+	return readPrivateKey(file)
+}
 
+// read the hex encoded private key of an operator from a file
+func readPrivateKey(file string) kyber.Scalar {
+	buf, err := ioutil.ReadFile(file)
+	log.ErrFatal(err, "Couldn't read the key of the operator")
+	private, err := encoding.StringHexToScalar(cothority.Suite, strings.TrimSpace(string(buf)))
+	log.ErrFatal(err, "Invalid key of the operator")
+	return private
 }
 
 // read information about the roster
@@ -57,6 +57,7 @@ require (
 	go.dedis.ch/cothority/v3 v3.0.0-pre4
 	go.dedis.ch/kyber/v3 v3.0.0-pre3
 	go.dedis.ch/onet/v3 v3.0.0-pre4
+	go.dedis.ch/protobuf v1.0.6
 	go.opencensus.io v0.19.1 // indirect
 	go4.org v0.0.0-20190218023631-ce4c26f7be8e // indirect
 	golang.org/x/build v0.0.0-20190307052853-f4aa23954131 // indirect
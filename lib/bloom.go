@@ -0,0 +1,104 @@
+package lib
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/willf/bloom"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+)
+
+// Default parameters of the Bloom filters of the private structural
+// consensus, for pages of a few thousand nodes
+const (
+	DefaultFilterElements = 8192
+	DefaultFilterFPRate   = 0.001
+)
+
+// NewDOMFilter inserts the subtree and shell hashes of the page in a Bloom
+// filter with m cells and k hash functions, and returns the cells as a
+// vector of zeros and ones that can be summed with the ones of other conodes
+func NewDOMFilter(hashes *DOMHashes, m, k uint) []uint32 {
+	f := bloom.New(m, k)
+	for _, h := range hashes.Subtrees {
+		f.Add(h)
+	}
+	for _, h := range hashes.Shells {
+		f.Add(h)
+	}
+	cells := make([]uint32, m)
+	for i := range cells {
+		if f.TestLocations([]uint64{uint64(i)}) {
+			cells[i] = 1
+		}
+	}
+	return cells
+}
+
+// BloomCounter returns a counter estimating how many conodes inserted a
+// hash in their filters, given the sum of the cells of all the filters. As
+// for a count-min sketch, the estimate can only be too large.
+func BloomCounter(counts []uint32, k uint) DOMCounter {
+	return func(hash []byte) int {
+		min := -1
+		for _, l := range bloom.Locations(hash, k) {
+			c := int(counts[l%uint64(len(counts))])
+			if min < 0 || c < min {
+				min = c
+			}
+		}
+		return min
+	}
+}
+
+// BloomMask returns the mask hiding the filter of a conode from the leader.
+// The mask is the sum of a pseudo-random vector shared with every other
+// participant, added by one of the two and subtracted by the other, so that
+// all the masks cancel out in the sum of the masked filters. The shared
+// vectors are derived from a Diffie-Hellman exchange between the keys of
+// the conodes and the nonce of the request.
+func BloomMask(private kyber.Scalar, public kyber.Point, participants []kyber.Point, nonce []byte, m uint) ([]uint32, error) {
+	own := public.String()
+	mask := make([]uint32, m)
+	buf := make([]byte, 4*m)
+	for _, p := range participants {
+		other := p.String()
+		if other == own {
+			continue
+		}
+
+		// seed shared by the two conodes only
+		h := cothority.Suite.Hash()
+		h.Write([]byte("bloom-mask"))
+		if _, err := DhExchange(private, p).MarshalTo(h); err != nil {
+			return nil, err
+		}
+		h.Write(nonce)
+		if _, err := cothority.Suite.XOF(h.Sum(nil)).Read(buf); err != nil {
+			return nil, err
+		}
+
+		for i := range mask {
+			v := binary.BigEndian.Uint32(buf[4*i:])
+			if own < other {
+				mask[i] += v
+			} else {
+				mask[i] -= v
+			}
+		}
+	}
+	return mask, nil
+}
+
+// AddCells adds the cells of src to dst modulo 2^32, which is how masked
+// filters are combined
+func AddCells(dst, src []uint32) error {
+	if len(dst) != len(src) {
+		return errors.New("filters of different sizes")
+	}
+	for i := range dst {
+		dst[i] += src[i]
+	}
+	return nil
+}
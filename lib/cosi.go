@@ -0,0 +1,149 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+// cosiDomain separates the coefficients of the keys of collective signatures
+// from any other hash of the keys
+const cosiDomain = "dpcc cosi"
+
+// CosiThreshold returns the minimum number of signers of a collective
+// signature among n conodes, i.e. more than two thirds of them
+func CosiThreshold(n int) int {
+	return n - (n-1)/3
+}
+
+// CosiCommit picks the random secret of a signer for a collective Schnorr
+// signature and returns it together with its commitment
+func CosiCommit() (kyber.Scalar, kyber.Point) {
+	v := cothority.Suite.Scalar().Pick(random.New())
+	return v, cothority.Suite.Point().Mul(v, nil)
+}
+
+// CosiAggregate returns the sum of the points, used for the commitments of the
+// signers
+func CosiAggregate(points []kyber.Point) kyber.Point {
+	sum := cothority.Suite.Point().Null()
+	for _, p := range points {
+		sum.Add(sum, p)
+	}
+	return sum
+}
+
+// cosiCoefficient returns the coefficient of the public key of a signer in
+// the aggregated key, which depends on the keys of all the signers
+func cosiCoefficient(publics []kyber.Point, public kyber.Point) (kyber.Scalar, error) {
+	h := cothority.Suite.Hash()
+	h.Write([]byte(cosiDomain))
+	for _, p := range publics {
+		if _, err := p.MarshalTo(h); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := public.MarshalTo(h); err != nil {
+		return nil, err
+	}
+	return cothority.Suite.Scalar().SetBytes(h.Sum(nil)), nil
+}
+
+// CosiPublic returns the aggregated key of the signers. Every key is
+// weighted by a coefficient depending on all the keys, so that a signer
+// cannot choose its key to cancel the keys of the others.
+func CosiPublic(publics []kyber.Point) (kyber.Point, error) {
+	sum := cothority.Suite.Point().Null()
+	for _, p := range publics {
+		a, err := cosiCoefficient(publics, p)
+		if err != nil {
+			return nil, err
+		}
+		sum.Add(sum, cothority.Suite.Point().Mul(a, p))
+	}
+	return sum, nil
+}
+
+// CosiChallenge computes the challenge of the collective signature from the
+// aggregate commitment, the aggregated key and the message
+func CosiChallenge(commitment, public kyber.Point, msg []byte) (kyber.Scalar, error) {
+	h := cothority.Suite.Hash()
+	if _, err := commitment.MarshalTo(h); err != nil {
+		return nil, err
+	}
+	if _, err := public.MarshalTo(h); err != nil {
+		return nil, err
+	}
+	h.Write(msg)
+	return cothority.Suite.Scalar().SetBytes(h.Sum(nil)), nil
+}
+
+// CosiResponse computes the response to the challenge of the signer with the
+// given key among the signers
+func CosiResponse(publics []kyber.Point, public kyber.Point, secret, challenge, private kyber.Scalar) (kyber.Scalar, error) {
+	a, err := cosiCoefficient(publics, public)
+	if err != nil {
+		return nil, err
+	}
+	r := cothority.Suite.Scalar().Mul(challenge, a)
+	r.Mul(r, private)
+	return r.Add(r, secret), nil
+}
+
+// CosiSignature returns the collective signature made of the aggregate
+// commitment and the sum of the responses
+func CosiSignature(commitment kyber.Point, responses []kyber.Scalar) ([]byte, error) {
+	sum := cothority.Suite.Scalar().Zero()
+	for _, r := range responses {
+		sum.Add(sum, r)
+	}
+	var buf bytes.Buffer
+	if _, err := commitment.MarshalTo(&buf); err != nil {
+		return nil, err
+	}
+	if _, err := sum.MarshalTo(&buf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// CosiVerify verifies the collective signature of the message by all the
+// given public keys
+func CosiVerify(publics []kyber.Point, msg, sig []byte) error {
+	if len(publics) == 0 {
+		return errors.New("no signer")
+	}
+	pointLen := cothority.Suite.PointLen()
+	if len(sig) != pointLen+cothority.Suite.ScalarLen() {
+		return errors.New("invalid collective signature length")
+	}
+	commitment := cothority.Suite.Point()
+	if err := commitment.UnmarshalBinary(sig[:pointLen]); err != nil {
+		return err
+	}
+	response := cothority.Suite.Scalar()
+	if err := response.UnmarshalBinary(sig[pointLen:]); err != nil {
+		return err
+	}
+
+	public, err := CosiPublic(publics)
+	if err != nil {
+		return err
+	}
+	challenge, err := CosiChallenge(commitment, public, msg)
+	if err != nil {
+		return err
+	}
+
+	// the signature is valid if rG == V + cA
+	left := cothority.Suite.Point().Mul(response, nil)
+	right := cothority.Suite.Point().Mul(challenge, public)
+	right.Add(right, commitment)
+	if !left.Equal(right) {
+		return errors.New("invalid collective signature")
+	}
+	return nil
+}
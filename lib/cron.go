@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule tells when a task runs. It is given either as the five fields of
+// a crontab line (minute, hour, day of month, month, day of week), each
+// being *, a number, a range a-b, a list of them separated by commas, and
+// optionally followed by a step /n, or as one of the descriptors @hourly,
+// @daily, @weekly, @monthly and @every <duration>.
+type Schedule struct {
+	minute, hour, dom, month, dow uint64
+	// a day matches if it matches both fields when one of them is *, or
+	// any of them otherwise, as in crontab
+	domStar, dowStar bool
+	// fixed interval of @every schedules
+	every time.Duration
+}
+
+// cronField gives the bounds of a field of a crontab line
+type cronField struct {
+	name     string
+	min, max uint
+}
+
+var cronFields = []cronField{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+var cronDescriptors = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+}
+
+// cronHorizon bounds the search of the next time of a schedule that never
+// matches, such as the 31st of February
+const cronHorizon = 5 * 366 * 24 * time.Hour
+
+// ParseSchedule parses a crontab line or a descriptor
+func ParseSchedule(spec string) (*Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "@every ") {
+		d, err := time.ParseDuration(strings.TrimSpace(strings.TrimPrefix(spec, "@every ")))
+		if err != nil {
+			return nil, err
+		}
+		if d <= 0 {
+			return nil, errors.New("the interval must be positive")
+		}
+		return &Schedule{every: d}, nil
+	}
+	if line, ok := cronDescriptors[spec]; ok {
+		spec = line
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != len(cronFields) {
+		return nil, errors.New("expected 5 fields in schedule: " + spec)
+	}
+	bits := make([]uint64, len(fields))
+	for i, f := range fields {
+		b, err := parseCronField(f, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		bits[i] = b
+	}
+	return &Schedule{
+		minute:  bits[0],
+		hour:    bits[1],
+		dom:     bits[2],
+		month:   bits[3],
+		dow:     bits[4],
+		domStar: fields[2] == "*",
+		dowStar: fields[4] == "*",
+	}, nil
+}
+
+// parseCronField returns the values matched by a field as a bit set
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := uint(1)
+		if i := strings.Index(part, "/"); i >= 0 {
+			s, err := strconv.ParseUint(part[i+1:], 10, 8)
+			if err != nil || s == 0 {
+				return 0, errors.New("invalid step in " + f.name + ": " + part)
+			}
+			step = uint(s)
+			part = part[:i]
+		}
+
+		lo, hi := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			a, err := parseCronValue(bounds[0], f)
+			if err != nil {
+				return 0, err
+			}
+			b, err := parseCronValue(bounds[1], f)
+			if err != nil {
+				return 0, err
+			}
+			if a > b {
+				return 0, errors.New("invalid range in " + f.name + ": " + part)
+			}
+			lo, hi = a, b
+		default:
+			v, err := parseCronValue(part, f)
+			if err != nil {
+				return 0, err
+			}
+			lo = v
+			// a single value with a step starts a range
+			if step == 1 {
+				hi = v
+			}
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+func parseCronValue(s string, f cronField) (uint, error) {
+	v, err := strconv.ParseUint(s, 10, 8)
+	if err != nil || uint(v) < f.min || uint(v) > f.max {
+		return 0, errors.New("invalid " + f.name + ": " + s)
+	}
+	return uint(v), nil
+}
+
+// Interval returns a lower bound of the time between two runs of the
+// schedule
+func (s *Schedule) Interval() time.Duration {
+	if s.every > 0 {
+		return s.every
+	}
+	// schedules that match several minutes of an hour run at least every
+	// minute, the others at least every hour
+	if s.minute&(s.minute-1) != 0 {
+		return time.Minute
+	}
+	return time.Hour
+}
+
+// Next returns the first time strictly after t matched by the schedule, or
+// the zero time if the schedule never matches
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.every > 0 {
+		return t.Add(s.every)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(cronHorizon)
+	for t.Before(limit) {
+		switch {
+		case s.month&(1<<uint(t.Month())) == 0:
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+		case !s.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+		case s.hour&(1<<uint(t.Hour())) == 0:
+			t = t.Truncate(time.Hour).Add(time.Hour)
+		case s.minute&(1<<uint(t.Minute())) == 0:
+			t = t.Add(time.Minute)
+		default:
+			return t
+		}
+	}
+	return time.Time{}
+}
+
+func (s *Schedule) matchDay(t time.Time) bool {
+	dom := s.dom&(1<<uint(t.Day())) != 0
+	dow := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return dom && dow
+	}
+	return dom || dow
+}
@@ -1,17 +1,12 @@
 package lib
 
 import (
-	"crypto/aes"
-	"crypto/cipher"
-	"hash"
+	"bytes"
 
 	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/kyber/v3"
-	"golang.org/x/crypto/hkdf"
 )
 
-var sharedKeyLength = 32
-
 // DhExchange computes the shared key from a private key and a public key
 func DhExchange(ownPrivate kyber.Scalar, remotePublic kyber.Point) kyber.Point {
 	sk := cothority.Suite.Point()
@@ -19,32 +14,23 @@ func DhExchange(ownPrivate kyber.Scalar, remotePublic kyber.Point) kyber.Point {
 	return sk
 }
 
-// NewAEAD returns the AEAD cipher to be use to encrypt a share
-func NewAEAD(fn func() hash.Hash, preSharedKey kyber.Point, context []byte) (cipher.AEAD, error) {
-	preBuff, _ := preSharedKey.MarshalBinary()
-	reader := hkdf.New(fn, preBuff, nil, context)
-
-	sharedKey := make([]byte, sharedKeyLength)
-	if _, err := reader.Read(sharedKey); err != nil {
-		return nil, err
-	}
-	block, err := aes.NewCipher(sharedKey)
-	if err != nil {
-		return nil, err
-	}
-	gcm, err := cipher.NewGCM(block)
-	if err != nil {
+// PrivateResponseInfo returns the HPKE info of the encryption of the digests
+// of a conode for the client, which binds the ciphertext to the conode
+func PrivateResponseInfo(node kyber.Point) ([]byte, error) {
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte("dpcc hash private"))
+	if _, err := node.MarshalTo(&buf); err != nil {
 		return nil, err
 	}
-	return gcm, nil
+	return buf.Bytes(), nil
 }
 
-// Context returns the context slice to be used when encrypting a share
-func Context(client, server kyber.Point) []byte {
-	h := cothority.Suite.Hash()
-	_, _ = h.Write([]byte("client"))
-	_, _ = client.MarshalTo(h)
-	_, _ = h.Write([]byte("server"))
-	_, _ = server.MarshalTo(h)
-	return h.Sum(nil)
+// PrivateResponsePayload returns the payload of the statement signed by a
+// conode for its encrypted digests, so that the leader can neither replay old
+// ciphertexts nor swap the ciphertexts of two conodes
+func PrivateResponsePayload(enc, ciphertext []byte) []byte {
+	var buf bytes.Buffer
+	writeBytes(&buf, enc)
+	writeBytes(&buf, ciphertext)
+	return buf.Bytes()
 }
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"bytes"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// Formats of the canonicalized content used for diffs
+const (
+	// FormatLines is used for text, one line of the text per line
+	FormatLines = "lines"
+	// FormatDOM is used for HTML, one node of the DOM per line
+	FormatDOM = "dom"
+)
+
+// Operations of a diff edit
+const (
+	DiffDelete = "-"
+	DiffInsert = "+"
+)
+
+// maxDiffCells bounds the memory used to compute a diff, above it the whole
+// content is reported as changed
+const maxDiffCells = 16 << 20
+
+// DiffEdit is a line deleted from the first version or inserted from the
+// second one. Line numbers start at 1.
+type DiffEdit struct {
+	Op   string
+	Line int
+	Text string
+}
+
+// CanonicalContent returns the content in a canonical form suited for
+// diffs, together with its format: HTML pages are serialized with one node
+// of the DOM per line, other resources are split in lines without trailing
+// spaces
+func CanonicalContent(data []byte, contentType string) (string, []string, error) {
+	if strings.Contains(contentType, "html") {
+		lines, err := DOMLines(data)
+		return FormatDOM, lines, err
+	}
+	text := strings.Replace(string(data), "\r\n", "\n", -1)
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		lines[i] = strings.TrimRight(l, " \t")
+	}
+	return FormatLines, lines, nil
+}
+
+// DOMLines parses the HTML page and returns a line for every element, with
+// its path in the tree and its sorted attributes, and for every non-empty
+// text node, with the path of its parent and its normalized text
+func DOMLines(data []byte) ([]string, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	lines := make([]string, 0)
+	var walk func(n *html.Node, path string)
+	walk = func(n *html.Node, path string) {
+		// index of the elements among their siblings with the same tag
+		count := make(map[string]int)
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			switch c.Type {
+			case html.ElementNode:
+				count[c.Data]++
+				p := path + "/" + c.Data + "[" + strconv.Itoa(count[c.Data]) + "]"
+				lines = append(lines, p+attributes(c))
+				walk(c, p)
+			case html.TextNode:
+				text := strings.Join(strings.Fields(c.Data), " ")
+				if text != "" {
+					lines = append(lines, path+": "+text)
+				}
+			}
+		}
+	}
+	walk(doc, "")
+	return lines, nil
+}
+
+// attributes returns the attributes of the node sorted by key
+func attributes(n *html.Node) string {
+	if len(n.Attr) == 0 {
+		return ""
+	}
+	attrs := make([]string, len(n.Attr))
+	for i, a := range n.Attr {
+		attrs[i] = a.Key + "=" + strconv.Quote(a.Val)
+	}
+	sort.Strings(attrs)
+	return " " + strings.Join(attrs, " ")
+}
+
+// Diff returns the edits transforming the lines a into the lines b, computed
+// from their longest common subsequence
+func Diff(a, b []string) []*DiffEdit {
+	// skip the common prefix and suffix
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	ma := a[prefix : len(a)-suffix]
+	mb := b[prefix : len(b)-suffix]
+
+	edits := make([]*DiffEdit, 0)
+	if (len(ma)+1)*(len(mb)+1) > maxDiffCells {
+		for i, l := range ma {
+			edits = append(edits, &DiffEdit{Op: DiffDelete, Line: prefix + i + 1, Text: l})
+		}
+		for j, l := range mb {
+			edits = append(edits, &DiffEdit{Op: DiffInsert, Line: prefix + j + 1, Text: l})
+		}
+		return edits
+	}
+
+	// lcs[i][j] is the length of the longest common subsequence of
+	// ma[i:] and mb[j:]
+	lcs := make([][]int, len(ma)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(mb)+1)
+	}
+	for i := len(ma) - 1; i >= 0; i-- {
+		for j := len(mb) - 1; j >= 0; j-- {
+			if ma[i] == mb[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < len(ma) || j < len(mb) {
+		switch {
+		case i < len(ma) && j < len(mb) && ma[i] == mb[j]:
+			i++
+			j++
+		case j == len(mb) || (i < len(ma) && lcs[i+1][j] >= lcs[i][j+1]):
+			edits = append(edits, &DiffEdit{Op: DiffDelete, Line: prefix + i + 1, Text: ma[i]})
+			i++
+		default:
+			edits = append(edits, &DiffEdit{Op: DiffInsert, Line: prefix + j + 1, Text: mb[j]})
+			j++
+		}
+	}
+	return edits
+}
+
+// ContentPayload returns the payload of the statement signed by a conode to
+// attest that it saw the resource with the given canonicalized content
+func ContentPayload(format string, content []byte) []byte {
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(format))
+	writeBytes(&buf, content)
+	return buf.Bytes()
+}
@@ -0,0 +1,283 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"hash"
+	"io"
+
+	"golang.org/x/crypto/blake2b"
+)
+
+// Identifiers of the supported digest algorithms. They are part of the signed
+// data, so they must never change.
+const (
+	SHA256     = "sha256"
+	SHA512     = "sha512"
+	BLAKE2b256 = "blake2b-256"
+	BLAKE2b512 = "blake2b-512"
+)
+
+// DefaultAlgorithm is used when no digest algorithm is requested. It matches
+// the hash function of the cothority suite used before algorithms could be
+// chosen.
+const DefaultAlgorithm = SHA256
+
+// similarityMaxSize is the maximum size of the resources for which
+// similarity digests are computed, since they are kept in memory
+const similarityMaxSize = 16 << 20
+
+// Digest is a digest labeled with the algorithm that produced it
+type Digest struct {
+	Algorithm string
+	Value     []byte
+}
+
+// similarityAlgorithm computes digests that are close for resources that are
+// similar, as opposed to the exact hash functions returned by NewHash
+type similarityAlgorithm struct {
+	// digest returns nil if the resource is not supported
+	digest func(data []byte, contentType string) ([]byte, error)
+	// score returns the similarity of two digests, between 0 and 1
+	score func(a, b []byte) (float64, error)
+}
+
+var similarityAlgorithms = make(map[string]*similarityAlgorithm)
+
+func registerSimilarity(name string, digest func([]byte, string) ([]byte, error), score func(a, b []byte) (float64, error)) {
+	similarityAlgorithms[name] = &similarityAlgorithm{digest: digest, score: score}
+}
+
+// IsSimilarity returns true if the algorithm produces similarity digests
+func IsSimilarity(algorithm string) bool {
+	_, ok := similarityAlgorithms[algorithm]
+	return ok
+}
+
+// Similarity returns the similarity between 0 and 1 of two digests computed
+// with the given similarity algorithm
+func Similarity(algorithm string, a, b []byte) (float64, error) {
+	s, ok := similarityAlgorithms[algorithm]
+	if !ok {
+		return 0, errors.New("not a similarity algorithm: " + algorithm)
+	}
+	return s.score(a, b)
+}
+
+// NewHash returns a new hash function for the given algorithm identifier
+func NewHash(algorithm string) (hash.Hash, error) {
+	switch algorithm {
+	case SHA256:
+		return sha256.New(), nil
+	case SHA512:
+		return sha512.New(), nil
+	case BLAKE2b256:
+		return blake2b.New256(nil)
+	case BLAKE2b512:
+		return blake2b.New512(nil)
+	default:
+		return nil, errors.New("unsupported digest algorithm: " + algorithm)
+	}
+}
+
+// CheckAlgorithms returns the algorithms to use for a request and an error if
+// any of them is unknown or repeated. The default algorithm is added if no
+// exact hash function is requested, so that there is always an exact hash.
+func CheckAlgorithms(algorithms []string) ([]string, error) {
+	seen := make(map[string]bool)
+	exact := false
+	for _, a := range algorithms {
+		if !IsSimilarity(a) {
+			if _, err := NewHash(a); err != nil {
+				return nil, err
+			}
+			exact = true
+		}
+		if seen[a] {
+			return nil, errors.New("repeated digest algorithm: " + a)
+		}
+		seen[a] = true
+	}
+	if !exact {
+		return append([]string{DefaultAlgorithm}, algorithms...), nil
+	}
+	return algorithms, nil
+}
+
+// PrimaryAlgorithm returns the first exact hash function of the algorithms
+// checked by CheckAlgorithms, which is used to decide if conodes agree
+func PrimaryAlgorithm(algorithms []string) string {
+	for _, a := range algorithms {
+		if !IsSimilarity(a) {
+			return a
+		}
+	}
+	return DefaultAlgorithm
+}
+
+// StreamDigests fetches the resource referenced by URL and computes the
+// digests for all the given algorithms in a single pass over the body. The
+// resource is kept in memory only if similarity digests are requested, and
+// these digests are omitted for resources that are too large or that are
+// not supported by the algorithm.
+func StreamDigests(URL string, opts *FetchOptions, algorithms []string) (*ResourceInfo, []*Digest, error) {
+	return StreamDigestsTo(URL, opts, algorithms, nil)
+}
+
+// StreamDigestsTo is StreamDigests that also copies the body to w, if it is
+// not nil
+func StreamDigestsTo(URL string, opts *FetchOptions, algorithms []string, w io.Writer) (*ResourceInfo, []*Digest, error) {
+	hashers := make(map[string]hash.Hash)
+	writers := make([]io.Writer, 0, len(algorithms)+2)
+	var content *LimitedBuffer
+	for _, a := range algorithms {
+		if IsSimilarity(a) {
+			if content == nil {
+				content = &LimitedBuffer{Limit: similarityMaxSize}
+				writers = append(writers, content)
+			}
+			continue
+		}
+		h, err := NewHash(a)
+		if err != nil {
+			return nil, nil, err
+		}
+		hashers[a] = h
+		writers = append(writers, h)
+	}
+	if len(writers) == 0 {
+		return nil, nil, errors.New("no digest algorithm provided")
+	}
+	if w != nil {
+		writers = append(writers, w)
+	}
+
+	info, err := StreamResource(URL, opts, io.MultiWriter(writers...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	digests := make([]*Digest, 0, len(algorithms))
+	for _, a := range algorithms {
+		var v []byte
+		if h, ok := hashers[a]; ok {
+			v = h.Sum(nil)
+		} else if !content.Overflow {
+			v, err = similarityAlgorithms[a].digest(content.Bytes(), info.ContentType)
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		if v != nil {
+			digests = append(digests, &Digest{Algorithm: a, Value: v})
+		}
+	}
+
+	return info, digests, nil
+}
+
+// LimitedBuffer stores the data written to it up to a limit, and only
+// records that the limit has been exceeded afterwards
+type LimitedBuffer struct {
+	bytes.Buffer
+	Limit    int
+	Overflow bool
+}
+
+func (b *LimitedBuffer) Write(p []byte) (int, error) {
+	if b.Overflow || b.Len()+len(p) > b.Limit {
+		b.Overflow = true
+		b.Reset()
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
+
+// FindDigest returns the value of the digest computed with the given
+// algorithm, or nil if there is none
+func FindDigest(digests []*Digest, algorithm string) []byte {
+	for _, d := range digests {
+		if d.Algorithm == algorithm {
+			return d.Value
+		}
+	}
+	return nil
+}
+
+// EncodeDigests returns an unambiguous encoding of the digests, including
+// their algorithm identifiers, to be signed or encrypted
+func EncodeDigests(digests []*Digest) []byte {
+	var buf bytes.Buffer
+	writeUint32(&buf, uint32(len(digests)))
+	for _, d := range digests {
+		writeBytes(&buf, []byte(d.Algorithm))
+		writeBytes(&buf, d.Value)
+	}
+	return buf.Bytes()
+}
+
+// DecodeDigests parses digests encoded by EncodeDigests
+func DecodeDigests(b []byte) ([]*Digest, error) {
+	r := bytes.NewReader(b)
+	n, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	// every digest takes at least 8 bytes
+	if uint64(n)*8 > uint64(r.Len()) {
+		return nil, errors.New("invalid number of digests")
+	}
+	digests := make([]*Digest, n)
+	for i := range digests {
+		a, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		v, err := readBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		digests[i] = &Digest{Algorithm: string(a), Value: v}
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing data after digests")
+	}
+	return digests, nil
+}
+
+func writeUint32(w io.Writer, v uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], v)
+	w.Write(b[:])
+}
+
+func writeBytes(w io.Writer, b []byte) {
+	writeUint32(w, uint32(len(b)))
+	w.Write(b)
+}
+
+func readUint32(r io.Reader) (uint32, error) {
+	var b [4]byte
+	if _, err := io.ReadFull(r, b[:]); err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint32(b[:]), nil
+}
+
+func readBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if int64(n) > int64(r.Len()) {
+		return nil, errors.New("length exceeds the remaining data")
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r, b); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,200 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof/dleq"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3"
+)
+
+// distKeyDomain separates the signatures of distributed keys from any other
+// signature made with the service keys
+const distKeyDomain = "dpcc distributed key"
+
+// SignedCiphertext is a ciphertext sent by a conode in a protocol run, signed
+// with the nonce of the client
+type SignedCiphertext struct {
+	PublicKey  kyber.Point
+	Ciphertext *ElGamalCiphertext
+	Signature  []byte
+}
+
+// Blinding is the blinding of ciphertexts by a node, with the proofs that
+// every ciphertext has been multiplied by a non-zero scalar
+type Blinding struct {
+	PublicKey   kyber.Point
+	Ciphertexts []*ElGamalCiphertext
+	Proofs      []*dleq.Proof
+}
+
+// PartialDecryption is the partial decryption of ciphertexts by a holder of
+// the share of the given index of the distributed key, with the proofs that
+// the share has been used
+type PartialDecryption struct {
+	PublicKey kyber.Point
+	Index     int
+	Partials  []kyber.Point
+	Proofs    []*dleq.Proof
+}
+
+// distKeyMessage returns the message signed by the conodes of the roster for
+// the commitments of the shares of its distributed key, whatever the order of
+// the roster
+func distKeyMessage(r *onet.Roster, commits []kyber.Point) ([]byte, error) {
+	keys := []string{}
+	for _, p := range ServicePublics(r) {
+		b, err := p.MarshalBinary()
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, string(b))
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(distKeyDomain))
+	writeUint32(&buf, uint32(len(keys)))
+	for _, k := range keys {
+		writeBytes(&buf, []byte(k))
+	}
+	writeUint32(&buf, uint32(len(commits)))
+	for _, c := range commits {
+		if c == nil {
+			return nil, errors.New("invalid commitment of distributed key")
+		}
+		if _, err := c.MarshalTo(&buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// SignDistKey signs the commitments of the shares of the distributed key of
+// the roster, whose first one is the key, so that the clients can verify the
+// partial decryptions of the conodes
+func SignDistKey(private kyber.Scalar, r *onet.Roster, commits []kyber.Point) ([]byte, error) {
+	msg, err := distKeyMessage(r, commits)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.Sign(cothority.Suite, private, msg)
+}
+
+// VerifyDistKey checks that every conode of the roster signed the
+// commitments of the distributed key. The signatures are indexed by the
+// service public keys of the conodes.
+func VerifyDistKey(r *onet.Roster, commits []kyber.Point, sigs map[string][]byte) error {
+	if len(commits) == 0 || len(commits) > len(r.List) {
+		return errors.New("invalid commitments of distributed key")
+	}
+	msg, err := distKeyMessage(r, commits)
+	if err != nil {
+		return err
+	}
+	for _, p := range ServicePublics(r) {
+		sig, ok := sigs[p.String()]
+		if !ok {
+			return errors.New("distributed key not signed by " + p.String())
+		}
+		if err := schnorr.Verify(cothority.Suite, p, msg, sig); err != nil {
+			return errors.New("invalid signature of distributed key by " + p.String())
+		}
+	}
+	return nil
+}
+
+// SharePublic returns the public key of the share of the given index of the
+// distributed key
+func SharePublic(commits []kyber.Point, index int) kyber.Point {
+	return share.NewPubPoly(cothority.Suite, nil, commits).Eval(index).V
+}
+
+// VerifySignedCiphertexts checks that the ciphertexts have been signed for
+// the nonce by distinct conodes of the given ones, all of them
+func VerifySignedCiphertexts(protocol string, nonce []byte, conodes []kyber.Point, cts []*SignedCiphertext) error {
+	expected := make(map[string]bool)
+	for _, p := range conodes {
+		expected[p.String()] = true
+	}
+	if len(cts) != len(expected) {
+		return errors.New("not one ciphertext per conode")
+	}
+	for _, ct := range cts {
+		if ct == nil || ct.PublicKey == nil || !expected[ct.PublicKey.String()] {
+			return errors.New("ciphertext of an unexpected conode")
+		}
+		delete(expected, ct.PublicKey.String())
+		if err := VerifyCiphertexts(ct.PublicKey, protocol, nonce,
+			[]*ElGamalCiphertext{ct.Ciphertext}, ct.Signature); err != nil {
+			return errors.New("invalid signature of ciphertext of " + ct.PublicKey.String())
+		}
+	}
+	return nil
+}
+
+// SortSignedCiphertexts returns the ciphertexts sorted by the public keys of
+// their signers
+func SortSignedCiphertexts(cts []*SignedCiphertext) []*SignedCiphertext {
+	sorted := append([]*SignedCiphertext{}, cts...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].PublicKey.String() < sorted[j].PublicKey.String()
+	})
+	return sorted
+}
+
+// PartialDecryptProof returns the partial decryptions of the ciphertexts with
+// the share, with their proofs
+func PartialDecryptProof(public kyber.Point, s *share.PriShare, cts []*ElGamalCiphertext) (*PartialDecryption, error) {
+	p := &PartialDecryption{
+		PublicKey: public,
+		Index:     s.I,
+		Partials:  make([]kyber.Point, len(cts)),
+		Proofs:    make([]*dleq.Proof, len(cts)),
+	}
+	for i, ct := range cts {
+		var err error
+		p.Proofs[i], _, p.Partials[i], err = dleq.NewDLEQProof(cothority.Suite,
+			cothority.Suite.Point().Base(), ct.K, s.V)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return p, nil
+}
+
+// Verify checks that the partial decryptions of the ciphertexts have been
+// computed with the share of the distributed key with the commitments
+func (p *PartialDecryption) Verify(commits []kyber.Point, cts []*ElGamalCiphertext) error {
+	if len(p.Partials) != len(cts) || len(p.Proofs) != len(cts) {
+		return errors.New("partial decryption of a different size")
+	}
+	if p.Index < 0 {
+		return errors.New("invalid index of share")
+	}
+	public := SharePublic(commits, p.Index)
+	for i, ct := range cts {
+		if p.Partials[i] == nil || p.Proofs[i] == nil {
+			return errors.New("incomplete partial decryption")
+		}
+		if err := p.Proofs[i].Verify(cothority.Suite, cothority.Suite.Point().Base(),
+			ct.K, public, p.Partials[i]); err != nil {
+			return errors.New("invalid proof of partial decryption")
+		}
+	}
+	return nil
+}
+
+// PubShares labels the partial decryptions with the index of their share
+func (p *PartialDecryption) PubShares() []*share.PubShare {
+	shares := make([]*share.PubShare, len(p.Partials))
+	for i, v := range p.Partials {
+		shares[i] = &share.PubShare{I: p.Index, V: v}
+	}
+	return shares
+}
@@ -0,0 +1,241 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/net/html"
+)
+
+// DOMHashes stores the hashes of the DOM of a page: a hash for every subtree,
+// which does not depend on the position of the subtree in the page, and a
+// hash for the shell of every element, i.e. its path, tag and attributes
+// without its children
+type DOMHashes struct {
+	Subtrees [][]byte
+	Shells   [][]byte
+}
+
+// domNode stores the hashes of a node of the DOM
+type domNode struct {
+	subtree []byte
+	shell   []byte
+}
+
+// parseDOMHashes parses the HTML page and hashes all its subtrees and shells
+func parseDOMHashes(data []byte) (*html.Node, map[*html.Node]*domNode, error) {
+	doc, err := html.Parse(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	nodes := make(map[*html.Node]*domNode)
+	hashDOM(doc, "", nodes)
+	return doc, nodes, nil
+}
+
+// NewDOMHashes returns the hashes of the DOM of the HTML page, to be sent to
+// the leader of a structural consensus
+func NewDOMHashes(data []byte) (*DOMHashes, error) {
+	_, nodes, err := parseDOMHashes(data)
+	if err != nil {
+		return nil, err
+	}
+	subtrees := make(map[string]bool)
+	shells := make(map[string]bool)
+	for _, n := range nodes {
+		subtrees[string(n.subtree)] = true
+		if n.shell != nil {
+			shells[string(n.shell)] = true
+		}
+	}
+	return &DOMHashes{
+		Subtrees: sortedKeys(subtrees),
+		Shells:   sortedKeys(shells),
+	}, nil
+}
+
+// hashDOM computes the hashes of the node and of its descendants. Comments
+// and white spaces are ignored, as in the canonical content.
+func hashDOM(n *html.Node, path string, nodes map[*html.Node]*domNode) []byte {
+	h := sha256.New()
+	switch n.Type {
+	case html.TextNode:
+		text := strings.Join(strings.Fields(n.Data), " ")
+		if text == "" {
+			return nil
+		}
+		writeBytes(h, []byte("text"))
+		writeBytes(h, []byte(text))
+		nodes[n] = &domNode{subtree: h.Sum(nil)}
+		return nodes[n].subtree
+	case html.ElementNode, html.DocumentNode:
+		writeBytes(h, []byte("element"))
+		writeBytes(h, []byte(n.Data+attributes(n)))
+	default:
+		return nil
+	}
+
+	count := make(map[string]int)
+	for c := n.FirstChild; c != nil; c = c.NextSibling {
+		p := path
+		if c.Type == html.ElementNode {
+			count[c.Data]++
+			p = path + "/" + c.Data + "[" + strconv.Itoa(count[c.Data]) + "]"
+		}
+		if child := hashDOM(c, p, nodes); child != nil {
+			writeBytes(h, child)
+		}
+	}
+
+	shell := sha256.New()
+	writeBytes(shell, []byte("shell"))
+	writeBytes(shell, []byte(path+attributes(n)))
+	nodes[n] = &domNode{subtree: h.Sum(nil), shell: shell.Sum(nil)}
+	return nodes[n].subtree
+}
+
+// DOMCounter returns the number of conodes that observed a subtree or shell
+// hash
+type DOMCounter func(hash []byte) int
+
+// CountDOMHashes returns a counter of the hashes observed by the conodes
+func CountDOMHashes(observed []*DOMHashes) DOMCounter {
+	counts := make(map[string]int)
+	for _, o := range observed {
+		for _, s := range uniqueKeys(o.Subtrees) {
+			counts[s]++
+		}
+		for _, s := range uniqueKeys(o.Shells) {
+			counts[s]++
+		}
+	}
+	return func(hash []byte) int {
+		return counts[string(hash)]
+	}
+}
+
+// ConsensusDocument returns the page, as seen by the leader, keeping only the
+// nodes that a threshold of conodes observed: a subtree is kept entirely if
+// its hash has been observed enough times, otherwise an element is kept with
+// its filtered children if its shell has been observed enough times, and it
+// is removed in all other cases
+func ConsensusDocument(data []byte, count DOMCounter, threshold int) ([]byte, error) {
+	if threshold <= 0 {
+		return nil, errors.New("threshold must be positive")
+	}
+
+	doc, nodes, err := parseDOMHashes(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var filter func(n *html.Node)
+	filter = func(n *html.Node) {
+		for c := n.FirstChild; c != nil; {
+			next := c.NextSibling
+			hashes, ok := nodes[c]
+			switch {
+			case !ok:
+				// comments and white spaces are not part of the
+				// consensus
+				if c.Type != html.TextNode && c.Type != html.DoctypeNode {
+					n.RemoveChild(c)
+				}
+			case count(hashes.subtree) >= threshold:
+				// the whole subtree is kept
+			case hashes.shell != nil && count(hashes.shell) >= threshold:
+				filter(c)
+			default:
+				n.RemoveChild(c)
+			}
+			c = next
+		}
+	}
+	filter(doc)
+
+	var buf bytes.Buffer
+	if err := html.Render(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// ObservedDocument returns true if every node of the consensus document has
+// been observed in the page whose hashes are given: either its whole subtree
+// or, for an element whose children have been filtered, its shell
+func ObservedDocument(document []byte, observed *DOMHashes) (bool, error) {
+	doc, nodes, err := parseDOMHashes(document)
+	if err != nil {
+		return false, err
+	}
+	subtrees := make(map[string]bool)
+	for _, s := range observed.Subtrees {
+		subtrees[string(s)] = true
+	}
+	shells := make(map[string]bool)
+	for _, s := range observed.Shells {
+		shells[string(s)] = true
+	}
+
+	var check func(n *html.Node) bool
+	check = func(n *html.Node) bool {
+		for c := n.FirstChild; c != nil; c = c.NextSibling {
+			hashes, ok := nodes[c]
+			switch {
+			case !ok, subtrees[string(hashes.subtree)]:
+			case hashes.shell != nil && shells[string(hashes.shell)]:
+				if !check(c) {
+					return false
+				}
+			default:
+				return false
+			}
+		}
+		return true
+	}
+	return check(doc), nil
+}
+
+// DOMConsensusFlags returns the flags of the statement collectively signed
+// for a consensus document, depending on how the filters were combined
+func DOMConsensusFlags(private, encrypted bool) uint32 {
+	flags := StatementCollective
+	if private {
+		flags |= StatementMaskedFilters
+	}
+	if encrypted {
+		flags |= StatementEncryptedFilters
+	}
+	return flags
+}
+
+func sortedKeys(m map[string]bool) [][]byte {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	b := make([][]byte, len(keys))
+	for i, k := range keys {
+		b[i] = []byte(k)
+	}
+	return b
+}
+
+// uniqueKeys returns the distinct values, so that a conode cannot count
+// more than once for the same node
+func uniqueKeys(values [][]byte) []string {
+	seen := make(map[string]bool)
+	keys := make([]string, 0, len(values))
+	for _, v := range values {
+		if !seen[string(v)] {
+			seen[string(v)] = true
+			keys = append(keys, string(v))
+		}
+	}
+	return keys
+}
@@ -0,0 +1,172 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"strconv"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+// ciphertextsDomain separates the signatures of ciphertexts from any other
+// signature made with the service keys
+const ciphertextsDomain = "dpcc ciphertexts"
+
+// Default parameters of the encrypted Bloom filters, which are smaller than
+// the masked ones since every cell is an ElGamal ciphertext
+const (
+	DefaultEncryptedFilterElements = 1024
+	DefaultEncryptedFilterFPRate   = 0.01
+)
+
+// ElGamalCiphertext is the exponential ElGamal encryption (rG, rX + mG) of a
+// small integer m, so that adding two ciphertexts adds their plaintexts
+type ElGamalCiphertext struct {
+	K kyber.Point
+	C kyber.Point
+}
+
+// EqualCiphertexts returns true if the two vectors hold the same ciphertexts
+func EqualCiphertexts(a, b []*ElGamalCiphertext) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] == nil || b[i] == nil || a[i].K == nil || b[i].K == nil ||
+			a[i].C == nil || b[i].C == nil {
+			return false
+		}
+		if !a[i].K.Equal(b[i].K) || !a[i].C.Equal(b[i].C) {
+			return false
+		}
+	}
+	return true
+}
+
+// ciphertextsMessage returns the message signed by a conode for the
+// ciphertexts it sent in a protocol run for the nonce of the client
+func ciphertextsMessage(protocol string, nonce []byte, cts []*ElGamalCiphertext) ([]byte, error) {
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(ciphertextsDomain))
+	writeBytes(&buf, []byte(protocol))
+	writeBytes(&buf, nonce)
+	writeUint32(&buf, uint32(len(cts)))
+	for _, ct := range cts {
+		if ct == nil || ct.K == nil || ct.C == nil {
+			return nil, errors.New("invalid ciphertext")
+		}
+		if _, err := ct.K.MarshalTo(&buf); err != nil {
+			return nil, err
+		}
+		if _, err := ct.C.MarshalTo(&buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
+
+// SignCiphertexts signs the ciphertexts sent by a conode in a protocol run
+// for the nonce of the client, so that nobody can replace or reuse them
+func SignCiphertexts(private kyber.Scalar, protocol string, nonce []byte, cts []*ElGamalCiphertext) ([]byte, error) {
+	msg, err := ciphertextsMessage(protocol, nonce, cts)
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.Sign(cothority.Suite, private, msg)
+}
+
+// VerifyCiphertexts verifies the signature of the ciphertexts by a conode
+func VerifyCiphertexts(public kyber.Point, protocol string, nonce []byte, cts []*ElGamalCiphertext, sig []byte) error {
+	msg, err := ciphertextsMessage(protocol, nonce, cts)
+	if err != nil {
+		return err
+	}
+	return schnorr.Verify(cothority.Suite, public, msg, sig)
+}
+
+// EncryptCells encrypts every cell of the vector under the distributed public
+// key
+func EncryptCells(public kyber.Point, cells []uint32) []*ElGamalCiphertext {
+	cts := make([]*ElGamalCiphertext, len(cells))
+	for i, m := range cells {
+		r := cothority.Suite.Scalar().Pick(random.New())
+		c := cothority.Suite.Point().Mul(r, public)
+		c.Add(c, cothority.Suite.Point().Mul(cothority.Suite.Scalar().SetInt64(int64(m)), nil))
+		cts[i] = &ElGamalCiphertext{
+			K: cothority.Suite.Point().Mul(r, nil),
+			C: c,
+		}
+	}
+	return cts
+}
+
+// AddCiphertexts adds the ciphertexts of src to the ones of dst, cell by
+// cell. A nil dst cell is initialized with the src one.
+func AddCiphertexts(dst, src []*ElGamalCiphertext) error {
+	if len(dst) != len(src) {
+		return errors.New("encrypted filters of different sizes")
+	}
+	for i, s := range src {
+		if s == nil || s.K == nil || s.C == nil {
+			return errors.New("invalid ciphertext")
+		}
+		if dst[i] == nil {
+			dst[i] = &ElGamalCiphertext{
+				K: cothority.Suite.Point().Set(s.K),
+				C: cothority.Suite.Point().Set(s.C),
+			}
+			continue
+		}
+		dst[i].K.Add(dst[i].K, s.K)
+		dst[i].C.Add(dst[i].C, s.C)
+	}
+	return nil
+}
+
+// PartialDecrypt returns the share xK of the decryption of every ciphertext
+// by a holder of a part x of the collective private key
+func PartialDecrypt(private kyber.Scalar, cts []*ElGamalCiphertext) []kyber.Point {
+	partials := make([]kyber.Point, len(cts))
+	for i, ct := range cts {
+		partials[i] = cothority.Suite.Point().Mul(private, ct.K)
+	}
+	return partials
+}
+
+// DecryptCounts combines the partial decryptions of t out of the n holders
+// of shares of the distributed private key and returns the plaintexts, which
+// must be between 0 and max so that their discrete logarithm can be found
+func DecryptCounts(cts []*ElGamalCiphertext, partials [][]*share.PubShare, t, n, max int) ([]uint32, error) {
+	// table of the discrete logarithms of the possible plaintexts
+	logs := make(map[string]uint32, max+1)
+	p := cothority.Suite.Point().Null()
+	for i := 0; i <= max; i++ {
+		logs[p.String()] = uint32(i)
+		p = cothority.Suite.Point().Add(p, cothority.Suite.Point().Base())
+	}
+
+	counts := make([]uint32, len(cts))
+	for i, ct := range cts {
+		shares := make([]*share.PubShare, len(partials))
+		for j, ps := range partials {
+			if len(ps) != len(cts) {
+				return nil, errors.New("partial decryption of a different size")
+			}
+			shares[j] = ps[i]
+		}
+		m, err := ThresholdDecrypt(ct, shares, t, n)
+		if err != nil {
+			return nil, err
+		}
+		c, ok := logs[m.String()]
+		if !ok {
+			return nil, errors.New("invalid decryption of cell " + strconv.Itoa(i))
+		}
+		counts[i] = c
+	}
+	return counts, nil
+}
@@ -0,0 +1,197 @@
+package lib
+
+import (
+	"errors"
+	"sort"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof/dleq"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+// HashToPoint maps a digest to a point whose discrete logarithm is unknown,
+// so that equal digests give equal points
+func HashToPoint(digest []byte) kyber.Point {
+	h := cothority.Suite.Hash()
+	h.Write([]byte("hash-to-point"))
+	h.Write(digest)
+	return cothority.Suite.Point().Pick(cothority.Suite.XOF(h.Sum(nil)))
+}
+
+// EncryptPoint encrypts the point with ElGamal under the public key
+func EncryptPoint(public, m kyber.Point) *ElGamalCiphertext {
+	r := cothority.Suite.Scalar().Pick(random.New())
+	c := cothority.Suite.Point().Mul(r, public)
+	return &ElGamalCiphertext{
+		K: cothority.Suite.Point().Mul(r, nil),
+		C: c.Add(c, m),
+	}
+}
+
+// SubCiphertext returns the encryption of the difference of the plaintexts
+// of a and b
+func SubCiphertext(a, b *ElGamalCiphertext) *ElGamalCiphertext {
+	return &ElGamalCiphertext{
+		K: cothority.Suite.Point().Sub(a.K, b.K),
+		C: cothority.Suite.Point().Sub(a.C, b.C),
+	}
+}
+
+// EqualityDifferences returns the encrypted differences of the plaintexts of
+// every pair of ciphertexts, in the order of the ciphertexts
+func EqualityDifferences(cts []*ElGamalCiphertext) []*ElGamalCiphertext {
+	differences := []*ElGamalCiphertext{}
+	for i, a := range cts {
+		for _, b := range cts[i+1:] {
+			differences = append(differences, SubCiphertext(a, b))
+		}
+	}
+	return differences
+}
+
+// BlindCiphertexts multiplies every ciphertext by a fresh random non-zero
+// scalar: the plaintext stays the null point if it was, and becomes a random
+// point otherwise. Every blinding comes with a proof that both points of the
+// ciphertext have been multiplied by the same scalar.
+func BlindCiphertexts(public kyber.Point, cts []*ElGamalCiphertext) (*Blinding, error) {
+	b := &Blinding{
+		PublicKey:   public,
+		Ciphertexts: make([]*ElGamalCiphertext, len(cts)),
+		Proofs:      make([]*dleq.Proof, len(cts)),
+	}
+	for i, ct := range cts {
+		z := cothority.Suite.Scalar().Pick(random.New())
+		for z.Equal(cothority.Suite.Scalar().Zero()) {
+			z.Pick(random.New())
+		}
+		proof, k, c, err := dleq.NewDLEQProof(cothority.Suite, ct.K, ct.C, z)
+		if err != nil {
+			return nil, err
+		}
+		b.Ciphertexts[i] = &ElGamalCiphertext{K: k, C: c}
+		b.Proofs[i] = proof
+	}
+	return b, nil
+}
+
+// Verify checks that the blinded ciphertexts are the given ones multiplied
+// by non-zero scalars, so that a difference of equal digests cannot be
+// turned into a difference of distinct ones nor the other way round
+func (b *Blinding) Verify(cts []*ElGamalCiphertext) error {
+	if len(b.Ciphertexts) != len(cts) || len(b.Proofs) != len(cts) {
+		return errors.New("blinding of a different size")
+	}
+	null := cothority.Suite.Point().Null()
+	for i, ct := range cts {
+		blinded := b.Ciphertexts[i]
+		if blinded == nil || blinded.K == nil || blinded.C == nil || b.Proofs[i] == nil {
+			return errors.New("incomplete blinding")
+		}
+		if blinded.K.Equal(null) {
+			return errors.New("blinding by zero")
+		}
+		if err := b.Proofs[i].Verify(cothority.Suite, ct.K, ct.C,
+			blinded.K, blinded.C); err != nil {
+			return errors.New("invalid proof of blinding")
+		}
+	}
+	return nil
+}
+
+// SumBlindings adds the blinded ciphertexts of all the blindings
+func SumBlindings(blindings []*Blinding) ([]*ElGamalCiphertext, error) {
+	if len(blindings) == 0 {
+		return nil, errors.New("no blinding")
+	}
+	sums := make([]*ElGamalCiphertext, len(blindings[0].Ciphertexts))
+	for i, ct := range blindings[0].Ciphertexts {
+		sums[i] = &ElGamalCiphertext{K: ct.K.Clone(), C: ct.C.Clone()}
+	}
+	for _, b := range blindings[1:] {
+		if err := AddCiphertexts(sums, b.Ciphertexts); err != nil {
+			return nil, err
+		}
+	}
+	return sums, nil
+}
+
+// EqualityResults decrypts the sums of the blinded differences from the
+// partial decryptions of the holders of t out of the n shares, and returns
+// for every pair whether the digests are equal
+func EqualityResults(sums []*ElGamalCiphertext, partials []*PartialDecryption, t, n int) ([]bool, error) {
+	equal := make([]bool, len(sums))
+	for i, ct := range sums {
+		shares := make([]*share.PubShare, len(partials))
+		for k, p := range partials {
+			shares[k] = &share.PubShare{I: p.Index, V: p.Partials[i]}
+		}
+		m, err := ThresholdDecrypt(ct, shares, t, n)
+		if err != nil {
+			return nil, err
+		}
+		equal[i] = m.Equal(m.Clone().Null())
+	}
+	return equal, nil
+}
+
+// EqualityGroups groups the nodes whose digests are equal, given the results
+// for every pair in the order of EqualityDifferences, the largest groups
+// first
+func EqualityGroups(nodes []string, equal []bool) ([][]string, error) {
+	if len(equal) != len(nodes)*(len(nodes)-1)/2 {
+		return nil, errors.New("not one result per pair of nodes")
+	}
+
+	// the groups are built with a union-find over the equal pairs
+	parent := make([]int, len(nodes))
+	for i := range parent {
+		parent[i] = i
+	}
+	var find func(i int) int
+	find = func(i int) int {
+		if parent[i] != i {
+			parent[i] = find(parent[i])
+		}
+		return parent[i]
+	}
+
+	pair := 0
+	for i := range nodes {
+		for j := i + 1; j < len(nodes); j++ {
+			if equal[pair] {
+				parent[find(j)] = find(i)
+			}
+			pair++
+		}
+	}
+
+	members := make(map[int][]string)
+	for i, pk := range nodes {
+		members[find(i)] = append(members[find(i)], pk)
+	}
+	groups := [][]string{}
+	for i := range nodes {
+		if g, ok := members[i]; ok {
+			groups = append(groups, g)
+		}
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return len(groups[i]) > len(groups[j])
+	})
+	return groups, nil
+}
+
+// ThresholdDecrypt decrypts the ciphertext from the partial decryptions of t
+// out of the n holders of shares of the distributed private key
+func ThresholdDecrypt(ct *ElGamalCiphertext, partials []*share.PubShare, t, n int) (kyber.Point, error) {
+	if len(partials) < t {
+		return nil, errors.New("not enough partial decryptions")
+	}
+	sK, err := share.RecoverCommit(cothority.Suite, partials, t, n)
+	if err != nil {
+		return nil, err
+	}
+	return cothority.Suite.Point().Sub(ct.C, sK), nil
+}
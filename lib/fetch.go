@@ -2,12 +2,13 @@ package lib
 
 import (
 	"bytes"
+	"context"
 	"errors"
-	"io/ioutil"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
+	"time"
 
 	"github.com/PuerkitoBio/goquery"
 	"go.dedis.ch/onet/v3/log"
@@ -26,6 +27,51 @@ type Resource struct {
 // this function only fetches the main content referenced by the URL and not
 // other contents present in the resource.
 func FetchMainResource(URL string) (*Resource, error) {
+	// the whole resource is kept in memory, use StreamResource for large
+	// resources
+	buf := new(bytes.Buffer)
+	info, err := StreamResource(URL, nil, buf)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Resource{
+		URL:         URL,
+		ContentType: info.ContentType,
+		Data:        buf.Bytes(),
+	}
+
+	return r, nil
+}
+
+// clients of the fetches, which share their transports so that idle
+// connections are reused instead of being left open by every fetch
+var (
+	httpClient = &http.Client{Transport: &http.Transport{
+		Proxy:           http.ProxyFromEnvironment,
+		MaxIdleConns:    100,
+		IdleConnTimeout: 90 * time.Second,
+	}}
+	fileClient = newFileClient()
+)
+
+// newFileClient returns the client of the file scheme.
+// This can be very dangerous, because we give the client access to the
+// conodes file. We allow to use file as scheme only for research purposes.
+func newFileClient() *http.Client {
+	// The typical use case for NewFileTransport is to register the
+	// "file" protocol with a Transport.
+	t := &http.Transport{}
+	// we want to access only the upper folder and nothing else
+	t.RegisterProtocol("file", http.NewFileTransport(http.Dir("..")))
+	return &http.Client{Transport: t}
+}
+
+// openResource sends the request for the resource referenced by URL and
+// returns the response, whose body has to be closed by the caller. The
+// request is canceled if the response header doesn't arrive within the
+// progress timeout of opts.
+func openResource(ctx context.Context, cancel context.CancelFunc, URL string, opts *FetchOptions) (*http.Response, error) {
 	// parse the URL to see if there is any problem
 	u, err := url.Parse(URL)
 	if err != nil {
@@ -33,64 +79,53 @@ func FetchMainResource(URL string) (*Resource, error) {
 	}
 
 	// we handle the request depending on the scheme specified in the url
-	var res *http.Response
+	var c *http.Client
 	switch u.Scheme {
 	case "http", "https":
-		res, err = http.Get(u.String())
-		if err != nil {
-			return nil, err
-		}
-		if res.StatusCode != 200 {
-			s := strconv.Itoa(res.StatusCode)
-			return nil, errors.New("status code " + s + " different from 200, aborting")
-		}
-		defer res.Body.Close()
+		c = httpClient
 	case "file":
-		// this can be very dangerous, because we give the client access
-		// to the conodes file. We allow to use file as scheme only for
-		// research purposes
-
-		// The typical use case for NewFileTransport is to register the
-		// "file" protocol with a Transport.
-		t := &http.Transport{}
-		// we want to access only the upper folder and nothing else
-		t.RegisterProtocol("file", http.NewFileTransport(http.Dir("..")))
-		c := &http.Client{Transport: t}
-		res, err = c.Get(u.String())
-		if err != nil {
-			return nil, err
-		}
-		defer res.Body.Close()
+		c = fileClient
 	default:
 		return nil, errors.New("scheme not supported")
-
 	}
 
-	// from now on the procedure is the same for http, https, and file:///
-
-	// get data
-	b, err := ioutil.ReadAll(res.Body)
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
 	if err != nil {
 		return nil, err
 	}
+	var timer *time.Timer
+	if opts.ProgressTimeout > 0 {
+		timer = time.AfterFunc(opts.ProgressTimeout, cancel)
+	}
+	res, err := c.Do(req.WithContext(ctx))
+	// the request is canceled if the timer fired, even if the header
+	// arrived meanwhile
+	if timer != nil && !timer.Stop() {
+		if err == nil {
+			res.Body.Close()
+		}
+		return nil, ErrProgressTimeout
+	}
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != 200 {
+		res.Body.Close()
+		s := strconv.Itoa(res.StatusCode)
+		return nil, errors.New("status code " + s + " different from 200, aborting")
+	}
 
-	// get content type
-	ct := res.Header.Get("Content-Type")
+	return res, nil
+}
 
-	// test if content type is supported: for now we handle
-	// text/html, text/css, and image/*
+// checkContentType returns an error if the content type is not supported:
+// for now we handle text/html, text/css, and image/*
+func checkContentType(ct string) error {
 	re := regexp.MustCompile(`html|image|css`)
 	if !re.MatchString(ct) {
-		return nil, errors.New("unsupported content type: " + ct)
-	}
-
-	r := &Resource{
-		URL:         URL,
-		ContentType: ct,
-		Data:        b,
+		return errors.New("unsupported content type: " + ct)
 	}
-
-	return r, nil
+	return nil
 }
 
 // FetchAllResources fetches the main content and (part of) the files
@@ -121,6 +156,12 @@ func FetchAllResources(URL string) ([]*Resource, error) {
 	return resources, nil
 }
 
+// SubresourceLinks returns the absolute URLs of the style sheets and images
+// of an HTML page
+func SubresourceLinks(pageURL string, page []byte) []string {
+	return scrapeLinks(pageURL, bytes.NewBuffer(page))
+}
+
 // scrapeLinks returns a list of strings extracted from the page referenced by
 // pageURL
 func scrapeLinks(pageURL string, page *bytes.Buffer) []string {
@@ -0,0 +1,218 @@
+package lib
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+
+	"golang.org/x/crypto/curve25519"
+)
+
+// HPKESuite identifies the HPKE (RFC 9180) suite used to encrypt the private
+// responses, in base mode
+const HPKESuite = "DHKEM(X25519, HKDF-SHA256), HKDF-SHA256, AES-128-GCM"
+
+// identifiers of the algorithms of the suite, from the IANA registries
+const (
+	hpkeKEMX25519     = 0x0020
+	hpkeKDFHKDFSHA256 = 0x0001
+	hpkeAEADAES128GCM = 0x0001
+)
+
+// sizes of the keys and secrets of the suite
+const (
+	hpkeNsecret = 32
+	hpkeNk      = 16
+	hpkeNn      = 12
+	hpkeNh      = 32
+	x25519Len   = 32
+)
+
+const hpkeModeBase = 0x00
+
+var (
+	hpkeVersion = []byte("HPKE-v1")
+	kemSuiteID  = []byte{'K', 'E', 'M', 0x00, hpkeKEMX25519}
+	hpkeSuiteID = []byte{'H', 'P', 'K', 'E',
+		0x00, hpkeKEMX25519, 0x00, hpkeKDFHKDFSHA256, 0x00, hpkeAEADAES128GCM}
+)
+
+// HPKEGenerateKeyPair generates an X25519 key pair for the receiver of HPKE
+// messages
+func HPKEGenerateKeyPair() ([]byte, []byte, error) {
+	ikm := make([]byte, x25519Len)
+	if _, err := rand.Read(ikm); err != nil {
+		return nil, nil, err
+	}
+	return hpkeDeriveKeyPair(ikm)
+}
+
+// HPKESeal encrypts the plaintext to the public key of the receiver in a
+// single shot, and returns the encapsulated key and the ciphertext. The info
+// binds the encryption to its context, the additional data is authenticated
+// but not encrypted.
+func HPKESeal(pkR, info, aad, plaintext []byte) ([]byte, []byte, error) {
+	ikmE := make([]byte, x25519Len)
+	if _, err := rand.Read(ikmE); err != nil {
+		return nil, nil, err
+	}
+	skE, _, err := hpkeDeriveKeyPair(ikmE)
+	if err != nil {
+		return nil, nil, err
+	}
+	return hpkeSealWithEphemeral(skE, pkR, info, aad, plaintext)
+}
+
+// HPKEOpen decrypts a message encrypted by HPKESeal with the private key of
+// the receiver
+func HPKEOpen(skR, enc, info, aad, ciphertext []byte) ([]byte, error) {
+	pkR, err := x25519Base(skR)
+	if err != nil {
+		return nil, err
+	}
+	dh, err := x25519(skR, enc)
+	if err != nil {
+		return nil, err
+	}
+	aead, nonce, err := hpkeKeySchedule(hpkeSharedSecret(dh, enc, pkR), info)
+	if err != nil {
+		return nil, err
+	}
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// hpkeSealWithEphemeral encrypts with the given ephemeral private key, which
+// makes the encryption deterministic for the test vectors
+func hpkeSealWithEphemeral(skE, pkR, info, aad, plaintext []byte) ([]byte, []byte, error) {
+	enc, err := x25519Base(skE)
+	if err != nil {
+		return nil, nil, err
+	}
+	dh, err := x25519(skE, pkR)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, nonce, err := hpkeKeySchedule(hpkeSharedSecret(dh, enc, pkR), info)
+	if err != nil {
+		return nil, nil, err
+	}
+	return enc, aead.Seal(nil, nonce, plaintext, aad), nil
+}
+
+// hpkeDeriveKeyPair derives an X25519 key pair from the input keying
+// material
+func hpkeDeriveKeyPair(ikm []byte) ([]byte, []byte, error) {
+	prk := labeledExtract(kemSuiteID, nil, "dkp_prk", ikm)
+	sk := labeledExpand(kemSuiteID, prk, "sk", nil, x25519Len)
+	pk, err := x25519Base(sk)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sk, pk, nil
+}
+
+// hpkeSharedSecret derives the shared secret of the KEM from the
+// Diffie-Hellman secret and the public keys
+func hpkeSharedSecret(dh, enc, pkR []byte) []byte {
+	kemContext := append(append([]byte{}, enc...), pkR...)
+	prk := labeledExtract(kemSuiteID, nil, "eae_prk", dh)
+	return labeledExpand(kemSuiteID, prk, "shared_secret", kemContext, hpkeNsecret)
+}
+
+// hpkeKeySchedule returns the AEAD of the key and the nonce of the first
+// message derived from the shared secret
+func hpkeKeySchedule(sharedSecret, info []byte) (cipher.AEAD, []byte, error) {
+	key, nonce := hpkeKeyAndNonce(sharedSecret, info)
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, nil, err
+	}
+	return aead, nonce, nil
+}
+
+// hpkeKeyAndNonce derives the AEAD key and the nonce of the first message in
+// base mode, without pre-shared key
+func hpkeKeyAndNonce(sharedSecret, info []byte) ([]byte, []byte) {
+	context := []byte{hpkeModeBase}
+	context = append(context, labeledExtract(hpkeSuiteID, nil, "psk_id_hash", nil)...)
+	context = append(context, labeledExtract(hpkeSuiteID, nil, "info_hash", info)...)
+	secret := labeledExtract(hpkeSuiteID, sharedSecret, "secret", nil)
+
+	key := labeledExpand(hpkeSuiteID, secret, "key", context, hpkeNk)
+	nonce := labeledExpand(hpkeSuiteID, secret, "base_nonce", context, hpkeNn)
+	return key, nonce
+}
+
+// labeledExtract is the HKDF-Extract of the input keying material prefixed
+// with the version, the suite and the label
+func labeledExtract(suiteID, salt []byte, label string, ikm []byte) []byte {
+	if salt == nil {
+		salt = make([]byte, hpkeNh)
+	}
+	mac := hmac.New(sha256.New, salt)
+	mac.Write(hpkeVersion)
+	mac.Write(suiteID)
+	mac.Write([]byte(label))
+	mac.Write(ikm)
+	return mac.Sum(nil)
+}
+
+// labeledExpand is the HKDF-Expand of the pseudo-random key, with the info
+// prefixed with the output length, the version, the suite and the label
+func labeledExpand(suiteID, prk []byte, label string, info []byte, length int) []byte {
+	labeledInfo := make([]byte, 2)
+	binary.BigEndian.PutUint16(labeledInfo, uint16(length))
+	labeledInfo = append(labeledInfo, hpkeVersion...)
+	labeledInfo = append(labeledInfo, suiteID...)
+	labeledInfo = append(labeledInfo, label...)
+	labeledInfo = append(labeledInfo, info...)
+
+	out := make([]byte, 0, length+hpkeNh)
+	var t []byte
+	for i := byte(1); len(out) < length; i++ {
+		mac := hmac.New(sha256.New, prk)
+		mac.Write(t)
+		mac.Write(labeledInfo)
+		mac.Write([]byte{i})
+		t = mac.Sum(nil)
+		out = append(out, t...)
+	}
+	return out[:length]
+}
+
+// x25519 computes the Diffie-Hellman secret of the private and public keys,
+// rejecting the all-zero output of low order points
+func x25519(sk, pk []byte) ([]byte, error) {
+	if len(sk) != x25519Len || len(pk) != x25519Len {
+		return nil, errors.New("invalid X25519 key length")
+	}
+	var dst, scalar, point [x25519Len]byte
+	copy(scalar[:], sk)
+	copy(point[:], pk)
+	curve25519.ScalarMult(&dst, &scalar, &point)
+	var zero [x25519Len]byte
+	if subtle.ConstantTimeCompare(dst[:], zero[:]) == 1 {
+		return nil, errors.New("invalid X25519 public key")
+	}
+	return dst[:], nil
+}
+
+// x25519Base returns the public key of the private key
+func x25519Base(sk []byte) ([]byte, error) {
+	if len(sk) != x25519Len {
+		return nil, errors.New("invalid X25519 key length")
+	}
+	var dst, scalar [x25519Len]byte
+	copy(scalar[:], sk)
+	curve25519.ScalarBaseMult(&dst, &scalar)
+	return dst[:], nil
+}
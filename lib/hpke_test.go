@@ -0,0 +1,84 @@
+package lib
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// test vector of RFC 9180, appendix A.1.1, with the first message
+var hpkeVector = struct {
+	info, ikmE, skEm, pkEm, ikmR, skRm, pkRm string
+	sharedSecret, key, baseNonce             string
+	aad, pt, ct                              string
+}{
+	info:         "4f6465206f6e2061204772656369616e2055726e",
+	ikmE:         "7268600d403fce431561aef583ee1613527cff655c1343f29812e66706df3234",
+	skEm:         "52c4a758a802cd8b936eceea314432798d5baf2d7e9235dc084ab1b9cfa2f736",
+	pkEm:         "37fda3567bdbd628e88668c3c8d7e97d1d1253b6d4ea6d44c150f741f1bf4431",
+	ikmR:         "6db9df30aa07dd42ee5e8181afdb977e538f5e1fec8a06223f33f7013e525037",
+	skRm:         "4612c550263fc8ad58375df3f557aac531d26850903e55a9f23f21d8534e8ac8",
+	pkRm:         "3948cfe0ad1ddb695d780e59077195da6c56506b027329794ab02bca80815c4d",
+	sharedSecret: "fe0e18c9f024ce43799ae393c7e8fe8fce9d218875e8227b0187c04e7d2ea1fc",
+	key:          "4531685d41d65f03dc48f6b8302c05b0",
+	baseNonce:    "56d890e5accaaf011cff4b7d",
+	aad:          "436f756e742d30",
+	pt:           "4265617574792069732074727574682c20747275746820626561757479",
+	ct:           "f938558b5d72f1a23810b4be2ab4f84331acc02fc97babc53a52ae8218a355a96d8770ac83d07bea87e13c512a",
+}
+
+func unhex(t *testing.T, s string) []byte {
+	b, err := hex.DecodeString(s)
+	require.Nil(t, err)
+	return b
+}
+
+func TestHPKEDeriveKeyPair(t *testing.T) {
+	v := hpkeVector
+	skE, pkE, err := hpkeDeriveKeyPair(unhex(t, v.ikmE))
+	require.Nil(t, err)
+	require.Equal(t, unhex(t, v.skEm), skE)
+	require.Equal(t, unhex(t, v.pkEm), pkE)
+
+	skR, pkR, err := hpkeDeriveKeyPair(unhex(t, v.ikmR))
+	require.Nil(t, err)
+	require.Equal(t, unhex(t, v.skRm), skR)
+	require.Equal(t, unhex(t, v.pkRm), pkR)
+}
+
+func TestHPKEKeySchedule(t *testing.T) {
+	v := hpkeVector
+	dh, err := x25519(unhex(t, v.skEm), unhex(t, v.pkRm))
+	require.Nil(t, err)
+	sharedSecret := hpkeSharedSecret(dh, unhex(t, v.pkEm), unhex(t, v.pkRm))
+	require.Equal(t, unhex(t, v.sharedSecret), sharedSecret)
+
+	key, nonce := hpkeKeyAndNonce(sharedSecret, unhex(t, v.info))
+	require.Equal(t, unhex(t, v.key), key)
+	require.Equal(t, unhex(t, v.baseNonce), nonce)
+}
+
+func TestHPKESealOpen(t *testing.T) {
+	v := hpkeVector
+	enc, ct, err := hpkeSealWithEphemeral(unhex(t, v.skEm), unhex(t, v.pkRm),
+		unhex(t, v.info), unhex(t, v.aad), unhex(t, v.pt))
+	require.Nil(t, err)
+	require.Equal(t, unhex(t, v.pkEm), enc)
+	require.Equal(t, unhex(t, v.ct), ct)
+
+	pt, err := HPKEOpen(unhex(t, v.skRm), enc, unhex(t, v.info), unhex(t, v.aad), ct)
+	require.Nil(t, err)
+	require.Equal(t, unhex(t, v.pt), pt)
+
+	// random ephemeral keys, and the additional data is authenticated
+	sk, pk, err := HPKEGenerateKeyPair()
+	require.Nil(t, err)
+	enc, ct, err = HPKESeal(pk, []byte("info"), []byte("aad"), []byte("digests"))
+	require.Nil(t, err)
+	pt, err = HPKEOpen(sk, enc, []byte("info"), []byte("aad"), ct)
+	require.Nil(t, err)
+	require.Equal(t, []byte("digests"), pt)
+	_, err = HPKEOpen(sk, enc, []byte("info"), []byte("other"), ct)
+	require.NotNil(t, err)
+}
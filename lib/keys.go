@@ -0,0 +1,29 @@
+package lib
+
+import (
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// ServiceName is the name under which the service is registered on the
+// conodes, and under which `conode setup` stores its dedicated key pair.
+const ServiceName = "DPPC"
+
+// ServicePublic returns the public key of the service of the conode. The
+// conodes sign and agree on keys with the service key pair instead of their
+// identity key pair, which can thus be rotated without a new identity. The
+// identity key is returned for conodes without a service key pair.
+func ServicePublic(si *network.ServerIdentity) kyber.Point {
+	return si.ServicePublic(ServiceName)
+}
+
+// ServicePublics returns the public keys of the service of the conodes of
+// the roster, in the order of the roster
+func ServicePublics(r *onet.Roster) []kyber.Point {
+	publics := make([]kyber.Point, len(r.List))
+	for i, si := range r.List {
+		publics[i] = ServicePublic(si)
+	}
+	return publics
+}
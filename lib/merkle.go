@@ -0,0 +1,429 @@
+package lib
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+)
+
+// The transparency log is an append-only Merkle tree as in RFC 6962: leaves
+// and interior nodes are hashed with SHA-256 and distinct prefixes, and the
+// tree of n leaves splits at the largest power of two smaller than n.
+
+// domains separating the encodings of the leaves and of the tree heads from
+// any other data hashed or signed with the service keys
+const (
+	leafDomain     = "dpcc leaf"
+	treeHeadDomain = "dpcc tree head"
+)
+
+// EncodeLeaf returns the data of a leaf made of the given fields, each
+// prefixed by its length
+func EncodeLeaf(fields ...[]byte) []byte {
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(leafDomain))
+	for _, f := range fields {
+		writeBytes(&buf, f)
+	}
+	return buf.Bytes()
+}
+
+// DecodeLeaf returns the fields of the data of a leaf
+func DecodeLeaf(data []byte) ([][]byte, error) {
+	r := bytes.NewReader(data)
+	domain, err := readBytes(r)
+	if err != nil || string(domain) != leafDomain {
+		return nil, errors.New("invalid leaf")
+	}
+	var fields [][]byte
+	for r.Len() > 0 {
+		f, err := readBytes(r)
+		if err != nil {
+			return nil, errors.New("invalid leaf")
+		}
+		fields = append(fields, f)
+	}
+	return fields, nil
+}
+
+// LeafHash returns the hash of a leaf of the tree
+func LeafHash(data []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{0})
+	h.Write(data)
+	return h.Sum(nil)
+}
+
+// nodeHash returns the hash of an interior node of the tree
+func nodeHash(left, right []byte) []byte {
+	h := sha256.New()
+	h.Write([]byte{1})
+	h.Write(left)
+	h.Write(right)
+	return h.Sum(nil)
+}
+
+// splitPoint returns the largest power of two smaller than n, for n > 1
+func splitPoint(n uint64) uint64 {
+	k := uint64(1)
+	for k<<1 < n {
+		k <<= 1
+	}
+	return k
+}
+
+// rangeHash returns the root of the subtree of the leaves [start, end) of a
+// tree, for start < end
+type rangeHash func(start, end uint64) ([]byte, error)
+
+// sliceHash computes the roots of the subtrees of a slice of leaf hashes
+func sliceHash(leaves [][]byte) rangeHash {
+	return func(start, end uint64) ([]byte, error) {
+		return MerkleRoot(leaves[start:end]), nil
+	}
+}
+
+// MerkleRoot returns the root of the tree of the leaf hashes
+func MerkleRoot(leaves [][]byte) []byte {
+	switch len(leaves) {
+	case 0:
+		h := sha256.Sum256(nil)
+		return h[:]
+	case 1:
+		return leaves[0]
+	}
+	k := splitPoint(uint64(len(leaves)))
+	return nodeHash(MerkleRoot(leaves[:k]), MerkleRoot(leaves[k:]))
+}
+
+// InclusionProof returns the audit path of the leaf at the index in the tree
+// of the leaf hashes, from the leaf to the root
+func InclusionProof(leaves [][]byte, index int) ([][]byte, error) {
+	if index < 0 || index >= len(leaves) {
+		return nil, errors.New("leaf index out of the tree")
+	}
+	return inclusionPath(sliceHash(leaves), 0, uint64(len(leaves)), uint64(index))
+}
+
+// inclusionPath is PATH of RFC 6962 for the subtree of the leaves
+// [start, end)
+func inclusionPath(hash rangeHash, start, end, index uint64) ([][]byte, error) {
+	if end-start <= 1 {
+		return nil, nil
+	}
+	k := start + splitPoint(end-start)
+	var path [][]byte
+	var sibling []byte
+	var err error
+	if index < k {
+		if path, err = inclusionPath(hash, start, k, index); err != nil {
+			return nil, err
+		}
+		sibling, err = hash(k, end)
+	} else {
+		if path, err = inclusionPath(hash, k, end, index); err != nil {
+			return nil, err
+		}
+		sibling, err = hash(start, k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(path, sibling), nil
+}
+
+// ConsistencyProof returns the proof that the tree of the first size leaf
+// hashes is a prefix of the tree of all the leaf hashes
+func ConsistencyProof(leaves [][]byte, size int) ([][]byte, error) {
+	if size < 0 || size > len(leaves) {
+		return nil, errors.New("tree size out of the tree")
+	}
+	if size == 0 || size == len(leaves) {
+		return nil, nil
+	}
+	return subproof(sliceHash(leaves), 0, uint64(len(leaves)), uint64(size), true)
+}
+
+// subproof is SUBPROOF of RFC 6962 for the subtree of the leaves
+// [start, end), complete telling if the subtree of the leaves [start, size)
+// is a complete subtree of the original tree
+func subproof(hash rangeHash, start, end, size uint64, complete bool) ([][]byte, error) {
+	if size == end {
+		if complete {
+			return nil, nil
+		}
+		h, err := hash(start, end)
+		if err != nil {
+			return nil, err
+		}
+		return [][]byte{h}, nil
+	}
+	k := start + splitPoint(end-start)
+	var proof [][]byte
+	var sibling []byte
+	var err error
+	if size <= k {
+		if proof, err = subproof(hash, start, k, size, complete); err != nil {
+			return nil, err
+		}
+		sibling, err = hash(k, end)
+	} else {
+		if proof, err = subproof(hash, k, end, size, false); err != nil {
+			return nil, err
+		}
+		sibling, err = hash(start, k)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return append(proof, sibling), nil
+}
+
+// MerkleNodes stores the roots of the complete subtrees of a tree. The
+// subtree of a level and an index holds the leaves
+// [index << level, (index + 1) << level), level 0 holding the leaf hashes.
+type MerkleNodes interface {
+	Node(level uint, index uint64) ([]byte, error)
+	SetNode(level uint, index uint64, hash []byte) error
+}
+
+// MerkleTree is a tree whose complete subtrees are kept in a store, so that
+// appending a leaf, computing a root or a proof only reads and writes a
+// logarithmic number of hashes
+type MerkleTree struct {
+	Nodes MerkleNodes
+	Size  uint64
+}
+
+// Append adds a leaf hash to the tree, with the roots of the complete
+// subtrees it completes
+func (t *MerkleTree) Append(leaf []byte) error {
+	index := t.Size
+	if err := t.Nodes.SetNode(0, index, leaf); err != nil {
+		return err
+	}
+	h := leaf
+	for level := uint(0); index&1 == 1; level++ {
+		left, err := t.Nodes.Node(level, index-1)
+		if err != nil {
+			return err
+		}
+		h = nodeHash(left, h)
+		index >>= 1
+		if err := t.Nodes.SetNode(level+1, index, h); err != nil {
+			return err
+		}
+	}
+	t.Size++
+	return nil
+}
+
+// hash computes the roots of the subtrees of the tree from the stored
+// complete subtrees. The recursion of RFC 6962 only meets complete subtrees
+// that are aligned on their size.
+func (t *MerkleTree) hash(start, end uint64) ([]byte, error) {
+	n := end - start
+	if n&(n-1) == 0 && start%n == 0 {
+		level := uint(0)
+		for uint64(1)<<level < n {
+			level++
+		}
+		h, err := t.Nodes.Node(level, start>>level)
+		if err == nil && h == nil {
+			err = errors.New("missing node of the tree")
+		}
+		return h, err
+	}
+	k := start + splitPoint(n)
+	left, err := t.hash(start, k)
+	if err != nil {
+		return nil, err
+	}
+	right, err := t.hash(k, end)
+	if err != nil {
+		return nil, err
+	}
+	return nodeHash(left, right), nil
+}
+
+// Root returns the root of the tree of the first size leaves
+func (t *MerkleTree) Root(size uint64) ([]byte, error) {
+	if size > t.Size {
+		return nil, errors.New("tree size out of the tree")
+	}
+	if size == 0 {
+		return MerkleRoot(nil), nil
+	}
+	return t.hash(0, size)
+}
+
+// InclusionProof returns the audit path of the leaf at the index in the tree
+// of the first size leaves, from the leaf to the root
+func (t *MerkleTree) InclusionProof(index, size uint64) ([][]byte, error) {
+	if size > t.Size {
+		return nil, errors.New("tree size out of the tree")
+	}
+	if index >= size {
+		return nil, errors.New("leaf index out of the tree")
+	}
+	return inclusionPath(t.hash, 0, size, index)
+}
+
+// ConsistencyProof returns the proof that the tree of the first leaves is a
+// prefix of the tree of the second leaves
+func (t *MerkleTree) ConsistencyProof(first, second uint64) ([][]byte, error) {
+	if second > t.Size || first > second {
+		return nil, errors.New("tree size out of the tree")
+	}
+	if first == 0 || first == second {
+		return nil, nil
+	}
+	return subproof(t.hash, 0, second, first, true)
+}
+
+// VerifyInclusion checks that the audit path leads from the leaf hash at the
+// index to the root of a tree of the given size
+func VerifyInclusion(index, size uint64, leaf []byte, proof [][]byte, root []byte) error {
+	if index >= size {
+		return errors.New("leaf index out of the tree")
+	}
+	fn, sn := index, size-1
+	r := leaf
+	for _, p := range proof {
+		if sn == 0 {
+			return errors.New("inclusion proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			r = nodeHash(p, r)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			r = nodeHash(r, p)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("inclusion proof too short")
+	}
+	if !bytes.Equal(r, root) {
+		return errors.New("inclusion proof doesn't lead to the root")
+	}
+	return nil
+}
+
+// VerifyConsistency checks that the tree of size1 leaves with root1 is a
+// prefix of the tree of size2 leaves with root2
+func VerifyConsistency(size1, size2 uint64, root1, root2 []byte, proof [][]byte) error {
+	switch {
+	case size1 > size2:
+		return errors.New("the first tree is larger than the second one")
+	case size1 == 0 || size1 == size2:
+		if len(proof) != 0 {
+			return errors.New("consistency proof too long")
+		}
+		if size1 == size2 && !bytes.Equal(root1, root2) {
+			return errors.New("trees of the same size with different roots")
+		}
+		return nil
+	}
+
+	// the root of the first tree starts the path when it is a complete
+	// subtree of the second one
+	if size1&(size1-1) == 0 {
+		proof = append([][]byte{root1}, proof...)
+	}
+	if len(proof) == 0 {
+		return errors.New("consistency proof too short")
+	}
+	fn, sn := size1-1, size2-1
+	for fn&1 == 1 {
+		fn >>= 1
+		sn >>= 1
+	}
+	fr, sr := proof[0], proof[0]
+	for _, c := range proof[1:] {
+		if sn == 0 {
+			return errors.New("consistency proof too long")
+		}
+		if fn&1 == 1 || fn == sn {
+			fr = nodeHash(c, fr)
+			sr = nodeHash(c, sr)
+			for fn&1 == 0 && fn != 0 {
+				fn >>= 1
+				sn >>= 1
+			}
+		} else {
+			sr = nodeHash(sr, c)
+		}
+		fn >>= 1
+		sn >>= 1
+	}
+	if sn != 0 {
+		return errors.New("consistency proof too short")
+	}
+	if !bytes.Equal(fr, root1) || !bytes.Equal(sr, root2) {
+		return errors.New("consistency proof doesn't lead to the roots")
+	}
+	return nil
+}
+
+// TreeHead is the root of the transparency log of a conode at a given size,
+// signed by the conode. Auditors keep the heads they saw and ask for proofs
+// that later heads extend them.
+type TreeHead struct {
+	Size uint64
+	// Unix time in seconds at which the conode signed the head
+	Timestamp int64
+	Root      []byte
+	// service public key of the conode
+	NodeKey   kyber.Point
+	Signature []byte
+}
+
+// Encode returns the encoding of the tree head covered by its signature
+func (h *TreeHead) Encode() ([]byte, error) {
+	if h.NodeKey == nil {
+		return nil, errors.New("tree head without node key")
+	}
+	key, err := h.NodeKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(treeHeadDomain))
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], h.Size)
+	buf.Write(b[:])
+	binary.BigEndian.PutUint64(b[:], uint64(h.Timestamp))
+	buf.Write(b[:])
+	writeBytes(&buf, h.Root)
+	writeBytes(&buf, key)
+	return buf.Bytes(), nil
+}
+
+// SignTreeHead signs the encoding of the tree head with a Schnorr signature
+func SignTreeHead(private kyber.Scalar, h *TreeHead) error {
+	msg, err := h.Encode()
+	if err != nil {
+		return err
+	}
+	h.Signature, err = schnorr.Sign(cothority.Suite, private, msg)
+	return err
+}
+
+// VerifyTreeHead verifies the signature of the tree head by its node key
+func VerifyTreeHead(h *TreeHead) error {
+	msg, err := h.Encode()
+	if err != nil {
+		return err
+	}
+	return schnorr.Verify(cothority.Suite, h.NodeKey, msg, h.Signature)
+}
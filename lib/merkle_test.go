@@ -0,0 +1,192 @@
+package lib
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+// test vectors of RFC 6962, as used by Certificate Transparency: the inputs of
+// the leaves and the roots of the trees of their first 1 to 8 leaves
+var merkleInputs = []string{
+	"",
+	"00",
+	"10",
+	"2021",
+	"3031",
+	"40414243",
+	"5051525354555657",
+	"606162636465666768696a6b6c6d6e6f",
+}
+
+var merkleRoots = []string{
+	"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+	"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	"aeb6bcfe274b70a14fb067a5e5578264db0fa9b51af5e0ba159158f329e06e77",
+	"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	"4e3bbb1f7b478dcfe71fb631631519a3bca12c9aefca1612bfce4c13a86264d4",
+	"76e67dadbcdf1e10e1b74ddc608abd2f98dfb16fbce75277b5232a127f2087ef",
+	"ddb89be403809e325750d3d263cd78929c2942b7942a34b77e122c9594a74c8c",
+	"5dc9da79a70659a9ad559cb701ded9a2ab9d823aad2f4960cfe370eff4604328",
+}
+
+var merkleInclusionProofs = []struct {
+	index, size uint64
+	proof       []string
+}{
+	{0, 8, []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+	}},
+	{5, 8, []string{
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+		"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	}},
+	{2, 3, []string{
+		"fac54203e7cc696cf0dfcb42c92a1d9dbaf70ad9e621f4bd8d98662f00e3c125",
+	}},
+	{1, 5, []string{
+		"6e340b9cffb37a989ca544e6bb780a2c78901d3fb33738768511a30617afa01d",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+	}},
+	{0, 1, nil},
+}
+
+var merkleConsistencyProofs = []struct {
+	first, second uint64
+	proof         []string
+}{
+	{1, 1, nil},
+	{1, 8, []string{
+		"96a296d224f285c67bee93c30f8a309157f0daa35dc5b87e410b78630a09cfc7",
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"6b47aaf29ee3c2af9af889bc1fb9254dabd31177f16232dd6aab035ca39bf6e4",
+	}},
+	{6, 8, []string{
+		"0ebc5d3437fbe2db158b9f126a1d118e308181031d0a949f8dededebc558ef6a",
+		"ca854ea128ed050b41b35ffc1b87b8eb2bde461e9e3b5596ece6b9d5975a0ae0",
+		"d37ee418976dd95753c1c73862b9398fa2a2cf9b4ff0fdfe8b30cd95209614b7",
+	}},
+	{2, 5, []string{
+		"5f083f0a1a33ca076a95279832580db3e0ef4584bdff1f54c8a360f50de3031e",
+		"bc1a0643b12e4d2d7c77918f44e0f4f79a838b6cf9ec5b5c283e1f4d88599e6b",
+	}},
+}
+
+// memoryNodes keeps the nodes of a tree in memory
+type memoryNodes map[[2]uint64][]byte
+
+func (m memoryNodes) Node(level uint, index uint64) ([]byte, error) {
+	return m[[2]uint64{uint64(level), index}], nil
+}
+
+func (m memoryNodes) SetNode(level uint, index uint64, hash []byte) error {
+	m[[2]uint64{uint64(level), index}] = hash
+	return nil
+}
+
+func merkleLeaves(t *testing.T) [][]byte {
+	var leaves [][]byte
+	for _, in := range merkleInputs {
+		leaves = append(leaves, LeafHash(unhex(t, in)))
+	}
+	return leaves
+}
+
+func unhexAll(t *testing.T, ss []string) [][]byte {
+	var bs [][]byte
+	for _, s := range ss {
+		bs = append(bs, unhex(t, s))
+	}
+	return bs
+}
+
+func TestMerkleRoot(t *testing.T) {
+	leaves := merkleLeaves(t)
+	tree := &MerkleTree{Nodes: memoryNodes{}}
+	for i, leaf := range leaves {
+		require.Nil(t, tree.Append(leaf))
+		root, err := tree.Root(uint64(i + 1))
+		require.Nil(t, err)
+		require.Equal(t, unhex(t, merkleRoots[i]), root)
+		require.Equal(t, unhex(t, merkleRoots[i]), MerkleRoot(leaves[:i+1]))
+	}
+	// the roots of the smaller trees are still available
+	for i := range leaves {
+		root, err := tree.Root(uint64(i + 1))
+		require.Nil(t, err)
+		require.Equal(t, unhex(t, merkleRoots[i]), root)
+	}
+	root, err := tree.Root(0)
+	require.Nil(t, err)
+	require.Equal(t, unhex(t, "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"), root)
+	_, err = tree.Root(9)
+	require.NotNil(t, err)
+}
+
+func TestMerkleInclusionProof(t *testing.T) {
+	leaves := merkleLeaves(t)
+	tree := &MerkleTree{Nodes: memoryNodes{}}
+	for _, leaf := range leaves {
+		require.Nil(t, tree.Append(leaf))
+	}
+	for _, v := range merkleInclusionProofs {
+		expected := unhexAll(t, v.proof)
+		proof, err := InclusionProof(leaves[:v.size], int(v.index))
+		require.Nil(t, err)
+		require.Equal(t, expected, proof)
+		proof, err = tree.InclusionProof(v.index, v.size)
+		require.Nil(t, err)
+		require.Equal(t, expected, proof)
+
+		root := unhex(t, merkleRoots[v.size-1])
+		require.Nil(t, VerifyInclusion(v.index, v.size, leaves[v.index], proof, root))
+		require.NotNil(t, VerifyInclusion(v.index, v.size, leaves[(v.index+1)%8], proof, root))
+		if len(proof) > 0 {
+			require.NotNil(t, VerifyInclusion(v.index, v.size, leaves[v.index], proof[1:], root))
+			require.NotNil(t, VerifyInclusion(v.index, v.size, leaves[v.index],
+				append(proof, proof...), root))
+		}
+	}
+	_, err := tree.InclusionProof(8, 8)
+	require.NotNil(t, err)
+}
+
+func TestMerkleConsistencyProof(t *testing.T) {
+	leaves := merkleLeaves(t)
+	tree := &MerkleTree{Nodes: memoryNodes{}}
+	for _, leaf := range leaves {
+		require.Nil(t, tree.Append(leaf))
+	}
+	for _, v := range merkleConsistencyProofs {
+		expected := unhexAll(t, v.proof)
+		proof, err := ConsistencyProof(leaves[:v.second], int(v.first))
+		require.Nil(t, err)
+		require.Equal(t, expected, proof)
+		proof, err = tree.ConsistencyProof(v.first, v.second)
+		require.Nil(t, err)
+		require.Equal(t, expected, proof)
+
+		root1, root2 := unhex(t, merkleRoots[v.first-1]), unhex(t, merkleRoots[v.second-1])
+		require.Nil(t, VerifyConsistency(v.first, v.second, root1, root2, proof))
+		if v.first != v.second {
+			require.NotNil(t, VerifyConsistency(v.first, v.second, root2, root2, proof))
+			require.NotNil(t, VerifyConsistency(v.first, v.second, root1, root2, proof[1:]))
+		}
+	}
+
+	// every pair of trees is consistent
+	for second := uint64(1); second <= 8; second++ {
+		for first := uint64(1); first <= second; first++ {
+			proof, err := tree.ConsistencyProof(first, second)
+			require.Nil(t, err)
+			require.Nil(t, VerifyConsistency(first, second, unhex(t, merkleRoots[first-1]),
+				unhex(t, merkleRoots[second-1]), proof))
+		}
+	}
+	_, err := tree.ConsistencyProof(3, 2)
+	require.NotNil(t, err)
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+	"go.dedis.ch/onet/v3"
+)
+
+// operatorDomain separates the signatures of the operators of the conodes
+// from any other signature
+const operatorDomain = "dpcc operator"
+
+// OperatorRequestValidity bounds the difference between the time of a request
+// signed by an operator and the clock of the conode
+const OperatorRequestValidity = 5 * time.Minute
+
+// OperatorRequest authorizes an action on a conode, such as the refresh of a
+// distributed key, with a signature by the key of its operator
+type OperatorRequest struct {
+	// service public key of the conode, so that the request cannot be
+	// replayed on another conode of the same operator
+	Conode kyber.Point
+	Action string
+	// Unix time in seconds at which the request was signed
+	Timestamp int64
+	// Payload identifies the object of the action
+	Payload   []byte
+	Signature []byte
+}
+
+// message returns the encoding of the request signed by the operator
+func (o *OperatorRequest) message() ([]byte, error) {
+	if o.Conode == nil {
+		return nil, errors.New("operator request without conode")
+	}
+	key, err := o.Conode.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(operatorDomain))
+	writeBytes(&buf, key)
+	writeBytes(&buf, []byte(o.Action))
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(o.Timestamp))
+	buf.Write(ts[:])
+	writeBytes(&buf, o.Payload)
+	return buf.Bytes(), nil
+}
+
+// SignOperatorRequest returns the request for the action on the conode,
+// signed now by the operator
+func SignOperatorRequest(private kyber.Scalar, conode kyber.Point, action string, payload []byte) (*OperatorRequest, error) {
+	o := &OperatorRequest{
+		Conode:    conode,
+		Action:    action,
+		Timestamp: time.Now().Unix(),
+		Payload:   payload,
+	}
+	msg, err := o.message()
+	if err != nil {
+		return nil, err
+	}
+	o.Signature, err = schnorr.Sign(cothority.Suite, private, msg)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// VerifyOperatorRequest checks that the request for the action on the conode
+// has been signed recently by the operator
+func VerifyOperatorRequest(operator, conode kyber.Point, action string, payload []byte, o *OperatorRequest) error {
+	if o == nil {
+		return errors.New("missing operator signature")
+	}
+	if o.Conode == nil || !o.Conode.Equal(conode) || o.Action != action ||
+		!bytes.Equal(o.Payload, payload) {
+		return errors.New("operator request for another action")
+	}
+	age := time.Since(time.Unix(o.Timestamp, 0))
+	if age > OperatorRequestValidity || age < -OperatorRequestValidity {
+		return errors.New("operator request expired")
+	}
+	msg, err := o.message()
+	if err != nil {
+		return err
+	}
+	return schnorr.Verify(cothority.Suite, operator, msg, o.Signature)
+}
+
+// RosterPayload identifies the roster by the service keys of its conodes, in
+// the order of the roster, for the operator requests about the roster
+func RosterPayload(r *onet.Roster) ([]byte, error) {
+	var buf bytes.Buffer
+	for _, p := range ServicePublics(r) {
+		if _, err := p.MarshalTo(&buf); err != nil {
+			return nil, err
+		}
+	}
+	return buf.Bytes(), nil
+}
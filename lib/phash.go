@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"math/bits"
+	"strings"
+
+	// decoders of the supported image formats
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// DHash64 is the identifier of the 64 bits difference hash of an image, which
+// is the same for images that look the same even if their bytes differ, e.g.
+// because they have been recompressed
+const DHash64 = "dhash-64"
+
+// maxImagePixels bounds the size of the images decoded for their perceptual
+// hash, as a small compressed image can decode to a huge one
+const maxImagePixels = 1 << 25
+
+func init() {
+	registerSimilarity(DHash64, dHashDigest, dHashScore)
+}
+
+// DHash computes the 64 bits difference hash of the image: the image is
+// reduced to 9x8 gray pixels and every bit tells if a pixel is brighter than
+// its right neighbour
+func DHash(img image.Image) uint64 {
+	const width, height = 9, 8
+	var gray [height][width]float64
+
+	b := img.Bounds()
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+		if y1 == y0 {
+			y1++
+		}
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+			if x1 == x0 {
+				x1++
+			}
+			gray[y][x] = averageLuminance(img, image.Rect(x0, y0, x1, y1))
+		}
+	}
+
+	var dhash uint64
+	bit := uint(0)
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			if gray[y][x] > gray[y][x+1] {
+				dhash |= 1 << bit
+			}
+			bit++
+		}
+	}
+	return dhash
+}
+
+// averageLuminance returns the average luminance of the pixels of the image
+// in the rectangle
+func averageLuminance(img image.Image, r image.Rectangle) float64 {
+	r = r.Intersect(img.Bounds())
+	if r.Empty() {
+		return 0
+	}
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			red, green, blue, _ := img.At(x, y).RGBA()
+			sum += 0.299*float64(red) + 0.587*float64(green) + 0.114*float64(blue)
+		}
+	}
+	return sum / float64(r.Dx()*r.Dy())
+}
+
+// dHashDigest decodes the PNG, JPEG and GIF images and computes their
+// difference hash, other resources have no perceptual digest
+func dHashDigest(data []byte, contentType string) ([]byte, error) {
+	if !strings.HasPrefix(contentType, "image/") {
+		return nil, nil
+	}
+	// the dimensions are checked before decoding the pixels
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err == nil && int64(cfg.Width)*int64(cfg.Height) > maxImagePixels {
+		log.Lvlf3("no perceptual hash for image of %dx%d pixels", cfg.Width, cfg.Height)
+		return nil, nil
+	}
+	img, format, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		// format not supported, e.g. SVG, or corrupted image: the exact
+		// hash is still computed
+		log.Lvl3("no perceptual hash for image:", err)
+		return nil, nil
+	}
+	log.Lvlf4("computing perceptual hash of %s image", format)
+	d := make([]byte, 8)
+	binary.BigEndian.PutUint64(d, DHash(img))
+	return d, nil
+}
+
+// dHashScore returns the fraction of equal bits of two difference hashes
+func dHashScore(a, b []byte) (float64, error) {
+	if len(a) != 8 || len(b) != 8 {
+		return 0, errors.New("invalid dhash length")
+	}
+	x := binary.BigEndian.Uint64(a) ^ binary.BigEndian.Uint64(b)
+	return 1 - float64(bits.OnesCount64(x))/64, nil
+}
@@ -0,0 +1,114 @@
+package lib
+
+import (
+	"bytes"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// attributes of HTML elements holding links to rewrite
+var linkAttributes = []string{"href", "src", "action", "poster", "background"}
+
+// links of style sheets, as url() values and imports of other style sheets
+var (
+	cssLink   = regexp.MustCompile(`url\(\s*['"]?([^'")\s]+)['"]?\s*\)`)
+	cssImport = regexp.MustCompile(`@import\s+['"]([^'"]+)['"]`)
+)
+
+// RewriteHTML rewrites the links of an HTML page to the URLs returned by
+// rewrite for their absolute URLs, and inserts banner, an HTML fragment, at
+// the beginning of its body. Links to fragments of the page and links that
+// are not HTTP URLs are kept.
+func RewriteHTML(pageURL string, page []byte, rewrite func(string) string, banner string) ([]byte, error) {
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(page))
+	if err != nil {
+		return nil, err
+	}
+
+	// relative links are resolved against the base of the page, which
+	// must not apply to the rewritten ones
+	base := pageURL
+	doc.Find("base[href]").Each(func(i int, item *goquery.Selection) {
+		href, _ := item.Attr("href")
+		if l, err := sanitizeLink(pageURL, href); err == nil && i == 0 {
+			base = l
+		}
+		item.Remove()
+	})
+
+	for _, attr := range linkAttributes {
+		doc.Find("[" + attr + "]").Each(func(i int, item *goquery.Selection) {
+			v, _ := item.Attr(attr)
+			item.SetAttr(attr, rewriteLink(base, v, rewrite))
+		})
+	}
+	doc.Find("[srcset]").Each(func(i int, item *goquery.Selection) {
+		v, _ := item.Attr("srcset")
+		item.SetAttr("srcset", rewriteSrcset(base, v, rewrite))
+	})
+	doc.Find("[style]").Each(func(i int, item *goquery.Selection) {
+		v, _ := item.Attr("style")
+		item.SetAttr("style", string(RewriteCSS(base, []byte(v), rewrite)))
+	})
+	doc.Find("style").Each(func(i int, item *goquery.Selection) {
+		item.SetText(string(RewriteCSS(base, []byte(item.Text()), rewrite)))
+	})
+
+	if banner != "" {
+		doc.Find("body").PrependHtml(banner)
+	}
+	out, err := doc.Html()
+	if err != nil {
+		return nil, err
+	}
+	return []byte(out), nil
+}
+
+// RewriteCSS rewrites the links of a style sheet to the URLs returned by
+// rewrite for their absolute URLs
+func RewriteCSS(cssURL string, css []byte, rewrite func(string) string) []byte {
+	replace := func(re *regexp.Regexp, prefix, suffix string) func([]byte) []byte {
+		return func(m []byte) []byte {
+			link := string(re.FindSubmatch(m)[1])
+			return []byte(prefix + rewriteLink(cssURL, link, rewrite) + suffix)
+		}
+	}
+	css = cssLink.ReplaceAllFunc(css, replace(cssLink, `url("`, `")`))
+	return cssImport.ReplaceAllFunc(css, replace(cssImport, `@import "`, `"`))
+}
+
+// rewriteLink returns the rewritten URL of a link of the page at base, or the
+// link itself if it is not rewritten
+func rewriteLink(base, link string, rewrite func(string) string) string {
+	link = strings.TrimSpace(link)
+	if link == "" || strings.HasPrefix(link, "#") {
+		return link
+	}
+	l, err := sanitizeLink(base, link)
+	if err != nil {
+		return link
+	}
+	u, err := url.Parse(l)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return link
+	}
+	return rewrite(l)
+}
+
+// rewriteSrcset rewrites the URLs of the candidates of a srcset attribute,
+// keeping their descriptors
+func rewriteSrcset(base, srcset string, rewrite func(string) string) string {
+	candidates := strings.Split(srcset, ",")
+	for i, c := range candidates {
+		fields := strings.Fields(c)
+		if len(fields) == 0 {
+			continue
+		}
+		fields[0] = rewriteLink(base, fields[0], rewrite)
+		candidates[i] = strings.Join(fields, " ")
+	}
+	return strings.Join(candidates, ", ")
+}
@@ -0,0 +1,69 @@
+package lib
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func replayRewrite(l string) string {
+	return "/run/" + l
+}
+
+func TestRewriteHTML(t *testing.T) {
+	page := `<html><head><link rel="stylesheet" href="style.css">
+<style>body { background: url(/bg.png); }</style></head>
+<body><a href="#top">top</a> <a href="/about">about</a>
+<a href="mailto:someone@example.com">mail</a>
+<img src="https://cdn.example.com/logo.png" srcset="small.png 1x, big.png 2x">
+<p style="background-image: url('p.png')">text</p>
+<form action="search"></form></body></html>`
+
+	out, err := RewriteHTML("http://example.com/dir/page.html", []byte(page), replayRewrite,
+		`<div id="banner">archived</div>`)
+	require.Nil(t, err)
+	html := string(out)
+	for _, s := range []string{
+		`href="/run/http://example.com/dir/style.css"`,
+		`url("/run/http://example.com/bg.png")`,
+		`href="#top"`,
+		`href="/run/http://example.com/about"`,
+		`href="mailto:someone@example.com"`,
+		`src="/run/https://cdn.example.com/logo.png"`,
+		`srcset="/run/http://example.com/dir/small.png 1x, /run/http://example.com/dir/big.png 2x"`,
+		`url(&#34;/run/http://example.com/dir/p.png&#34;)`,
+		`action="/run/http://example.com/dir/search"`,
+		`<body><div id="banner">archived</div>`,
+	} {
+		require.True(t, strings.Contains(html, s), "missing %s in %s", s, html)
+	}
+}
+
+func TestRewriteHTMLBase(t *testing.T) {
+	// the links are resolved against the base, which is removed
+	page := `<html><head><base href="http://other.com/sub/"></head>
+<body><img src="a.png"></body></html>`
+	out, err := RewriteHTML("http://example.com/", []byte(page), replayRewrite, "")
+	require.Nil(t, err)
+	require.True(t, strings.Contains(string(out), `src="/run/http://other.com/sub/a.png"`))
+	require.False(t, strings.Contains(string(out), "<base"))
+}
+
+func TestRewriteCSS(t *testing.T) {
+	css := `@import "print.css";
+@import 'http://fonts.example.com/font.css';
+body { background: url( "../img/bg.png" ); }
+div { background: url(data:image/png;base64,AAAA); }
+p { background: url(#frag); }`
+	out := string(RewriteCSS("http://example.com/css/main.css", []byte(css), replayRewrite))
+	for _, s := range []string{
+		`@import "/run/http://example.com/css/print.css"`,
+		`@import "/run/http://fonts.example.com/font.css"`,
+		`url("/run/http://example.com/img/bg.png")`,
+		`url("data:image/png;base64,AAAA")`,
+		`url("#frag")`,
+	} {
+		require.True(t, strings.Contains(out, s), "missing %s in %s", s, out)
+	}
+}
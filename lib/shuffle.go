@@ -0,0 +1,147 @@
+package lib
+
+import (
+	"bytes"
+	"errors"
+	"sort"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/proof"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/kyber/v3/shuffle"
+	"go.dedis.ch/kyber/v3/util/random"
+)
+
+// shuffleProtocol separates the proofs of the shuffles from other proofs
+const shuffleProtocol = "dpcc-shuffle"
+
+// shuffleStepProtocol separates the signatures of the shuffles from the
+// signatures of other ciphertexts
+const shuffleStepProtocol = "dpcc-shuffle-step"
+
+// ShuffleStep is the output of the shuffle of a list of ciphertexts by a
+// node, with the proof that it is a permutation and re-encryption of the
+// input
+type ShuffleStep struct {
+	PublicKey   kyber.Point
+	Ciphertexts []*ElGamalCiphertext
+	Proof       []byte
+	// signature of the output with the nonce of the client by the node
+	Signature []byte
+}
+
+// SignShuffleStep signs the output of the shuffle with the nonce of the
+// client, so that the shuffle cannot be attributed to another node nor
+// replayed in another run
+func SignShuffleStep(private kyber.Scalar, nonce []byte, s *ShuffleStep) error {
+	sig, err := SignCiphertexts(private, shuffleStepProtocol, nonce, s.Ciphertexts)
+	if err != nil {
+		return err
+	}
+	s.Signature = sig
+	return nil
+}
+
+// EmbedDigest embeds the beginning of the digest in a point, so that it can
+// be recovered after decryption. Only the first EmbedLen bytes are kept, 29
+// bytes with Ed25519.
+func EmbedDigest(digest []byte) kyber.Point {
+	if l := cothority.Suite.Point().EmbedLen(); len(digest) > l {
+		digest = digest[:l]
+	}
+	return cothority.Suite.Point().Embed(digest, random.New())
+}
+
+// TruncateDigest returns the part of the digest embedded by EmbedDigest
+func TruncateDigest(digest []byte) []byte {
+	if l := cothority.Suite.Point().EmbedLen(); len(digest) > l {
+		return digest[:l]
+	}
+	return digest
+}
+
+// ShuffleCiphertexts permutes and re-encrypts the ciphertexts under the
+// public key and proves it
+func ShuffleCiphertexts(public kyber.Point, cts []*ElGamalCiphertext) ([]*ElGamalCiphertext, []byte, error) {
+	if len(cts) < 2 {
+		return nil, nil, errors.New("not enough ciphertexts to shuffle")
+	}
+	K, C := splitCiphertexts(cts)
+	KK, CC, prover := shuffle.Shuffle(cothority.Suite, cothority.Suite.Point().Base(), public, K, C, random.New())
+	prf, err := proof.HashProve(cothority.Suite, shuffleProtocol, prover)
+	if err != nil {
+		return nil, nil, err
+	}
+	out := make([]*ElGamalCiphertext, len(KK))
+	for i := range KK {
+		out[i] = &ElGamalCiphertext{K: KK[i], C: CC[i]}
+	}
+	return out, prf, nil
+}
+
+// VerifyShuffles verifies that every step is a valid shuffle of the output
+// of the previous one, starting from the input ciphertexts, signed with the
+// nonce by its node
+func VerifyShuffles(public kyber.Point, nonce []byte, input []*ElGamalCiphertext, steps []*ShuffleStep) error {
+	for _, s := range steps {
+		if s == nil || s.PublicKey == nil {
+			return errors.New("invalid shuffle")
+		}
+		if len(s.Ciphertexts) != len(input) {
+			return errors.New("shuffle of a different size")
+		}
+		for _, ct := range s.Ciphertexts {
+			if ct == nil || ct.K == nil || ct.C == nil {
+				return errors.New("invalid ciphertext in shuffle")
+			}
+		}
+		K, C := splitCiphertexts(input)
+		KK, CC := splitCiphertexts(s.Ciphertexts)
+		verifier := shuffle.Verifier(cothority.Suite, cothority.Suite.Point().Base(), public, K, C, KK, CC)
+		if err := proof.HashVerify(cothority.Suite, shuffleProtocol, verifier, s.Proof); err != nil {
+			return err
+		}
+		if err := VerifyCiphertexts(s.PublicKey, shuffleStepProtocol, nonce,
+			s.Ciphertexts, s.Signature); err != nil {
+			return errors.New("invalid signature of shuffle by " + s.PublicKey.String())
+		}
+		input = s.Ciphertexts
+	}
+	return nil
+}
+
+// DecryptDigests decrypts the shuffled digests from the partial decryptions
+// of the holders of t out of the n shares, and returns them sorted
+func DecryptDigests(cts []*ElGamalCiphertext, partials []*PartialDecryption, t, n int) ([][]byte, error) {
+	digests := [][]byte{}
+	for i, ct := range cts {
+		shares := make([]*share.PubShare, len(partials))
+		for k, p := range partials {
+			shares[k] = &share.PubShare{I: p.Index, V: p.Partials[i]}
+		}
+		m, err := ThresholdDecrypt(ct, shares, t, n)
+		if err != nil {
+			return nil, err
+		}
+		digest, err := m.Data()
+		if err != nil {
+			return nil, err
+		}
+		digests = append(digests, digest)
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i], digests[j]) < 0
+	})
+	return digests, nil
+}
+
+func splitCiphertexts(cts []*ElGamalCiphertext) ([]kyber.Point, []kyber.Point) {
+	K := make([]kyber.Point, len(cts))
+	C := make([]kyber.Point, len(cts))
+	for i, ct := range cts {
+		K[i] = ct.K
+		C[i] = ct.C
+	}
+	return K, C
+}
@@ -0,0 +1,108 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/fnv"
+	"math/bits"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SimHash64 is the identifier of the 64 bits SimHash computed over the
+// shingles of the canonicalized text of a resource
+const SimHash64 = "simhash-64"
+
+// shingleSize is the number of consecutive words in a shingle
+const shingleSize = 4
+
+func init() {
+	registerSimilarity(SimHash64, simHashDigest, simHashScore)
+}
+
+// CanonicalText returns the text of the resource in a canonical form: the
+// markup, scripts and styles of HTML pages are removed, the text is lower
+// cased and every sequence of white spaces is replaced by a single space
+func CanonicalText(data []byte, contentType string) (string, error) {
+	text := string(data)
+	if strings.Contains(contentType, "html") {
+		doc, err := goquery.NewDocumentFromReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		doc.Find("script, style, noscript").Remove()
+		text = doc.Text()
+	}
+	return strings.Join(strings.Fields(strings.ToLower(text)), " "), nil
+}
+
+// SimHash computes the 64 bits SimHash of the text, using the shingles of
+// its words as features
+func SimHash(text string) uint64 {
+	words := strings.Fields(text)
+	if len(words) == 0 {
+		return 0
+	}
+
+	var weights [64]int
+	for _, s := range shingles(words) {
+		h := fnv.New64a()
+		h.Write([]byte(s))
+		v := h.Sum64()
+		for i := uint(0); i < 64; i++ {
+			if v&(1<<i) != 0 {
+				weights[i]++
+			} else {
+				weights[i]--
+			}
+		}
+	}
+
+	var simhash uint64
+	for i := uint(0); i < 64; i++ {
+		if weights[i] > 0 {
+			simhash |= 1 << i
+		}
+	}
+	return simhash
+}
+
+// shingles returns all the sequences of shingleSize consecutive words, or
+// the whole text if it is shorter than a shingle
+func shingles(words []string) []string {
+	if len(words) <= shingleSize {
+		return []string{strings.Join(words, " ")}
+	}
+	s := make([]string, 0, len(words)-shingleSize+1)
+	for i := 0; i+shingleSize <= len(words); i++ {
+		s = append(s, strings.Join(words[i:i+shingleSize], " "))
+	}
+	return s
+}
+
+// simHashDigest computes the SimHash of textual resources, other resources
+// have no similarity digest
+func simHashDigest(data []byte, contentType string) ([]byte, error) {
+	if !regexp.MustCompile(`html|text|css|xml|json`).MatchString(contentType) {
+		return nil, nil
+	}
+	text, err := CanonicalText(data, contentType)
+	if err != nil {
+		return nil, err
+	}
+	d := make([]byte, 8)
+	binary.BigEndian.PutUint64(d, SimHash(text))
+	return d, nil
+}
+
+// simHashScore returns the fraction of equal bits of two SimHashes
+func simHashScore(a, b []byte) (float64, error) {
+	if len(a) != 8 || len(b) != 8 {
+		return 0, errors.New("invalid simhash length")
+	}
+	x := binary.BigEndian.Uint64(a) ^ binary.BigEndian.Uint64(b)
+	return 1 - float64(bits.OnesCount64(x))/64, nil
+}
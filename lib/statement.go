@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/sign/schnorr"
+)
+
+// StatementVersion is the version of the encoding of the statements
+const StatementVersion = 1
+
+// statementDomain separates the signatures of statements from any other
+// signature made with the service keys
+const statementDomain = "dpcc statement"
+
+// Flags of the statements, describing the mode in which the protocol ran
+const (
+	// StatementEncrypted is set when the digests have been encrypted for
+	// the client, and only the ciphertext is part of the statement
+	StatementEncrypted uint32 = 1 << iota
+	// StatementCollective is set when the statement is signed by all the
+	// conodes with their aggregated key
+	StatementCollective
+	// StatementMaskedFilters is set when the conodes sent masked Bloom
+	// filters to the leader instead of their hashes
+	StatementMaskedFilters
+	// StatementEncryptedFilters is set when the conodes sent Bloom
+	// filters encrypted under their distributed key
+	StatementEncryptedFilters
+)
+
+// Statement is what a conode signs about a resource: which protocol it ran,
+// for which URL and nonce of the client, when, and what it observed. The
+// client rebuilds the statement from its request and the response of the
+// conode, so that a signature cannot be moved to another request.
+type Statement struct {
+	Version  uint32
+	Protocol string
+	URL      string
+	// digests of the resource, with their algorithm identifiers
+	Digests []*Digest
+	Nonce   []byte
+	// Unix time in seconds at which the conode made the statement
+	Timestamp int64
+	// service public key of the conode, or aggregated key of the conodes
+	// for collective statements
+	NodeKey kyber.Point
+	Flags   uint32
+	// Payload holds protocol specific data, such as the encrypted digests
+	// or the canonicalized content of the resource
+	Payload []byte
+}
+
+// NewStatement returns a statement of the current version made now by the
+// conode with the given service public key
+func NewStatement(protocol, URL string, nonce []byte, node kyber.Point) *Statement {
+	return &Statement{
+		Version:   StatementVersion,
+		Protocol:  protocol,
+		URL:       URL,
+		Nonce:     nonce,
+		Timestamp: time.Now().Unix(),
+		NodeKey:   node,
+	}
+}
+
+// Encode returns the canonical encoding of the statement, prefixed by a
+// domain separator. Every variable length field is prefixed by its length.
+func (s *Statement) Encode() ([]byte, error) {
+	if s.Version != StatementVersion {
+		return nil, errors.New("unsupported statement version")
+	}
+	if s.NodeKey == nil {
+		return nil, errors.New("statement without node key")
+	}
+	key, err := s.NodeKey.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	writeBytes(&buf, []byte(statementDomain))
+	writeUint32(&buf, s.Version)
+	writeBytes(&buf, []byte(s.Protocol))
+	writeBytes(&buf, []byte(s.URL))
+	writeBytes(&buf, EncodeDigests(s.Digests))
+	writeBytes(&buf, s.Nonce)
+	var ts [8]byte
+	binary.BigEndian.PutUint64(ts[:], uint64(s.Timestamp))
+	buf.Write(ts[:])
+	writeBytes(&buf, key)
+	writeUint32(&buf, s.Flags)
+	writeBytes(&buf, s.Payload)
+	return buf.Bytes(), nil
+}
+
+// SignStatement signs the encoding of the statement with a Schnorr signature
+func SignStatement(private kyber.Scalar, s *Statement) ([]byte, error) {
+	msg, err := s.Encode()
+	if err != nil {
+		return nil, err
+	}
+	return schnorr.Sign(cothority.Suite, private, msg)
+}
+
+// VerifyStatement verifies the signature of the statement by its node key
+func VerifyStatement(s *Statement, sig []byte) error {
+	msg, err := s.Encode()
+	if err != nil {
+		return err
+	}
+	return schnorr.Verify(cothority.Suite, s.NodeKey, msg, sig)
+}
@@ -0,0 +1,224 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"hash"
+	"io"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.dedis.ch/onet/v3/log"
+)
+
+// ErrTooLarge is returned when a resource exceeds the maximum size allowed by
+// the fetch options
+var ErrTooLarge = errors.New("resource exceeds the maximum allowed size")
+
+// ErrProgressTimeout is returned when no data has been received for longer
+// than the progress timeout allowed by the fetch options
+var ErrProgressTimeout = errors.New("no progress while downloading the resource")
+
+// FetchOptions bounds the download of a resource. The zero value of every
+// field means that only the limit of the conode applies, see FetchLimits.
+type FetchOptions struct {
+	// MaxSize is the maximum number of bytes read from the body
+	MaxSize int64
+	// ProgressTimeout aborts the download if no data is received for
+	// this duration
+	ProgressTimeout time.Duration
+	// Timeout aborts the download if it is not completed within this
+	// duration
+	Timeout time.Duration
+	// AnyContentType disables the content type check, e.g. to verify
+	// release tarballs or ISO images
+	AnyContentType bool
+}
+
+// DefaultFetchLimits are the limits of a conode on the downloads when its
+// operator has not set any
+var DefaultFetchLimits = FetchOptions{
+	MaxSize:         1 << 32,
+	ProgressTimeout: time.Minute,
+	Timeout:         30 * time.Minute,
+}
+
+// fetchLimits are the limits of the conode on the downloads, which apply
+// whatever the options requested by the clients
+var (
+	fetchLimits     = DefaultFetchLimits
+	fetchLimitsLock sync.RWMutex
+)
+
+// SetFetchLimits sets the limits of the conode on the downloads. Every limit
+// must be set.
+func SetFetchLimits(limits FetchOptions) error {
+	if limits.MaxSize <= 0 || limits.ProgressTimeout <= 0 || limits.Timeout <= 0 {
+		return errors.New("every fetch limit must be positive")
+	}
+	fetchLimitsLock.Lock()
+	defer fetchLimitsLock.Unlock()
+	fetchLimits = limits
+	return nil
+}
+
+// FetchLimits returns the limits of the conode on the downloads
+func FetchLimits() FetchOptions {
+	fetchLimitsLock.RLock()
+	defer fetchLimitsLock.RUnlock()
+	return fetchLimits
+}
+
+// Bound returns the options restricted by the limits: a limit applies when
+// the option is unlimited or above it. The options can be nil.
+func (o *FetchOptions) Bound(limits FetchOptions) *FetchOptions {
+	b := &FetchOptions{}
+	if o != nil {
+		*b = *o
+	}
+	if b.MaxSize <= 0 || b.MaxSize > limits.MaxSize {
+		b.MaxSize = limits.MaxSize
+	}
+	if b.ProgressTimeout <= 0 || b.ProgressTimeout > limits.ProgressTimeout {
+		b.ProgressTimeout = limits.ProgressTimeout
+	}
+	if b.Timeout <= 0 || b.Timeout > limits.Timeout {
+		b.Timeout = limits.Timeout
+	}
+	return b
+}
+
+// ResourceInfo describes a resource whose body has been streamed
+type ResourceInfo struct {
+	URL         string
+	ContentType string
+	Size        int64
+	// status line and header of the response, without the fields of
+	// the transfer encoding that have been removed from the body
+	Status string
+	Header http.Header
+}
+
+// StreamResource fetches the resource referenced by URL and copies its body
+// to w without keeping it in memory. The download is bounded by opts, which
+// can be nil, and by the limits of the conode.
+func StreamResource(URL string, opts *FetchOptions, w io.Writer) (*ResourceInfo, error) {
+	opts = opts.Bound(FetchLimits())
+
+	ctx, cancel := context.WithTimeout(context.Background(), opts.Timeout)
+	defer cancel()
+
+	res, err := openResource(ctx, cancel, URL, opts)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	// check content type before downloading anything
+	ct := res.Header.Get("Content-Type")
+	if !opts.AnyContentType {
+		if err := checkContentType(ct); err != nil {
+			return nil, err
+		}
+	}
+
+	// reject early the resources that announce a size above the limit
+	if opts.MaxSize > 0 && res.ContentLength > opts.MaxSize {
+		return nil, ErrTooLarge
+	}
+
+	var body io.Reader = res.Body
+	if opts.ProgressTimeout > 0 {
+		pr := newProgressReader(res.Body, opts.ProgressTimeout)
+		defer pr.stop()
+		body = pr
+	}
+	if opts.MaxSize > 0 {
+		// read one more byte to detect resources above the limit
+		body = io.LimitReader(body, opts.MaxSize+1)
+	}
+
+	n, err := io.Copy(w, body)
+	if err != nil {
+		return nil, err
+	}
+	if opts.MaxSize > 0 && n > opts.MaxSize {
+		return nil, ErrTooLarge
+	}
+	log.Lvlf3("streamed %d bytes from %s", n, URL)
+
+	info := &ResourceInfo{
+		URL:         URL,
+		ContentType: ct,
+		Size:        n,
+		Status:      res.Proto + " " + res.Status,
+		Header:      res.Header,
+	}
+
+	return info, nil
+}
+
+// StreamHash fetches the resource referenced by URL and computes the digests
+// of all the given hashers in a single pass over the body. The digests are
+// returned in the same order as the hashers.
+func StreamHash(URL string, opts *FetchOptions, hashers ...hash.Hash) (*ResourceInfo, [][]byte, error) {
+	if len(hashers) == 0 {
+		return nil, nil, errors.New("no hash function provided")
+	}
+
+	writers := make([]io.Writer, len(hashers))
+	for i, h := range hashers {
+		writers[i] = h
+	}
+
+	info, err := StreamResource(URL, opts, io.MultiWriter(writers...))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	digests := make([][]byte, len(hashers))
+	for i, h := range hashers {
+		digests[i] = h.Sum(nil)
+	}
+
+	return info, digests, nil
+}
+
+// progressReader aborts the reads from the underlying body if no data has
+// been received for the given timeout
+type progressReader struct {
+	body    io.ReadCloser
+	timeout time.Duration
+	timer   *time.Timer
+	expired int32
+}
+
+func newProgressReader(body io.ReadCloser, timeout time.Duration) *progressReader {
+	p := &progressReader{
+		body:    body,
+		timeout: timeout,
+	}
+	// closing the body unblocks a pending read
+	p.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&p.expired, 1)
+		p.body.Close()
+	})
+	return p
+}
+
+func (p *progressReader) Read(b []byte) (int, error) {
+	n, err := p.body.Read(b)
+	if atomic.LoadInt32(&p.expired) == 1 {
+		return n, ErrProgressTimeout
+	}
+	if n > 0 {
+		p.timer.Reset(p.timeout)
+	}
+	return n, err
+}
+
+func (p *progressReader) stop() {
+	p.timer.Stop()
+}
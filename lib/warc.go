@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// WARCVersion is the version of the WARC files written and read
+const WARCVersion = "WARC/1.1"
+
+// types of the WARC records
+const (
+	WARCInfo     = "warcinfo"
+	WARCRequest  = "request"
+	WARCResponse = "response"
+	WARCMetadata = "metadata"
+)
+
+// maxWARCRecordSize bounds the content of the records read, which are kept
+// in memory. The content is read as it comes, so that a record announcing a
+// large content doesn't allocate it upfront.
+const maxWARCRecordSize = 1 << 30
+
+// WARCField is a named field of the header of a WARC record
+type WARCField struct {
+	Name  string
+	Value string
+}
+
+// WARCRecord is a record of a WARC file, as specified by ISO 28500:2017. The
+// fields are kept in order, the Content-Length field being derived from the
+// content when the record is written.
+type WARCRecord struct {
+	Fields  []WARCField
+	Content []byte
+}
+
+// NewWARCRecord returns a record of the given type made at the given time,
+// with a new record ID and the digest of its content
+func NewWARCRecord(kind string, date time.Time, content []byte) *WARCRecord {
+	r := &WARCRecord{Content: content}
+	r.Set("WARC-Type", kind)
+	r.Set("WARC-Record-ID", NewWARCRecordID())
+	r.Set("WARC-Date", date.UTC().Format(time.RFC3339))
+	r.Set("WARC-Block-Digest", WARCDigest(content))
+	return r
+}
+
+// NewWARCRecordID returns a random URN UUID, as used to identify records
+func NewWARCRecordID() string {
+	b := GenNonce()[:16]
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("<urn:uuid:%x-%x-%x-%x-%x>", b[:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
+
+// WARCDigest returns the labeled SHA-256 digest of data, in base 32 as the
+// digests of other WARC tools
+func WARCDigest(data []byte) string {
+	h := sha256.Sum256(data)
+	return "sha256:" + base32.StdEncoding.EncodeToString(h[:])
+}
+
+// Get returns the value of the first field with the name, ignoring case, or
+// the empty string
+func (r *WARCRecord) Get(name string) string {
+	for _, f := range r.Fields {
+		if strings.EqualFold(f.Name, name) {
+			return f.Value
+		}
+	}
+	return ""
+}
+
+// Set replaces the value of the field with the name, or adds the field
+func (r *WARCRecord) Set(name, value string) {
+	for i, f := range r.Fields {
+		if strings.EqualFold(f.Name, name) {
+			r.Fields[i].Value = value
+			return
+		}
+	}
+	r.Fields = append(r.Fields, WARCField{Name: name, Value: value})
+}
+
+// WriteWARCRecord writes a record to a WARC file
+func WriteWARCRecord(w io.Writer, r *WARCRecord) error {
+	var buf bytes.Buffer
+	buf.WriteString(WARCVersion + "\r\n")
+	for _, f := range r.Fields {
+		if strings.EqualFold(f.Name, "Content-Length") {
+			continue
+		}
+		if strings.ContainsAny(f.Name+f.Value, "\r\n") {
+			return errors.New("invalid WARC field " + f.Name)
+		}
+		buf.WriteString(f.Name + ": " + f.Value + "\r\n")
+	}
+	buf.WriteString("Content-Length: " + strconv.Itoa(len(r.Content)) + "\r\n\r\n")
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		return err
+	}
+	if _, err := w.Write(r.Content); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\r\n\r\n")
+	return err
+}
+
+// WARCWriter writes the records of a WARC file, every record being a gzip
+// member of its own if compressed, as in .warc.gz files
+type WARCWriter struct {
+	w        io.Writer
+	compress bool
+}
+
+// NewWARCWriter returns a writer of the records of a WARC file
+func NewWARCWriter(w io.Writer, compress bool) *WARCWriter {
+	return &WARCWriter{w: w, compress: compress}
+}
+
+// WriteRecord writes a record to the WARC file
+func (ww *WARCWriter) WriteRecord(r *WARCRecord) error {
+	if !ww.compress {
+		return WriteWARCRecord(ww.w, r)
+	}
+	gz := gzip.NewWriter(ww.w)
+	if err := WriteWARCRecord(gz, r); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// WARCReader reads the records of a WARC file, compressed with gzip or not
+type WARCReader struct {
+	r *bufio.Reader
+	// the compression is detected on the first record
+	started bool
+}
+
+// NewWARCReader returns a reader of the records of the WARC file
+func NewWARCReader(r io.Reader) *WARCReader {
+	return &WARCReader{r: bufio.NewReader(r)}
+}
+
+// start reads the records of the decompressed file if it begins with the
+// magic number of gzip. The gzip reader reads the members of the records one
+// after the other.
+func (wr *WARCReader) start() error {
+	wr.started = true
+	magic, err := wr.r.Peek(2)
+	if err != nil || magic[0] != 0x1f || magic[1] != 0x8b {
+		return nil
+	}
+	gz, err := gzip.NewReader(wr.r)
+	if err != nil {
+		return err
+	}
+	wr.r = bufio.NewReader(gz)
+	return nil
+}
+
+// ReadRecord returns the next record of the file, or io.EOF at its end.
+// Versions 1.0 and 1.1 of the format are accepted.
+func (wr *WARCReader) ReadRecord() (*WARCRecord, error) {
+	if !wr.started {
+		if err := wr.start(); err != nil {
+			return nil, err
+		}
+	}
+	// records are separated by empty lines
+	var line string
+	for line == "" {
+		l, err := wr.r.ReadString('\n')
+		if err == io.EOF && l == "" {
+			return nil, io.EOF
+		}
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		line = strings.TrimRight(l, "\r\n")
+	}
+	if line != WARCVersion && line != "WARC/1.0" {
+		return nil, errors.New("unsupported WARC version: " + line)
+	}
+
+	r := &WARCRecord{}
+	for {
+		l, err := wr.r.ReadString('\n')
+		if err != nil {
+			return nil, io.ErrUnexpectedEOF
+		}
+		l = strings.TrimRight(l, "\r\n")
+		if l == "" {
+			break
+		}
+		i := strings.Index(l, ":")
+		if i <= 0 {
+			return nil, errors.New("invalid WARC field: " + l)
+		}
+		r.Fields = append(r.Fields, WARCField{
+			Name:  l[:i],
+			Value: strings.TrimSpace(l[i+1:]),
+		})
+	}
+
+	n, err := strconv.ParseInt(r.Get("Content-Length"), 10, 64)
+	if err != nil || n < 0 {
+		return nil, errors.New("invalid WARC content length")
+	}
+	if n > maxWARCRecordSize {
+		return nil, errors.New("WARC record too large")
+	}
+	var content bytes.Buffer
+	read, err := io.Copy(&content, io.LimitReader(wr.r, n))
+	if err != nil {
+		return nil, err
+	}
+	if read != n {
+		return nil, io.ErrUnexpectedEOF
+	}
+	r.Content = content.Bytes()
+	return r, nil
+}
@@ -0,0 +1,46 @@
+package lib
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestWARCRecords(t *testing.T) {
+	records := []*WARCRecord{
+		NewWARCRecord(WARCInfo, time.Now(), []byte("software: test\r\n")),
+		NewWARCRecord(WARCResponse, time.Now(), []byte("HTTP/1.1 200 OK\r\n\r\nbody")),
+	}
+	for _, compress := range []bool{false, true} {
+		var buf bytes.Buffer
+		w := NewWARCWriter(&buf, compress)
+		for _, r := range records {
+			require.Nil(t, w.WriteRecord(r))
+		}
+
+		rd := NewWARCReader(&buf)
+		for _, r := range records {
+			read, err := rd.ReadRecord()
+			require.Nil(t, err)
+			require.Equal(t, r.Content, read.Content)
+			require.Equal(t, r.Get("WARC-Record-ID"), read.Get("WARC-Record-ID"))
+		}
+		_, err := rd.ReadRecord()
+		require.Equal(t, io.EOF, err)
+	}
+}
+
+func TestWARCTruncatedRecord(t *testing.T) {
+	// a record announcing a large content is read as far as it goes
+	file := WARCVersion + "\r\nWARC-Type: response\r\nContent-Length: 1000000000\r\n\r\nshort"
+	_, err := NewWARCReader(strings.NewReader(file)).ReadRecord()
+	require.Equal(t, io.ErrUnexpectedEOF, err)
+
+	file = WARCVersion + "\r\nWARC-Type: response\r\nContent-Length: 2000000000\r\n\r\n"
+	_, err = NewWARCReader(strings.NewReader(file)).ReadRecord()
+	require.NotNil(t, err)
+}
@@ -0,0 +1,251 @@
+package protocol
+
+import (
+	"errors"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	dkg "go.dedis.ch/kyber/v3/share/dkg/pedersen"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// NameDKG is the protocol identifier string
+const NameDKG = "DKG"
+
+func init() {
+	network.RegisterMessages(DKGAnnouncement{}, DKGDeal{}, DKGResponse{},
+		DKGDone{})
+	onet.GlobalProtocolRegister(NameDKG, NewDKGProtocol)
+}
+
+// DKG is the core structure of the distributed key generation: every node of
+// the tree deals shares of a random secret to the others, so that all the
+// nodes end up with a share of a collective private key, whose public key is
+// known to everybody and which can only be used with the cooperation of a
+// threshold of nodes. Complaints are not resolved, the generation fails if a
+// deal is not approved by all the nodes. If the nodes already share a key,
+// they can instead deal new shares of the same key, e.g. with another
+// threshold.
+type DKG struct {
+	*onet.TreeNodeInstance
+	// number of nodes needed to use the collective private key, by default
+	// two thirds of the nodes
+	Threshold int
+	// share of the current key of the node and public keys of the nodes in
+	// the order of its shares, set by the service to reshare the key
+	Previous        *dkg.DistKeyShare
+	PreviousPublics []kyber.Point
+	// requests of the operators of the nodes authorizing the resharing
+	Operators []*lib.OperatorRequest
+	// called by every node other than the root with the announcement, to
+	// check that it may take part in the generation
+	Authorize func(*DKGAnnouncement) error
+	// called by every node with its share of the collective key
+	OnShare func(*dkg.DistKeyShare)
+
+	// results of the protocol
+	// the share of the collective key of the node
+	Share *dkg.DistKeyShare
+
+	// protocol channels
+	announce chan chanDKGAnnouncement
+	deal     chan chanDKGDeal
+	response chan chanDKGResponse
+	done     chan []chanDKGDone
+	// the channel that indicates if we are finished or not
+	Finished chan bool
+}
+
+// NewDKGProtocol returns a DKGProtocol with the right channels initialized
+func NewDKGProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	log.Lvl2("creating new DKG protocol")
+	d := &DKG{
+		TreeNodeInstance: n,
+		Finished:         make(chan bool, 1),
+	}
+
+	// every node receives a deal and a response about every deal from all
+	// the other nodes before reading them
+	size := len(n.List())
+	if err := n.RegisterChannelsLength(size*size, &d.announce, &d.deal,
+		&d.response, &d.done); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Start is executed by the root to start the protocol, by checking the
+// threshold and by sending the announcement
+func (d *DKG) Start() error {
+	log.Lvl2("starting DKG protocol")
+	nbrNodes := len(d.List())
+	if d.Threshold == 0 {
+		d.Threshold = nbrNodes - (nbrNodes-1)/3
+	}
+	if d.Threshold < 1 || d.Threshold > nbrNodes {
+		return errors.New("threshold must be between 1 and the number of nodes")
+	}
+	a := &DKGAnnouncement{Threshold: d.Threshold, Operators: d.Operators}
+	if d.Previous != nil {
+		a.Public = d.Previous.Public()
+	}
+	return d.SendToChildren(a)
+}
+
+// Dispatch runs the generation on every node and gathers the public keys
+// computed by the conodes on the root
+func (d *DKG) Dispatch() error {
+	defer d.Done()
+
+	if !d.IsRoot() {
+		log.Lvl3(d.Name(), "waiting for announcement")
+		a := (<-d.announce).DKGAnnouncement
+		if d.Authorize != nil {
+			if err := d.Authorize(&a); err != nil {
+				return err
+			}
+		}
+		d.Threshold = a.Threshold
+		if a.Public == nil {
+			d.Previous = nil
+		} else if d.Previous == nil || !d.Previous.Public().Equal(a.Public) {
+			return errors.New("no share of the distributed key to reshare")
+		}
+		if err := d.generate(); err != nil {
+			return err
+		}
+		return d.SendToParent(&DKGDone{Public: d.Share.Public()})
+	}
+
+	if err := d.generate(); err != nil {
+		return err
+	}
+	if d.Previous != nil && !d.Previous.Public().Equal(d.Share.Public()) {
+		return errors.New("resharing changed the distributed key")
+	}
+	for _, r := range <-d.done {
+		if r.Public == nil || !r.Public.Equal(d.Share.Public()) {
+			return errors.New("different distributed key on " + r.ServerIdentity.String())
+		}
+	}
+
+	log.Lvl2("DKG protocol terminated")
+	d.Finished <- true
+	return nil
+}
+
+// generate exchanges the deals and the responses with the other nodes and
+// computes the share of the node
+func (d *DKG) generate() error {
+	nodes := d.List()
+	if d.Threshold < 1 || d.Threshold > len(nodes) {
+		return errors.New("invalid threshold")
+	}
+	publics := make([]kyber.Point, len(nodes))
+	for i, n := range nodes {
+		publics[i] = nodePublic(n)
+	}
+	gen, err := d.generator(publics)
+	if err != nil {
+		return err
+	}
+
+	deals, err := gen.Deals()
+	if err != nil {
+		return err
+	}
+	for i, deal := range deals {
+		if !nodes[i].ID.Equal(d.TreeNode().ID) {
+			if err := d.SendTo(nodes[i], &DKGDeal{Deal: deal}); err != nil {
+				return err
+			}
+			continue
+		}
+		// when resharing, the node deals a share to itself as well
+		resp, err := gen.ProcessDeal(deal)
+		if err != nil {
+			return err
+		}
+		if err := d.broadcast(&DKGResponse{Response: resp}); err != nil {
+			return err
+		}
+	}
+
+	// process the deals of the other nodes and send our responses to
+	// everybody
+	for i := 0; i < len(nodes)-1; i++ {
+		deal := (<-d.deal).DKGDeal
+		resp, err := gen.ProcessDeal(deal.Deal)
+		if err != nil {
+			return err
+		}
+		if err := d.broadcast(&DKGResponse{Response: resp}); err != nil {
+			return err
+		}
+	}
+
+	// process the responses of the other nodes about all the deals, which
+	// include their own deals when resharing
+	nbrDeals := len(nodes) - 1
+	if d.Previous != nil {
+		nbrDeals = len(nodes)
+	}
+	for i := 0; i < (len(nodes)-1)*nbrDeals; i++ {
+		resp := (<-d.response).DKGResponse
+		justification, err := gen.ProcessResponse(resp.Response)
+		if err != nil {
+			return err
+		}
+		if justification != nil {
+			return errors.New("complaint about the deal of this node")
+		}
+	}
+	if !gen.Certified() {
+		return errors.New("distributed key not certified")
+	}
+
+	d.Share, err = gen.DistKeyShare()
+	if err != nil {
+		return err
+	}
+	log.Lvl3(d.Name(), "computed its share of the distributed key")
+	if d.OnShare != nil {
+		d.OnShare(d.Share)
+	}
+	return nil
+}
+
+// generator returns the generator of the shares of a new key, or of new
+// shares of the previous key
+func (d *DKG) generator(publics []kyber.Point) (*dkg.DistKeyGenerator, error) {
+	if d.Previous == nil {
+		return dkg.NewDistKeyGenerator(cothority.Suite, servicePrivate(d.TreeNodeInstance), publics, d.Threshold)
+	}
+	return dkg.NewDistKeyHandler(&dkg.Config{
+		Suite:        cothority.Suite,
+		Longterm:     servicePrivate(d.TreeNodeInstance),
+		OldNodes:     d.PreviousPublics,
+		PublicCoeffs: d.Previous.Commits,
+		NewNodes:     publics,
+		Share:        d.Previous,
+		Threshold:    d.Threshold,
+		OldThreshold: len(d.Previous.Commits),
+	})
+}
+
+// broadcast sends the message to all the other nodes of the tree
+func (d *DKG) broadcast(msg interface{}) error {
+	for _, n := range d.List() {
+		if n.ID.Equal(d.TreeNode().ID) {
+			continue
+		}
+		if err := d.SendTo(n, msg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package protocol
+
+import (
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	dkg "go.dedis.ch/kyber/v3/share/dkg/pedersen"
+	"go.dedis.ch/onet/v3"
+)
+
+// DKGAnnouncement is sent down the tree by the root to start a new
+// distributed key generation, or the resharing of the distributed key if set,
+// with the requests of the operators of the nodes authorizing the resharing
+type DKGAnnouncement struct {
+	Threshold int
+	Public    kyber.Point
+	Operators []*lib.OperatorRequest
+}
+
+type chanDKGAnnouncement struct {
+	*onet.TreeNode
+	DKGAnnouncement
+}
+
+// DKGDeal is sent by every node to every other node and contains the share
+// of the secret of the sender for the receiver
+type DKGDeal struct {
+	Deal *dkg.Deal
+}
+
+type chanDKGDeal struct {
+	*onet.TreeNode
+	DKGDeal
+}
+
+// DKGResponse is sent by every node to every other node and contains the
+// approval or the complaint of the sender about a deal
+type DKGResponse struct {
+	Response *dkg.Response
+}
+
+type chanDKGResponse struct {
+	*onet.TreeNode
+	DKGResponse
+}
+
+// DKGDone is sent by every conode to the root and contains the distributed
+// public key it computed
+type DKGDone struct {
+	Public kyber.Point
+}
+
+type chanDKGDone struct {
+	*onet.TreeNode
+	DKGDone
+}
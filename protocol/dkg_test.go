@@ -0,0 +1,37 @@
+package protocol
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+func TestDKGProtocol(t *testing.T) {
+	// define log visibility level
+	//log.SetDebugVisible(3)
+
+	for _, nbrHosts := range []int{3, 7} {
+		log.Lvl2("testing DKG protocol with", nbrHosts, "hosts")
+		local := onet.NewLocalTest(tSuite)
+		_, _, tree := local.GenBigTree(nbrHosts, nbrHosts, nbrHosts, true)
+
+		instance, err := local.CreateProtocol(NameDKG, tree)
+		require.Nil(t, err)
+		p := instance.(*DKG)
+		require.Nil(t, p.Start())
+
+		select {
+		case <-p.Finished:
+			// two thirds of the nodes are needed by default
+			require.Equal(t, nbrHosts-(nbrHosts-1)/3, p.Threshold)
+			require.NotNil(t, p.Share.Public())
+			require.Equal(t, p.Threshold, len(p.Share.Commits))
+		case <-time.After(time.Second * 10):
+			t.Fatal("couldn't get DKG protocol done in time")
+		}
+		local.CloseAll()
+	}
+}
@@ -0,0 +1,642 @@
+package protocol
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/si-co/dpcc/lib"
+	"github.com/willf/bloom"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// NameDOMConsensus is the protocol identifier string
+const NameDOMConsensus = "DOMConsensus"
+
+// maxFilterSize bounds the number of cells of the Bloom filters requested by
+// the root
+const maxFilterSize = 1 << 24
+
+// maxEncryptedFilterSize bounds the number of ciphertexts of the encrypted
+// filters requested by the root
+const maxEncryptedFilterSize = 1 << 16
+
+// maxTimestampSkew bounds the difference between the time of the consensus
+// document chosen by the root and the clock of the conodes signing it
+const maxTimestampSkew = time.Minute
+
+func init() {
+	network.RegisterMessages(DOMConsensusAnnouncement{}, DOMConsensusResponse{},
+		DOMConsensusDecrypt{}, DOMConsensusPartial{}, DOMConsensusProposal{},
+		DOMConsensusCommitment{}, DOMConsensusChallenge{}, DOMConsensusCosiResponse{})
+	onet.GlobalProtocolRegister(NameDOMConsensus, NewDOMConsensusProtocol)
+}
+
+// DOMConsensus is the core structure of the protocol building a consensus
+// version of a page: every conode sends the hashes of the subtrees of the DOM
+// of the page, the root keeps only the nodes of its own version that a
+// threshold of conodes observed, and the resulting document is collectively
+// signed by the root and the conodes that observed all its nodes
+type DOMConsensus struct {
+	*onet.TreeNodeInstance
+	// resource's URL
+	URL string
+	// nonce received from the client
+	Nonce []byte
+	// number of conodes that must have observed a node to keep it, by
+	// default two thirds of the conodes
+	Threshold int
+	// limits for the download of the resource
+	Options *lib.FetchOptions
+	// in private mode the conodes send masked Bloom filters of their hashes
+	// instead of the hashes, so that the root only learns the sums of the
+	// filters
+	PrivateFilters bool
+	// in encrypted mode the conodes send their Bloom filters encrypted under
+	// the distributed key of the roster and signed, the root sends all the
+	// filters to the conodes, which check theirs and decrypt only the sum
+	EncryptedFilters bool
+	// number of cells and of hash functions of the Bloom filters, by
+	// default computed for lib.DefaultFilterElements, or for
+	// lib.DefaultEncryptedFilterElements in encrypted mode
+	FilterSize   uint
+	FilterHashes uint
+
+	// distributed key of the roster and share of the node, set by the
+	// service for the encrypted mode
+	DistKey      kyber.Point
+	Share        *share.PriShare
+	KeyThreshold int
+	NbrShares    int
+
+	// results of the protocol
+	// the consensus document
+	Document []byte
+	// the public keys of the signers of the document, at least two thirds
+	// of the conodes
+	Signers []kyber.Point
+	// the collective signature of the document
+	Signature []byte
+	// the time of the collectively signed statement
+	Timestamp int64
+
+	// version of the page seen by the root
+	page []byte
+	// hashes of the version of the page seen by the conode
+	hashes *lib.DOMHashes
+	// encrypted filter of the conode
+	filter []*lib.ElGamalCiphertext
+	// sum of the encrypted filters, decrypted by the conodes
+	encrypted []*lib.ElGamalCiphertext
+
+	// secret used for the collective signature, nil if the conode refused
+	// to sign
+	secret kyber.Scalar
+	// aggregate commitment and response of the root
+	commitment   kyber.Point
+	rootResponse kyber.Scalar
+
+	// protocol channels
+	announce     chan chanDOMConsensusAnnouncement
+	response     chan []chanDOMConsensusResponse
+	decrypt      chan chanDOMConsensusDecrypt
+	partial      chan []chanDOMConsensusPartial
+	proposal     chan chanDOMConsensusProposal
+	commit       chan []chanDOMConsensusCommitment
+	challenge    chan chanDOMConsensusChallenge
+	cosiResponse chan []chanDOMConsensusCosiResponse
+	// the channel that indicates if we are finished or not
+	Finished chan bool
+}
+
+// NewDOMConsensusProtocol returns a DOMConsensusProtocol with the right
+// channels initialized
+func NewDOMConsensusProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	log.Lvl2("creating new DOM consensus protocol")
+	d := &DOMConsensus{
+		TreeNodeInstance: n,
+		Finished:         make(chan bool, 1),
+	}
+
+	// register the channels we want listen on
+	if err := n.RegisterChannels(&d.announce, &d.response, &d.decrypt,
+		&d.partial, &d.proposal, &d.commit, &d.challenge, &d.cosiResponse); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Start is executed by the root to start the protocol, by checking that all
+// the needed parameters have been initialized and by sending the announcement
+func (d *DOMConsensus) Start() error {
+	log.Lvl2("starting DOM consensus protocol")
+	if d.URL == "" {
+		return errors.New("initialize URL first")
+	}
+	if d.Nonce == nil {
+		return errors.New("initialize nonce first")
+	}
+	nbrChild := len(d.Children())
+	if d.Threshold == 0 {
+		d.Threshold = nbrChild - (nbrChild-1)/3
+	}
+	if d.Threshold < 1 || d.Threshold > nbrChild {
+		return errors.New("threshold must be between 1 and the number of conodes")
+	}
+	if d.PrivateFilters && d.EncryptedFilters {
+		return errors.New("choose either masked or encrypted filters")
+	}
+	if d.EncryptedFilters && d.FilterSize > maxEncryptedFilterSize {
+		return errors.New("encrypted filters are too large")
+	}
+	if d.EncryptedFilters {
+		if err := d.checkKey(); err != nil {
+			return err
+		}
+	}
+	if d.FilterSize == 0 || d.FilterHashes == 0 {
+		switch {
+		case d.PrivateFilters:
+			d.FilterSize, d.FilterHashes = bloom.EstimateParameters(
+				lib.DefaultFilterElements, lib.DefaultFilterFPRate)
+		case d.EncryptedFilters:
+			d.FilterSize, d.FilterHashes = bloom.EstimateParameters(
+				lib.DefaultEncryptedFilterElements, lib.DefaultEncryptedFilterFPRate)
+		}
+	}
+
+	a := &DOMConsensusAnnouncement{
+		URL:              d.URL,
+		Nonce:            d.Nonce,
+		Options:          d.Options,
+		PrivateFilters:   d.PrivateFilters,
+		EncryptedFilters: d.EncryptedFilters,
+		FilterSize:       d.FilterSize,
+		FilterHashes:     d.FilterHashes,
+		DistKey:          d.DistKey,
+	}
+	return d.SendToChildren(a)
+}
+
+// Dispatch will listen on the channels of the two phases of the protocol
+func (d *DOMConsensus) Dispatch() error {
+	defer d.Done()
+
+	// if we are a leaf, we answer the announcement, the proposal and the
+	// challenge
+	if !d.IsRoot() {
+		log.Lvl3(d.Name(), "waiting for announcement")
+		a := (<-d.announce).DOMConsensusAnnouncement
+		if err := d.handleAnnouncement(&a); err != nil {
+			return err
+		}
+		if d.EncryptedFilters {
+			log.Lvl3(d.Name(), "waiting for decryption request")
+			dec := (<-d.decrypt).DOMConsensusDecrypt
+			if err := d.handleDecrypt(&dec); err != nil {
+				return err
+			}
+		}
+		log.Lvl3(d.Name(), "waiting for consensus document")
+		prop := (<-d.proposal).DOMConsensusProposal
+		if err := d.handleProposal(&prop); err != nil {
+			return err
+		}
+		log.Lvl3(d.Name(), "waiting for challenge")
+		c := (<-d.challenge).DOMConsensusChallenge
+		return d.handleChallenge(&c)
+	}
+
+	// if we are the root, we assemble the consensus document and sign it
+	// collectively
+	if err := d.handleResponses(<-d.response); err != nil {
+		return err
+	}
+	if d.EncryptedFilters {
+		if err := d.handlePartials(<-d.partial); err != nil {
+			return err
+		}
+	}
+	if err := d.handleCommitments(<-d.commit); err != nil {
+		return err
+	}
+	if err := d.handleCosiResponses(<-d.cosiResponse); err != nil {
+		return err
+	}
+
+	log.Lvl2("DOM consensus protocol terminated")
+	d.Finished <- true
+	return nil
+}
+
+// handleAnnouncement hashes the DOM of the page and sends the hashes to the
+// root
+func (d *DOMConsensus) handleAnnouncement(in *DOMConsensusAnnouncement) error {
+	d.URL = in.URL
+	d.Nonce = in.Nonce
+	d.Options = in.Options
+	d.PrivateFilters = in.PrivateFilters
+	d.EncryptedFilters = in.EncryptedFilters
+	d.FilterSize = in.FilterSize
+	d.FilterHashes = in.FilterHashes
+	log.Lvlf4("%s received %s as URL in announcement", d.Name(), d.URL)
+
+	page, err := d.fetchPage()
+	if err != nil {
+		return err
+	}
+	hashes, err := lib.NewDOMHashes(page)
+	if err != nil {
+		return err
+	}
+	d.hashes = hashes
+
+	r := &DOMConsensusResponse{
+		PublicKey: servicePublic(d.TreeNodeInstance),
+	}
+	switch {
+	case d.PrivateFilters:
+		r.Filter, err = d.maskedFilter(hashes)
+		if err != nil {
+			return err
+		}
+		log.Lvlf3("%s sending masked filter of %d cells to parent", d.Name(), len(r.Filter))
+	case d.EncryptedFilters:
+		if d.FilterSize == 0 || d.FilterSize > maxEncryptedFilterSize || d.FilterHashes == 0 {
+			return errors.New("invalid Bloom filter parameters")
+		}
+		if err := d.checkKey(); err != nil {
+			return err
+		}
+		if in.DistKey == nil || !in.DistKey.Equal(d.DistKey) {
+			return errors.New("announced key is not the distributed key of the roster")
+		}
+		filter := lib.NewDOMFilter(hashes, d.FilterSize, d.FilterHashes)
+		d.filter = lib.EncryptCells(d.DistKey, filter)
+		r.Encrypted = d.filter
+		r.Signature, err = lib.SignCiphertexts(servicePrivate(d.TreeNodeInstance), NameDOMConsensus, d.Nonce, d.filter)
+		if err != nil {
+			return err
+		}
+		log.Lvlf3("%s sending encrypted filter of %d cells to parent", d.Name(), len(r.Encrypted))
+	default:
+		r.Hashes = hashes
+		log.Lvlf3("%s sending %d subtree hashes to parent", d.Name(), len(hashes.Subtrees))
+	}
+	return d.SendToParent(r)
+}
+
+// checkKey checks that the service provided the share of the distributed key
+// of the roster
+func (d *DOMConsensus) checkKey() error {
+	if d.DistKey == nil || d.Share == nil || d.Share.V == nil {
+		return errors.New("no distributed key for the roster")
+	}
+	if d.KeyThreshold < 1 || d.KeyThreshold > d.NbrShares {
+		return errors.New("invalid threshold of the distributed key")
+	}
+	return nil
+}
+
+// handleDecrypt checks the encrypted filters sent by the root: there must be
+// one filter signed by every conode, and the one of this conode must be
+// unmodified. The conode then adds the filters itself and sends the partial
+// decryption of the sum to the root, so that a single filter is never
+// decrypted.
+func (d *DOMConsensus) handleDecrypt(in *DOMConsensusDecrypt) error {
+	children := d.Root().Children
+	if len(in.Filters) != len(children) {
+		return errors.New("decryption request without the filters of all the conodes")
+	}
+	conodes := make(map[string]bool)
+	for _, c := range children {
+		conodes[nodePublic(c).String()] = true
+	}
+	own := false
+	sum := make([]*lib.ElGamalCiphertext, d.FilterSize)
+	for _, f := range in.Filters {
+		if f == nil || f.PublicKey == nil || !conodes[f.PublicKey.String()] {
+			return errors.New("filter of an unexpected conode in decryption request")
+		}
+		delete(conodes, f.PublicKey.String())
+		if err := lib.VerifyCiphertexts(f.PublicKey, NameDOMConsensus, d.Nonce, f.Ciphertexts, f.Signature); err != nil {
+			return errors.New("invalid signature of filter in decryption request: " + err.Error())
+		}
+		if f.PublicKey.Equal(servicePublic(d.TreeNodeInstance)) {
+			if !lib.EqualCiphertexts(f.Ciphertexts, d.filter) {
+				return errors.New("filter of the conode modified in decryption request")
+			}
+			own = true
+		}
+		if err := lib.AddCiphertexts(sum, f.Ciphertexts); err != nil {
+			return err
+		}
+	}
+	if !own {
+		return errors.New("filter of the conode missing in decryption request")
+	}
+
+	p := &DOMConsensusPartial{
+		PublicKey: servicePublic(d.TreeNodeInstance),
+		Index:     d.Share.I,
+		Partials:  lib.PartialDecrypt(d.Share.V, sum),
+	}
+	return d.SendToParent(p)
+}
+
+// handlePartials decrypts the sum of the filters with the partial
+// decryptions of the conodes and of the root, and goes on with the
+// consensus document
+func (d *DOMConsensus) handlePartials(responses []chanDOMConsensusPartial) error {
+	partials := [][]*share.PubShare{
+		pubShares(d.Share.I, lib.PartialDecrypt(d.Share.V, d.encrypted)),
+	}
+	for _, r := range responses {
+		if len(r.Partials) != len(d.encrypted) {
+			log.Lvl2("ignoring invalid partial decryptions from", r.ServerIdentity)
+			continue
+		}
+		partials = append(partials, pubShares(r.Index, r.Partials))
+	}
+	counts, err := lib.DecryptCounts(d.encrypted, partials, d.KeyThreshold, d.NbrShares, len(d.Children()))
+	if err != nil {
+		return err
+	}
+	return d.signDocument(lib.BloomCounter(counts, d.FilterHashes))
+}
+
+// maskedFilter returns the Bloom filter of the hashes masked with the
+// secrets shared with the other conodes
+func (d *DOMConsensus) maskedFilter(hashes *lib.DOMHashes) ([]uint32, error) {
+	if d.FilterSize == 0 || d.FilterSize > maxFilterSize || d.FilterHashes == 0 {
+		return nil, errors.New("invalid Bloom filter parameters")
+	}
+	participants := []kyber.Point{}
+	for _, c := range d.Root().Children {
+		participants = append(participants, nodePublic(c))
+	}
+	mask, err := lib.BloomMask(servicePrivate(d.TreeNodeInstance), servicePublic(d.TreeNodeInstance), participants, d.Nonce, d.FilterSize)
+	if err != nil {
+		return nil, err
+	}
+	filter := lib.NewDOMFilter(hashes, d.FilterSize, d.FilterHashes)
+	if err := lib.AddCells(filter, mask); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// handleResponses combines the hashes or the filters of the conodes. The
+// encrypted filters are sent back to the conodes for decryption, otherwise
+// the consensus document is assembled and signed right away.
+func (d *DOMConsensus) handleResponses(responses []chanDOMConsensusResponse) error {
+	var err error
+	d.page, err = d.fetchPage()
+	if err != nil {
+		return err
+	}
+
+	observed := make([]*lib.DOMHashes, len(responses))
+	counts := make([]uint32, d.FilterSize)
+	d.encrypted = make([]*lib.ElGamalCiphertext, d.FilterSize)
+	filters := []*DOMConsensusFilter{}
+	for i, r := range responses {
+		switch {
+		case d.PrivateFilters:
+			// the masks cancel out once all the filters are added
+			if err := lib.AddCells(counts, r.Filter); err != nil {
+				return err
+			}
+		case d.EncryptedFilters:
+			if err := lib.VerifyCiphertexts(nodePublic(r.TreeNode), NameDOMConsensus, d.Nonce, r.Encrypted, r.Signature); err != nil {
+				return errors.New("invalid signature of filter of " + r.ServerIdentity.String())
+			}
+			if err := lib.AddCiphertexts(d.encrypted, r.Encrypted); err != nil {
+				return err
+			}
+			filters = append(filters, &DOMConsensusFilter{
+				PublicKey:   nodePublic(r.TreeNode),
+				Ciphertexts: r.Encrypted,
+				Signature:   r.Signature,
+			})
+		case r.Hashes == nil:
+			return errors.New("incomplete response from " + r.ServerIdentity.String())
+		}
+		observed[i] = r.Hashes
+	}
+
+	switch {
+	case d.PrivateFilters:
+		return d.signDocument(lib.BloomCounter(counts, d.FilterHashes))
+	case d.EncryptedFilters:
+		return d.SendToChildren(&DOMConsensusDecrypt{Filters: filters})
+	default:
+		return d.signDocument(lib.CountDOMHashes(observed))
+	}
+}
+
+// signDocument assembles the consensus document from the version of the
+// root and the counts of the hashes, and proposes it to the conodes
+func (d *DOMConsensus) signDocument(count lib.DOMCounter) error {
+	var err error
+	d.Document, err = lib.ConsensusDocument(d.page, count, d.Threshold)
+	if err != nil {
+		return err
+	}
+	d.Timestamp = time.Now().Unix()
+	p := &DOMConsensusProposal{
+		Document:  d.Document,
+		Timestamp: d.Timestamp,
+	}
+	return d.SendToChildren(p)
+}
+
+// handleProposal sends a commitment for the collective signature of the
+// consensus document to the root, if the conode observed all the nodes of
+// the document and its time is close to the clock of the conode. Otherwise
+// the conode refuses to sign it.
+func (d *DOMConsensus) handleProposal(in *DOMConsensusProposal) error {
+	d.Document = in.Document
+	d.Timestamp = in.Timestamp
+	c := &DOMConsensusCommitment{
+		PublicKey: servicePublic(d.TreeNodeInstance),
+	}
+	observed, err := lib.ObservedDocument(in.Document, d.hashes)
+	skew := time.Since(time.Unix(in.Timestamp, 0))
+	switch {
+	case err != nil:
+		log.Lvl2(d.Name(), "refusing to sign invalid consensus document:", err)
+	case !observed:
+		log.Lvl2(d.Name(), "refusing to sign consensus document with nodes it didn't observe")
+	case skew > maxTimestampSkew || skew < -maxTimestampSkew:
+		log.Lvl2(d.Name(), "refusing to sign consensus document made at", in.Timestamp)
+	default:
+		d.secret, c.Commitment = lib.CosiCommit()
+	}
+	return d.SendToParent(c)
+}
+
+// handleCommitments sends the challenge of the collective signature to the
+// conodes, signed by the root and the conodes that committed, which must be
+// at least two thirds of the conodes
+func (d *DOMConsensus) handleCommitments(responses []chanDOMConsensusCommitment) error {
+	var commitment kyber.Point
+	d.secret, commitment = lib.CosiCommit()
+	commitments := []kyber.Point{commitment}
+	d.Signers = []kyber.Point{servicePublic(d.TreeNodeInstance)}
+	for _, r := range responses {
+		if r.Commitment == nil {
+			log.Lvl2("conode", r.ServerIdentity, "refused to sign the consensus document")
+			continue
+		}
+		commitments = append(commitments, r.Commitment)
+		d.Signers = append(d.Signers, nodePublic(r.TreeNode))
+	}
+	if len(d.Signers) < lib.CosiThreshold(len(d.List())) {
+		return errors.New("not enough conodes agreed on the consensus document")
+	}
+
+	d.commitment = lib.CosiAggregate(commitments)
+	st, err := d.statement(d.Document, d.Signers, d.Timestamp)
+	if err != nil {
+		return err
+	}
+	msg, err := st.Encode()
+	if err != nil {
+		return err
+	}
+	challenge, err := lib.CosiChallenge(d.commitment, st.NodeKey, msg)
+	if err != nil {
+		return err
+	}
+	d.rootResponse, err = lib.CosiResponse(d.Signers, servicePublic(d.TreeNodeInstance),
+		d.secret, challenge, servicePrivate(d.TreeNodeInstance))
+	if err != nil {
+		return err
+	}
+
+	c := &DOMConsensusChallenge{
+		Document:   d.Document,
+		Signers:    d.Signers,
+		Commitment: d.commitment,
+		Challenge:  challenge,
+		Timestamp:  d.Timestamp,
+	}
+	return d.SendToChildren(c)
+}
+
+// statement returns the statement collectively signed by the signers for the
+// consensus document
+func (d *DOMConsensus) statement(document []byte, signers []kyber.Point, timestamp int64) (*lib.Statement, error) {
+	public, err := lib.CosiPublic(signers)
+	if err != nil {
+		return nil, err
+	}
+	st := lib.NewStatement(NameDOMConsensus, d.URL, d.Nonce, public)
+	st.Timestamp = timestamp
+	st.Flags = lib.DOMConsensusFlags(d.PrivateFilters, d.EncryptedFilters)
+	st.Payload = document
+	return st, nil
+}
+
+// handleChallenge checks the challenge computed by the root and sends the
+// response of the conode, or no response if it refused to sign
+func (d *DOMConsensus) handleChallenge(in *DOMConsensusChallenge) error {
+	r := &DOMConsensusCosiResponse{
+		PublicKey: servicePublic(d.TreeNodeInstance),
+	}
+	if d.secret == nil {
+		return d.SendToParent(r)
+	}
+
+	// check that we are among the signers and that the challenge really
+	// is for the document we accepted
+	if !bytes.Equal(in.Document, d.Document) || in.Timestamp != d.Timestamp {
+		return errors.New("challenge for another consensus document")
+	}
+	found := false
+	for _, s := range in.Signers {
+		if s.Equal(servicePublic(d.TreeNodeInstance)) {
+			found = true
+		}
+	}
+	if !found {
+		return errors.New("conode not among the signers")
+	}
+	st, err := d.statement(in.Document, in.Signers, in.Timestamp)
+	if err != nil {
+		return err
+	}
+	msg, err := st.Encode()
+	if err != nil {
+		return err
+	}
+	challenge, err := lib.CosiChallenge(in.Commitment, st.NodeKey, msg)
+	if err != nil {
+		return err
+	}
+	if !challenge.Equal(in.Challenge) {
+		return errors.New("challenge does not match the consensus document")
+	}
+
+	r.Response, err = lib.CosiResponse(in.Signers, servicePublic(d.TreeNodeInstance),
+		d.secret, challenge, servicePrivate(d.TreeNodeInstance))
+	if err != nil {
+		return err
+	}
+	return d.SendToParent(r)
+}
+
+// handleCosiResponses aggregates the responses of the signers in the
+// collective signature
+func (d *DOMConsensus) handleCosiResponses(responses []chanDOMConsensusCosiResponse) error {
+	all := []kyber.Scalar{d.rootResponse}
+	for _, r := range responses {
+		if r.Response != nil {
+			all = append(all, r.Response)
+		}
+	}
+	sig, err := lib.CosiSignature(d.commitment, all)
+	if err != nil {
+		return err
+	}
+	st, err := d.statement(d.Document, d.Signers, d.Timestamp)
+	if err != nil {
+		return err
+	}
+	msg, err := st.Encode()
+	if err != nil {
+		return err
+	}
+	if err := lib.CosiVerify(d.Signers, msg, sig); err != nil {
+		return err
+	}
+	d.Signature = sig
+	return nil
+}
+
+// fetchPage fetches the HTML page, bounded by the options of the protocol
+func (d *DOMConsensus) fetchPage() ([]byte, error) {
+	opts := &lib.FetchOptions{MaxSize: contentMaxSize}
+	if d.Options != nil {
+		*opts = *d.Options
+		if opts.MaxSize <= 0 || opts.MaxSize > contentMaxSize {
+			opts.MaxSize = contentMaxSize
+		}
+	}
+	buf := new(bytes.Buffer)
+	info, err := lib.StreamResource(d.URL, opts, buf)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(info.ContentType, "html") {
+		return nil, errors.New("structural consensus needs an HTML page, got " + info.ContentType)
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+)
+
+// DOMConsensusAnnouncement is sent down the tree by the root to start a new
+// DOMConsensus protocol
+type DOMConsensusAnnouncement struct {
+	URL              string
+	Nonce            []byte
+	Options          *lib.FetchOptions
+	PrivateFilters   bool
+	EncryptedFilters bool
+	FilterSize       uint
+	FilterHashes     uint
+	DistKey          kyber.Point
+}
+
+type chanDOMConsensusAnnouncement struct {
+	*onet.TreeNode
+	DOMConsensusAnnouncement
+}
+
+// DOMConsensusResponse is sent by every conode to the root and contains the
+// hashes of the DOM of the page, or their masked Bloom filter, or their
+// encrypted Bloom filter signed by the conode
+type DOMConsensusResponse struct {
+	PublicKey kyber.Point
+	Hashes    *lib.DOMHashes
+	Filter    []uint32
+	Encrypted []*lib.ElGamalCiphertext
+	Signature []byte
+}
+
+type chanDOMConsensusResponse struct {
+	*onet.TreeNode
+	DOMConsensusResponse
+}
+
+// DOMConsensusFilter is the encrypted Bloom filter of a conode, with its
+// signature
+type DOMConsensusFilter struct {
+	PublicKey   kyber.Point
+	Ciphertexts []*lib.ElGamalCiphertext
+	Signature   []byte
+}
+
+// DOMConsensusDecrypt is sent down the tree by the root with the encrypted
+// filters of all the conodes, whose sum is decrypted
+type DOMConsensusDecrypt struct {
+	Filters []*DOMConsensusFilter
+}
+
+type chanDOMConsensusDecrypt struct {
+	*onet.TreeNode
+	DOMConsensusDecrypt
+}
+
+// DOMConsensusPartial is sent by every conode to the root and contains its
+// partial decryption of the sum of the encrypted filters, with the index of
+// its share of the distributed key
+type DOMConsensusPartial struct {
+	PublicKey kyber.Point
+	Index     int
+	Partials  []kyber.Point
+}
+
+type chanDOMConsensusPartial struct {
+	*onet.TreeNode
+	DOMConsensusPartial
+}
+
+// DOMConsensusProposal is sent down the tree by the root with the consensus
+// document, before its collective signature
+type DOMConsensusProposal struct {
+	Document  []byte
+	Timestamp int64
+}
+
+type chanDOMConsensusProposal struct {
+	*onet.TreeNode
+	DOMConsensusProposal
+}
+
+// DOMConsensusCommitment is sent by every conode to the root and contains its
+// commitment for the collective signature, or no commitment if the conode
+// refuses to sign the document
+type DOMConsensusCommitment struct {
+	PublicKey  kyber.Point
+	Commitment kyber.Point
+}
+
+type chanDOMConsensusCommitment struct {
+	*onet.TreeNode
+	DOMConsensusCommitment
+}
+
+// DOMConsensusChallenge is sent down the tree by the root with the consensus
+// document to be collectively signed
+type DOMConsensusChallenge struct {
+	Document   []byte
+	Signers    []kyber.Point
+	Commitment kyber.Point
+	Challenge  kyber.Scalar
+	Timestamp  int64
+}
+
+type chanDOMConsensusChallenge struct {
+	*onet.TreeNode
+	DOMConsensusChallenge
+}
+
+// DOMConsensusCosiResponse is sent by every conode to the root and contains
+// its response to the challenge of the collective signature, or no response
+// if the conode is not among the signers
+type DOMConsensusCosiResponse struct {
+	PublicKey kyber.Point
+	Response  kyber.Scalar
+}
+
+type chanDOMConsensusCosiResponse struct {
+	*onet.TreeNode
+	DOMConsensusCosiResponse
+}
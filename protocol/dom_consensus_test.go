@@ -0,0 +1,127 @@
+package protocol
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/si-co/dpcc/lib"
+	"github.com/stretchr/testify/require"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// the encrypted filters need the distributed key of the service, they are
+// tested in TestDOMConsensusServiceEncrypted
+
+func TestDOMConsensusProtocol(t *testing.T) {
+	testDOMConsensus(t, false)
+}
+
+func TestDOMConsensusProtocolPrivate(t *testing.T) {
+	testDOMConsensus(t, true)
+}
+
+func testDOMConsensus(t *testing.T, private bool) {
+	// define log visibility level
+	//log.SetDebugVisible(3)
+
+	// every other visitor, including the leader, sees an injected ad
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		ad := ""
+		if v%2 == 0 {
+			ad = "<div class=\"ad\">buy now</div>"
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body><h1>news</h1>%s<p>article</p></body></html>", ad)
+	}))
+	defer ts.Close()
+
+	nbrHosts := 4
+	log.Lvl2("testing DOM consensus protocol with", nbrHosts, "hosts")
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(nbrHosts, nbrHosts, nbrHosts, true)
+
+	instance, err := local.CreateProtocol(NameDOMConsensus, tree)
+	require.Nil(t, err)
+	nonce := lib.GenNonce()
+	p := instance.(*DOMConsensus)
+	p.URL = ts.URL
+	p.Nonce = nonce
+	p.Threshold = 2
+	p.PrivateFilters = private
+	require.Nil(t, p.Start())
+
+	select {
+	case <-p.Finished:
+		// the ad has been seen by a single conode
+		doc := string(p.Document)
+		require.True(t, strings.Contains(doc, "article"))
+		require.False(t, strings.Contains(doc, "buy now"))
+
+		// the document is signed by all the conodes
+		require.Equal(t, nbrHosts, len(p.Signers))
+		public, err := lib.CosiPublic(p.Signers)
+		require.Nil(t, err)
+		st := lib.NewStatement(NameDOMConsensus, ts.URL, nonce, public)
+		st.Timestamp = p.Timestamp
+		st.Flags = lib.DOMConsensusFlags(private, false)
+		st.Payload = p.Document
+		msg, err := st.Encode()
+		require.Nil(t, err)
+		require.Nil(t, lib.CosiVerify(p.Signers, msg, p.Signature))
+	case <-time.After(time.Second * 10):
+		t.Fatal("couldn't get DOM consensus protocol done in time")
+	}
+}
+
+func TestDOMConsensusProtocolRefused(t *testing.T) {
+	// the leader and a single conode see an injected ad
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		ad := ""
+		if v%2 == 0 {
+			ad = "<div class=\"ad\">buy now</div>"
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body><h1>news</h1>%s<p>article</p></body></html>", ad)
+	}))
+	defer ts.Close()
+
+	nbrHosts := 4
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(nbrHosts, nbrHosts, nbrHosts, true)
+
+	instance, err := local.CreateProtocol(NameDOMConsensus, tree)
+	require.Nil(t, err)
+	p := instance.(*DOMConsensus)
+	p.URL = ts.URL
+	p.Nonce = lib.GenNonce()
+	p.Threshold = 1
+	require.Nil(t, p.Start())
+
+	// the ad is kept, so the conodes that didn't see it refuse to sign and
+	// the document is not signed by two thirds of the conodes
+	select {
+	case <-p.Finished:
+		t.Fatal("consensus document signed by conodes that didn't observe it")
+	case <-time.After(time.Second * 3):
+	}
+}
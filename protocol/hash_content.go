@@ -0,0 +1,293 @@
+package protocol
+
+import (
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// NameHashContent is the protocol identifier string
+const NameHashContent = "HashContent"
+
+// contentMaxSize is the maximum size of a resource kept for the diff report,
+// since the whole content is sent to the root
+const contentMaxSize = 8 << 20
+
+// keptContent is the content hashed by a conode in a hash public run, so that
+// the diff report compares the versions the digests were computed on
+type keptContent struct {
+	contentType string
+	data        []byte
+	digests     []*lib.Digest
+	expires     time.Time
+}
+
+// contents kept by the conodes of the process, indexed by keptContentKey
+var (
+	keptContents     = make(map[string]*keptContent)
+	keptContentsLock sync.Mutex
+)
+
+// keptContentKey identifies the content hashed by a conode for a request
+func keptContentKey(node kyber.Point, URL string, nonce []byte) string {
+	return node.String() + " " + hex.EncodeToString(nonce) + " " + URL
+}
+
+// keepContent keeps a content for the diff report for the time asked by the
+// leader, which cannot exceed twice the longest wait of a protocol, and
+// removes the expired contents
+func keepContent(key string, c *keptContent, ttl time.Duration) {
+	if max := 2 * (lib.FetchLimits().Timeout + time.Minute); ttl > max {
+		ttl = max
+	}
+	keptContentsLock.Lock()
+	defer keptContentsLock.Unlock()
+	now := time.Now()
+	purgeContents(now)
+	c.expires = now.Add(ttl)
+	keptContents[key] = c
+}
+
+// takeContent returns and removes a kept content, or nil if there is none,
+// and removes the expired contents
+func takeContent(key string) *keptContent {
+	keptContentsLock.Lock()
+	defer keptContentsLock.Unlock()
+	purgeContents(time.Now())
+	c, ok := keptContents[key]
+	if !ok {
+		return nil
+	}
+	delete(keptContents, key)
+	return c
+}
+
+// purgeContents removes the contents expired at the given time. The contents
+// must be locked.
+func purgeContents(now time.Time) {
+	for k, kc := range keptContents {
+		if now.After(kc.expires) {
+			delete(keptContents, k)
+		}
+	}
+}
+
+func init() {
+	network.RegisterMessages(HashContentAnnouncement{}, HashContentResponse{})
+	onet.GlobalProtocolRegister(NameHashContent, NewHashContentProtocol)
+}
+
+// HashContent is the core structure of the protocol, which is run after the
+// hash public protocol between the root and the conodes that answered, so
+// that the root can compare the different versions of the resource. A
+// representative of every group of conodes that computed the same hash sends
+// the content it hashed in the hash public run, which must have kept it. The
+// other conodes drop the content they kept and send an empty response.
+type HashContent struct {
+	*onet.TreeNodeInstance
+	// resource's URL
+	URL string
+	// nonce received from the client
+	Nonce []byte
+	// identifier of the digest algorithm used to form the groups
+	Algorithm string
+	// public keys of the representatives of the groups
+	Representatives []string
+	// map of representative responses indexed by the public key of the
+	// representative
+	Responses map[string]*HashContentResponse
+	// associated lock
+	responsesLock *sync.Mutex
+
+	// protocol channels
+	// the channel waiting for Announcement messages
+	announce chan chanHashContentAnnouncement
+	// the channel waiting for Response messages
+	response chan []chanHashContentResponse
+	// the channel that indicates if we are finished or not
+	Finished chan bool
+}
+
+// NewHashContentProtocol returns a HashContentProtocol with the right
+// channels initialized
+func NewHashContentProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	log.Lvl2("creating new hash content protocol")
+	h := &HashContent{
+		TreeNodeInstance: n,
+		Responses:        make(map[string]*HashContentResponse),
+		responsesLock:    new(sync.Mutex),
+		Finished:         make(chan bool, 1),
+	}
+
+	// register the channels we want listen on
+	if err := n.RegisterChannels(&h.announce, &h.response); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Start is executed by the root to start the protocol, by checking that all
+// the needed parameters have been initialized and by handling the announcement
+// for the root itself
+func (h *HashContent) Start() error {
+	log.Lvl2("starting hash content protocol")
+	// check parameters of the protocol
+	if h.URL == "" {
+		return errors.New("initialize URL first")
+	}
+	if h.Nonce == nil {
+		return errors.New("initialize nonce first")
+	}
+	if _, err := lib.NewHash(h.Algorithm); err != nil {
+		return err
+	}
+
+	// start announcement phase
+	a := &HashContentAnnouncement{
+		URL:             h.URL,
+		Nonce:           h.Nonce,
+		Algorithm:       h.Algorithm,
+		Representatives: h.Representatives,
+	}
+
+	return h.handleAnnouncement(a)
+}
+
+// Dispatch will listen on the two channels we use
+func (h *HashContent) Dispatch() error {
+	defer h.Done()
+	nbrChild := len(h.Children())
+
+	// if we are a leaf, we should handle the announcement
+	if !h.IsRoot() {
+		log.Lvl3(h.Name(), "waiting for announcement")
+		a := (<-h.announce).HashContentAnnouncement
+		if err := h.handleAnnouncement(&a); err != nil {
+			return err
+		}
+	}
+
+	// if we are the root, we should handle the responses
+	if !h.IsLeaf() {
+		for n, r := range <-h.response {
+			log.Lvlf3("%s handling response of child %d/%d",
+				h.Name(), n+1, nbrChild)
+			err := h.handleResponse(&r.HashContentResponse)
+			if err != nil {
+				return err
+			}
+		}
+
+		// once all responses have been aggregated, communicate end of
+		// the protocol to service
+		log.Lvl2("hash content protocol terminated")
+		h.Finished <- true
+	}
+
+	return nil
+}
+
+// handleAnnouncement stores the parameters of the protocol and forwards the
+// announcement to the conodes
+func (h *HashContent) handleAnnouncement(in *HashContentAnnouncement) error {
+	// store parameters of the protocol
+	h.URL = in.URL
+	h.Nonce = in.Nonce
+	h.Algorithm = in.Algorithm
+	h.Representatives = in.Representatives
+	log.Lvlf4("%s received %s as URL and %s as algorithm in announcement",
+		h.Name(), h.URL, h.Algorithm)
+
+	// if we are a leaf, we should go to response
+	if h.IsLeaf() {
+		return h.handleResponse(nil)
+	}
+
+	// root should send announcement to children
+	return h.SendToChildren(in)
+}
+
+func (h *HashContent) handleResponse(in *HashContentResponse) error {
+	if h.IsLeaf() {
+		// a representative that cannot send its content answers all the
+		// same, so that the root doesn't wait for it
+		r, err := h.content()
+		if err != nil {
+			log.Lvl2(h.Name(), "couldn't send the content of", h.URL, ":", err)
+			r = &HashContentResponse{PublicKey: servicePublic(h.TreeNodeInstance)}
+		}
+		log.Lvlf3("%s sending content to parent", h.Name())
+		return h.SendToParent(r)
+	}
+
+	// if we are the root, we store the child contribution
+	pkString := in.PublicKey.String()
+	log.Lvlf3("%s storing content of node %s", h.Name(), pkString)
+	h.responsesLock.Lock()
+	h.Responses[pkString] = in
+	h.responsesLock.Unlock()
+	return nil
+}
+
+// content takes the content kept by the conode and returns it signed if the
+// conode is a representative, or an empty response otherwise
+func (h *HashContent) content() (*HashContentResponse, error) {
+	pk := servicePublic(h.TreeNodeInstance)
+	kept := takeContent(keptContentKey(pk, h.URL, h.Nonce))
+	representative := false
+	for _, r := range h.Representatives {
+		if r == pk.String() {
+			representative = true
+		}
+	}
+	if !representative {
+		return &HashContentResponse{PublicKey: pk}, nil
+	}
+
+	// the content is the one hashed in the hash public run, so that the
+	// root can link it to the group of the representative
+	if kept == nil {
+		return nil, errors.New("no content kept for " + h.URL)
+	}
+	value := lib.FindDigest(kept.digests, h.Algorithm)
+	if value == nil {
+		return nil, errors.New("no " + h.Algorithm + " digest of the kept content")
+	}
+	digest := &lib.Digest{Algorithm: h.Algorithm, Value: value}
+
+	// canonicalize the content, so that irrelevant differences do not show
+	// up in the diff
+	format, lines, err := lib.CanonicalContent(kept.data, kept.contentType)
+	if err != nil {
+		return nil, err
+	}
+	content := []byte(strings.Join(lines, "\n"))
+
+	// sign the content, so that the client knows that this version really
+	// comes from this conode
+	st := lib.NewStatement(NameHashContent, h.URL, h.Nonce, pk)
+	st.Digests = []*lib.Digest{digest}
+	st.Payload = lib.ContentPayload(format, content)
+	sig, err := lib.SignStatement(servicePrivate(h.TreeNodeInstance), st)
+	if err != nil {
+		return nil, err
+	}
+
+	return &HashContentResponse{
+		PublicKey: st.NodeKey,
+		Digest:    digest,
+		Format:    format,
+		Content:   content,
+		Timestamp: st.Timestamp,
+		Signature: sig,
+	}, nil
+}
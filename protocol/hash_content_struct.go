@@ -0,0 +1,38 @@
+package protocol
+
+import (
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+)
+
+// HashContentAnnouncement is sent down the tree by the root to ask the
+// representatives of the hash groups for their canonicalized content
+type HashContentAnnouncement struct {
+	URL             string
+	Nonce           []byte
+	Algorithm       string
+	Representatives []string
+}
+
+type chanHashContentAnnouncement struct {
+	*onet.TreeNode
+	HashContentAnnouncement
+}
+
+// HashContentResponse is sent by every conode to the root and contains the
+// signed canonicalized content of the representatives, and only the public
+// key of the other conodes
+type HashContentResponse struct {
+	PublicKey kyber.Point
+	Digest    *lib.Digest
+	Format    string
+	Content   []byte
+	Timestamp int64
+	Signature []byte
+}
+
+type chanHashContentResponse struct {
+	*onet.TreeNode
+	HashContentResponse
+}
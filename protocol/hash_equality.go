@@ -0,0 +1,413 @@
+package protocol
+
+import (
+	"errors"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// NameHashEquality is the protocol identifier string
+const NameHashEquality = "HashEquality"
+
+func init() {
+	network.RegisterMessages(HashEqualityAnnouncement{}, HashEqualityResponse{},
+		HashEqualityBlind{}, HashEqualityBlinded{}, HashEqualityDecrypt{},
+		HashEqualityPartial{})
+	onet.GlobalProtocolRegister(NameHashEquality, NewHashEqualityProtocol)
+}
+
+// HashEquality is the core structure of the private equality test: every
+// conode encrypts its digest under the distributed key of the roster and
+// signs it with the nonce of the client, every node computes the encrypted
+// differences of the digests of every pair of conodes from the signed
+// ciphertexts and blinds them with random factors, and a threshold of nodes
+// decrypt their sums. A blinded difference decrypts to the null point if the
+// digests are equal and to a random point otherwise, so that only the
+// agreement between the conodes is revealed. The blindings and the partial
+// decryptions come with proofs, checked by the conodes and by the client.
+type HashEquality struct {
+	*onet.TreeNodeInstance
+	// resource's URL
+	URL string
+	// nonce received from the client
+	Nonce []byte
+	// identifier of the digest algorithm to use
+	Algorithm string
+	// limits for the download of the resource
+	Options *lib.FetchOptions
+
+	// distributed key of the roster and share of the node, set by the
+	// service
+	DistKey   kyber.Point
+	Share     *share.PriShare
+	Threshold int
+	NbrShares int
+	// commitments of the shares of the distributed key
+	Commits []kyber.Point
+
+	// results of the protocol
+	// public keys of the conodes, as strings, grouped by equal digests
+	Groups [][]string
+	// transcript of the test, verified by the client
+	Inputs        []*lib.SignedCiphertext
+	Blindings     []*lib.Blinding
+	Partials      []*lib.PartialDecryption
+	KeySignatures map[string][]byte
+
+	// public keys of the conodes in the order of the pairs
+	nodes []string
+	// ciphertext of the conode
+	ciphertext *lib.ElGamalCiphertext
+	// encrypted differences of every pair
+	differences []*lib.ElGamalCiphertext
+	// blinding of the node
+	blinding *lib.Blinding
+	// sums of the blinded differences of every pair
+	sums []*lib.ElGamalCiphertext
+
+	// protocol channels
+	announce chan chanHashEqualityAnnouncement
+	response chan []chanHashEqualityResponse
+	blind    chan chanHashEqualityBlind
+	blindRes chan []chanHashEqualityBlinded
+	decrypt  chan chanHashEqualityDecrypt
+	partial  chan []chanHashEqualityPartial
+	// the channel that indicates if we are finished or not
+	Finished chan bool
+}
+
+// NewHashEqualityProtocol returns a HashEqualityProtocol with the right
+// channels initialized
+func NewHashEqualityProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	log.Lvl2("creating new hash equality protocol")
+	h := &HashEquality{
+		TreeNodeInstance: n,
+		Finished:         make(chan bool, 1),
+	}
+
+	// register the channels we want listen on
+	if err := n.RegisterChannels(&h.announce, &h.response, &h.blind,
+		&h.blindRes, &h.decrypt, &h.partial); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Start is executed by the root to start the protocol, by checking that all
+// the needed parameters have been initialized and by sending the announcement
+func (h *HashEquality) Start() error {
+	log.Lvl2("starting hash equality protocol")
+	if h.URL == "" {
+		return errors.New("initialize URL first")
+	}
+	if h.Nonce == nil {
+		return errors.New("initialize nonce first")
+	}
+	if err := h.checkKey(); err != nil {
+		return err
+	}
+	if h.Algorithm == "" {
+		h.Algorithm = lib.DefaultAlgorithm
+	}
+	if _, err := lib.NewHash(h.Algorithm); err != nil {
+		return err
+	}
+
+	a := &HashEqualityAnnouncement{
+		URL:       h.URL,
+		Nonce:     h.Nonce,
+		Algorithm: h.Algorithm,
+		Options:   h.Options,
+		DistKey:   h.DistKey,
+	}
+	return h.SendToChildren(a)
+}
+
+// Dispatch will listen on the channels of the three phases of the protocol
+func (h *HashEquality) Dispatch() error {
+	defer h.Done()
+
+	// if we are a leaf, we answer the announcement and the requests of
+	// the root
+	if !h.IsRoot() {
+		log.Lvl3(h.Name(), "waiting for announcement")
+		a := (<-h.announce).HashEqualityAnnouncement
+		if err := h.handleAnnouncement(&a); err != nil {
+			return err
+		}
+		log.Lvl3(h.Name(), "waiting for differences to blind")
+		b := (<-h.blind).HashEqualityBlind
+		if err := h.handleBlind(&b); err != nil {
+			return err
+		}
+		log.Lvl3(h.Name(), "waiting for decryption request")
+		d := (<-h.decrypt).HashEqualityDecrypt
+		return h.handleDecrypt(&d)
+	}
+
+	// if we are the root, we compare the digests of the conodes
+	if err := h.handleResponses(<-h.response); err != nil {
+		return err
+	}
+	if err := h.handleBlinded(<-h.blindRes); err != nil {
+		return err
+	}
+	if err := h.handlePartials(<-h.partial); err != nil {
+		return err
+	}
+
+	log.Lvl2("hash equality protocol terminated")
+	h.Finished <- true
+	return nil
+}
+
+// handleAnnouncement computes the digest of the resource and sends it
+// encrypted to the root
+func (h *HashEquality) handleAnnouncement(in *HashEqualityAnnouncement) error {
+	h.URL = in.URL
+	h.Nonce = in.Nonce
+	h.Algorithm = in.Algorithm
+	h.Options = in.Options
+	log.Lvlf4("%s received %s as URL in announcement", h.Name(), h.URL)
+
+	if err := h.checkKey(); err != nil {
+		return err
+	}
+	if in.DistKey == nil || !in.DistKey.Equal(h.DistKey) {
+		return errors.New("announced key is not the distributed key of the roster")
+	}
+	if lib.IsSimilarity(h.Algorithm) {
+		return errors.New("equality test needs an exact digest algorithm")
+	}
+
+	_, digests, err := lib.StreamDigests(h.URL, h.Options, []string{h.Algorithm})
+	if err != nil {
+		return err
+	}
+	digest := lib.FindDigest(digests, h.Algorithm)
+	if digest == nil {
+		return errors.New("no digest computed")
+	}
+
+	h.ciphertext = lib.EncryptPoint(h.DistKey, lib.HashToPoint(digest))
+	sig, err := lib.SignCiphertexts(servicePrivate(h.TreeNodeInstance), NameHashEquality,
+		h.Nonce, []*lib.ElGamalCiphertext{h.ciphertext})
+	if err != nil {
+		return err
+	}
+	keySig, err := lib.SignDistKey(servicePrivate(h.TreeNodeInstance), h.Roster(), h.Commits)
+	if err != nil {
+		return err
+	}
+	r := &HashEqualityResponse{
+		PublicKey:    servicePublic(h.TreeNodeInstance),
+		Ciphertext:   h.ciphertext,
+		Signature:    sig,
+		KeySignature: keySig,
+	}
+	return h.SendToParent(r)
+}
+
+// handleResponses checks the signed ciphertexts of the conodes and sends
+// them to the conodes to compute and blind the differences of every pair
+func (h *HashEquality) handleResponses(responses []chanHashEqualityResponse) error {
+	keySig, err := lib.SignDistKey(servicePrivate(h.TreeNodeInstance), h.Roster(), h.Commits)
+	if err != nil {
+		return err
+	}
+	h.KeySignatures = map[string][]byte{servicePublic(h.TreeNodeInstance).String(): keySig}
+	inputs := []*lib.SignedCiphertext{}
+	for _, r := range responses {
+		pk := nodePublic(r.TreeNode)
+		ct := r.Ciphertext
+		if ct == nil || ct.K == nil || ct.C == nil {
+			return errors.New("invalid response from " + r.ServerIdentity.String())
+		}
+		if err := lib.VerifyCiphertexts(pk, NameHashEquality, h.Nonce,
+			[]*lib.ElGamalCiphertext{ct}, r.Signature); err != nil {
+			return errors.New("invalid signature from " + r.ServerIdentity.String())
+		}
+		inputs = append(inputs, &lib.SignedCiphertext{
+			PublicKey:  pk,
+			Ciphertext: ct,
+			Signature:  r.Signature,
+		})
+		h.KeySignatures[pk.String()] = r.KeySignature
+	}
+	if err := h.setInputs(inputs); err != nil {
+		return err
+	}
+
+	// the root blinds the differences as well
+	h.blinding, err = lib.BlindCiphertexts(servicePublic(h.TreeNodeInstance), h.differences)
+	if err != nil {
+		return err
+	}
+	return h.SendToChildren(&HashEqualityBlind{Inputs: h.Inputs})
+}
+
+// handleBlind checks the signed ciphertexts of the conodes, including its
+// own, and blinds the differences of every pair with random factors
+func (h *HashEquality) handleBlind(in *HashEqualityBlind) error {
+	own := false
+	for _, input := range in.Inputs {
+		if input != nil && input.PublicKey != nil &&
+			input.PublicKey.Equal(servicePublic(h.TreeNodeInstance)) {
+			own = lib.EqualCiphertexts([]*lib.ElGamalCiphertext{input.Ciphertext},
+				[]*lib.ElGamalCiphertext{h.ciphertext})
+		}
+	}
+	if !own {
+		return errors.New("own ciphertext missing or modified")
+	}
+	if err := h.setInputs(in.Inputs); err != nil {
+		return err
+	}
+
+	var err error
+	h.blinding, err = lib.BlindCiphertexts(servicePublic(h.TreeNodeInstance), h.differences)
+	if err != nil {
+		return err
+	}
+	return h.SendToParent(&HashEqualityBlinded{Blinding: h.blinding})
+}
+
+// handleBlinded checks the blindings of the conodes and sends them to the
+// conodes to decrypt their sums
+func (h *HashEquality) handleBlinded(responses []chanHashEqualityBlinded) error {
+	h.Blindings = []*lib.Blinding{h.blinding}
+	for _, r := range responses {
+		b := r.Blinding
+		if b == nil || b.PublicKey == nil || !b.PublicKey.Equal(nodePublic(r.TreeNode)) {
+			return errors.New("invalid blinding from " + r.ServerIdentity.String())
+		}
+		if err := b.Verify(h.differences); err != nil {
+			return errors.New("invalid blinding from " + r.ServerIdentity.String() +
+				": " + err.Error())
+		}
+		h.Blindings = append(h.Blindings, b)
+	}
+
+	var err error
+	h.sums, err = lib.SumBlindings(h.Blindings)
+	if err != nil {
+		return err
+	}
+	return h.SendToChildren(&HashEqualityDecrypt{Blindings: h.Blindings})
+}
+
+// handleDecrypt checks that the blindings of all the nodes, including its
+// own, are valid blindings of the differences, and sends the partial
+// decryptions of their sums to the root
+func (h *HashEquality) handleDecrypt(in *HashEqualityDecrypt) error {
+	expected := make(map[string]bool)
+	for _, n := range h.List() {
+		expected[nodePublic(n).String()] = true
+	}
+	if len(in.Blindings) != len(expected) {
+		return errors.New("not one blinding per node")
+	}
+	own := false
+	for _, b := range in.Blindings {
+		if b == nil || b.PublicKey == nil || !expected[b.PublicKey.String()] {
+			return errors.New("blinding of an unexpected node")
+		}
+		delete(expected, b.PublicKey.String())
+		if err := b.Verify(h.differences); err != nil {
+			return err
+		}
+		if b.PublicKey.Equal(servicePublic(h.TreeNodeInstance)) {
+			own = lib.EqualCiphertexts(b.Ciphertexts, h.blinding.Ciphertexts)
+		}
+	}
+	if !own {
+		return errors.New("own blinding missing or modified")
+	}
+
+	sums, err := lib.SumBlindings(in.Blindings)
+	if err != nil {
+		return err
+	}
+	p, err := lib.PartialDecryptProof(servicePublic(h.TreeNodeInstance), h.Share, sums)
+	if err != nil {
+		return err
+	}
+	return h.SendToParent(&HashEqualityPartial{Partial: p})
+}
+
+// handlePartials checks the partial decryptions of the conodes, decrypts the
+// sums of the blinded differences and groups the conodes whose digests are
+// equal
+func (h *HashEquality) handlePartials(responses []chanHashEqualityPartial) error {
+	own, err := lib.PartialDecryptProof(servicePublic(h.TreeNodeInstance), h.Share, h.sums)
+	if err != nil {
+		return err
+	}
+	h.Partials = []*lib.PartialDecryption{own}
+	for _, r := range responses {
+		p := r.Partial
+		if p == nil || p.PublicKey == nil || !p.PublicKey.Equal(nodePublic(r.TreeNode)) ||
+			p.Verify(h.Commits, h.sums) != nil {
+			log.Lvl2("ignoring invalid partial decryptions from", r.ServerIdentity)
+			continue
+		}
+		h.Partials = append(h.Partials, p)
+	}
+
+	equal, err := lib.EqualityResults(h.sums, h.Partials, h.Threshold, h.NbrShares)
+	if err != nil {
+		return err
+	}
+	h.Groups, err = lib.EqualityGroups(h.nodes, equal)
+	return err
+}
+
+// setInputs checks that the ciphertexts have been signed by the children of
+// the root, sorts them by public key and computes the encrypted differences
+// of every pair
+func (h *HashEquality) setInputs(inputs []*lib.SignedCiphertext) error {
+	conodes := []kyber.Point{}
+	for _, c := range h.Root().Children {
+		conodes = append(conodes, nodePublic(c))
+	}
+	if err := lib.VerifySignedCiphertexts(NameHashEquality, h.Nonce, conodes, inputs); err != nil {
+		return err
+	}
+	h.Inputs = lib.SortSignedCiphertexts(inputs)
+	h.nodes = make([]string, len(h.Inputs))
+	cts := make([]*lib.ElGamalCiphertext, len(h.Inputs))
+	for i, input := range h.Inputs {
+		h.nodes[i] = input.PublicKey.String()
+		cts[i] = input.Ciphertext
+	}
+	h.differences = lib.EqualityDifferences(cts)
+	return nil
+}
+
+// checkKey checks that the service provided the share of the distributed key
+// of the roster
+func (h *HashEquality) checkKey() error {
+	if h.DistKey == nil || h.Share == nil || h.Share.V == nil {
+		return errors.New("no distributed key for the roster")
+	}
+	if h.Threshold < 1 || h.Threshold > h.NbrShares || len(h.Commits) != h.Threshold {
+		return errors.New("invalid threshold of the distributed key")
+	}
+	return nil
+}
+
+// pubShares labels the partial decryptions with the index of the share used
+// to compute them
+func pubShares(index int, partials []kyber.Point) []*share.PubShare {
+	shares := make([]*share.PubShare, len(partials))
+	for i, p := range partials {
+		shares[i] = &share.PubShare{I: index, V: p}
+	}
+	return shares
+}
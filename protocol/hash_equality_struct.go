@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+)
+
+// HashEqualityAnnouncement is sent down the tree by the root to start a new
+// HashEquality protocol
+type HashEqualityAnnouncement struct {
+	URL       string
+	Nonce     []byte
+	Algorithm string
+	Options   *lib.FetchOptions
+	DistKey   kyber.Point
+}
+
+type chanHashEqualityAnnouncement struct {
+	*onet.TreeNode
+	HashEqualityAnnouncement
+}
+
+// HashEqualityResponse is sent by every conode to the root and contains its
+// digest mapped to a point and encrypted under the distributed key, signed
+// with the nonce, and its signature of the distributed key
+type HashEqualityResponse struct {
+	PublicKey    kyber.Point
+	Ciphertext   *lib.ElGamalCiphertext
+	Signature    []byte
+	KeySignature []byte
+}
+
+type chanHashEqualityResponse struct {
+	*onet.TreeNode
+	HashEqualityResponse
+}
+
+// HashEqualityBlind is sent down the tree by the root with the signed
+// ciphertexts of the conodes, from which every conode computes the encrypted
+// differences of the digests of every pair of conodes
+type HashEqualityBlind struct {
+	Inputs []*lib.SignedCiphertext
+}
+
+type chanHashEqualityBlind struct {
+	*onet.TreeNode
+	HashEqualityBlind
+}
+
+// HashEqualityBlinded is sent by every conode to the root and contains the
+// differences multiplied by random scalars of the conode, with their proofs
+type HashEqualityBlinded struct {
+	Blinding *lib.Blinding
+}
+
+type chanHashEqualityBlinded struct {
+	*onet.TreeNode
+	HashEqualityBlinded
+}
+
+// HashEqualityDecrypt is sent down the tree by the root with the blindings
+// of all the nodes, whose sums are to be decrypted
+type HashEqualityDecrypt struct {
+	Blindings []*lib.Blinding
+}
+
+type chanHashEqualityDecrypt struct {
+	*onet.TreeNode
+	HashEqualityDecrypt
+}
+
+// HashEqualityPartial is sent by every conode to the root and contains its
+// partial decryptions of the sums of the blinded differences, with their
+// proofs
+type HashEqualityPartial struct {
+	Partial *lib.PartialDecryption
+}
+
+type chanHashEqualityPartial struct {
+	*onet.TreeNode
+	HashEqualityPartial
+}
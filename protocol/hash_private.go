@@ -1,15 +1,10 @@
 package protocol
 
 import (
-	"bytes"
 	"errors"
-	"io"
 	"sync"
 
 	"github.com/si-co/dpcc/lib"
-	"go.dedis.ch/cothority/v3"
-	"go.dedis.ch/kyber/v3"
-	"go.dedis.ch/kyber/v3/util/random"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -29,8 +24,15 @@ type HashPrivate struct {
 	*onet.TreeNodeInstance
 	// resource's URL
 	URL string
+	// nonce received from the client, signed by the conodes and used as
+	// additional data of the encryption
+	Nonce []byte
+	// identifiers of the digest algorithms to use
+	Algorithms []string
+	// limits for the download of the resource
+	Options *lib.FetchOptions
 	// public keys provided by the server
-	ClientPublicKeys map[string]kyber.Point
+	ClientPublicKeys map[string][]byte
 	// map of encrypted hashes received from every server
 	Responses map[string]*ConodeResponse
 	// associated lock
@@ -76,10 +78,21 @@ func (h *HashPrivate) Start() error {
 	if h.ClientPublicKeys == nil {
 		return errors.New("please provide a list of ephemeral public keys")
 	}
+	if h.Nonce == nil {
+		return errors.New("please initialize nonce first")
+	}
+	algorithms, err := lib.CheckAlgorithms(h.Algorithms)
+	if err != nil {
+		return err
+	}
+	h.Algorithms = algorithms
 
 	a := &HashPrivateAnnouncement{
 		URL:              h.URL,
+		Nonce:            h.Nonce,
 		ClientPublicKeys: h.ClientPublicKeys,
+		Algorithms:       h.Algorithms,
+		Options:          h.Options,
 	}
 
 	return h.handleAnnouncement(a)
@@ -126,9 +139,15 @@ func (h *HashPrivate) handleAnnouncement(in *HashPrivateAnnouncement) error {
 	// store parameters of the protocol
 	h.URL = in.URL
 	log.Lvlf3("%s received %s as URL in announcement", h.Name(), h.URL)
+	h.Nonce = in.Nonce
+	log.Lvlf3("%s received %x as nonce in announcement", h.Name(), h.Nonce)
 	h.ClientPublicKeys = in.ClientPublicKeys
-	log.Lvlf3("%s received %#v as ClientPublicKeys in announcement",
+	log.Lvlf3("%s received %x as ClientPublicKeys in announcement",
 		h.Name(), h.ClientPublicKeys)
+	h.Algorithms = in.Algorithms
+	log.Lvlf3("%s received %v as algorithms in announcement", h.Name(), h.Algorithms)
+	h.Options = in.Options
+	log.Lvlf3("%s received %#v as options in announcement", h.Name(), h.Options)
 
 	// if we are a leaf, we should go to response
 	if h.IsLeaf() {
@@ -141,47 +160,52 @@ func (h *HashPrivate) handleAnnouncement(in *HashPrivateAnnouncement) error {
 
 func (h *HashPrivate) handleResponse(in *HashPrivateResponse) error {
 	if h.IsLeaf() {
-		// fetch resource specified by the URL
+		// fetch resource specified by the URL and hash it on the fly
+		// with all the requested algorithms, so that the resource is
+		// never entirely kept in memory
 		// in this case we do not parse nor normalize the resource, we
 		// take the hash of the data as they are seen by the host
-		resource, err := lib.FetchMainResource(h.URL)
+		_, digests, err := lib.StreamDigests(h.URL, h.Options, h.Algorithms)
 		if err != nil {
 			return err
 		}
 
-		clientPublicKey := h.ClientPublicKeys[h.Public().String()]
-
-		// compute hash
-		hasher := cothority.Suite.Hash()
-		io.Copy(hasher, bytes.NewReader(resource.Data))
-		hash := hasher.Sum(nil)
-
-		// compute previsously shared key
-		pre := lib.DhExchange(h.Private(), clientPublicKey)
-
-		// determine context for HKDF
-		ctx := lib.Context(clientPublicKey, h.Public())
+		clientPublicKey, ok := h.ClientPublicKeys[servicePublic(h.TreeNodeInstance).String()]
+		if !ok {
+			return errors.New("no ephemeral public key for this conode")
+		}
 
-		// instantiate AEAD scheme (AES128-GCM)
-		gcm, err := lib.NewAEAD(cothority.Suite.Hash, pre, ctx)
+		// encrypt the labeled digests with HPKE (lib.HPKESuite) to
+		// the ephemeral key of the client, bound to this conode and to
+		// the nonce of the client
+		info, err := lib.PrivateResponseInfo(servicePublic(h.TreeNodeInstance))
+		if err != nil {
+			return err
+		}
+		enc, encrypted, err := lib.HPKESeal(clientPublicKey, info, h.Nonce,
+			lib.EncodeDigests(digests))
 		if err != nil {
 			return err
 		}
 
-		// even if the ephemeral key used by the client is randomly
-		// generated and should therefore be always different from
-		// previous keys, we use a random nonce for every encryption
-		nonce := make([]byte, gcm.NonceSize())
-		random.Bytes(nonce, random.New())
-
-		// encrypt hash with AES128-GCM
-		encrypted := gcm.Seal(nil, nonce, hash, nil)
+		// sign a statement about the ciphertext with the nonce of the
+		// client, so that the client can detect a replayed or swapped
+		// response
+		st := lib.NewStatement(NameHashPrivate, h.URL, h.Nonce, servicePublic(h.TreeNodeInstance))
+		st.Flags = lib.StatementEncrypted
+		st.Payload = lib.PrivateResponsePayload(enc, encrypted)
+		signature, err := lib.SignStatement(servicePrivate(h.TreeNodeInstance), st)
+		if err != nil {
+			return err
+		}
 
 		// send response to parent
 		r := &HashPrivateResponse{
-			PublicKey:     h.Public(),
+			PublicKey:     st.NodeKey,
 			EncryptedHash: encrypted,
-			Nonce:         nonce,
+			Encapsulation: enc,
+			Timestamp:     st.Timestamp,
+			Signature:     signature,
 		}
 
 		return h.SendToParent(r)
@@ -193,7 +217,9 @@ func (h *HashPrivate) handleResponse(in *HashPrivateResponse) error {
 	cr := &ConodeResponse{
 		PublicKey:     in.PublicKey,
 		EncryptedHash: in.EncryptedHash,
-		Nonce:         in.Nonce,
+		Encapsulation: in.Encapsulation,
+		Timestamp:     in.Timestamp,
+		Signature:     in.Signature,
 	}
 	h.responsesLock.Lock()
 	h.Responses[pk.String()] = cr
@@ -1,6 +1,7 @@
 package protocol
 
 import (
+	"github.com/si-co/dpcc/lib"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3"
 )
@@ -9,7 +10,10 @@ import (
 // HashPrivate protocol
 type HashPrivateAnnouncement struct {
 	URL              string
-	ClientPublicKeys map[string]kyber.Point
+	Nonce            []byte
+	ClientPublicKeys map[string][]byte
+	Algorithms       []string
+	Options          *lib.FetchOptions
 }
 
 type chanHashPrivateAnnouncement struct {
@@ -22,7 +26,9 @@ type chanHashPrivateAnnouncement struct {
 type HashPrivateResponse struct {
 	PublicKey     kyber.Point
 	EncryptedHash []byte
-	Nonce         []byte
+	Encapsulation []byte
+	Timestamp     int64
+	Signature     []byte
 }
 
 type chanHashPrivateResponse struct {
@@ -31,9 +37,12 @@ type chanHashPrivateResponse struct {
 }
 
 // ConodeResponse is a data structure used by the leader of the protocol to
-// send the encrypted hashes and the nonces of all conodes to the client
+// send the encrypted hashes and the encapsulated keys of all conodes to the
+// client
 type ConodeResponse struct {
 	PublicKey     kyber.Point
 	EncryptedHash []byte
-	Nonce         []byte
+	Encapsulation []byte
+	Timestamp     int64
+	Signature     []byte
 }
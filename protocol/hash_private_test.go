@@ -6,7 +6,6 @@ import (
 
 	"github.com/si-co/dpcc/lib"
 	"github.com/stretchr/testify/require"
-	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 )
@@ -31,11 +30,14 @@ func TestHashPrivateProtocol(t *testing.T) {
 		}
 
 		// generate ephemeral public keys for all the workers
-		ephemeralPrivateKeys, ephemeralPublicKeys := lib.GenEphemeralKeys(roster)
+		ephemeralPrivateKeys, ephemeralPublicKeys, err := lib.GenEphemeralKeys(roster)
+		require.Nil(t, err)
 
 		// set parameters of the protocol
 		p := instance.(*HashPrivate)
+		nonce := lib.GenNonce()
 		p.URL = tURL
+		p.Nonce = nonce
 		p.ClientPublicKeys = ephemeralPublicKeys
 
 		// start the protocol
@@ -58,17 +60,23 @@ func TestHashPrivateProtocol(t *testing.T) {
 
 			// decrypted the responses
 			for pk, v := range responses {
-				// compute previsously shared key
-				pre := lib.DhExchange(ephemeralPrivateKeys[pk], v.PublicKey)
-				// determine context for this AEAD scheme
-				ctx := lib.Context(ephemeralPublicKeys[pk], v.PublicKey)
-				// instantiate AEAD scheme (AES128-GCM)
-				gcm, err := lib.NewAEAD(cothority.Suite.Hash, pre, ctx)
+				// the ciphertext is signed by the conode
+				st := lib.NewStatement(NameHashPrivate, tURL, nonce, v.PublicKey)
+				st.Timestamp = v.Timestamp
+				st.Flags = lib.StatementEncrypted
+				st.Payload = lib.PrivateResponsePayload(v.Encapsulation, v.EncryptedHash)
+				require.Nil(t, lib.VerifyStatement(st, v.Signature))
+				// decrypt with HPKE
+				info, err := lib.PrivateResponseInfo(v.PublicKey)
 				require.Nil(t, err)
-				// encrypt hash with AES128-GCM
-				decrypted, err := gcm.Open(nil, v.Nonce, v.EncryptedHash, nil)
+				decrypted, err := lib.HPKEOpen(ephemeralPrivateKeys[pk], v.Encapsulation,
+					info, nonce, v.EncryptedHash)
 				require.Nil(t, err)
 				require.NotNil(t, decrypted)
+				// the plaintext contains the labeled digests
+				digests, err := lib.DecodeDigests(decrypted)
+				require.Nil(t, err)
+				require.Equal(t, lib.DefaultAlgorithm, digests[0].Algorithm)
 			}
 
 		case <-time.After(time.Second * 5):
@@ -1,14 +1,13 @@
 package protocol
 
 import (
-	"bytes"
 	"encoding/base64"
 	"errors"
 	"io"
 	"sync"
+	"time"
 
 	"github.com/si-co/dpcc/lib"
-	"go.dedis.ch/cothority/v3"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -28,6 +27,13 @@ type HashPublic struct {
 	*onet.TreeNodeInstance
 	// resource's URL
 	URL string
+	// identifiers of the digest algorithms to use
+	Algorithms []string
+	// limits for the download of the resource
+	Options *lib.FetchOptions
+	// how long the conodes keep the content they hash for a diff report,
+	// see HashContent. The content is not kept if 0.
+	ContentTTL time.Duration
 	// nonce received from the client
 	Nonce []byte
 	// map of conode responses indexed by the public key of the worker
@@ -75,11 +81,19 @@ func (h *HashPublic) Start() error {
 	if h.Nonce == nil {
 		return errors.New("initialize nonce first")
 	}
+	algorithms, err := lib.CheckAlgorithms(h.Algorithms)
+	if err != nil {
+		return err
+	}
+	h.Algorithms = algorithms
 
 	// start announcement phase
 	a := &HashPublicAnnouncement{
-		URL:   h.URL,
-		Nonce: h.Nonce,
+		URL:        h.URL,
+		Nonce:      h.Nonce,
+		Algorithms: h.Algorithms,
+		Options:    h.Options,
+		ContentTTL: h.ContentTTL,
 	}
 
 	return h.handleAnnouncement(a)
@@ -128,6 +142,11 @@ func (h *HashPublic) handleAnnouncement(in *HashPublicAnnouncement) error {
 	log.Lvlf4("%s received %s as URL in announcement", h.Name(), h.URL)
 	h.Nonce = in.Nonce
 	log.Lvlf4("%s received %s ad nonce in announcement", h.Name(), h.Nonce)
+	h.Algorithms = in.Algorithms
+	log.Lvlf4("%s received %v as algorithms in announcement", h.Name(), h.Algorithms)
+	h.Options = in.Options
+	log.Lvlf4("%s received %#v as options in announcement", h.Name(), h.Options)
+	h.ContentTTL = in.ContentTTL
 
 	// if we are a leaf, we should go to response
 	if h.IsLeaf() {
@@ -140,22 +159,39 @@ func (h *HashPublic) handleAnnouncement(in *HashPublicAnnouncement) error {
 
 func (h *HashPublic) handleResponse(in *HashPublicResponse) error {
 	if h.IsLeaf() {
-		// fetch resource specified by the URL
+		// fetch resource specified by the URL and hash it on the fly
+		// with all the requested algorithms, so that the resource is
+		// never entirely kept in memory
 		// in this case we do not parse nor normalize the resource, we
 		// take the hash of the data as they are seen by the host
-		resource, err := lib.FetchMainResource(h.URL)
+		var content *lib.LimitedBuffer
+		var w io.Writer
+		if h.ContentTTL > 0 {
+			content = &lib.LimitedBuffer{Limit: contentMaxSize}
+			w = content
+		}
+		info, digests, err := lib.StreamDigestsTo(h.URL, h.Options, h.Algorithms, w)
 		if err != nil {
 			return err
 		}
+		for _, d := range digests {
+			log.Lvlf4("%s computed %s digest %s", h.Name(), d.Algorithm,
+				base64.StdEncoding.EncodeToString(d.Value))
+		}
+		if content != nil && !content.Overflow {
+			keepContent(keptContentKey(servicePublic(h.TreeNodeInstance), h.URL, h.Nonce),
+				&keptContent{
+					contentType: info.ContentType,
+					data:        content.Bytes(),
+					digests:     digests,
+				}, h.ContentTTL)
+		}
 
-		// compute hash
-		hasher := cothority.Suite.Hash()
-		io.Copy(hasher, bytes.NewReader(resource.Data))
-		hash := hasher.Sum(nil)
-		log.Lvlf4("%s computed hash %s", h.Name(), base64.StdEncoding.EncodeToString(hash))
-
-		// compute signature with nonce
-		sig, err := lib.SignWithNonce(h.Private(), hash, h.Nonce)
+		// sign a statement about the digests, covering the algorithm
+		// identifiers, the URL and the nonce as well
+		st := lib.NewStatement(NameHashPublic, h.URL, h.Nonce, servicePublic(h.TreeNodeInstance))
+		st.Digests = digests
+		sig, err := lib.SignStatement(servicePrivate(h.TreeNodeInstance), st)
 		if err != nil {
 			return err
 		}
@@ -163,8 +199,9 @@ func (h *HashPublic) handleResponse(in *HashPublicResponse) error {
 
 		// since we are a leaf, send response to parent
 		r := &HashPublicResponse{
-			PublicKey: h.Public(),
-			Hash:      hash,
+			PublicKey: st.NodeKey,
+			Digests:   digests,
+			Timestamp: st.Timestamp,
 			Signature: sig,
 		}
 
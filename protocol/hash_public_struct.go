@@ -1,6 +1,9 @@
 package protocol
 
 import (
+	"time"
+
+	"github.com/si-co/dpcc/lib"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3"
 )
@@ -11,6 +14,9 @@ type HashPublicAnnouncement struct {
 	URL               string
 	ClientContentHash []byte
 	Nonce             []byte
+	Algorithms        []string
+	Options           *lib.FetchOptions
+	ContentTTL        time.Duration
 }
 
 type chanHashPublicAnnouncement struct {
@@ -21,7 +27,8 @@ type chanHashPublicAnnouncement struct {
 // HashPublicResponse send by every conode to the root
 type HashPublicResponse struct {
 	PublicKey kyber.Point
-	Hash      []byte
+	Digests   []*lib.Digest
+	Timestamp int64
 	Signature []byte
 }
 
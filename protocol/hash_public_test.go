@@ -1,6 +1,9 @@
 package protocol
 
 import (
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
 	"time"
 
@@ -53,13 +56,19 @@ func TestHashPublicProtocol(t *testing.T) {
 
 			// verify all the signatures, which should be correct
 			for _, r := range p.Responses {
-				err := lib.VerifyWithNonce(r.PublicKey, r.Hash, nonce, r.Signature)
-				require.Nil(t, err)
+				st := lib.NewStatement(NameHashPublic, tURL, nonce, r.PublicKey)
+				st.Timestamp = r.Timestamp
+				st.Digests = r.Digests
+				require.Nil(t, lib.VerifyStatement(st, r.Signature))
+
+				// the signature does not hold for another URL
+				st.URL = tURL + "other"
+				require.NotNil(t, lib.VerifyStatement(st, r.Signature))
 			}
 
 			// print the map containing the hashes
 			//			for pkString, r := range p.Responses {
-			//				hashEncoded := base64.StdEncoding.EncodeToString(r.Hash)
+			//				hashEncoded := base64.StdEncoding.EncodeToString(r.Digests[0].Value)
 			//				fmt.Printf("Server with pk %s sended %s\n", pkString, hashEncoded)
 			//			}
 
@@ -70,3 +79,83 @@ func TestHashPublicProtocol(t *testing.T) {
 		local.CloseAll()
 	}
 }
+
+func TestHashPublicProtocolLargeResource(t *testing.T) {
+	// serve a resource that is too big to be comfortably kept in memory
+	// by all the conodes of the test
+	size := int64(64 << 20)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/octet-stream")
+		io.CopyN(w, zeroReader{}, size)
+	}))
+	defer ts.Close()
+
+	// compute the expected digests
+	algorithms := []string{lib.SHA256, lib.SHA512, lib.BLAKE2b256}
+	expected := make([]*lib.Digest, len(algorithms))
+	for i, a := range algorithms {
+		hasher, err := lib.NewHash(a)
+		require.Nil(t, err)
+		io.CopyN(hasher, zeroReader{}, size)
+		expected[i] = &lib.Digest{Algorithm: a, Value: hasher.Sum(nil)}
+	}
+
+	nbrHosts := 4
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+	_, _, tree := local.GenBigTree(nbrHosts, nbrHosts, nbrHosts, true)
+
+	instance, err := local.CreateProtocol(NameHashPublic, tree)
+	require.Nil(t, err)
+	p := instance.(*HashPublic)
+	p.URL = ts.URL
+	p.Nonce = lib.GenNonce()
+	p.Algorithms = algorithms
+	p.Options = &lib.FetchOptions{
+		MaxSize:         size,
+		ProgressTimeout: time.Second,
+		AnyContentType:  true,
+	}
+	require.Nil(t, p.Start())
+
+	select {
+	case <-p.Finished:
+		require.Equal(t, nbrHosts-1, len(p.Responses))
+		for _, r := range p.Responses {
+			require.Equal(t, expected, r.Digests)
+		}
+	case <-time.After(time.Second * 30):
+		t.Fatal("couldn't get hash public protocol done in time")
+	}
+}
+
+// zeroReader is an infinite source of zeros
+type zeroReader struct{}
+
+func (zeroReader) Read(b []byte) (int, error) {
+	for i := range b {
+		b[i] = 0
+	}
+	return len(b), nil
+}
+
+func TestKeptContents(t *testing.T) {
+	keepContent("a", &keptContent{data: []byte("a")}, 10*time.Millisecond)
+	keepContent("b", &keptContent{data: []byte("b")}, time.Minute)
+	time.Sleep(20 * time.Millisecond)
+
+	// taking a content removes it and the expired ones
+	c := takeContent("b")
+	require.NotNil(t, c)
+	require.Equal(t, []byte("b"), c.data)
+	require.Nil(t, takeContent("b"))
+	keptContentsLock.Lock()
+	require.Equal(t, 0, len(keptContents))
+	keptContentsLock.Unlock()
+
+	// the leader cannot ask to keep a content for too long
+	keepContent("c", &keptContent{}, 1000*time.Hour)
+	c = takeContent("c")
+	require.NotNil(t, c)
+	require.True(t, c.expires.Before(time.Now().Add(2*(lib.FetchLimits().Timeout+time.Minute)+time.Second)))
+}
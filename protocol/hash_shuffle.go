@@ -0,0 +1,389 @@
+package protocol
+
+import (
+	"errors"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// NameHashShuffle is the protocol identifier string
+const NameHashShuffle = "HashShuffle"
+
+func init() {
+	network.RegisterMessages(HashShuffleAnnouncement{}, HashShuffleResponse{},
+		HashShuffleRequest{}, HashShuffleStep{}, HashShuffleDecrypt{},
+		HashShufflePartial{})
+	onet.GlobalProtocolRegister(NameHashShuffle, NewHashShuffleProtocol)
+}
+
+// HashShuffle is the core structure of the protocol revealing the digests of
+// the conodes without revealing who computed which: every conode encrypts
+// its digest under the distributed key of the roster, all the nodes shuffle
+// the encrypted digests one after the other with a verifiable Neff shuffle,
+// and a threshold of nodes decrypt the output of the last shuffle once they
+// checked that every node shuffled it. The encrypted digests and the shuffles
+// are signed with the nonce of the client and the partial decryptions come
+// with proofs, so that the client can verify the whole run. Only the
+// beginning of the digests, as kept by lib.EmbedDigest, is revealed.
+type HashShuffle struct {
+	*onet.TreeNodeInstance
+	// resource's URL
+	URL string
+	// nonce received from the client
+	Nonce []byte
+	// identifier of the digest algorithm to use
+	Algorithm string
+	// limits for the download of the resource
+	Options *lib.FetchOptions
+
+	// distributed key of the roster and share of the node, set by the
+	// service
+	DistKey   kyber.Point
+	Share     *share.PriShare
+	Threshold int
+	NbrShares int
+	// commitments of the shares of the distributed key
+	Commits []kyber.Point
+
+	// results of the protocol
+	// the truncated digests of the conodes, sorted
+	Digests [][]byte
+	// the signed encrypted digests, their shuffles and the partial
+	// decryptions of the last one
+	Inputs   []*lib.SignedCiphertext
+	Steps    []*lib.ShuffleStep
+	Partials []*lib.PartialDecryption
+	// signatures of the distributed key by the nodes
+	KeySignatures map[string][]byte
+
+	// encrypted digest of the conode
+	ciphertext *lib.ElGamalCiphertext
+
+	// protocol channels
+	announce chan chanHashShuffleAnnouncement
+	response chan []chanHashShuffleResponse
+	request  chan chanHashShuffleRequest
+	step     chan chanHashShuffleStep
+	decrypt  chan chanHashShuffleDecrypt
+	partial  chan []chanHashShufflePartial
+	// the channel that indicates if we are finished or not
+	Finished chan bool
+}
+
+// NewHashShuffleProtocol returns a HashShuffleProtocol with the right
+// channels initialized
+func NewHashShuffleProtocol(n *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	log.Lvl2("creating new hash shuffle protocol")
+	h := &HashShuffle{
+		TreeNodeInstance: n,
+		Finished:         make(chan bool, 1),
+	}
+
+	// register the channels we want listen on
+	if err := n.RegisterChannels(&h.announce, &h.response, &h.request,
+		&h.step, &h.decrypt, &h.partial); err != nil {
+		return nil, err
+	}
+
+	return h, nil
+}
+
+// Start is executed by the root to start the protocol, by checking that all
+// the needed parameters have been initialized and by sending the announcement
+func (h *HashShuffle) Start() error {
+	log.Lvl2("starting hash shuffle protocol")
+	if h.URL == "" {
+		return errors.New("initialize URL first")
+	}
+	if h.Nonce == nil {
+		return errors.New("initialize nonce first")
+	}
+	if err := h.checkKey(); err != nil {
+		return err
+	}
+	if len(h.Children()) < 2 {
+		return errors.New("at least two conodes are needed to shuffle")
+	}
+	if h.Algorithm == "" {
+		h.Algorithm = lib.DefaultAlgorithm
+	}
+	if _, err := lib.NewHash(h.Algorithm); err != nil {
+		return err
+	}
+
+	a := &HashShuffleAnnouncement{
+		URL:       h.URL,
+		Nonce:     h.Nonce,
+		Algorithm: h.Algorithm,
+		Options:   h.Options,
+		DistKey:   h.DistKey,
+	}
+	return h.SendToChildren(a)
+}
+
+// Dispatch will listen on the channels of the three phases of the protocol
+func (h *HashShuffle) Dispatch() error {
+	defer h.Done()
+
+	// if we are a leaf, we answer the announcement and the requests of
+	// the root
+	if !h.IsRoot() {
+		log.Lvl3(h.Name(), "waiting for announcement")
+		a := (<-h.announce).HashShuffleAnnouncement
+		if err := h.handleAnnouncement(&a); err != nil {
+			return err
+		}
+		log.Lvl3(h.Name(), "waiting for shuffle request")
+		r := (<-h.request).HashShuffleRequest
+		if err := h.handleRequest(&r); err != nil {
+			return err
+		}
+		log.Lvl3(h.Name(), "waiting for decryption request")
+		d := (<-h.decrypt).HashShuffleDecrypt
+		return h.handleDecrypt(&d)
+	}
+
+	// if we are the root, we have the digests shuffled by every node and
+	// decrypted
+	if err := h.handleResponses(<-h.response); err != nil {
+		return err
+	}
+	if err := h.handlePartials(<-h.partial); err != nil {
+		return err
+	}
+
+	log.Lvl2("hash shuffle protocol terminated")
+	h.Finished <- true
+	return nil
+}
+
+// handleAnnouncement computes the digest of the resource and sends it
+// encrypted to the root
+func (h *HashShuffle) handleAnnouncement(in *HashShuffleAnnouncement) error {
+	h.URL = in.URL
+	h.Nonce = in.Nonce
+	h.Algorithm = in.Algorithm
+	h.Options = in.Options
+	log.Lvlf4("%s received %s as URL in announcement", h.Name(), h.URL)
+
+	if err := h.checkKey(); err != nil {
+		return err
+	}
+	if in.DistKey == nil || !in.DistKey.Equal(h.DistKey) {
+		return errors.New("announced key is not the distributed key of the roster")
+	}
+	if lib.IsSimilarity(h.Algorithm) {
+		return errors.New("shuffle needs an exact digest algorithm")
+	}
+
+	_, digests, err := lib.StreamDigests(h.URL, h.Options, []string{h.Algorithm})
+	if err != nil {
+		return err
+	}
+	digest := lib.FindDigest(digests, h.Algorithm)
+	if digest == nil {
+		return errors.New("no digest computed")
+	}
+
+	h.ciphertext = lib.EncryptPoint(h.DistKey, lib.EmbedDigest(digest))
+	sig, err := lib.SignCiphertexts(servicePrivate(h.TreeNodeInstance), NameHashShuffle,
+		h.Nonce, []*lib.ElGamalCiphertext{h.ciphertext})
+	if err != nil {
+		return err
+	}
+	keySig, err := lib.SignDistKey(servicePrivate(h.TreeNodeInstance), h.Roster(), h.Commits)
+	if err != nil {
+		return err
+	}
+	r := &HashShuffleResponse{
+		PublicKey:    servicePublic(h.TreeNodeInstance),
+		Ciphertext:   h.ciphertext,
+		Signature:    sig,
+		KeySignature: keySig,
+	}
+	return h.SendToParent(r)
+}
+
+// handleResponses collects the signed encrypted digests and asks every node,
+// the root first, to shuffle them in turn
+func (h *HashShuffle) handleResponses(responses []chanHashShuffleResponse) error {
+	keySig, err := lib.SignDistKey(servicePrivate(h.TreeNodeInstance), h.Roster(), h.Commits)
+	if err != nil {
+		return err
+	}
+	h.KeySignatures = map[string][]byte{servicePublic(h.TreeNodeInstance).String(): keySig}
+	h.Inputs = []*lib.SignedCiphertext{}
+	for _, r := range responses {
+		pk := nodePublic(r.TreeNode)
+		ct := r.Ciphertext
+		if ct == nil || ct.K == nil || ct.C == nil {
+			return errors.New("invalid response from " + r.ServerIdentity.String())
+		}
+		if err := lib.VerifyCiphertexts(pk, NameHashShuffle, h.Nonce,
+			[]*lib.ElGamalCiphertext{ct}, r.Signature); err != nil {
+			return errors.New("invalid signature from " + r.ServerIdentity.String())
+		}
+		h.Inputs = append(h.Inputs, &lib.SignedCiphertext{
+			PublicKey:  pk,
+			Ciphertext: ct,
+			Signature:  r.Signature,
+		})
+		h.KeySignatures[pk.String()] = r.KeySignature
+	}
+
+	// the root shuffles first
+	step, err := h.shuffle(inputCiphertexts(h.Inputs))
+	if err != nil {
+		return err
+	}
+	h.Steps = []*lib.ShuffleStep{step}
+
+	for _, c := range h.Children() {
+		req := &HashShuffleRequest{Inputs: h.Inputs, Steps: h.Steps}
+		if err := h.SendTo(c, req); err != nil {
+			return err
+		}
+		s := (<-h.step).HashShuffleStep
+		if s.Step == nil || s.Step.PublicKey == nil ||
+			!s.Step.PublicKey.Equal(nodePublic(c)) {
+			return errors.New("invalid shuffle from " + c.ServerIdentity.String())
+		}
+		last := h.Steps[len(h.Steps)-1].Ciphertexts
+		if err := lib.VerifyShuffles(h.DistKey, h.Nonce, last, []*lib.ShuffleStep{s.Step}); err != nil {
+			return err
+		}
+		h.Steps = append(h.Steps, s.Step)
+	}
+
+	return h.SendToChildren(&HashShuffleDecrypt{Inputs: h.Inputs, Steps: h.Steps})
+}
+
+// handleRequest checks the previous shuffles and shuffles the output of the
+// last one
+func (h *HashShuffle) handleRequest(in *HashShuffleRequest) error {
+	if err := h.checkShuffles(in.Inputs, in.Steps); err != nil {
+		return err
+	}
+	step, err := h.shuffle(in.Steps[len(in.Steps)-1].Ciphertexts)
+	if err != nil {
+		return err
+	}
+	return h.SendToParent(&HashShuffleStep{Step: step})
+}
+
+// handleDecrypt checks that every node shuffled the encrypted digests and
+// sends the partial decryptions of the output of the last shuffle
+func (h *HashShuffle) handleDecrypt(in *HashShuffleDecrypt) error {
+	if err := h.checkShuffles(in.Inputs, in.Steps); err != nil {
+		return err
+	}
+	shufflers := make(map[string]bool)
+	for _, s := range in.Steps {
+		shufflers[s.PublicKey.String()] = true
+	}
+	for _, n := range h.List() {
+		if !shufflers[nodePublic(n).String()] {
+			return errors.New("encrypted digests not shuffled by every node")
+		}
+	}
+
+	p, err := lib.PartialDecryptProof(servicePublic(h.TreeNodeInstance), h.Share,
+		in.Steps[len(in.Steps)-1].Ciphertexts)
+	if err != nil {
+		return err
+	}
+	return h.SendToParent(&HashShufflePartial{Partial: p})
+}
+
+// handlePartials checks the partial decryptions of the conodes, decrypts the
+// output of the last shuffle and extracts the digests
+func (h *HashShuffle) handlePartials(responses []chanHashShufflePartial) error {
+	output := h.Steps[len(h.Steps)-1].Ciphertexts
+	own, err := lib.PartialDecryptProof(servicePublic(h.TreeNodeInstance), h.Share, output)
+	if err != nil {
+		return err
+	}
+	h.Partials = []*lib.PartialDecryption{own}
+	for _, r := range responses {
+		p := r.Partial
+		if p == nil || p.PublicKey == nil || !p.PublicKey.Equal(nodePublic(r.TreeNode)) ||
+			p.Verify(h.Commits, output) != nil {
+			log.Lvl2("ignoring invalid partial decryptions from", r.ServerIdentity)
+			continue
+		}
+		h.Partials = append(h.Partials, p)
+	}
+
+	h.Digests, err = lib.DecryptDigests(output, h.Partials, h.Threshold, h.NbrShares)
+	return err
+}
+
+// shuffle shuffles the ciphertexts and returns the signed step of the node
+func (h *HashShuffle) shuffle(cts []*lib.ElGamalCiphertext) (*lib.ShuffleStep, error) {
+	shuffled, proof, err := lib.ShuffleCiphertexts(h.DistKey, cts)
+	if err != nil {
+		return nil, err
+	}
+	step := &lib.ShuffleStep{
+		PublicKey:   servicePublic(h.TreeNodeInstance),
+		Ciphertexts: shuffled,
+		Proof:       proof,
+	}
+	if err := lib.SignShuffleStep(servicePrivate(h.TreeNodeInstance), h.Nonce, step); err != nil {
+		return nil, err
+	}
+	return step, nil
+}
+
+// checkShuffles verifies the shuffles and that the inputs have been signed
+// by every child of the root, the encrypted digest of the conode among them
+// unmodified, so that it cannot be singled out by inputs of the root
+func (h *HashShuffle) checkShuffles(inputs []*lib.SignedCiphertext, steps []*lib.ShuffleStep) error {
+	if len(steps) == 0 {
+		return errors.New("no shuffle")
+	}
+	conodes := []kyber.Point{}
+	for _, c := range h.Root().Children {
+		conodes = append(conodes, nodePublic(c))
+	}
+	if err := lib.VerifySignedCiphertexts(NameHashShuffle, h.Nonce, conodes, inputs); err != nil {
+		return err
+	}
+	found := false
+	for _, input := range inputs {
+		if input.PublicKey.Equal(servicePublic(h.TreeNodeInstance)) {
+			found = lib.EqualCiphertexts([]*lib.ElGamalCiphertext{input.Ciphertext},
+				[]*lib.ElGamalCiphertext{h.ciphertext})
+		}
+	}
+	if !found {
+		return errors.New("encrypted digest of the conode not among the inputs")
+	}
+	return lib.VerifyShuffles(h.DistKey, h.Nonce, inputCiphertexts(inputs), steps)
+}
+
+// inputCiphertexts returns the ciphertexts of the signed inputs
+func inputCiphertexts(inputs []*lib.SignedCiphertext) []*lib.ElGamalCiphertext {
+	cts := make([]*lib.ElGamalCiphertext, len(inputs))
+	for i, input := range inputs {
+		cts[i] = input.Ciphertext
+	}
+	return cts
+}
+
+// checkKey checks that the service provided the share of the distributed key
+// of the roster
+func (h *HashShuffle) checkKey() error {
+	if h.DistKey == nil || h.Share == nil || h.Share.V == nil {
+		return errors.New("no distributed key for the roster")
+	}
+	if h.Threshold < 1 || h.Threshold > h.NbrShares || len(h.Commits) != h.Threshold {
+		return errors.New("invalid threshold of the distributed key")
+	}
+	return nil
+}
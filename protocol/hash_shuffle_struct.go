@@ -0,0 +1,83 @@
+package protocol
+
+import (
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+)
+
+// HashShuffleAnnouncement is sent down the tree by the root to start a new
+// HashShuffle protocol
+type HashShuffleAnnouncement struct {
+	URL       string
+	Nonce     []byte
+	Algorithm string
+	Options   *lib.FetchOptions
+	DistKey   kyber.Point
+}
+
+type chanHashShuffleAnnouncement struct {
+	*onet.TreeNode
+	HashShuffleAnnouncement
+}
+
+// HashShuffleResponse is sent by every conode to the root and contains its
+// digest embedded in a point and encrypted under the distributed key, signed
+// with the nonce, and its signature of the distributed key
+type HashShuffleResponse struct {
+	PublicKey    kyber.Point
+	Ciphertext   *lib.ElGamalCiphertext
+	Signature    []byte
+	KeySignature []byte
+}
+
+type chanHashShuffleResponse struct {
+	*onet.TreeNode
+	HashShuffleResponse
+}
+
+// HashShuffleRequest is sent by the root to one conode at a time with the
+// encrypted digests and the shuffles done so far
+type HashShuffleRequest struct {
+	Inputs []*lib.SignedCiphertext
+	Steps  []*lib.ShuffleStep
+}
+
+type chanHashShuffleRequest struct {
+	*onet.TreeNode
+	HashShuffleRequest
+}
+
+// HashShuffleStep is sent back to the root by the conode with its shuffle of
+// the output of the last step
+type HashShuffleStep struct {
+	Step *lib.ShuffleStep
+}
+
+type chanHashShuffleStep struct {
+	*onet.TreeNode
+	HashShuffleStep
+}
+
+// HashShuffleDecrypt is sent down the tree by the root with all the shuffles,
+// the output of the last one being decrypted
+type HashShuffleDecrypt struct {
+	Inputs []*lib.SignedCiphertext
+	Steps  []*lib.ShuffleStep
+}
+
+type chanHashShuffleDecrypt struct {
+	*onet.TreeNode
+	HashShuffleDecrypt
+}
+
+// HashShufflePartial is sent by every conode to the root and contains its
+// partial decryptions of the shuffled digests, with their proofs
+type HashShufflePartial struct {
+	Partial *lib.PartialDecryption
+}
+
+type chanHashShufflePartial struct {
+	*onet.TreeNode
+	HashShufflePartial
+}
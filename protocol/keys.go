@@ -0,0 +1,24 @@
+package protocol
+
+import (
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3"
+)
+
+// servicePrivate returns the private key of the service of the conode
+// running the node, used in place of the private key of its identity
+func servicePrivate(n *onet.TreeNodeInstance) kyber.Scalar {
+	return n.Host().ServerIdentity.ServicePrivate(lib.ServiceName)
+}
+
+// servicePublic returns the public key of the service of the conode running
+// the node, as announced in the roster
+func servicePublic(n *onet.TreeNodeInstance) kyber.Point {
+	return lib.ServicePublic(n.ServerIdentity())
+}
+
+// nodePublic returns the public key of the service of a node of the tree
+func nodePublic(n *onet.TreeNode) kyber.Point {
+	return lib.ServicePublic(n.ServerIdentity)
+}
@@ -0,0 +1,406 @@
+package dpcc
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/onet/v3"
+)
+
+// paths of the Memento (RFC 7089) TimeGate and TimeMap of an URL in the
+// replay, followed by the URL
+const (
+	replayTimeGate = "/timegate/"
+	replayTimeMap  = "/timemap/link/"
+)
+
+// replayFetchTTL is the time after which the archived runs of an URL are
+// fetched again, to replay the runs archived meanwhile
+const replayFetchTTL = 5 * time.Minute
+
+// replayPolicy is the content security policy of the archived resources: they
+// are sandboxed, so that their scripts cannot act as the replay, and can only
+// load resources of the replay itself
+const replayPolicy = "sandbox; default-src 'self' 'unsafe-inline' data:"
+
+// Replay serves archived runs over HTTP, so that they can be browsed as the
+// conodes captured them. An archived resource is served at /<run ID>/<URL>,
+// with its links rewritten to the archive, and the runs are listed at /.
+// The archived pages are the mementos of their URL, which has a Memento
+// TimeGate at /timegate/<URL> and a TimeMap at /timemap/link/<URL>.
+type Replay struct {
+	roster *onet.Roster
+	// fetch returns the archived runs of an URL, if they are not all given
+	// to the replay
+	fetch func(URL string) ([]*WARCCapture, error)
+
+	sync.Mutex
+	captures []*WARCCapture
+	runs     map[string]*WARCCapture
+	// times at which the archived runs of the URLs were fetched
+	fetched map[string]time.Time
+}
+
+// NewReplay returns a replay of the archived runs, which are verified against
+// the signatures of the conodes of the roster
+func NewReplay(r *onet.Roster, captures []*WARCCapture) (*Replay, error) {
+	rp := &Replay{roster: r, runs: make(map[string]*WARCCapture)}
+	if err := rp.add(captures); err != nil {
+		return nil, err
+	}
+	if len(rp.captures) == 0 {
+		return nil, errors.New("no archived run to replay")
+	}
+	return rp, nil
+}
+
+// NewArchiveReplay returns a replay of the archived runs of the URLs that are
+// requested, which are fetched again once in a while and verified against
+// the signatures of the conodes of the roster
+func NewArchiveReplay(r *onet.Roster, fetch func(URL string) ([]*WARCCapture, error)) *Replay {
+	return &Replay{
+		roster:  r,
+		fetch:   fetch,
+		runs:    make(map[string]*WARCCapture),
+		fetched: make(map[string]time.Time),
+	}
+}
+
+// add verifies archived runs and adds the new ones to the replay
+func (rp *Replay) add(captures []*WARCCapture) error {
+	for _, c := range captures {
+		if err := VerifyCapture(rp.roster, c); err != nil {
+			return err
+		}
+	}
+	rp.Lock()
+	defer rp.Unlock()
+	for _, c := range captures {
+		if _, ok := rp.runs[c.Run.ID]; ok {
+			continue
+		}
+		rp.runs[c.Run.ID] = c
+		rp.captures = append(rp.captures, c)
+	}
+	sort.SliceStable(rp.captures, func(i, j int) bool {
+		return rp.signedTime(rp.captures[i].Run) < rp.signedTime(rp.captures[j].Run)
+	})
+	return nil
+}
+
+// signedTime returns the time of a run as signed by the conodes that agreed
+// on its content, which dates its memento
+func (rp *Replay) signedTime(run *Run) int64 {
+	return AgreedTime(run, AgreedGroup(run, len(rp.roster.List)-1, lib.SHA256))
+}
+
+// load fetches the archived runs of the URL if it has not been done lately
+func (rp *Replay) load(URL string) error {
+	if rp.fetch == nil {
+		return nil
+	}
+	rp.Lock()
+	last, done := rp.fetched[URL]
+	rp.Unlock()
+	if done && time.Since(last) < replayFetchTTL {
+		return nil
+	}
+	captures, err := rp.fetch(URL)
+	if err != nil {
+		return err
+	}
+	if err := rp.add(captures); err != nil {
+		return err
+	}
+	rp.Lock()
+	rp.fetched[URL] = time.Now()
+	rp.Unlock()
+	return nil
+}
+
+// mementos returns the archived runs of the URL in chronological order
+func (rp *Replay) mementos(URL string) ([]*WARCCapture, error) {
+	if err := rp.load(URL); err != nil {
+		return nil, err
+	}
+	rp.Lock()
+	defer rp.Unlock()
+	var mementos []*WARCCapture
+	for _, c := range rp.captures {
+		if c.Run.URL == URL {
+			mementos = append(mementos, c)
+		}
+	}
+	return mementos, nil
+}
+
+// ReplayPath returns the path of an archived resource of a run in the replay
+func ReplayPath(ID, URL string) string {
+	return "/" + ID + "/" + URL
+}
+
+// ServeHTTP serves the list of the runs, an archived resource, or the
+// TimeGate or TimeMap of an URL
+func (rp *Replay) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	// the archived URL is taken as sent, as cleaning the path would alter it
+	p := req.RequestURI
+	switch {
+	case p == "" || p == "/":
+		rp.serveIndex(w)
+		return
+	case strings.HasPrefix(p, replayTimeGate):
+		rp.serveTimeGate(w, req, strings.TrimPrefix(p, replayTimeGate))
+		return
+	case strings.HasPrefix(p, replayTimeMap):
+		rp.serveTimeMap(w, req, strings.TrimPrefix(p, replayTimeMap))
+		return
+	}
+	parts := strings.SplitN(strings.TrimPrefix(p, "/"), "/", 2)
+	if len(parts) < 2 {
+		http.Error(w, "no archived run "+parts[0], http.StatusNotFound)
+		return
+	}
+	rp.Lock()
+	c, ok := rp.runs[parts[0]]
+	rp.Unlock()
+	if !ok {
+		// the run may be one of the archived runs of the URL
+		if err := rp.load(parts[1]); err != nil {
+			http.Error(w, "couldn't fetch the archived runs: "+err.Error(), http.StatusBadGateway)
+			return
+		}
+		rp.Lock()
+		c, ok = rp.runs[parts[0]]
+		rp.Unlock()
+	}
+	if !ok {
+		http.Error(w, "no archived run "+parts[0], http.StatusNotFound)
+		return
+	}
+	rp.serveResource(w, req, c, parts[1])
+}
+
+// serveIndex lists the archived runs
+func (rp *Replay) serveIndex(w http.ResponseWriter) {
+	rp.Lock()
+	captures := rp.captures
+	rp.Unlock()
+	var b strings.Builder
+	b.WriteString("<!DOCTYPE html>\n<html><head><title>dpcc archive</title></head><body>\n")
+	b.WriteString("<h1>Archived runs</h1>\n<ul>\n")
+	for _, c := range captures {
+		run := c.Run
+		fmt.Fprintf(&b, "<li>%s <a href=\"%s\">%s</a> run %s</li>\n",
+			formatReplayTime(rp.signedTime(run)), html.EscapeString(ReplayPath(run.ID, run.URL)),
+			html.EscapeString(run.URL), html.EscapeString(run.ID))
+	}
+	b.WriteString("</ul>\n</body></html>\n")
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, b.String())
+}
+
+// serveResource serves the archived resource or subresource of a run, with
+// its links rewritten to the same run
+func (rp *Replay) serveResource(w http.ResponseWriter, req *http.Request, c *WARCCapture, URL string) {
+	run := c.Run
+	data, ok := c.Contents[URL]
+	if !ok {
+		// browsers escape some characters of the links
+		if u, err := url.PathUnescape(URL); err == nil {
+			URL = u
+			data, ok = c.Contents[URL]
+		}
+	}
+	if !ok {
+		http.Error(w, URL+" has not been archived with run "+run.ID, http.StatusNotFound)
+		return
+	}
+
+	contentType := run.Capture.ContentType
+	if URL != run.URL {
+		for _, r := range run.Capture.Resources {
+			if r.URL == URL {
+				contentType = r.ContentType
+			}
+		}
+	}
+	rewrite := func(l string) string {
+		return ReplayPath(run.ID, l)
+	}
+	switch {
+	case strings.Contains(contentType, "html"):
+		banner := ""
+		if URL == run.URL {
+			banner = rp.banner(run)
+		}
+		page, err := lib.RewriteHTML(URL, data, rewrite, banner)
+		if err != nil {
+			http.Error(w, "invalid archived page: "+err.Error(), http.StatusInternalServerError)
+			return
+		}
+		data = page
+	case strings.Contains(contentType, "css"):
+		data = lib.RewriteCSS(URL, data, rewrite)
+	}
+	// the archived page is a memento of its URL
+	if URL == run.URL {
+		w.Header().Set("Memento-Datetime", mementoTime(rp.signedTime(run)))
+		w.Header().Set("Link", strings.Join([]string{
+			mementoLink(URL, "original"),
+			mementoLink(replayURL(req, replayTimeGate+URL), "timegate"),
+			mementoLink(replayURL(req, replayTimeMap+URL), "timemap") + `; type="application/link-format"`,
+		}, ", "))
+	}
+	w.Header().Set("Content-Security-Policy", replayPolicy)
+	w.Header().Set("Content-Type", contentType)
+	w.Write(data)
+}
+
+// serveTimeGate redirects to the memento of the URL nearest to the time
+// requested in the Accept-Datetime header, or to the last one
+func (rp *Replay) serveTimeGate(w http.ResponseWriter, req *http.Request, URL string) {
+	mementos, err := rp.mementos(URL)
+	if err != nil {
+		http.Error(w, "couldn't fetch the archived runs: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Vary", "accept-datetime")
+	w.Header().Set("Link", strings.Join([]string{
+		mementoLink(URL, "original"),
+		mementoLink(replayURL(req, replayTimeMap+URL), "timemap") + `; type="application/link-format"`,
+	}, ", "))
+	if len(mementos) == 0 {
+		http.Error(w, URL+" has not been archived", http.StatusNotFound)
+		return
+	}
+
+	best := mementos[len(mementos)-1]
+	if v := req.Header.Get("Accept-Datetime"); v != "" {
+		t, err := http.ParseTime(v)
+		if err != nil {
+			http.Error(w, "invalid Accept-Datetime: "+v, http.StatusBadRequest)
+			return
+		}
+		// the earlier memento is chosen between two equally near ones
+		at := t.Unix()
+		best = mementos[0]
+		for _, c := range mementos[1:] {
+			if abs(rp.signedTime(c.Run)-at) < abs(rp.signedTime(best.Run)-at) {
+				best = c
+			}
+		}
+	}
+	http.Redirect(w, req, replayURL(req, ReplayPath(best.Run.ID, URL)), http.StatusFound)
+}
+
+// serveTimeMap lists the mementos of the URL in the link format (RFC 6690)
+func (rp *Replay) serveTimeMap(w http.ResponseWriter, req *http.Request, URL string) {
+	mementos, err := rp.mementos(URL)
+	if err != nil {
+		http.Error(w, "couldn't fetch the archived runs: "+err.Error(), http.StatusBadGateway)
+		return
+	}
+	if len(mementos) == 0 {
+		http.Error(w, URL+" has not been archived", http.StatusNotFound)
+		return
+	}
+
+	first, last := rp.signedTime(mementos[0].Run), rp.signedTime(mementos[len(mementos)-1].Run)
+	links := []string{
+		mementoLink(URL, "original"),
+		mementoLink(replayURL(req, replayTimeGate+URL), "timegate"),
+		mementoLink(replayURL(req, replayTimeMap+URL), "self") +
+			`; type="application/link-format"; from="` + mementoTime(first) +
+			`"; until="` + mementoTime(last) + `"`,
+	}
+	for i, c := range mementos {
+		rel := "memento"
+		switch {
+		case len(mementos) == 1:
+			rel = "first last memento"
+		case i == 0:
+			rel = "first memento"
+		case i == len(mementos)-1:
+			rel = "last memento"
+		}
+		links = append(links, mementoLink(replayURL(req, ReplayPath(c.Run.ID, URL)), rel)+
+			`; datetime="`+mementoTime(rp.signedTime(c.Run))+`"`)
+	}
+	w.Header().Set("Content-Type", "application/link-format")
+	fmt.Fprint(w, strings.Join(links, ",\n")+"\n")
+}
+
+// replayURL returns the absolute URL of a path of the replay
+func replayURL(req *http.Request, path string) string {
+	scheme := "http"
+	if req.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + path
+}
+
+// mementoLink returns a link with the relation type, as in Link headers
+func mementoLink(URL, rel string) string {
+	return "<" + URL + `>; rel="` + rel + `"`
+}
+
+// mementoTime formats a Unix time in seconds as the datetimes of Memento
+func mementoTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(http.TimeFormat)
+}
+
+func abs(x int64) int64 {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
+
+// banner returns the HTML fragment shown above an archived page, with the
+// verdict of the conodes and the ones that signed the agreed content
+func (rp *Replay) banner(run *Run) string {
+	g := AgreedGroup(run, len(rp.roster.List)-1, lib.SHA256)
+	var signers []string
+	for _, pk := range g.Nodes {
+		name := pk
+		for _, si := range rp.roster.List {
+			if lib.ServicePublic(si).String() == pk {
+				name = string(si.Address)
+			}
+		}
+		signers = append(signers, html.EscapeString(name))
+	}
+	sort.Strings(signers)
+
+	var b strings.Builder
+	b.WriteString(`<div style="all: initial; display: block; padding: 8px; ` +
+		`background: #ffd; border-bottom: 1px solid #999; font: 13px sans-serif; color: #000">`)
+	fmt.Fprintf(&b, "<strong>dpcc archive</strong> of %s captured at %s, run %s<br>\n",
+		html.EscapeString(run.URL), formatReplayTime(rp.signedTime(run)), html.EscapeString(run.ID))
+	fmt.Fprintf(&b, "%d of %d conodes agreed on SHA-256 hash %s<br>\n",
+		len(g.Nodes), len(run.Responses), hex.EncodeToString(g.Digest))
+	fmt.Fprintf(&b, "Signed by %s", strings.Join(signers, ", "))
+	if len(run.Capture.Resources) > 0 {
+		b.WriteString("<br>\nThe subresources were fetched by the leader alone and are not attested by the other conodes")
+	}
+	b.WriteString("</div>")
+	return b.String()
+}
+
+// formatReplayTime formats a Unix time in seconds
+func formatReplayTime(t int64) string {
+	return time.Unix(t, 0).UTC().Format(time.RFC3339)
+}
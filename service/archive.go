@@ -0,0 +1,434 @@
+package service
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/onet/v3/log"
+)
+
+const (
+	// envArchiveDir is the directory where the conode keeps the contents of
+	// the archived runs. Archiving is disabled if it is not set.
+	envArchiveDir = "DPCC_ARCHIVE_DIR"
+	// envArchiveMaxSize bounds the size in bytes of the contents captured
+	// in a run, subresources included
+	envArchiveMaxSize = "DPCC_ARCHIVE_MAX_SIZE"
+	// envArchiveMaxStore bounds the size in bytes of all the contents kept
+	// by the conode
+	envArchiveMaxStore = "DPCC_ARCHIVE_MAX_STORE"
+)
+
+const (
+	defaultArchiveMaxSize  = 64 << 20
+	defaultArchiveMaxStore = 4 << 30
+	// blobChunkSize bounds the data of a blob sent in a response
+	blobChunkSize = 1 << 20
+	// warcPageSize is the size above which no more run is added to a page
+	// of a WARC export
+	warcPageSize = 16 << 20
+)
+
+// maxSubresources bounds the number of subresources captured with a page
+const maxSubresources = 100
+
+// errArchiveFull is returned when a content exceeds the room left for it
+var errArchiveFull = errors.New("the content exceeds the size left in the archive")
+
+// blobStore keeps contents in files named after their SHA-256 digest. Every
+// blob is counted once per reference by a capture, and removed once no
+// capture refers to it any more.
+type blobStore struct {
+	dir      string
+	maxSize  int64
+	maxStore int64
+	// references to the blobs, indexed by hexadecimal digest, and size of
+	// all the blobs
+	refs map[string]int
+	size int64
+	sync.Mutex
+}
+
+// newBlobStore opens the blob store of the directory set in the environment.
+// It returns nil if no directory has been set.
+func newBlobStore() (*blobStore, error) {
+	dir := os.Getenv(envArchiveDir)
+	if dir == "" {
+		return nil, nil
+	}
+	limits := []int64{defaultArchiveMaxSize, defaultArchiveMaxStore}
+	for i, name := range []string{envArchiveMaxSize, envArchiveMaxStore} {
+		if v := os.Getenv(name); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n <= 0 {
+				return nil, errors.New("invalid " + name + ": " + v)
+			}
+			limits[i] = n
+		}
+	}
+	b, err := openBlobStore(dir, limits[0], limits[1])
+	if err != nil {
+		return nil, errors.New("invalid " + envArchiveDir + ": " + err.Error())
+	}
+	return b, nil
+}
+
+// openBlobStore opens the blob store of the directory, with the maximal size
+// of a capture and of the store
+func openBlobStore(dir string, maxSize, maxStore int64) (*blobStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &blobStore{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxStore: maxStore,
+		refs:     make(map[string]int),
+	}, nil
+}
+
+// path returns the file of the blob with the digest, spread over
+// subdirectories by the first byte of the digest
+func (b *blobStore) path(digest []byte) string {
+	h := hex.EncodeToString(digest)
+	return filepath.Join(b.dir, h[:2], h[2:])
+}
+
+// load counts the references of the captures of the runs to the blobs and
+// the size of the store, and removes the blobs that no capture refers to,
+// such as those of the runs pruned or being archived when the conode stopped
+func (b *blobStore) load(runs map[string]*dpcc.Run) error {
+	b.Lock()
+	defer b.Unlock()
+	b.refs = make(map[string]int)
+	b.size = 0
+	for _, run := range runs {
+		for _, d := range captureDigests(run.Capture) {
+			b.refs[hex.EncodeToString(d)]++
+		}
+	}
+	return filepath.Walk(b.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(b.dir, path)
+		if err != nil {
+			return err
+		}
+		if b.refs[strings.Replace(rel, string(filepath.Separator), "", -1)] > 0 {
+			b.size += info.Size()
+			return nil
+		}
+		log.Lvl2("removing unreferenced blob", rel)
+		return os.Remove(path)
+	})
+}
+
+// captureDigests returns the digests of the contents of a capture, once per
+// reference
+func captureDigests(c *dpcc.Capture) [][]byte {
+	if c == nil {
+		return nil
+	}
+	digests := [][]byte{c.Digest}
+	for _, r := range c.Resources {
+		digests = append(digests, r.Digest)
+	}
+	return digests
+}
+
+// limitWriter fails once more than n bytes have been written
+type limitWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (l *limitWriter) Write(p []byte) (int, error) {
+	if int64(len(p)) > l.n {
+		return 0, errArchiveFull
+	}
+	l.n -= int64(len(p))
+	return l.w.Write(p)
+}
+
+// fetch streams the resource referenced by URL into a blob of at most max
+// bytes, and returns its description and digest. A content that is already
+// stored is kept once. The blob is referenced once more, until released.
+func (b *blobStore) fetch(URL string, opts *lib.FetchOptions, max int64) (*lib.ResourceInfo, []byte, error) {
+	b.Lock()
+	if free := b.maxStore - b.size; free < max {
+		max = free
+	}
+	b.Unlock()
+
+	tmp, err := ioutil.TempFile(b.dir, "fetch-")
+	if err != nil {
+		return nil, nil, err
+	}
+	// nothing is left to remove once the blob has been renamed
+	defer os.Remove(tmp.Name())
+
+	h := sha256.New()
+	info, err := lib.StreamResource(URL, opts, &limitWriter{w: io.MultiWriter(tmp, h), n: max})
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	digest := h.Sum(nil)
+	key := hex.EncodeToString(digest)
+	b.Lock()
+	defer b.Unlock()
+	if b.refs[key] > 0 {
+		b.refs[key]++
+		return info, digest, nil
+	}
+	// other contents may have been stored meanwhile
+	if b.size+info.Size > b.maxStore {
+		return nil, nil, errArchiveFull
+	}
+	p := b.path(digest)
+	if err := os.MkdirAll(filepath.Dir(p), 0700); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		return nil, nil, err
+	}
+	b.refs[key] = 1
+	b.size += info.Size
+	return info, digest, nil
+}
+
+// release drops a reference to the blobs with the digests, and removes the
+// blobs no capture refers to any more
+func (b *blobStore) release(digests ...[]byte) {
+	b.Lock()
+	defer b.Unlock()
+	for _, d := range digests {
+		key := hex.EncodeToString(d)
+		if b.refs[key] == 0 {
+			continue
+		}
+		if b.refs[key]--; b.refs[key] > 0 {
+			continue
+		}
+		delete(b.refs, key)
+		p := b.path(d)
+		if info, err := os.Stat(p); err == nil {
+			b.size -= info.Size()
+		}
+		if err := os.Remove(p); err != nil {
+			log.Error("couldn't remove blob", key, ":", err)
+		}
+	}
+}
+
+// open opens the blob with the digest and returns its size
+func (b *blobStore) open(digest []byte) (*os.File, int64, error) {
+	if len(digest) != sha256.Size {
+		return nil, 0, errors.New("invalid digest")
+	}
+	f, err := os.Open(b.path(digest))
+	if os.IsNotExist(err) {
+		return nil, 0, errors.New("no blob with digest " + hex.EncodeToString(digest))
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, 0, err
+	}
+	return f, info.Size(), nil
+}
+
+// read returns at most n bytes of the blob with the digest from the offset,
+// and the size of the blob
+func (b *blobStore) read(digest []byte, offset, n int64) ([]byte, int64, error) {
+	f, size, err := b.open(digest)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+	if offset < 0 || offset > size {
+		return nil, 0, errors.New("offset out of the blob")
+	}
+	if size-offset < n {
+		n = size - offset
+	}
+	data := make([]byte, n)
+	if _, err := f.ReadAt(data, offset); err != nil && err != io.EOF {
+		return nil, 0, err
+	}
+	return data, size, nil
+}
+
+// get returns the content of the blob with the digest, which is at most the
+// maximal size of a capture
+func (b *blobStore) get(digest []byte) ([]byte, error) {
+	data, size, err := b.read(digest, 0, b.maxSize)
+	if err == nil && int64(len(data)) != size {
+		err = errors.New("blob larger than a capture")
+	}
+	return data, err
+}
+
+// archiveAlgorithms returns the algorithms of a request to archive, which
+// need SHA-256 to find the agreed content
+func archiveAlgorithms(algorithms []string) []string {
+	for _, a := range algorithms {
+		if a == lib.SHA256 {
+			return algorithms
+		}
+	}
+	return append(append([]string{}, algorithms...), lib.SHA256)
+}
+
+// capture keeps the content of the resource of a run if the conodes agreed on
+// its SHA-256 hash, and the content of its subresources if requested. The
+// leader fetches the resource again, so the capture fails if the resource
+// changed meanwhile.
+func (s *Service) capture(req *dpcc.HashPublicRequest, run *dpcc.Run) (*dpcc.Capture, error) {
+	g := dpcc.AgreedGroup(run, len(req.Roster.List)-1, lib.SHA256)
+	if g == nil {
+		return nil, errors.New("the conodes didn't agree on the content")
+	}
+	info, digest, err := s.blobs.fetch(req.URL, req.Options, s.blobs.maxSize)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(digest, g.Digest) {
+		s.blobs.release(digest)
+		return nil, errors.New("the resource changed since the conodes hashed it")
+	}
+	c := &dpcc.Capture{
+		Digest:      digest,
+		ContentType: info.ContentType,
+		Size:        info.Size,
+		Status:      info.Status,
+		Header:      encodeHeader(info.Header),
+	}
+	if !req.Subresources || !strings.Contains(info.ContentType, "html") {
+		return c, nil
+	}
+
+	page, err := s.blobs.get(digest)
+	if err != nil {
+		s.blobs.release(digest)
+		return nil, err
+	}
+	// the subresources share the size left to the capture
+	left := s.blobs.maxSize - info.Size
+	seen := map[string]bool{req.URL: true}
+	for _, l := range lib.SubresourceLinks(req.URL, page) {
+		if seen[l] || len(c.Resources) >= maxSubresources || left <= 0 {
+			continue
+		}
+		seen[l] = true
+		info, digest, err := s.blobs.fetch(l, req.Options, left)
+		if err != nil {
+			log.Lvl2(s.ServerIdentity(), "couldn't capture", l, ":", err)
+			continue
+		}
+		left -= info.Size
+		c.Resources = append(c.Resources, &dpcc.CaptureResource{
+			URL:         l,
+			ContentType: info.ContentType,
+			Size:        info.Size,
+			Status:      info.Status,
+			Header:      encodeHeader(info.Header),
+			Digest:      digest,
+		})
+	}
+	return c, nil
+}
+
+// encodeHeader returns the fields of an HTTP header as they are sent
+func encodeHeader(h http.Header) []byte {
+	var buf bytes.Buffer
+	h.Write(&buf)
+	return buf.Bytes()
+}
+
+// Blob sends a chunk of an archived content back to the client, from the
+// requested offset
+func (s *Service) Blob(req *dpcc.BlobRequest) (*dpcc.BlobResponse, error) {
+	if s.blobs == nil {
+		return nil, errors.New("the conode doesn't archive contents")
+	}
+	data, size, err := s.blobs.read(req.Digest, req.Offset, blobChunkSize)
+	if err != nil {
+		return nil, err
+	}
+	return &dpcc.BlobResponse{Data: data, Size: size}, nil
+}
+
+// WARC sends a page of archived runs led by the conode back to the client as
+// a WARC file, either the run with the ID or the archived runs of the URL
+// following the run of the request
+func (s *Service) WARC(req *dpcc.WARCRequest) (*dpcc.WARCResponse, error) {
+	if s.blobs == nil {
+		return nil, errors.New("the conode doesn't archive contents")
+	}
+	var runs []*dpcc.Run
+	s.storage.Lock()
+	if req.ID != "" {
+		if run, ok := s.storage.Runs[req.ID]; ok && run.Capture != nil {
+			c := *run
+			runs = append(runs, &c)
+		}
+	} else if index, ok := s.storage.URLs[req.URL]; ok {
+		for _, id := range index.IDs {
+			if run, ok := s.storage.Runs[id]; ok && run.Capture != nil {
+				c := *run
+				runs = append(runs, &c)
+			}
+		}
+	}
+	s.storage.Unlock()
+	if len(runs) == 0 {
+		return nil, errors.New("no archived run")
+	}
+
+	// the runs are pruned oldest first, so the page starts with the
+	// first run if the last one sent has been pruned meanwhile
+	if req.After != "" {
+		for i, run := range runs {
+			if run.ID == req.After {
+				runs = runs[i+1:]
+				break
+			}
+		}
+	}
+	var buf bytes.Buffer
+	if err := dpcc.WriteWARCInfo(&buf); err != nil {
+		return nil, err
+	}
+	resp := &dpcc.WARCResponse{}
+	for i, run := range runs {
+		if err := dpcc.WriteWARCRun(&buf, run, s.blobs.get); err != nil {
+			return nil, err
+		}
+		if buf.Len() >= warcPageSize && i+1 < len(runs) {
+			resp.Next = run.ID
+			break
+		}
+	}
+	resp.Data = buf.Bytes()
+	return resp, nil
+}
@@ -0,0 +1,118 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
+	"github.com/si-co/dpcc/protocol"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// diffReport asks a representative of every group of conodes that computed
+// the same hash for its canonicalized content and computes the diffs between
+// the versions. The other conodes of the groups drop the content they kept.
+// It returns nil if all the conodes agree.
+func (s *Service) diffReport(req *dpcc.HashPublicRequest, algorithm string, verdict *dpcc.Verdict) (*dpcc.DiffReport, error) {
+	// representatives of the groups, the largest group first, with the
+	// digest of their group
+	representatives := make([]string, 0)
+	nodes := make([]string, 0)
+	digests := make(map[string][]byte)
+	for _, g := range verdict.Groups {
+		if g.Algorithm == algorithm {
+			representatives = append(representatives, g.Nodes[0])
+			nodes = append(nodes, g.Nodes...)
+			digests[g.Nodes[0]] = g.Digest
+		}
+	}
+	if len(representatives) < 2 {
+		return nil, nil
+	}
+
+	// the tree has the leader as root and the conodes of the groups as
+	// leaves
+	list := []*network.ServerIdentity{s.ServerIdentity()}
+	for _, pk := range nodes {
+		si := findServerIdentity(req.Roster, pk)
+		if si == nil {
+			return nil, errors.New("conode not in roster: " + pk)
+		}
+		list = append(list, si)
+	}
+	tree := onet.NewRoster(list).GenerateNaryTree(len(list))
+	if tree == nil {
+		return nil, errors.New("error while creating the tree for the content protocol")
+	}
+
+	instance, err := s.CreateProtocol(protocol.NameHashContent, tree)
+	if err != nil {
+		return nil, err
+	}
+	p := instance.(*protocol.HashContent)
+	p.URL = req.URL
+	p.Nonce = req.Nonce
+	p.Algorithm = algorithm
+	p.Representatives = representatives
+	if err = p.Start(); err != nil {
+		return nil, err
+	}
+
+	select {
+	case <-p.Finished:
+	case <-time.After(protocolTimeout(req.Options)):
+		return nil, errors.New("timeout in hash content protocol")
+	}
+
+	report := &dpcc.DiffReport{Algorithm: algorithm}
+	for _, pk := range representatives {
+		r, ok := p.Responses[pk]
+		if !ok || r.Digest == nil {
+			return nil, errors.New("missing content of representative " + pk)
+		}
+		// the version must be the one of the verdict
+		if r.Digest == nil || r.Digest.Algorithm != algorithm ||
+			!bytes.Equal(r.Digest.Value, digests[pk]) {
+			return nil, errors.New("content of representative " + pk + " doesn't match the digest of its group")
+		}
+		report.Versions = append(report.Versions, &dpcc.ContentVersion{
+			Node:      pk,
+			PublicKey: r.PublicKey,
+			Digest:    r.Digest,
+			Format:    r.Format,
+			Content:   r.Content,
+			Timestamp: r.Timestamp,
+			Signature: r.Signature,
+		})
+	}
+
+	ref := report.Versions[0]
+	for _, v := range report.Versions[1:] {
+		report.Diffs = append(report.Diffs, &dpcc.ContentDiff{
+			NodeA: ref.Node,
+			NodeB: v.Node,
+			Edits: lib.Diff(splitLines(ref.Content), splitLines(v.Content)),
+		})
+	}
+
+	return report, nil
+}
+
+// findServerIdentity returns the server identity of the roster with the
+// given service public key
+func findServerIdentity(r *onet.Roster, pk string) *network.ServerIdentity {
+	for _, si := range r.List {
+		if lib.ServicePublic(si).String() == pk {
+			return si
+		}
+	}
+	return nil
+}
+
+func splitLines(content []byte) []string {
+	return strings.Split(string(content), "\n")
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
+	"github.com/si-co/dpcc/protocol"
+
+	"go.dedis.ch/kyber/v3"
+	dkg "go.dedis.ch/kyber/v3/share/dkg/pedersen"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// distKey is the share of a distributed key of a roster held by a conode
+type distKey struct {
+	Public    kyber.Point
+	Threshold int
+	// public keys of the conodes in the order of the indexes of the shares
+	Publics []kyber.Point
+	Share   *dkg.DistKeyShare
+}
+
+// DKG receives a request for the distributed key of a roster from the
+// client, and executes the DKG protocol if the roster has no key yet. If the
+// threshold changed or if a refresh is asked, the conodes deal new shares of
+// the key, on request of their operators only.
+func (s *Service) DKG(req *dpcc.DKGRequest) (*dpcc.DKGResponse, error) {
+	key := s.distKey(req.Roster)
+	if key != nil && !req.Refresh &&
+		(req.Threshold == 0 || req.Threshold == key.Threshold) {
+		return &dpcc.DKGResponse{Public: key.Public, Threshold: key.Threshold}, nil
+	}
+	if err := s.authorizeDKG(req.Roster, req.Threshold, key != nil, req.Operators); err != nil {
+		return nil, err
+	}
+
+	// generate the tree
+	root := req.Roster.NewRosterWithRoot(s.ServerIdentity())
+	if root == nil {
+		return nil, errors.New("conode not in the roster")
+	}
+	tree := root.GenerateNaryTree(len(req.Roster.List))
+	if tree == nil {
+		return nil, errors.New("error while creating the tree for the requested protocol")
+	}
+
+	// create the protocol, configured with the share of the current key by
+	// NewProtocol
+	instance, err := s.CreateProtocol(protocol.NameDKG, tree)
+	if err != nil {
+		return nil, err
+	}
+	protocol := instance.(*protocol.DKG)
+
+	// configure protocol
+	protocol.Threshold = req.Threshold
+	protocol.Operators = req.Operators
+
+	// run protocol
+	if err = protocol.Start(); err != nil {
+		return nil, err
+	}
+
+	// wait protocol to finish or trigger timeout error
+	select {
+	case <-protocol.Finished:
+		key := s.storeDistKey(tree.Roster, treePublics(tree.List()),
+			protocol.Threshold, protocol.Share)
+		return &dpcc.DKGResponse{Public: key.Public, Threshold: key.Threshold}, nil
+	case <-time.After(dkgTimeout):
+		return nil, errors.New("timeout in DKG protocol")
+	}
+}
+
+// newDKGProtocol instantiates the DKG protocol with the share of the current
+// key of the roster, if it has one, so that the conodes store their share of
+// the distributed key
+func (s *Service) newDKGProtocol(node *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	instance, err := protocol.NewDKGProtocol(node)
+	if err != nil {
+		return nil, err
+	}
+	p := instance.(*protocol.DKG)
+	if key := s.distKey(node.Roster()); key != nil {
+		p.Previous = key.Share
+		p.PreviousPublics = key.Publics
+	}
+	p.Authorize = func(a *protocol.DKGAnnouncement) error {
+		return s.authorizeDKG(node.Roster(), a.Threshold, a.Public != nil, a.Operators)
+	}
+	p.OnShare = func(share *dkg.DistKeyShare) {
+		s.storeDistKey(node.Roster(), treePublics(node.List()), p.Threshold, share)
+	}
+	return p, nil
+}
+
+// authorizeDKG checks that the conode may take part in a generation of the
+// distributed key of the roster. A conode that already holds a key for the
+// roster refuses to generate a new one, and only deals new shares of it on
+// request of its own operator, so that a leader can neither replace the key
+// nor lower its threshold.
+func (s *Service) authorizeDKG(r *onet.Roster, threshold int, reshare bool, operators []*lib.OperatorRequest) error {
+	if s.distKey(r) == nil {
+		return nil
+	}
+	if !reshare {
+		return errors.New("the conode already holds a distributed key for the roster")
+	}
+	payload, err := dpcc.DKGPayload(r, threshold)
+	if err != nil {
+		return err
+	}
+	pk := lib.ServicePublic(s.ServerIdentity())
+	err = errors.New("missing operator signature")
+	for _, o := range operators {
+		if o == nil || o.Conode == nil || !o.Conode.Equal(pk) {
+			continue
+		}
+		if err = s.authorize(dpcc.ActionRefreshDKG, payload, o); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// distKey returns the share of the distributed key of the roster, nil if
+// the roster has none
+func (s *Service) distKey(r *onet.Roster) *distKey {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	return s.storage.DistKeys[rosterKey(r)]
+}
+
+// nodeDistKey returns the share of the distributed key of the roster of the
+// tree of a protocol
+func (s *Service) nodeDistKey(node *onet.TreeNodeInstance) (*distKey, error) {
+	key := s.distKey(node.Roster())
+	if key == nil {
+		return nil, errors.New("no distributed key for the roster")
+	}
+	return key, nil
+}
+
+// storeDistKey saves the share of the distributed key of the roster,
+// replacing the previous one
+func (s *Service) storeDistKey(r *onet.Roster, publics []kyber.Point, threshold int, share *dkg.DistKeyShare) *distKey {
+	key := &distKey{
+		Public:    share.Public(),
+		Threshold: threshold,
+		Publics:   publics,
+		Share:     share,
+	}
+	s.storage.Lock()
+	if s.storage.DistKeys == nil {
+		s.storage.DistKeys = make(map[string]*distKey)
+	}
+	s.storage.DistKeys[rosterKey(r)] = key
+	s.storage.Unlock()
+	s.save()
+	log.Lvl3(s.ServerIdentity(), "stored distributed key", key.Public)
+	return key
+}
+
+// treePublics returns the public keys of the nodes of the tree, in the order
+// of the indexes of their shares
+func treePublics(nodes []*onet.TreeNode) []kyber.Point {
+	publics := make([]kyber.Point, len(nodes))
+	for i, n := range nodes {
+		publics[i] = lib.ServicePublic(n.ServerIdentity)
+	}
+	return publics
+}
+
+// rosterKey identifies a roster by the service keys of its conodes, whatever
+// their order and the root of the tree, so that a change in the roster or a
+// rotation of service keys leads to a new distributed key
+func rosterKey(r *onet.Roster) string {
+	keys := make([]string, len(r.List))
+	for i, si := range r.List {
+		keys[i] = lib.ServicePublic(si).String()
+	}
+	sort.Strings(keys)
+	h := sha256.New()
+	for _, k := range keys {
+		h.Write([]byte(k))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"errors"
+	"time"
+
+	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/protocol"
+
+	"go.dedis.ch/onet/v3"
+)
+
+// HashEquality receives a request of private equality test from the client,
+// executes the corresponding protocol with the distributed key of the
+// roster, generated first if needed, and sends only the agreement between
+// the conodes back to the client
+func (s *Service) HashEquality(req *dpcc.HashEqualityRequest) (*dpcc.HashEqualityResponse, error) {
+	if s.distKey(req.Roster) == nil {
+		if _, err := s.DKG(&dpcc.DKGRequest{Roster: req.Roster}); err != nil {
+			return nil, err
+		}
+	}
+
+	// generate the tree
+	root := req.Roster.NewRosterWithRoot(s.ServerIdentity())
+	if root == nil {
+		return nil, errors.New("conode not in the roster")
+	}
+	tree := root.GenerateNaryTree(len(req.Roster.List))
+	if tree == nil {
+		return nil, errors.New("error while creating the tree for the requested protocol")
+	}
+
+	// create the protocol, configured with the distributed key by
+	// NewProtocol
+	instance, err := s.CreateProtocol(protocol.NameHashEquality, tree)
+	if err != nil {
+		return nil, err
+	}
+	protocol := instance.(*protocol.HashEquality)
+
+	// configure protocol
+	protocol.URL = req.URL
+	protocol.Nonce = req.Nonce
+	protocol.Algorithm = req.Algorithm
+	protocol.Options = req.Options
+
+	// run protocol
+	if err = protocol.Start(); err != nil {
+		return nil, err
+	}
+
+	// wait protocol to finish or trigger timeout error
+	select {
+	case <-protocol.Finished:
+		resp := &dpcc.HashEqualityResponse{
+			Nodes:         len(tree.List()) - 1,
+			Commits:       protocol.Commits,
+			KeySignatures: protocol.KeySignatures,
+			Inputs:        protocol.Inputs,
+			Blindings:     protocol.Blindings,
+			Partials:      protocol.Partials,
+		}
+		for _, g := range protocol.Groups {
+			resp.Groups = append(resp.Groups, &dpcc.EqualityGroup{Nodes: g})
+		}
+		return resp, nil
+	case <-time.After(protocolTimeout(req.Options)):
+		return nil, errors.New("timeout in hash equality protocol")
+	}
+}
+
+// newHashEqualityProtocol instantiates the private equality test with the
+// share of the distributed key of the roster held by the conode
+func (s *Service) newHashEqualityProtocol(node *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	key, err := s.nodeDistKey(node)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := protocol.NewHashEqualityProtocol(node)
+	if err != nil {
+		return nil, err
+	}
+	p := instance.(*protocol.HashEquality)
+	p.DistKey = key.Public
+	p.Share = key.Share.Share
+	p.Threshold = key.Threshold
+	p.NbrShares = len(key.Publics)
+	p.Commits = key.Share.Commits
+	return p, nil
+}
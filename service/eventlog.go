@@ -0,0 +1,176 @@
+package service
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+
+	"github.com/si-co/dpcc"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/eventlog"
+	"go.dedis.ch/cothority/v3/skipchain"
+	"go.dedis.ch/onet/v3/app"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// environment variables setting the tamper-evident log to which the conode
+// appends the agreed results of the runs it leads. Either all of them or none
+// must be set.
+const (
+	// envEventlogGroup is the group file of the conodes of the ByzCoin
+	// ledger holding the eventlog
+	envEventlogGroup = "DPCC_EVENTLOG_GROUP"
+	// envEventlogLedger is the hex encoded ID of the ledger
+	envEventlogLedger = "DPCC_EVENTLOG_LEDGER"
+	// envEventlogInstance is the hex encoded ID of the eventlog instance.
+	// Its darc must let the service key of the conode invoke
+	// eventlog.log.
+	envEventlogInstance = "DPCC_EVENTLOG_INSTANCE"
+)
+
+// logQueueSize bounds the number of runs waiting to be logged. The runs are
+// not logged when the ledger cannot keep up.
+const logQueueSize = 100
+
+// logJob is a run waiting to be logged
+type logJob struct {
+	run       *dpcc.Run
+	algorithm string
+}
+
+// eventLog appends events to an eventlog instance of a ledger
+type eventLog struct {
+	client *eventlog.Client
+	// runs waiting to be logged. The counters of the signer are updated
+	// by every transaction, so the events are logged one at a time.
+	queue chan logJob
+}
+
+// newEventLogClient returns the eventlog of the instance of the ledger, to
+// which the conode appends with its service key
+func newEventLogClient(ol *byzcoin.Client, instance byzcoin.InstanceID, si *network.ServerIdentity) *eventLog {
+	client := eventlog.NewClient(ol)
+	client.Instance = instance
+	client.Signers = []darc.Signer{darc.NewSignerEd25519(
+		si.ServicePublic(dpcc.ServiceName), si.ServicePrivate(dpcc.ServiceName))}
+	return &eventLog{client: client, queue: make(chan logJob, logQueueSize)}
+}
+
+// newEventLog reads the eventlog of the conode from the environment. It
+// returns nil if no eventlog has been set.
+func newEventLog(si *network.ServerIdentity) (*eventLog, error) {
+	group := os.Getenv(envEventlogGroup)
+	ledger := os.Getenv(envEventlogLedger)
+	instance := os.Getenv(envEventlogInstance)
+	if group == "" && ledger == "" && instance == "" {
+		return nil, nil
+	}
+	if group == "" || ledger == "" || instance == "" {
+		return nil, errors.New("the eventlog needs " + envEventlogGroup + ", " +
+			envEventlogLedger + " and " + envEventlogInstance)
+	}
+
+	f, err := os.Open(group)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	g, err := app.ReadGroupDescToml(f)
+	if err != nil {
+		return nil, errors.New("invalid " + envEventlogGroup + ": " + err.Error())
+	}
+	id, err := hex.DecodeString(ledger)
+	if err != nil {
+		return nil, errors.New("invalid " + envEventlogLedger + ": " + ledger)
+	}
+	iid, err := hex.DecodeString(instance)
+	if err != nil || len(iid) != len(byzcoin.InstanceID{}) {
+		return nil, errors.New("invalid " + envEventlogInstance + ": " + instance)
+	}
+
+	return newEventLogClient(byzcoin.NewClient(skipchain.SkipBlockID(id), *g.Roster),
+		byzcoin.NewInstanceID(iid), si), nil
+}
+
+// queueLog queues the run to be logged in the background, unless the queue
+// is full
+func (s *Service) queueLog(run *dpcc.Run, algorithm string) {
+	if s.eventLog == nil {
+		return
+	}
+	select {
+	case s.eventLog.queue <- logJob{run: run, algorithm: algorithm}:
+	default:
+		log.Warn(s.ServerIdentity(), "too many runs waiting to be logged, run", run.ID, "not logged")
+	}
+}
+
+// logRuns logs the queued runs one at a time
+func (s *Service) logRuns() {
+	for j := range s.eventLog.queue {
+		s.logRun(j.run, j.algorithm)
+	}
+}
+
+// logRun appends the result of a run to the eventlog if the conodes agreed on
+// a digest computed with the algorithm, and records the key of its event in
+// the run. Logging waits for the event to be in a block, so it is done by
+// logRuns in the background.
+func (s *Service) logRun(run *dpcc.Run, algorithm string) {
+	if s.eventLog == nil {
+		return
+	}
+	entry := dpcc.NewLogEntry(run, run.Nodes, algorithm)
+	if entry == nil {
+		return
+	}
+	content, err := entry.Encode()
+	if err != nil {
+		log.Error("couldn't encode log entry:", err)
+		return
+	}
+
+	keys, err := s.eventLog.client.Log(eventlog.NewEvent(dpcc.LogTopic, content))
+	if err != nil {
+		log.Error("couldn't log run", run.ID, ":", err)
+		return
+	}
+
+	s.storage.Lock()
+	// the run may have been pruned from the history meanwhile
+	if r, ok := s.storage.Runs[run.ID]; ok {
+		r.LogID = keys[0]
+	}
+	s.storage.Unlock()
+	s.save()
+	log.Lvl3(s.ServerIdentity(), "logged run", run.ID, "of", run.URL)
+}
+
+// LogProof sends a run led by the conode back to the client, with the proof
+// that its event is in the ledger
+func (s *Service) LogProof(req *dpcc.LogProofRequest) (*dpcc.LogProofResponse, error) {
+	if s.eventLog == nil {
+		return nil, errors.New("the conode doesn't log its runs")
+	}
+	s.storage.Lock()
+	run, ok := s.storage.Runs[req.ID]
+	var c dpcc.Run
+	if ok {
+		c = *run
+	}
+	s.storage.Unlock()
+	if !ok {
+		return nil, errors.New("no run with ID " + req.ID)
+	}
+	if c.LogID == nil {
+		return nil, errors.New("run " + req.ID + " has not been logged")
+	}
+
+	reply, err := s.eventLog.client.ByzCoin.GetProof(c.LogID)
+	if err != nil {
+		return nil, err
+	}
+	return &dpcc.LogProofResponse{Run: &c, Proof: &reply.Proof}, nil
+}
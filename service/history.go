@@ -0,0 +1,242 @@
+package service
+
+import (
+	"encoding/hex"
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
+	"github.com/si-co/dpcc/protocol"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// environment variables setting the retention of the history of the runs
+const (
+	// envHistoryMaxAge is the duration after which a run is removed from
+	// the history, such as "720h", 0 to keep the runs forever
+	envHistoryMaxAge = "DPCC_HISTORY_MAX_AGE"
+	// envHistoryMaxRuns is the maximal number of runs in the history, the
+	// oldest runs being removed first
+	envHistoryMaxRuns = "DPCC_HISTORY_MAX_RUNS"
+)
+
+// defaultHistoryMaxRuns bounds the history when no size has been set
+const defaultHistoryMaxRuns = 10000
+
+// runIndex lists the IDs of runs in chronological order
+type runIndex struct {
+	IDs []string
+}
+
+// historyRetention reads the retention of the history from the environment
+func historyRetention() (time.Duration, int, error) {
+	maxAge := time.Duration(0)
+	if v := os.Getenv(envHistoryMaxAge); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d < 0 {
+			return 0, 0, errors.New("invalid " + envHistoryMaxAge + ": " + v)
+		}
+		maxAge = d
+	}
+	maxRuns := defaultHistoryMaxRuns
+	if v := os.Getenv(envHistoryMaxRuns); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return 0, 0, errors.New("invalid " + envHistoryMaxRuns + ": " + v)
+		}
+		maxRuns = n
+	}
+	return maxAge, maxRuns, nil
+}
+
+// recordRun adds a run led by the conode to its history and its transparency
+// log, removes the runs that are too old or exceed the size of the history,
+// and saves it
+func (s *Service) recordRun(run *dpcc.Run) {
+	run.ID = hex.EncodeToString(lib.GenNonce()[:16])
+	run.Time = time.Now().Unix()
+
+	s.storage.Lock()
+	if s.storage.Runs == nil {
+		s.storage.Runs = make(map[string]*dpcc.Run)
+	}
+	if s.storage.URLs == nil {
+		s.storage.URLs = make(map[string]*runIndex)
+	}
+	s.storage.Runs[run.ID] = run
+	s.storage.Timeline = append(s.storage.Timeline, run.ID)
+	index, ok := s.storage.URLs[run.URL]
+	if !ok {
+		index = &runIndex{}
+		s.storage.URLs[run.URL] = index
+	}
+	index.IDs = append(index.IDs, run.ID)
+	s.appendLeaf(run)
+	s.pruneHistory(run.Time)
+	s.storage.Unlock()
+
+	s.save()
+	log.Lvl3(s.ServerIdentity(), "recorded run", run.ID, "of", run.URL)
+}
+
+// pruneHistory removes the oldest runs, as long as they are older than the
+// maximal age or the history is larger than its maximal size, with the
+// contents only they captured. The storage must be locked.
+func (s *Service) pruneHistory(now int64) {
+	for len(s.storage.Timeline) > 0 {
+		run := s.storage.Runs[s.storage.Timeline[0]]
+		tooOld := s.historyMaxAge > 0 && run != nil &&
+			now-run.Time > int64(s.historyMaxAge/time.Second)
+		if run != nil && !tooOld && len(s.storage.Timeline) <= s.historyMaxRuns {
+			return
+		}
+		s.storage.Timeline = s.storage.Timeline[1:]
+		if run == nil {
+			continue
+		}
+		delete(s.storage.Runs, run.ID)
+		if s.blobs != nil {
+			s.blobs.release(captureDigests(run.Capture)...)
+		}
+		// the runs of an URL are in chronological order as well
+		if index, ok := s.storage.URLs[run.URL]; ok {
+			if len(index.IDs) > 0 && index.IDs[0] == run.ID {
+				index.IDs = index.IDs[1:]
+			}
+			if len(index.IDs) == 0 {
+				delete(s.storage.URLs, run.URL)
+			}
+		}
+	}
+}
+
+// History lists the runs for an URL led by the conode, in chronological order
+func (s *Service) History(req *dpcc.HistoryRequest) (*dpcc.HistoryResponse, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+
+	resp := &dpcc.HistoryResponse{}
+	index, ok := s.storage.URLs[req.URL]
+	if !ok {
+		return resp, nil
+	}
+	for _, id := range index.IDs {
+		run, ok := s.storage.Runs[id]
+		if !ok || (req.Since != 0 && run.Time < req.Since) ||
+			(req.Until != 0 && run.Time > req.Until) {
+			continue
+		}
+		resp.Runs = append(resp.Runs, &dpcc.RunSummary{
+			ID:    run.ID,
+			URL:   run.URL,
+			Time:  run.Time,
+			Mode:  run.Mode,
+			Nodes: runNodes(run),
+		})
+	}
+	if req.Limit > 0 && len(resp.Runs) > req.Limit {
+		resp.Runs = resp.Runs[len(resp.Runs)-req.Limit:]
+	}
+	return resp, nil
+}
+
+// HistoryRun sends a run led by the conode back to the client
+func (s *Service) HistoryRun(req *dpcc.HistoryRunRequest) (*dpcc.HistoryRunResponse, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	run, ok := s.storage.Runs[req.ID]
+	if !ok {
+		return nil, errors.New("no run with ID " + req.ID)
+	}
+	return &dpcc.HistoryRunResponse{Run: run}, nil
+}
+
+// AgreedHash looks for the last hash public run for the URL, signed before
+// the requested time, in which the conodes agreed on the digest
+func (s *Service) AgreedHash(req *dpcc.AgreedHashRequest) (*dpcc.AgreedHashResponse, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+
+	if index, ok := s.storage.URLs[req.URL]; ok {
+		for i := len(index.IDs) - 1; i >= 0; i-- {
+			run, ok := s.storage.Runs[index.IDs[i]]
+			if !ok {
+				continue
+			}
+			g := dpcc.AgreedGroup(run, run.Nodes, req.Algorithm)
+			if g != nil && (req.Time == 0 || dpcc.AgreedTime(run, g) <= req.Time) {
+				return &dpcc.AgreedHashResponse{Run: run, Group: g}, nil
+			}
+		}
+	}
+	return nil, errors.New("no agreed hash for " + req.URL)
+}
+
+// runNodes returns the number of conodes that took part in a run
+func runNodes(run *dpcc.Run) int {
+	if run.Mode == protocol.NameDOMConsensus {
+		return len(run.Signers)
+	}
+	return len(run.Responses)
+}
+
+// publicRun returns the run of a hash public protocol
+func publicRun(req *dpcc.HashPublicRequest, resp *dpcc.HashPublicResponse) *dpcc.Run {
+	run := &dpcc.Run{
+		URL:       req.URL,
+		Mode:      protocol.NameHashPublic,
+		Nodes:     len(req.Roster.List) - 1,
+		Nonce:     req.Nonce,
+		Responses: make(map[string]*dpcc.RunResponse),
+		Verdict:   resp.Verdict,
+	}
+	for pk, r := range resp.Responses {
+		run.Responses[pk] = &dpcc.RunResponse{
+			PublicKey: r.PubliKey,
+			Digests:   r.Digests,
+			Timestamp: r.Timestamp,
+			Signature: r.Signature,
+		}
+	}
+	return run
+}
+
+// privateRun returns the run of a hash private protocol
+func privateRun(req *dpcc.HashPrivateRequest, resp *dpcc.HashPrivateResponse) *dpcc.Run {
+	run := &dpcc.Run{
+		URL:       req.URL,
+		Mode:      protocol.NameHashPrivate,
+		Nodes:     len(req.Roster.List) - 1,
+		Nonce:     req.Nonce,
+		Responses: make(map[string]*dpcc.RunResponse),
+		Flags:     lib.StatementEncrypted,
+	}
+	for pk, r := range resp.Responses {
+		run.Responses[pk] = &dpcc.RunResponse{
+			PublicKey:     r.PublicKey,
+			EncryptedHash: r.EncryptedHash,
+			Encapsulation: r.Encapsulation,
+			Timestamp:     r.Timestamp,
+			Signature:     r.Signature,
+		}
+	}
+	return run
+}
+
+// consensusRun returns the run of a DOM consensus protocol
+func consensusRun(req *dpcc.DOMConsensusRequest, resp *dpcc.DOMConsensusResponse) *dpcc.Run {
+	return &dpcc.Run{
+		URL:       req.URL,
+		Mode:      protocol.NameDOMConsensus,
+		Nodes:     len(req.Roster.List) - 1,
+		Nonce:     req.Nonce,
+		Document:  resp.Document,
+		Signers:   resp.Signers,
+		Flags:     req.Mode.Flags(),
+		Timestamp: resp.Timestamp,
+		Signature: resp.Signature,
+	}
+}
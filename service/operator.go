@@ -0,0 +1,81 @@
+package service
+
+import (
+	"errors"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/util/encoding"
+)
+
+// envOperatorKey is the hex encoded public key of the operator of the
+// conode, who alone can refresh the distributed keys and manage the watches.
+// These actions are disabled if it is not set.
+const envOperatorKey = "DPCC_OPERATOR_KEY"
+
+// operatorKey reads the public key of the operator from the environment, nil
+// if not set
+func operatorKey() (kyber.Point, error) {
+	v := os.Getenv(envOperatorKey)
+	if v == "" {
+		return nil, nil
+	}
+	p, err := encoding.StringHexToPoint(cothority.Suite, v)
+	if err != nil {
+		return nil, errors.New("invalid " + envOperatorKey + ": " + v)
+	}
+	return p, nil
+}
+
+// authorize checks that the request for the action has been signed by the
+// operator of the conode and has not been used before
+func (s *Service) authorize(action string, payload []byte, o *lib.OperatorRequest) error {
+	if s.operator == nil {
+		return errors.New("operator requests are disabled on this conode")
+	}
+	_, err := s.authorizeKeys([]kyber.Point{s.operator}, action, payload, o)
+	return err
+}
+
+// authorizeKeys checks that the request for the action has been signed by
+// one of the keys and has not been used before, and returns the key
+func (s *Service) authorizeKeys(keys []kyber.Point, action string, payload []byte, o *lib.OperatorRequest) (kyber.Point, error) {
+	var signer kyber.Point
+	err := errors.New("no key allowed")
+	for _, k := range keys {
+		if k == nil {
+			continue
+		}
+		if err = lib.VerifyOperatorRequest(k, lib.ServicePublic(s.ServerIdentity()),
+			action, payload, o); err == nil {
+			signer = k
+			break
+		}
+	}
+	if signer == nil {
+		return nil, errors.New("unauthorized request: " + err.Error())
+	}
+
+	// the requests are remembered until they expire
+	s.operatorLock.Lock()
+	defer s.operatorLock.Unlock()
+	now := time.Now()
+	for k, expires := range s.operatorRequests {
+		if now.After(expires) {
+			delete(s.operatorRequests, k)
+		}
+	}
+	key := action + "/" + strconv.FormatInt(o.Timestamp, 10) + "/" + string(payload)
+	if _, ok := s.operatorRequests[key]; ok {
+		return nil, errors.New("operator request already used")
+	}
+	if s.operatorRequests == nil {
+		s.operatorRequests = make(map[string]time.Time)
+	}
+	s.operatorRequests[key] = time.Unix(o.Timestamp, 0).Add(lib.OperatorRequestValidity)
+	return signer, nil
+}
@@ -2,12 +2,17 @@ package service
 
 import (
 	"errors"
+	"os"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
 	"github.com/si-co/dpcc/protocol"
 
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/log"
 	"go.dedis.ch/onet/v3/network"
@@ -16,11 +21,35 @@ import (
 // used for tests
 var templateID onet.ServiceID
 
+// defaultTimeout is the time the service waits for a protocol to finish,
+// besides the time the client allows the conodes to download the resource
+const defaultTimeout = time.Second * 5
+
+// dkgTimeout is the time the service waits for the generation of a
+// distributed key, in which every conode exchanges messages with all the
+// others
+const dkgTimeout = time.Minute
+
+// environment variables setting the limits of the conode on the downloads,
+// which apply whatever the options requested by the clients, see
+// lib.DefaultFetchLimits for the default ones
+const (
+	// envFetchMaxSize is the maximal size in bytes of a resource
+	envFetchMaxSize = "DPCC_FETCH_MAX_SIZE"
+	// envFetchProgressTimeout is the maximal time without receiving data,
+	// such as "30s"
+	envFetchProgressTimeout = "DPCC_FETCH_PROGRESS_TIMEOUT"
+	// envFetchTimeout is the maximal time of a download, such as "10m"
+	envFetchTimeout = "DPCC_FETCH_TIMEOUT"
+)
+
 func init() {
 	var err error
-	templateID, err = onet.RegisterNewService(dpcc.ServiceName, newService)
+	// the service has its own key pair, generated by `conode setup`, so
+	// that its keys can be rotated without changing the conode identity
+	templateID, err = onet.RegisterNewServiceWithSuite(dpcc.ServiceName, cothority.Suite, newService)
 	log.ErrFatal(err)
-	network.RegisterMessages(&storage{})
+	network.RegisterMessages(&storage{}, &runIndex{})
 }
 
 // Service is our template-service
@@ -29,6 +58,26 @@ type Service struct {
 
 	// storage of the service
 	storage *storage
+	// retention of the history of the runs, see historyRetention
+	historyMaxAge  time.Duration
+	historyMaxRuns int
+	// transparency log of the runs led by the conode, saved apart from
+	// the storage
+	tlog *transparencyLog
+	// tamper-evident log of the agreed results, nil if not set
+	eventLog *eventLog
+	// contents of the archived runs, nil if archiving is disabled
+	blobs *blobStore
+	// starts the goroutine running the watches
+	watchOnce sync.Once
+	// clients streaming the events of the watches
+	subscribers     map[*subscriber]bool
+	subscribersLock sync.Mutex
+	// public key of the operator, nil if the operator requests are
+	// disabled, and the requests already used
+	operator         kyber.Point
+	operatorRequests map[string]time.Time
+	operatorLock     sync.Mutex
 }
 
 // storageID reflects the data we're storing
@@ -36,34 +85,72 @@ var storageID = []byte("main")
 
 // storage is used to save our data
 type storage struct {
+	// shares of the distributed keys, indexed by roster
+	DistKeys map[string]*distKey
+	// runs led by the conode, indexed by ID
+	Runs map[string]*dpcc.Run
+	// IDs of all the runs, in chronological order
+	Timeline []string
+	// IDs of the runs of every URL, in chronological order
+	URLs map[string]*runIndex
+	// URLs watched by the conode, indexed by ID
+	Watches map[string]*dpcc.Watch
+	// events of the watches, in chronological order
+	Events []*dpcc.WatchEvent
+	// leaf hashes of the transparency log saved by older versions, moved
+	// to the transparency log when loaded
+	Leaves [][]byte
+
 	sync.Mutex
 }
 
 // HashPublic receives a request of hash public protocol from the client,
 // executes the correct protocol and sends the response back to the client
 func (s *Service) HashPublic(req *dpcc.HashPublicRequest) (*dpcc.HashPublicResponse, error) {
+	resp, _, err := s.hashPublic(req)
+	return resp, err
+}
+
+// hashPublic executes the hash public protocol and records the run in the
+// history of the conode
+func (s *Service) hashPublic(req *dpcc.HashPublicRequest) (*dpcc.HashPublicResponse, *dpcc.Run, error) {
+	algorithms := req.Algorithms
+	if req.Archive {
+		if s.blobs == nil {
+			return nil, nil, errors.New("the conode doesn't archive contents")
+		}
+		algorithms = archiveAlgorithms(algorithms)
+	}
+
 	// generate the tree
 	root := req.Roster.NewRosterWithRoot(s.ServerIdentity())
 	tree := root.GenerateNaryTree(len(req.Roster.List))
 	if tree == nil {
-		return nil, errors.New("error while creating the tree for the requested protocol")
+		return nil, nil, errors.New("error while creating the tree for the requested protocol")
 
 	}
 
 	// create protocol
 	instance, err := s.CreateProtocol(protocol.NameHashPublic, tree)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 	protocol := instance.(*protocol.HashPublic)
 
 	// configure protocol
 	protocol.URL = req.URL
 	protocol.Nonce = req.Nonce
+	protocol.Algorithms = algorithms
+	protocol.Options = req.Options
+	if req.Diff {
+		// the content must outlive the wait for this protocol and the
+		// one for the content protocol
+		protocol.ContentTTL = 2 * protocolTimeout(req.Options)
+	}
 
 	// run protocol
 	if err = protocol.Start(); err != nil {
-		return nil, err
+		return nil, nil, err
 	}
 
 	// wait protocol to finish or trigger timeout error
@@ -80,16 +167,43 @@ func (s *Service) HashPublic(req *dpcc.HashPublicRequest) (*dpcc.HashPublicRespo
 		for pk, r := range responses {
 			sr := &dpcc.HashPublicSingleResponse{
 				PubliKey:  r.PublicKey,
-				Hash:      r.Hash,
+				Digests:   r.Digests,
+				Timestamp: r.Timestamp,
 				Signature: r.Signature,
 			}
 			hashPublicResponses[pk] = sr
 		}
 
-		// send hashes and signatures to client
-		return &dpcc.HashPublicResponse{Responses: hashPublicResponses}, nil
-	case <-time.After(time.Second * 5):
-		return nil, errors.New("timeout in hash public protocol")
+		// send hashes, signatures and the resulting verdict to client
+		resp := &dpcc.HashPublicResponse{
+			Responses: hashPublicResponses,
+			Verdict:   dpcc.NewVerdict(hashPublicResponses),
+		}
+
+		// if the client asked for it, show how the versions of the
+		// conodes differ. The signed digests are sent back all the
+		// same if the report fails.
+		if req.Diff {
+			resp.Diff, err = s.diffReport(req, lib.PrimaryAlgorithm(protocol.Algorithms), resp.Verdict)
+			if err != nil {
+				log.Lvl2(s.ServerIdentity(), "couldn't compare the versions of", req.URL, ":", err)
+				resp.Diff = nil
+			}
+		}
+		run := publicRun(req, resp)
+		if req.Archive {
+			run.Capture, err = s.capture(req, run)
+			if err != nil {
+				log.Lvl2(s.ServerIdentity(), "couldn't archive", req.URL, ":", err)
+			}
+		}
+		s.recordRun(run)
+		s.queueLog(run, lib.PrimaryAlgorithm(protocol.Algorithms))
+		resp.RunID = run.ID
+		resp.Capture = run.Capture
+		return resp, run, nil
+	case <-time.After(protocolTimeout(req.Options)):
+		return nil, nil, errors.New("timeout in hash public protocol")
 	}
 }
 
@@ -114,7 +228,10 @@ func (s *Service) HashPrivate(req *dpcc.HashPrivateRequest) (*dpcc.HashPrivateRe
 
 	// configure protocol
 	protocol.URL = req.URL
+	protocol.Nonce = req.Nonce
 	protocol.ClientPublicKeys = req.ClientPublicKeys
+	protocol.Algorithms = req.Algorithms
+	protocol.Options = req.Options
 
 	// run protocol
 	if err = protocol.Start(); err != nil {
@@ -136,7 +253,9 @@ func (s *Service) HashPrivate(req *dpcc.HashPrivateRequest) (*dpcc.HashPrivateRe
 			sr := &dpcc.HashPrivateSingleResponse{
 				PublicKey:     r.PublicKey,
 				EncryptedHash: r.EncryptedHash,
-				Nonce:         r.Nonce,
+				Encapsulation: r.Encapsulation,
+				Timestamp:     r.Timestamp,
+				Signature:     r.Signature,
 			}
 			hashPrivateResponses[pk] = sr
 		}
@@ -144,13 +263,133 @@ func (s *Service) HashPrivate(req *dpcc.HashPrivateRequest) (*dpcc.HashPrivateRe
 		resp := &dpcc.HashPrivateResponse{
 			Responses: hashPrivateResponses,
 		}
+		s.recordRun(privateRun(req, resp))
 
 		return resp, nil
-	case <-time.After(time.Second * 5):
-		return nil, errors.New("timeout in hash public protocol")
+	case <-time.After(protocolTimeout(req.Options)):
+		return nil, errors.New("timeout in hash private protocol")
 	}
 }
 
+// DOMConsensus receives a request of DOM consensus protocol from the client,
+// executes the corresponding protocol and sends the collectively signed
+// consensus document back to the client
+func (s *Service) DOMConsensus(req *dpcc.DOMConsensusRequest) (*dpcc.DOMConsensusResponse, error) {
+	if !req.Mode.Valid() {
+		return nil, errors.New("unknown consensus mode")
+	}
+
+	// the encrypted filters need the distributed key of the roster. The
+	// conodes that already hold one refuse to generate another.
+	if req.Mode == dpcc.ConsensusEncrypted && s.distKey(req.Roster) == nil {
+		if _, err := s.DKG(&dpcc.DKGRequest{Roster: req.Roster}); err != nil {
+			return nil, err
+		}
+	}
+
+	// generate the tree
+	root := req.Roster.NewRosterWithRoot(s.ServerIdentity())
+	tree := root.GenerateNaryTree(len(req.Roster.List))
+	if tree == nil {
+		return nil, errors.New("error while creating the tree for the requested protocol")
+	}
+
+	// create the protocol, configured with the distributed key by
+	// NewProtocol
+	instance, err := s.CreateProtocol(protocol.NameDOMConsensus, tree)
+	if err != nil {
+		return nil, err
+	}
+	protocol := instance.(*protocol.DOMConsensus)
+
+	// configure protocol
+	protocol.URL = req.URL
+	protocol.Nonce = req.Nonce
+	protocol.Threshold = req.Threshold
+	protocol.Options = req.Options
+	protocol.PrivateFilters = req.Mode == dpcc.ConsensusPrivate
+	protocol.EncryptedFilters = req.Mode == dpcc.ConsensusEncrypted
+
+	// run protocol
+	if err = protocol.Start(); err != nil {
+		return nil, err
+	}
+
+	// wait protocol to finish or trigger timeout error
+	select {
+	case <-protocol.Finished:
+		resp := &dpcc.DOMConsensusResponse{
+			Document:  protocol.Document,
+			Signers:   protocol.Signers,
+			Timestamp: protocol.Timestamp,
+			Signature: protocol.Signature,
+		}
+		run := consensusRun(req, resp)
+		s.recordRun(run)
+		s.queueLog(run, "")
+		return resp, nil
+	case <-time.After(protocolTimeout(req.Options)):
+		return nil, errors.New("timeout in DOM consensus protocol")
+	}
+}
+
+// newDOMConsensusProtocol instantiates the DOM consensus with the share of
+// the distributed key of the roster, if it has one, used by the encrypted
+// filters
+func (s *Service) newDOMConsensusProtocol(node *onet.TreeNodeInstance) (onet.ProtocolInstance, error) {
+	instance, err := protocol.NewDOMConsensusProtocol(node)
+	if err != nil {
+		return nil, err
+	}
+	p := instance.(*protocol.DOMConsensus)
+	if key := s.distKey(node.Roster()); key != nil {
+		p.DistKey = key.Public
+		p.Share = key.Share.Share
+		p.KeyThreshold = key.Threshold
+		p.NbrShares = len(key.Publics)
+	}
+	return p, nil
+}
+
+// protocolTimeout returns how long the service waits for a protocol to
+// finish: the time of the protocol itself, plus the download timeout if the
+// client asked for one, bounded by the limits of the conode. A conode that
+// fails to fetch doesn't answer, so the default wait stays short.
+func protocolTimeout(opts *lib.FetchOptions) time.Duration {
+	if opts == nil || opts.Timeout <= 0 {
+		return defaultTimeout
+	}
+	return opts.Bound(lib.FetchLimits()).Timeout + defaultTimeout
+}
+
+// fetchLimits reads the limits on the downloads from the environment
+func fetchLimits() (lib.FetchOptions, error) {
+	limits := lib.DefaultFetchLimits
+	if v := os.Getenv(envFetchMaxSize); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n <= 0 {
+			return limits, errors.New("invalid " + envFetchMaxSize + ": " + v)
+		}
+		limits.MaxSize = n
+	}
+	for _, e := range []struct {
+		name string
+		d    *time.Duration
+	}{
+		{envFetchProgressTimeout, &limits.ProgressTimeout},
+		{envFetchTimeout, &limits.Timeout},
+	} {
+		if v := os.Getenv(e.name); v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil || d <= 0 {
+				return limits, errors.New("invalid " + e.name + ": " + v)
+			}
+			*e.d = d
+		}
+	}
+	return limits, nil
+}
+
 // NewProtocol is called on all nodes of a Tree (except the root, since it is
 // the one starting the protocol) so it's the Service that will be called to
 // generate the PI on all others node.
@@ -159,6 +398,16 @@ func (s *Service) HashPrivate(req *dpcc.HashPrivateRequest) (*dpcc.HashPrivateRe
 // instantiation of the protocol, use CreateProtocolService, and you can
 // give some extra-configuration to your protocol in here.
 func (s *Service) NewProtocol(node *onet.TreeNodeInstance, conf *onet.GenericConfig) (onet.ProtocolInstance, error) {
+	switch node.ProtocolName() {
+	case protocol.NameDKG:
+		return s.newDKGProtocol(node)
+	case protocol.NameDOMConsensus:
+		return s.newDOMConsensusProtocol(node)
+	case protocol.NameHashEquality:
+		return s.newHashEqualityProtocol(node)
+	case protocol.NameHashShuffle:
+		return s.newHashShuffleProtocol(node)
+	}
 	return nil, nil
 }
 
@@ -195,16 +444,63 @@ func (s *Service) tryLoad() error {
 // running on. Saving and loading can be done using the context. The data will
 // be stored in memory for tests and simulations, and on disk for real deployments.
 func newService(c *onet.Context) (onet.Service, error) {
+	maxAge, maxRuns, err := historyRetention()
+	if err != nil {
+		return nil, err
+	}
+	limits, err := fetchLimits()
+	if err != nil {
+		return nil, err
+	}
+	if err := lib.SetFetchLimits(limits); err != nil {
+		return nil, err
+	}
+	operator, err := operatorKey()
+	if err != nil {
+		return nil, err
+	}
 	s := &Service{
 		ServiceProcessor: onet.NewServiceProcessor(c),
+		historyMaxAge:    maxAge,
+		historyMaxRuns:   maxRuns,
+		operator:         operator,
 	}
-	if err := s.RegisterHandlers(s.HashPublic, s.HashPrivate); err != nil {
+	if err := s.RegisterHandlers(s.HashPublic, s.HashPrivate, s.DOMConsensus,
+		s.DKG, s.HashEquality, s.HashShuffle, s.History, s.HistoryRun,
+		s.AgreedHash, s.WatchAdd, s.WatchList, s.WatchRemove, s.WatchEvents,
+		s.LogProof, s.TreeHead, s.InclusionProof, s.ConsistencyProof, s.Blob,
+		s.WARC); err != nil {
 		log.Error(err, "Couldn't register messages")
 		return nil, err
 	}
+	if err := s.RegisterStreamingHandler(s.Subscribe); err != nil {
+		log.Error(err, "Couldn't register streaming messages")
+		return nil, err
+	}
 	if err := s.tryLoad(); err != nil {
 		log.Error(err)
 		return nil, err
 	}
+	if err := s.loadTransparencyLog(); err != nil {
+		log.Error(err)
+		return nil, err
+	}
+	if s.eventLog, err = newEventLog(s.ServerIdentity()); err != nil {
+		return nil, err
+	}
+	if s.eventLog != nil {
+		go s.logRuns()
+	}
+	if s.blobs, err = newBlobStore(); err != nil {
+		return nil, err
+	}
+	if s.blobs != nil {
+		if err := s.blobs.load(s.storage.Runs); err != nil {
+			return nil, err
+		}
+	}
+	if len(s.storage.Watches) > 0 {
+		s.startWatches()
+	}
 	return s, nil
 }
@@ -1,13 +1,39 @@
 package service
 
 import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/cothority/v3/byzcoin"
+	"go.dedis.ch/cothority/v3/darc"
+	"go.dedis.ch/cothority/v3/eventlog"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/kyber/v3/share"
+	"go.dedis.ch/kyber/v3/util/key"
 	"go.dedis.ch/onet/v3"
 
 	"github.com/si-co/dpcc"
 	"github.com/si-co/dpcc/lib"
+	"github.com/si-co/dpcc/protocol"
 	"github.com/stretchr/testify/require"
 )
 
@@ -29,10 +55,11 @@ func TestHashPublicService(t *testing.T) {
 	s5 := local.GetServices(nodes, templateID)[5].(*Service)
 	services := []*Service{s0, s1, s2, s3, s4, s5}
 
+	nonce := lib.GenNonce()
 	resp, err := s0.HashPublic(&dpcc.HashPublicRequest{
 		Roster: roster,
 		URL:    tURL,
-		Nonce:  lib.GenNonce(),
+		Nonce:  nonce,
 	})
 
 	// test if everything wents good
@@ -40,6 +67,20 @@ func TestHashPublicService(t *testing.T) {
 	require.NotNil(t, resp)
 	require.Equal(t, len(services)-1, len(resp.Responses))
 
+	// the responses are signed with the service keys of the roster
+	publics := make(map[string]bool)
+	for _, pk := range lib.ServicePublics(roster) {
+		publics[pk.String()] = true
+	}
+	for pk, r := range resp.Responses {
+		require.True(t, publics[pk])
+		require.Equal(t, pk, r.PubliKey.String())
+		st := lib.NewStatement(protocol.NameHashPublic, tURL, nonce, r.PubliKey)
+		st.Timestamp = r.Timestamp
+		st.Digests = r.Digests
+		require.Nil(t, lib.VerifyStatement(st, r.Signature))
+	}
+
 	local.CloseAll()
 }
 
@@ -60,11 +101,14 @@ func TestHashPrivateService(t *testing.T) {
 	services := []*Service{s0, s1, s2, s3, s4, s5}
 
 	// generate client's ephemeral keys
-	privateKeys, publicKeys := lib.GenEphemeralKeys(roster)
+	privateKeys, publicKeys, err := lib.GenEphemeralKeys(roster)
+	require.Nil(t, err)
 
+	nonce := lib.GenNonce()
 	resp, err := s0.HashPrivate(&dpcc.HashPrivateRequest{
 		Roster:           roster,
 		URL:              tURL,
+		Nonce:            nonce,
 		ClientPublicKeys: publicKeys,
 	})
 
@@ -75,18 +119,1403 @@ func TestHashPrivateService(t *testing.T) {
 
 	// try to decrypt the hashes
 	for pk, v := range resp.Responses {
-		// compute previsously shared key
-		pre := lib.DhExchange(privateKeys[pk], v.PublicKey)
-		// determine context for this AEAD scheme
-		ctx := lib.Context(publicKeys[pk], v.PublicKey)
-		// instantiate AEAD scheme (AES128-GCM)
-		gcm, err := lib.NewAEAD(cothority.Suite.Hash, pre, ctx)
+		// the ciphertext is signed by the conode
+		st := lib.NewStatement(protocol.NameHashPrivate, tURL, nonce, v.PublicKey)
+		st.Timestamp = v.Timestamp
+		st.Flags = lib.StatementEncrypted
+		st.Payload = lib.PrivateResponsePayload(v.Encapsulation, v.EncryptedHash)
+		require.Nil(t, lib.VerifyStatement(st, v.Signature))
+		// decrypt with HPKE
+		info, err := lib.PrivateResponseInfo(v.PublicKey)
 		require.Nil(t, err)
-		// encrypt hash with AES128-GCM
-		decrypted, err := gcm.Open(nil, v.Nonce, v.EncryptedHash, nil)
+		decrypted, err := lib.HPKEOpen(privateKeys[pk], v.Encapsulation, info,
+			nonce, v.EncryptedHash)
 		require.Nil(t, err)
 		require.NotNil(t, decrypted)
+		// the plaintext contains the labeled digests
+		digests, err := lib.DecodeDigests(decrypted)
+		require.Nil(t, err)
+		require.Equal(t, lib.DefaultAlgorithm, digests[0].Algorithm)
 	}
 
 	local.CloseAll()
 }
+
+func TestHashPublicServiceSimilarity(t *testing.T) {
+	// every conode sees a slightly different version of the page
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body><p>%s</p><p>visitor number %d</p></body></html>",
+			strings.Repeat("the same long article about content consensus ", 50), v)
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	resp, err := s0.HashPublic(&dpcc.HashPublicRequest{
+		Roster:     roster,
+		URL:        ts.URL,
+		Nonce:      lib.GenNonce(),
+		Algorithms: []string{lib.SHA256, lib.SimHash64},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, resp.Verdict)
+
+	// the exact hashes all differ
+	for _, g := range resp.Verdict.Groups {
+		if g.Algorithm == lib.SHA256 {
+			require.Equal(t, 1, len(g.Nodes))
+		}
+	}
+
+	// but the pages are almost identical
+	require.Equal(t, 3, len(resp.Verdict.Similarities))
+	for _, sc := range resp.Verdict.Similarities {
+		require.Equal(t, lib.SimHash64, sc.Algorithm)
+		require.True(t, sc.Score > 0.8)
+	}
+}
+
+func TestHashPublicServicePerceptual(t *testing.T) {
+	// the same picture served with different encodings, as a CDN would do
+	img := image.NewGray(image.Rect(0, 0, 90, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 90; x++ {
+			img.SetGray(x, y, color.Gray{Y: uint8((x/10*37 + y/10*91) % 256)})
+		}
+	}
+	var pngImg, jpegImg bytes.Buffer
+	require.Nil(t, png.Encode(&pngImg, img))
+	require.Nil(t, jpeg.Encode(&jpegImg, img, &jpeg.Options{Quality: 50}))
+
+	// a small PNG image announcing a huge size, which must not be decoded
+	large := append([]byte{}, pngImg.Bytes()...)
+	binary.BigEndian.PutUint32(large[16:], 1<<16)
+	binary.BigEndian.PutUint32(large[20:], 1<<16)
+	binary.BigEndian.PutUint32(large[29:], crc32.ChecksumIEEE(large[12:29]))
+
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/large.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(large)
+			return
+		}
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		if v%2 == 0 {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(pngImg.Bytes())
+		} else {
+			w.Header().Set("Content-Type", "image/jpeg")
+			w.Write(jpegImg.Bytes())
+		}
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	resp, err := s0.HashPublic(&dpcc.HashPublicRequest{
+		Roster:     roster,
+		URL:        ts.URL,
+		Nonce:      lib.GenNonce(),
+		Algorithms: []string{lib.SHA256, lib.DHash64},
+	})
+	require.Nil(t, err)
+	require.NotNil(t, resp.Verdict)
+
+	// the bytes differ but the picture is the same
+	for _, g := range resp.Verdict.Groups {
+		switch g.Algorithm {
+		case lib.SHA256:
+			require.True(t, len(g.Nodes) < 3)
+		case lib.DHash64:
+			require.Equal(t, 3, len(g.Nodes))
+		}
+	}
+
+	// only the exact hash of the large image is computed
+	resp, err = s0.HashPublic(&dpcc.HashPublicRequest{
+		Roster:     roster,
+		URL:        ts.URL + "/large.png",
+		Nonce:      lib.GenNonce(),
+		Algorithms: []string{lib.SHA256, lib.DHash64},
+	})
+	require.Nil(t, err)
+	for _, r := range resp.Responses {
+		require.NotNil(t, lib.FindDigest(r.Digests, lib.SHA256))
+		require.Nil(t, lib.FindDigest(r.Digests, lib.DHash64))
+	}
+}
+
+func TestHashPublicServiceDiff(t *testing.T) {
+	// every other conode sees an injected ad
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		ad := ""
+		if v%2 == 0 {
+			ad = "<div class=\"ad\">buy now</div>"
+		}
+		fmt.Fprintf(w, "<html><body><h1>news</h1>%s<p>article</p></body></html>", ad)
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	nonce := lib.GenNonce()
+	resp, err := s0.HashPublic(&dpcc.HashPublicRequest{
+		Roster: roster,
+		URL:    ts.URL,
+		Nonce:  nonce,
+		Diff:   true,
+	})
+	require.Nil(t, err)
+
+	// one version for each of the two groups, signed by the
+	// representatives
+	require.NotNil(t, resp.Diff)
+	require.Equal(t, 2, len(resp.Diff.Versions))
+	require.Equal(t, 1, len(resp.Diff.Diffs))
+	require.NotEqual(t, 0, len(resp.Diff.Diffs[0].Edits))
+	ad := false
+	for _, e := range resp.Diff.Diffs[0].Edits {
+		ad = ad || strings.Contains(e.Text, "buy now")
+	}
+	require.True(t, ad)
+	for _, v := range resp.Diff.Versions {
+		// the version is the one hashed in the run, whatever the
+		// resource served afterwards
+		var group *dpcc.DigestGroup
+		for _, g := range resp.Verdict.Groups {
+			for _, n := range g.Nodes {
+				if g.Algorithm == resp.Diff.Algorithm && n == v.Node {
+					group = g
+				}
+			}
+		}
+		require.NotNil(t, group)
+		require.Equal(t, group.Digest, v.Digest.Value)
+		require.Equal(t, lib.FindDigest(resp.Responses[v.Node].Digests, resp.Diff.Algorithm), v.Digest.Value)
+		require.Equal(t, lib.FormatDOM, v.Format)
+		st := lib.NewStatement(protocol.NameHashContent, ts.URL, nonce, v.PublicKey)
+		st.Timestamp = v.Timestamp
+		st.Digests = []*lib.Digest{v.Digest}
+		st.Payload = lib.ContentPayload(v.Format, v.Content)
+		require.Nil(t, lib.VerifyStatement(st, v.Signature))
+	}
+}
+
+func TestDKGService(t *testing.T) {
+	//log.SetDebugVisible(3)
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(5, 5, 1, true)
+	services := local.GetServices(nodes, templateID)
+	s0 := services[0].(*Service)
+
+	resp, err := s0.DKG(&dpcc.DKGRequest{Roster: roster, Threshold: 3})
+	require.Nil(t, err)
+	require.Equal(t, 3, resp.Threshold)
+
+	// every conode stored a share of the same key, and a threshold of
+	// shares recovers its private key
+	shares := make([]*share.PriShare, len(services))
+	for i, s := range services {
+		key := s.(*Service).distKey(roster)
+		require.NotNil(t, key)
+		require.True(t, key.Public.Equal(resp.Public))
+		shares[i] = key.Share.Share
+	}
+	secret, err := share.RecoverSecret(tSuite, shares[:3], 3, len(services))
+	require.Nil(t, err)
+	require.True(t, tSuite.Point().Mul(secret, nil).Equal(resp.Public))
+
+	// the key is reused by another leader
+	s1 := services[1].(*Service)
+	again, err := s1.DKG(&dpcc.DKGRequest{Roster: roster})
+	require.Nil(t, err)
+	require.True(t, again.Public.Equal(resp.Public))
+
+	// the shares are only renewed on request of the operators of the
+	// conodes, and the key stays the same
+	_, err = s1.DKG(&dpcc.DKGRequest{Roster: roster, Refresh: true})
+	require.NotNil(t, err)
+	operator := key.NewKeyPair(tSuite)
+	for _, s := range services {
+		s.(*Service).operator = operator.Public
+	}
+	o, err := dpcc.SignRefreshDKG(roster, 0, operator.Private)
+	require.Nil(t, err)
+
+	// a conode holding the key refuses a new key, or new shares its
+	// operator didn't ask for, whatever the leader says
+	s2 := services[2].(*Service)
+	require.NotNil(t, s2.authorizeDKG(roster, 0, false, o))
+	require.NotNil(t, s2.authorizeDKG(roster, 1, true, o))
+	var others []*lib.OperatorRequest
+	for _, r := range o {
+		if !r.Conode.Equal(lib.ServicePublic(s2.ServerIdentity())) {
+			others = append(others, r)
+		}
+	}
+	require.NotNil(t, s2.authorizeDKG(roster, 0, true, others))
+	stranger := key.NewKeyPair(tSuite)
+	forged, err := dpcc.SignRefreshDKG(roster, 1, stranger.Private)
+	require.Nil(t, err)
+	require.NotNil(t, s2.authorizeDKG(roster, 1, true, forged))
+
+	refreshed, err := s1.DKG(&dpcc.DKGRequest{Roster: roster, Refresh: true, Operators: o})
+	require.Nil(t, err)
+	require.True(t, refreshed.Public.Equal(resp.Public))
+	require.Equal(t, 4, refreshed.Threshold)
+	for i, s := range services {
+		k := s.(*Service).distKey(roster)
+		require.True(t, k.Public.Equal(resp.Public))
+		require.False(t, k.Share.Share.V.Equal(shares[i].V))
+		shares[i] = k.Share.Share
+	}
+	secret, err = share.RecoverSecret(tSuite, shares[:4], 4, len(services))
+	require.Nil(t, err)
+	require.True(t, tSuite.Point().Mul(secret, nil).Equal(resp.Public))
+
+	// the request of the operator cannot be replayed
+	_, err = s1.DKG(&dpcc.DKGRequest{Roster: roster, Refresh: true, Operators: o})
+	require.NotNil(t, err)
+
+	// a new roster leads to a new key
+	smaller := onet.NewRoster(roster.List[:4])
+	other, err := s0.DKG(&dpcc.DKGRequest{Roster: smaller})
+	require.Nil(t, err)
+	require.False(t, other.Public.Equal(refreshed.Public))
+}
+
+func TestHashEqualityService(t *testing.T) {
+	//log.SetDebugVisible(3)
+
+	// the third visitor sees another version
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		if v == 3 {
+			fmt.Fprint(w, "<html><body>censored</body></html>")
+			return
+		}
+		fmt.Fprint(w, "<html><body>article</body></html>")
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(5, 5, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	// the distributed key is generated with the first request
+	resp, err := s0.HashEquality(&dpcc.HashEqualityRequest{
+		Roster: roster,
+		URL:    ts.URL,
+		Nonce:  lib.GenNonce(),
+	})
+	require.Nil(t, err)
+	require.Equal(t, 4, resp.Nodes)
+	require.Equal(t, 2, len(resp.Groups))
+	require.Equal(t, 3, len(resp.Groups[0].Nodes))
+	require.Equal(t, 1, len(resp.Groups[1].Nodes))
+	require.NotNil(t, s0.distKey(roster))
+	require.Equal(t, 5, len(resp.KeySignatures))
+	require.Equal(t, 4, len(resp.Inputs))
+	require.Equal(t, 5, len(resp.Blindings))
+
+	// the client recomputes the groups from the transcript, the next
+	// visitors all see the article
+	c := &dpcc.Client{Client: local.NewClient(lib.ServiceName)}
+	resp, err = c.PrivateEqualityRequest(roster, ts.URL, "", nil)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(resp.Groups))
+	require.Equal(t, 4, len(resp.Groups[0].Nodes))
+}
+
+func TestDOMConsensusServiceEncrypted(t *testing.T) {
+	//log.SetDebugVisible(3)
+
+	// every other visitor, including the leader, sees an injected ad
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		ad := ""
+		if v%2 == 0 {
+			ad = "<div class=\"ad\">buy now</div>"
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body><h1>news</h1>%s<p>article</p></body></html>", ad)
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	// an unknown mode is refused before any distributed key is generated
+	_, err := s0.DOMConsensus(&dpcc.DOMConsensusRequest{
+		Roster: roster,
+		URL:    ts.URL,
+		Nonce:  lib.GenNonce(),
+		Mode:   dpcc.ConsensusEncrypted + 1,
+	})
+	require.NotNil(t, err)
+	require.Nil(t, s0.distKey(roster))
+
+	// the distributed key is generated with the first request, and the
+	// conodes only decrypt the sum of the filters they checked
+	resp, err := s0.DOMConsensus(&dpcc.DOMConsensusRequest{
+		Roster:    roster,
+		URL:       ts.URL,
+		Nonce:     lib.GenNonce(),
+		Threshold: 2,
+		Mode:      dpcc.ConsensusEncrypted,
+	})
+	require.Nil(t, err)
+	require.NotNil(t, s0.distKey(roster))
+	require.True(t, strings.Contains(string(resp.Document), "article"))
+	require.False(t, strings.Contains(string(resp.Document), "buy now"))
+	require.Equal(t, 4, len(resp.Signers))
+}
+
+func TestHashShuffleService(t *testing.T) {
+	//log.SetDebugVisible(3)
+
+	// the second visitor sees another version
+	var mu sync.Mutex
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		visits++
+		v := visits
+		mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		if v == 2 {
+			fmt.Fprint(w, "censored")
+			return
+		}
+		fmt.Fprint(w, "article")
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(5, 5, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	nonce := lib.GenNonce()
+	resp, err := s0.HashShuffle(&dpcc.HashShuffleRequest{
+		Roster: roster,
+		URL:    ts.URL,
+		Nonce:  nonce,
+	})
+	require.Nil(t, err)
+	require.Equal(t, lib.SHA256, resp.Algorithm)
+	require.Equal(t, len(roster.List), len(resp.Steps))
+	require.Equal(t, 5, len(resp.KeySignatures))
+	cts := []*lib.ElGamalCiphertext{}
+	for _, input := range resp.Inputs {
+		cts = append(cts, input.Ciphertext)
+	}
+	require.Nil(t, lib.VerifyShuffles(resp.DistKey, nonce, cts, resp.Steps))
+	// the shuffles are bound to the nonce of the request
+	require.NotNil(t, lib.VerifyShuffles(resp.DistKey, lib.GenNonce(), cts, resp.Steps))
+
+	// the multiset of the digests is revealed
+	article := sha256.Sum256([]byte("article"))
+	censored := sha256.Sum256([]byte("censored"))
+	count := make(map[string]int)
+	for _, d := range resp.Digests {
+		count[string(d)]++
+	}
+	require.Equal(t, 3, count[string(lib.TruncateDigest(article[:]))])
+	require.Equal(t, 1, count[string(lib.TruncateDigest(censored[:]))])
+
+	// the client verifies the whole transcript, the next visitors all see
+	// the article
+	c := &dpcc.Client{Client: local.NewClient(lib.ServiceName)}
+	resp, err = c.ShuffledHashRequest(roster, ts.URL, "", nil)
+	require.Nil(t, err)
+	require.Equal(t, 4, len(resp.Digests))
+	for _, d := range resp.Digests {
+		require.Equal(t, lib.TruncateDigest(article[:]), d)
+	}
+}
+
+func TestHistoryService(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>the same page for every conode</body></html>")
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+	s0.historyMaxRuns = 2
+
+	// the runs are recorded by the leader only
+	var nonces [][]byte
+	for i := 0; i < 3; i++ {
+		nonce := lib.GenNonce()
+		nonces = append(nonces, nonce)
+		_, err := s0.HashPublic(&dpcc.HashPublicRequest{
+			Roster: roster,
+			URL:    ts.URL,
+			Nonce:  nonce,
+		})
+		require.Nil(t, err)
+	}
+
+	// the oldest run has been removed from the history and the indexes
+	s0.storage.Lock()
+	require.Equal(t, 2, len(s0.storage.Runs))
+	require.Equal(t, 2, len(s0.storage.Timeline))
+	require.Equal(t, s0.storage.Timeline, s0.storage.URLs[ts.URL].IDs)
+	for i, id := range s0.storage.Timeline {
+		run := s0.storage.Runs[id]
+		require.Equal(t, ts.URL, run.URL)
+		require.Equal(t, protocol.NameHashPublic, run.Mode)
+		require.Equal(t, nonces[i+1], run.Nonce)
+		require.Equal(t, len(roster.List)-1, len(run.Responses))
+		require.NotNil(t, run.Verdict)
+	}
+	ids := s0.storage.Timeline
+	s0.storage.Unlock()
+
+	// the runs are listed in chronological order
+	history, err := s0.History(&dpcc.HistoryRequest{URL: ts.URL})
+	require.Nil(t, err)
+	require.Equal(t, 2, len(history.Runs))
+	for i, r := range history.Runs {
+		require.Equal(t, ids[i], r.ID)
+		require.Equal(t, len(roster.List)-1, r.Nodes)
+	}
+	history, err = s0.History(&dpcc.HistoryRequest{URL: ts.URL, Limit: 1})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(history.Runs))
+	require.Equal(t, ids[1], history.Runs[0].ID)
+	history, err = s0.History(&dpcc.HistoryRequest{URL: ts.URL + "/other"})
+	require.Nil(t, err)
+	require.Equal(t, 0, len(history.Runs))
+
+	// a run can be verified again by the client
+	run, err := s0.HistoryRun(&dpcc.HistoryRunRequest{ID: ids[0]})
+	require.Nil(t, err)
+	require.Nil(t, dpcc.VerifyRun(roster, run.Run))
+	_, err = s0.HistoryRun(&dpcc.HistoryRunRequest{ID: "unknown"})
+	require.NotNil(t, err)
+
+	// all the conodes agreed on the last run
+	agreed, err := s0.AgreedHash(&dpcc.AgreedHashRequest{URL: ts.URL})
+	require.Nil(t, err)
+	require.Equal(t, ids[1], agreed.Run.ID)
+	require.Equal(t, len(roster.List)-1, len(agreed.Group.Nodes))
+	digest := sha256.Sum256([]byte("<html><body>the same page for every conode</body></html>"))
+	require.Equal(t, digest[:], agreed.Group.Digest)
+	_, err = s0.AgreedHash(&dpcc.AgreedHashRequest{URL: ts.URL, Time: 1})
+	require.NotNil(t, err)
+
+	// the time of the run is the one signed by the conodes, not the one
+	// recorded by the leader
+	signed := dpcc.AgreedTime(agreed.Run, agreed.Group)
+	forged := *agreed.Run
+	forged.Time = 1
+	require.Equal(t, signed, dpcc.AgreedTime(&forged, agreed.Group))
+	require.NotEqual(t, int64(1), signed)
+
+	// the leader cannot reach the threshold by dropping responses
+	pk := agreed.Group.Nodes[0]
+	dropped := &dpcc.Run{
+		Mode:      protocol.NameHashPublic,
+		Responses: map[string]*dpcc.RunResponse{pk: agreed.Run.Responses[pk]},
+	}
+	require.NotNil(t, dpcc.AgreedGroup(dropped, 1, ""))
+	require.Nil(t, dpcc.AgreedGroup(dropped, len(roster.List)-1, ""))
+}
+
+func TestWatchService(t *testing.T) {
+	// the page can change, disappear, or be different for every conode
+	var mu sync.Mutex
+	mode := "a"
+	visits := 0
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		visits++
+		switch mode {
+		case "gone":
+			http.NotFound(w, r)
+			return
+		case "distinct":
+			w.Header().Set("Content-Type", "text/html")
+			fmt.Fprintf(w, "<html><body>visitor %d</body></html>", visits)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body>version %s</body></html>", mode)
+	}))
+	defer ts.Close()
+	setMode := func(m string) {
+		mu.Lock()
+		mode = m
+		mu.Unlock()
+	}
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+	operator := key.NewKeyPair(tSuite)
+
+	// only the operator can add watches
+	req := &dpcc.WatchAddRequest{Roster: roster, URL: ts.URL, Schedule: "*/5 * * * *"}
+	_, err := s0.WatchAdd(req)
+	require.NotNil(t, err)
+	s0.operator = operator.Public
+	_, err = s0.WatchAdd(req)
+	require.NotNil(t, err)
+	other := key.NewKeyPair(tSuite)
+	_, err = s0.WatchAdd(signWatchAdd(t, s0, other.Private, req))
+	require.NotNil(t, err)
+
+	// schedules that are invalid or too frequent are refused
+	for _, schedule := range []string{"", "every minute", "@every 10s", "* * * * * *", "0 0 31 2 *"} {
+		_, err := s0.WatchAdd(signWatchAdd(t, s0, operator.Private,
+			&dpcc.WatchAddRequest{Roster: roster, URL: ts.URL, Schedule: schedule}))
+		require.NotNil(t, err, schedule)
+	}
+
+	added, err := s0.WatchAdd(signWatchAdd(t, s0, operator.Private, req))
+	require.Nil(t, err)
+	require.True(t, operator.Public.Equal(added.Watch.Owner))
+	require.True(t, added.Watch.NextRun > 0)
+	list, err := s0.WatchList(&dpcc.WatchListRequest{})
+	require.Nil(t, err)
+	require.Equal(t, 1, len(list.Watches))
+
+	// run the watch as the scheduler would, changing the page in between
+	for _, m := range []string{"a", "a", "b", "gone", "b", "distinct", "a"} {
+		setMode(m)
+		s0.runWatch(added.Watch)
+	}
+
+	events, err := s0.WatchEvents(&dpcc.WatchEventsRequest{WatchID: added.Watch.ID})
+	require.Nil(t, err)
+	kinds := []string{}
+	for _, e := range events.Events {
+		require.Equal(t, ts.URL, e.URL)
+		kinds = append(kinds, e.Kind)
+	}
+	require.Equal(t, []string{dpcc.EventContentChanged, dpcc.EventUnreachable,
+		dpcc.EventDisagreement, dpcc.EventContentChanged}, kinds)
+
+	// the runs of the watch are in the history
+	history, err := s0.History(&dpcc.HistoryRequest{URL: ts.URL})
+	require.Nil(t, err)
+	require.Equal(t, 6, len(history.Runs))
+
+	// only the owner of the watch can remove it
+	_, err = s0.WatchRemove(&dpcc.WatchRemoveRequest{ID: added.Watch.ID})
+	require.NotNil(t, err)
+	_, err = s0.WatchRemove(signWatchRemove(t, s0, other.Private, added.Watch.ID))
+	require.NotNil(t, err)
+	_, err = s0.WatchRemove(signWatchRemove(t, s0, operator.Private, added.Watch.ID))
+	require.Nil(t, err)
+	_, err = s0.WatchRemove(signWatchRemove(t, s0, operator.Private, added.Watch.ID))
+	require.NotNil(t, err)
+	list, err = s0.WatchList(&dpcc.WatchListRequest{})
+	require.Nil(t, err)
+	require.Equal(t, 0, len(list.Watches))
+}
+
+// signWatchAdd signs the request for the conode with the key of the operator
+func signWatchAdd(t *testing.T, s *Service, operator kyber.Scalar, req *dpcc.WatchAddRequest) *dpcc.WatchAddRequest {
+	payload, err := dpcc.WatchAddPayload(req)
+	require.Nil(t, err)
+	signed := *req
+	signed.Operator, err = lib.SignOperatorRequest(operator, lib.ServicePublic(s.ServerIdentity()),
+		dpcc.ActionWatchAdd, payload)
+	require.Nil(t, err)
+	return &signed
+}
+
+// signWatchRemove returns the request removing the watch of the conode,
+// signed with the key
+func signWatchRemove(t *testing.T, s *Service, private kyber.Scalar, ID string) *dpcc.WatchRemoveRequest {
+	o, err := lib.SignOperatorRequest(private, lib.ServicePublic(s.ServerIdentity()),
+		dpcc.ActionWatchRemove, []byte(ID))
+	require.Nil(t, err)
+	return &dpcc.WatchRemoveRequest{ID: ID, Operator: o}
+}
+
+func TestSubscribeService(t *testing.T) {
+	var mu sync.Mutex
+	version := "a"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprintf(w, "<html><body>version %s</body></html>", version)
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+	operator := key.NewKeyPair(tSuite)
+	s0.operator = operator.Public
+
+	added, err := s0.WatchAdd(signWatchAdd(t, s0, operator.Private, &dpcc.WatchAddRequest{
+		Roster:   roster,
+		URL:      ts.URL,
+		Schedule: "@hourly",
+	}))
+	require.Nil(t, err)
+
+	// one subscriber for the watched URL, one for another URL
+	out, stop, err := s0.Subscribe(&dpcc.SubscribeRequest{URL: ts.URL})
+	require.Nil(t, err)
+	other, stopOther, err := s0.Subscribe(&dpcc.SubscribeRequest{URL: ts.URL + "/other"})
+	require.Nil(t, err)
+
+	s0.runWatch(added.Watch)
+	mu.Lock()
+	version = "b"
+	mu.Unlock()
+	s0.runWatch(added.Watch)
+
+	select {
+	case resp := <-out:
+		require.Equal(t, added.Watch.ID, resp.Event.WatchID)
+		require.Equal(t, dpcc.EventContentChanged, resp.Event.Kind)
+	case <-time.After(time.Second):
+		t.Fatal("no event streamed")
+	}
+	select {
+	case <-other:
+		t.Fatal("event streamed for another URL")
+	default:
+	}
+
+	// the stream ends when the client leaves
+	close(stop)
+	close(stopOther)
+	select {
+	case _, ok := <-out:
+		require.False(t, ok)
+	case <-time.After(time.Second):
+		t.Fatal("stream not closed")
+	}
+}
+
+func TestLogEntryService(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>the same page for every conode</body></html>")
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	_, run, err := s0.hashPublic(&dpcc.HashPublicRequest{
+		Roster: roster,
+		URL:    ts.URL,
+		Nonce:  lib.GenNonce(),
+	})
+	require.Nil(t, err)
+
+	// the entry of an agreed run survives the encoding in an event
+	entry := dpcc.NewLogEntry(run, len(roster.List)-1, "")
+	require.NotNil(t, entry)
+	require.Equal(t, run.ID, entry.RunID)
+	require.Equal(t, lib.DefaultAlgorithm, entry.Algorithm)
+	require.Equal(t, len(roster.List)-1, len(entry.Responses))
+	content, err := entry.Encode()
+	require.Nil(t, err)
+	decoded, err := dpcc.DecodeLogEntry(content)
+	require.Nil(t, err)
+	require.Nil(t, dpcc.VerifyLogEntry(roster, decoded, nil))
+
+	// the signatures don't cover another digest
+	decoded.Digest = append([]byte{}, decoded.Digest...)
+	decoded.Digest[0] ^= 1
+	require.NotNil(t, dpcc.VerifyLogEntry(roster, decoded, nil))
+
+	// the conode doesn't log its runs without an eventlog
+	_, err = s0.LogProof(&dpcc.LogProofRequest{ID: run.ID})
+	require.NotNil(t, err)
+	s0.storage.Lock()
+	require.Nil(t, s0.storage.Runs[run.ID].LogID)
+	s0.storage.Unlock()
+}
+
+func TestEventLogService(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>the same page for every conode</body></html>")
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	// a ledger run by the same conodes, with an eventlog whose darc lets
+	// the service key of the leader log events
+	owner := darc.NewSignerEd25519(nil, nil)
+	msg, err := byzcoin.DefaultGenesisMsg(byzcoin.CurrentVersion, roster,
+		[]string{"spawn:eventlog", "invoke:eventlog", "invoke:eventlog.log"}, owner.Identity(),
+		darc.NewIdentityEd25519(lib.ServicePublic(s0.ServerIdentity())))
+	require.Nil(t, err)
+	msg.BlockInterval = 100 * time.Millisecond
+	ledger, _, err := byzcoin.NewLedger(msg, false)
+	require.Nil(t, err)
+	el := eventlog.NewClient(ledger)
+	el.DarcID = msg.GenesisDarc.GetBaseID()
+	el.Signers = []darc.Signer{owner}
+	require.Nil(t, el.Create())
+	s0.eventLog = newEventLogClient(ledger, el.Instance, s0.ServerIdentity())
+	go s0.logRuns()
+
+	_, err = s0.HashPublic(&dpcc.HashPublicRequest{
+		Roster: roster,
+		URL:    ts.URL,
+		Nonce:  lib.GenNonce(),
+	})
+	require.Nil(t, err)
+	s0.storage.Lock()
+	id := s0.storage.Timeline[0]
+	s0.storage.Unlock()
+
+	// the run is logged in the background
+	var proof *dpcc.LogProofResponse
+	for i := 0; i < 100; i++ {
+		if proof, err = s0.LogProof(&dpcc.LogProofRequest{ID: id}); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	require.Nil(t, err)
+	e, err := dpcc.VerifyLogProof(ledger.ID, proof.Run.LogID, proof.Proof)
+	require.Nil(t, err)
+	require.Equal(t, id, e.RunID)
+	require.Nil(t, dpcc.VerifyLogEntry(roster, e, nil))
+
+	// the proof is checked against the ledger and the key of the event
+	_, err = dpcc.VerifyLogProof(ledger.ID, append([]byte{1}, proof.Run.LogID[1:]...), proof.Proof)
+	require.NotNil(t, err)
+
+	// the client verifies the run, the proof and the entry
+	c := &dpcc.Client{Client: local.NewClient(lib.ServiceName)}
+	run, entry, err := c.LogProof(roster, s0.ServerIdentity(), ledger.ID, id)
+	require.Nil(t, err)
+	require.Equal(t, id, run.ID)
+	require.Equal(t, e.Digest, entry.Digest)
+
+	// runs are not logged beyond the capacity of the queue
+	full := &eventLog{client: s0.eventLog.client, queue: make(chan logJob, 1)}
+	s0.eventLog = full
+	s0.queueLog(run, "")
+	s0.queueLog(run, "")
+	require.Equal(t, 1, len(full.queue))
+}
+
+func TestTransparencyService(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>the same page for every conode</body></html>")
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	var ids []string
+	var heads []*lib.TreeHead
+	for i := 0; i < 3; i++ {
+		_, run, err := s0.hashPublic(&dpcc.HashPublicRequest{
+			Roster: roster,
+			URL:    ts.URL,
+			Nonce:  lib.GenNonce(),
+		})
+		require.Nil(t, err)
+		ids = append(ids, run.ID)
+		resp, err := s0.TreeHead(&dpcc.TreeHeadRequest{})
+		require.Nil(t, err)
+		require.Equal(t, uint64(i+1), resp.Head.Size)
+		require.Nil(t, lib.VerifyTreeHead(resp.Head))
+		heads = append(heads, resp.Head)
+	}
+
+	// every run is in the current tree and in the trees that followed it
+	for i, id := range ids {
+		for size := uint64(i + 1); size <= 3; size++ {
+			resp, err := s0.InclusionProof(&dpcc.InclusionProofRequest{ID: id, Size: size})
+			require.Nil(t, err)
+			require.Nil(t, lib.VerifyTreeHead(resp.Head))
+			require.Equal(t, heads[size-1].Root, resp.Head.Root)
+			require.Equal(t, resp.Run.LeafIndex, resp.Index)
+			require.Equal(t, dpcc.RunLeafData(resp.Run), resp.Leaf)
+			require.Nil(t, lib.VerifyInclusion(resp.Run.LeafIndex, resp.Head.Size,
+				dpcc.RunLeaf(resp.Run), resp.Proof, resp.Head.Root))
+		}
+	}
+	_, err := s0.InclusionProof(&dpcc.InclusionProofRequest{ID: ids[2], Size: 2})
+	require.NotNil(t, err)
+
+	// a run pruned from the history is still proven by its leaf
+	s0.storage.Lock()
+	pruned := *s0.storage.Runs[ids[0]]
+	delete(s0.storage.Runs, ids[0])
+	s0.storage.Unlock()
+	resp, err := s0.InclusionProof(&dpcc.InclusionProofRequest{ID: ids[0]})
+	require.Nil(t, err)
+	require.Nil(t, resp.Run)
+	require.Nil(t, lib.VerifyInclusion(resp.Index, resp.Head.Size,
+		lib.LeafHash(resp.Leaf), resp.Proof, resp.Head.Root))
+	run, err := dpcc.LeafRun(resp.Leaf)
+	require.Nil(t, err)
+	require.Equal(t, pruned.ID, run.ID)
+	require.Equal(t, pruned.URL, run.URL)
+	require.Equal(t, pruned.Time, run.Time)
+	require.Equal(t, pruned.Nonce, run.Nonce)
+	_, err = dpcc.LeafRun(resp.Leaf[:len(resp.Leaf)-1])
+	require.NotNil(t, err)
+
+	// the log only grew
+	for _, old := range heads {
+		resp, err := s0.ConsistencyProof(&dpcc.ConsistencyProofRequest{First: old.Size})
+		require.Nil(t, err)
+		require.Nil(t, lib.VerifyConsistency(old.Size, resp.Head.Size, old.Root,
+			resp.Head.Root, resp.Proof))
+	}
+
+	// a conode rewriting its history cannot prove consistency any more
+	s0.tlog.Lock()
+	last, err := s0.tlog.Node(0, 2)
+	require.Nil(t, err)
+	s0.tlog.tree.Size = 1
+	require.Nil(t, s0.tlog.tree.Append(lib.LeafHash([]byte("another run"))))
+	require.Nil(t, s0.tlog.tree.Append(last))
+	s0.tlog.Unlock()
+	cresp, err := s0.ConsistencyProof(&dpcc.ConsistencyProofRequest{First: heads[1].Size})
+	require.Nil(t, err)
+	require.NotNil(t, lib.VerifyConsistency(heads[1].Size, cresp.Head.Size, heads[1].Root,
+		cresp.Head.Root, cresp.Proof))
+}
+
+func TestArchiveService(t *testing.T) {
+	page := `<html><body><img src="/logo.png"></body></html>`
+	logo := []byte("not really a PNG image")
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/logo.png" {
+			w.Header().Set("Content-Type", "image/png")
+			w.Write(logo)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, page)
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+
+	// archiving needs a blob store
+	req := &dpcc.HashPublicRequest{
+		Roster:       roster,
+		URL:          ts.URL,
+		Nonce:        lib.GenNonce(),
+		Algorithms:   []string{lib.SHA512},
+		Archive:      true,
+		Subresources: true,
+	}
+	_, err := s0.HashPublic(req)
+	require.NotNil(t, err)
+
+	dir, err := ioutil.TempDir("", "dpcc-archive")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	s0.blobs, err = openBlobStore(dir, defaultArchiveMaxSize, defaultArchiveMaxStore)
+	require.Nil(t, err)
+
+	resp, err := s0.HashPublic(req)
+	require.Nil(t, err)
+	require.NotNil(t, resp.Capture)
+	digest := sha256.Sum256([]byte(page))
+	require.Equal(t, digest[:], resp.Capture.Digest)
+	require.Equal(t, int64(len(page)), resp.Capture.Size)
+	require.Equal(t, 1, len(resp.Capture.Resources))
+	require.Equal(t, ts.URL+"/logo.png", resp.Capture.Resources[0].URL)
+
+	// the contents are sent back by digest
+	blob, err := s0.Blob(&dpcc.BlobRequest{Digest: resp.Capture.Digest})
+	require.Nil(t, err)
+	require.Equal(t, page, string(blob.Data))
+	require.Equal(t, int64(len(page)), blob.Size)
+	blob, err = s0.Blob(&dpcc.BlobRequest{Digest: resp.Capture.Resources[0].Digest})
+	require.Nil(t, err)
+	require.Equal(t, logo, blob.Data)
+	_, err = s0.Blob(&dpcc.BlobRequest{Digest: make([]byte, sha256.Size)})
+	require.NotNil(t, err)
+
+	// and read from an offset
+	blob, err = s0.Blob(&dpcc.BlobRequest{Digest: resp.Capture.Digest, Offset: 6})
+	require.Nil(t, err)
+	require.Equal(t, page[6:], string(blob.Data))
+	_, err = s0.Blob(&dpcc.BlobRequest{Digest: resp.Capture.Digest, Offset: int64(len(page) + 1)})
+	require.NotNil(t, err)
+
+	// the capture is part of the run
+	run, err := s0.HistoryRun(&dpcc.HistoryRunRequest{ID: resp.RunID})
+	require.Nil(t, err)
+	require.Equal(t, resp.Capture.Digest, run.Run.Capture.Digest)
+
+	// identical contents are stored once
+	req.Nonce = lib.GenNonce()
+	_, err = s0.HashPublic(req)
+	require.Nil(t, err)
+	countBlobs := func() int {
+		blobs := 0
+		filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err == nil && !info.IsDir() {
+				blobs++
+			}
+			return nil
+		})
+		return blobs
+	}
+	require.Equal(t, 2, countBlobs())
+	require.Equal(t, 2, s0.blobs.refs[hex.EncodeToString(resp.Capture.Digest)])
+	require.Equal(t, int64(len(page)+len(logo)), s0.blobs.size)
+
+	// the references are counted again when the conode restarts
+	require.Nil(t, s0.blobs.load(s0.storage.Runs))
+	require.Equal(t, 2, s0.blobs.refs[hex.EncodeToString(resp.Capture.Digest)])
+	require.Equal(t, int64(len(page)+len(logo)), s0.blobs.size)
+
+	// a capture larger than the limit is not kept
+	s0.blobs.maxSize = int64(len(page)) - 1
+	req.URL = ts.URL + "/other"
+	req.Nonce = lib.GenNonce()
+	resp, err = s0.HashPublic(req)
+	require.Nil(t, err)
+	require.Nil(t, resp.Capture)
+	require.Equal(t, 2, countBlobs())
+
+	// neither is a capture exceeding the room left in the store
+	s0.blobs.maxSize = defaultArchiveMaxSize
+	s0.blobs.maxStore = s0.blobs.size + 1
+	req.Nonce = lib.GenNonce()
+	resp, err = s0.HashPublic(req)
+	require.Nil(t, err)
+	require.Nil(t, resp.Capture)
+	require.Equal(t, 2, countBlobs())
+
+	// the contents are removed with the last runs that captured them
+	s0.historyMaxRuns = 1
+	req.URL = ts.URL
+	req.Archive = false
+	req.Nonce = lib.GenNonce()
+	_, err = s0.HashPublic(req)
+	require.Nil(t, err)
+	require.Equal(t, 0, countBlobs())
+	require.Equal(t, int64(0), s0.blobs.size)
+	require.Equal(t, 0, len(s0.blobs.refs))
+}
+
+func TestWARCService(t *testing.T) {
+	page := `<html><head><link rel="stylesheet" href="style.css"></head></html>`
+	style := "body { color: black; }"
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/style.css" {
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, style)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, page)
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+	dir, err := ioutil.TempDir("", "dpcc-warc")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	s0.blobs, err = openBlobStore(dir, defaultArchiveMaxSize, defaultArchiveMaxStore)
+	require.Nil(t, err)
+
+	req := &dpcc.HashPublicRequest{
+		Roster:       roster,
+		URL:          ts.URL,
+		Nonce:        lib.GenNonce(),
+		Algorithms:   []string{lib.SHA256},
+		Archive:      true,
+		Subresources: true,
+	}
+	resp, err := s0.HashPublic(req)
+	require.Nil(t, err)
+	_, err = s0.WARC(&dpcc.WARCRequest{ID: "unknown"})
+	require.NotNil(t, err)
+
+	// the run and its contents are read back from the WARC file
+	w, err := s0.WARC(&dpcc.WARCRequest{ID: resp.RunID})
+	require.Nil(t, err)
+	captures, err := dpcc.ReadWARC(bytes.NewReader(w.Data))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(captures))
+	require.Equal(t, resp.RunID, captures[0].Run.ID)
+	require.Equal(t, page, string(captures[0].Contents[ts.URL]))
+	require.Equal(t, style, string(captures[0].Contents[ts.URL+"/style.css"]))
+	require.Nil(t, dpcc.VerifyCapture(roster, captures[0]))
+
+	// all the archived runs of the URL are exported
+	req.Nonce = lib.GenNonce()
+	_, err = s0.HashPublic(req)
+	require.Nil(t, err)
+	w, err = s0.WARC(&dpcc.WARCRequest{URL: ts.URL})
+	require.Nil(t, err)
+	captures, err = dpcc.ReadWARC(bytes.NewReader(w.Data))
+	require.Nil(t, err)
+	require.Equal(t, 2, len(captures))
+	require.Equal(t, "", w.Next)
+
+	// the export goes on after the last run of the previous page
+	w, err = s0.WARC(&dpcc.WARCRequest{URL: ts.URL, After: resp.RunID})
+	require.Nil(t, err)
+	next, err := dpcc.ReadWARC(bytes.NewReader(w.Data))
+	require.Nil(t, err)
+	require.Equal(t, 1, len(next))
+	require.Equal(t, captures[1].Run.ID, next[0].Run.ID)
+
+	// and compressed record by record
+	var gz bytes.Buffer
+	require.Nil(t, dpcc.CopyWARC(lib.NewWARCWriter(&gz, true), bytes.NewReader(w.Data), true))
+	require.Equal(t, []byte{0x1f, 0x8b}, gz.Bytes()[:2])
+	next, err = dpcc.ReadWARC(&gz)
+	require.Nil(t, err)
+	require.Equal(t, 1, len(next))
+	require.Nil(t, dpcc.VerifyCapture(roster, next[0]))
+
+	// altered contents are detected
+	captures[1].Contents[ts.URL+"/style.css"] = []byte("body { color: red; }")
+	require.NotNil(t, dpcc.VerifyCapture(roster, captures[1]))
+}
+
+func TestReplayService(t *testing.T) {
+	page := `<html><head><link rel="stylesheet" href="style.css"></head>` +
+		`<body><a href="/about">about</a></body></html>`
+	style := `body { background: url(bg.png); }`
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/style.css" {
+			w.Header().Set("Content-Type", "text/css")
+			fmt.Fprint(w, style)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, page)
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+	dir, err := ioutil.TempDir("", "dpcc-replay")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	s0.blobs, err = openBlobStore(dir, defaultArchiveMaxSize, defaultArchiveMaxStore)
+	require.Nil(t, err)
+
+	resp, err := s0.HashPublic(&dpcc.HashPublicRequest{
+		Roster:       roster,
+		URL:          ts.URL,
+		Nonce:        lib.GenNonce(),
+		Algorithms:   []string{lib.SHA256},
+		Archive:      true,
+		Subresources: true,
+	})
+	require.Nil(t, err)
+	w, err := s0.WARC(&dpcc.WARCRequest{ID: resp.RunID})
+	require.Nil(t, err)
+	captures, err := dpcc.ReadWARC(bytes.NewReader(w.Data))
+	require.Nil(t, err)
+	replay, err := dpcc.NewReplay(roster, captures)
+	require.Nil(t, err)
+	rs := httptest.NewServer(replay)
+	defer rs.Close()
+
+	get := func(path string) (*http.Response, string) {
+		res, err := http.Get(rs.URL + path)
+		require.Nil(t, err)
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		require.Nil(t, err)
+		return res, string(body)
+	}
+
+	// the archived page is served sandboxed, with its links rewritten to
+	// the run and the banner of the archive
+	res, body := get(dpcc.ReplayPath(resp.RunID, ts.URL))
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "sandbox; default-src 'self' 'unsafe-inline' data:",
+		res.Header.Get("Content-Security-Policy"))
+	require.Equal(t, "text/html", res.Header.Get("Content-Type"))
+	require.True(t, strings.Contains(body, `href="`+dpcc.ReplayPath(resp.RunID, ts.URL+"/style.css")+`"`))
+	require.True(t, strings.Contains(body, `href="`+dpcc.ReplayPath(resp.RunID, ts.URL+"/about")+`"`))
+	require.True(t, strings.Contains(body, "dpcc archive"))
+	require.NotEqual(t, "", res.Header.Get("Memento-Datetime"))
+
+	// and so are its subresources
+	res, body = get(dpcc.ReplayPath(resp.RunID, ts.URL+"/style.css"))
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "sandbox; default-src 'self' 'unsafe-inline' data:",
+		res.Header.Get("Content-Security-Policy"))
+	require.Equal(t, `body { background: url("`+dpcc.ReplayPath(resp.RunID, ts.URL+"/bg.png")+`"); }`, body)
+	require.Equal(t, "", res.Header.Get("Memento-Datetime"))
+
+	// the runs are listed
+	res, body = get("/")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.True(t, strings.Contains(body, resp.RunID))
+
+	// contents that were not archived are not found
+	res, _ = get(dpcc.ReplayPath(resp.RunID, ts.URL+"/about"))
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = get(dpcc.ReplayPath("unknown", ts.URL))
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, err = http.Post(rs.URL+dpcc.ReplayPath(resp.RunID, ts.URL), "text/plain", nil)
+	require.Nil(t, err)
+	res.Body.Close()
+	require.Equal(t, http.StatusMethodNotAllowed, res.StatusCode)
+}
+
+func TestMementoService(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		fmt.Fprint(w, "<html><body>memento</body></html>")
+	}))
+	defer ts.Close()
+
+	local := onet.NewLocalTest(tSuite)
+	defer local.CloseAll()
+
+	nodes, roster, _ := local.GenBigTree(4, 4, 1, true)
+	s0 := local.GetServices(nodes, templateID)[0].(*Service)
+	dir, err := ioutil.TempDir("", "dpcc-memento")
+	require.Nil(t, err)
+	defer os.RemoveAll(dir)
+	s0.blobs, err = openBlobStore(dir, defaultArchiveMaxSize, defaultArchiveMaxStore)
+	require.Nil(t, err)
+
+	// two mementos signed at different times
+	var ids []string
+	for i := 0; i < 2; i++ {
+		if i > 0 {
+			time.Sleep(2 * time.Second)
+		}
+		resp, err := s0.HashPublic(&dpcc.HashPublicRequest{
+			Roster:     roster,
+			URL:        ts.URL,
+			Nonce:      lib.GenNonce(),
+			Algorithms: []string{lib.SHA256},
+			Archive:    true,
+		})
+		require.Nil(t, err)
+		require.NotNil(t, resp.Capture)
+		ids = append(ids, resp.RunID)
+	}
+
+	var fetches int32
+	replay := dpcc.NewArchiveReplay(roster, func(URL string) ([]*dpcc.WARCCapture, error) {
+		atomic.AddInt32(&fetches, 1)
+		w, err := s0.WARC(&dpcc.WARCRequest{URL: URL})
+		if err != nil {
+			return nil, nil
+		}
+		return dpcc.ReadWARC(bytes.NewReader(w.Data))
+	})
+	rs := httptest.NewServer(replay)
+	defer rs.Close()
+	client := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	get := func(path, datetime string) (*http.Response, string) {
+		req, err := http.NewRequest(http.MethodGet, rs.URL+path, nil)
+		require.Nil(t, err)
+		if datetime != "" {
+			req.Header.Set("Accept-Datetime", datetime)
+		}
+		res, err := client.Do(req)
+		require.Nil(t, err)
+		defer res.Body.Close()
+		body, err := ioutil.ReadAll(res.Body)
+		require.Nil(t, err)
+		return res, string(body)
+	}
+	httpTime := func(t int64) string {
+		return time.Unix(t, 0).UTC().Format(http.TimeFormat)
+	}
+
+	// the mementos are dated with the times signed by the conodes
+	var signed []int64
+	for _, id := range ids {
+		run, err := s0.HistoryRun(&dpcc.HistoryRunRequest{ID: id})
+		require.Nil(t, err)
+		signed = append(signed, dpcc.AgreedTime(run.Run, dpcc.AgreedGroup(run.Run, 3, lib.SHA256)))
+	}
+	require.True(t, signed[0] < signed[1])
+
+	timeGate := rs.URL + "/timegate/" + ts.URL
+	timeMap := rs.URL + "/timemap/link/" + ts.URL
+	for i, id := range ids {
+		res, _ := get(dpcc.ReplayPath(id, ts.URL), "")
+		require.Equal(t, http.StatusOK, res.StatusCode)
+		require.Equal(t, httpTime(signed[i]), res.Header.Get("Memento-Datetime"))
+		require.Equal(t, "<"+ts.URL+`>; rel="original", <`+timeGate+`>; rel="timegate", <`+
+			timeMap+`>; rel="timemap"; type="application/link-format"`, res.Header.Get("Link"))
+	}
+
+	// the TimeGate redirects to the memento nearest to the requested time,
+	// the last one by default and the earlier one between equally near ones
+	for _, v := range []struct {
+		datetime string
+		memento  int
+	}{
+		{"", 1},
+		{httpTime(signed[0] - 1000), 0},
+		{httpTime(signed[0]), 0},
+		{httpTime(signed[1]), 1},
+		{httpTime(signed[1] + 1000), 1},
+		{httpTime(signed[0] + (signed[1]-signed[0])/2), 0},
+	} {
+		res, _ := get("/timegate/"+ts.URL, v.datetime)
+		require.Equal(t, http.StatusFound, res.StatusCode)
+		require.Equal(t, rs.URL+dpcc.ReplayPath(ids[v.memento], ts.URL), res.Header.Get("Location"))
+		require.Equal(t, "accept-datetime", res.Header.Get("Vary"))
+		require.Equal(t, "<"+ts.URL+`>; rel="original", <`+timeMap+
+			`>; rel="timemap"; type="application/link-format"`, res.Header.Get("Link"))
+	}
+	res, _ := get("/timegate/"+ts.URL, "yesterday")
+	require.Equal(t, http.StatusBadRequest, res.StatusCode)
+
+	// the TimeMap lists the mementos with their signed times
+	res, body := get("/timemap/link/"+ts.URL, "")
+	require.Equal(t, http.StatusOK, res.StatusCode)
+	require.Equal(t, "application/link-format", res.Header.Get("Content-Type"))
+	require.True(t, strings.Contains(body, `<`+timeMap+`>; rel="self"; type="application/link-format"; from="`+
+		httpTime(signed[0])+`"; until="`+httpTime(signed[1])+`"`))
+	require.True(t, strings.Contains(body, `<`+rs.URL+dpcc.ReplayPath(ids[0], ts.URL)+
+		`>; rel="first memento"; datetime="`+httpTime(signed[0])+`"`))
+	require.True(t, strings.Contains(body, `<`+rs.URL+dpcc.ReplayPath(ids[1], ts.URL)+
+		`>; rel="last memento"; datetime="`+httpTime(signed[1])+`"`))
+
+	// the archived runs of an URL are fetched once in a while
+	require.Equal(t, int32(1), atomic.LoadInt32(&fetches))
+
+	// URLs that have not been archived have no mementos
+	res, _ = get("/timegate/"+ts.URL+"/other", "")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	require.Equal(t, "accept-datetime", res.Header.Get("Vary"))
+	res, _ = get("/timemap/link/"+ts.URL+"/other", "")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+	res, _ = get(dpcc.ReplayPath("unknown", ts.URL), "")
+	require.Equal(t, http.StatusNotFound, res.StatusCode)
+}
+
+func TestFetchLimits(t *testing.T) {
+	os.Setenv(envFetchMaxSize, "1024")
+	os.Setenv(envFetchTimeout, "2m")
+	defer os.Unsetenv(envFetchMaxSize)
+	defer os.Unsetenv(envFetchTimeout)
+	limits, err := fetchLimits()
+	require.Nil(t, err)
+	require.Equal(t, int64(1024), limits.MaxSize)
+	require.Equal(t, 2*time.Minute, limits.Timeout)
+	require.Equal(t, lib.DefaultFetchLimits.ProgressTimeout, limits.ProgressTimeout)
+	os.Setenv(envFetchTimeout, "0")
+	_, err = fetchLimits()
+	require.NotNil(t, err)
+
+	// the options of the clients can only restrict the limits
+	opts := (&lib.FetchOptions{MaxSize: 1 << 20, Timeout: time.Minute}).Bound(limits)
+	require.Equal(t, int64(1024), opts.MaxSize)
+	require.Equal(t, time.Minute, opts.Timeout)
+	require.Equal(t, limits.ProgressTimeout, opts.ProgressTimeout)
+
+	// the service only waits for a download as long as the client asked,
+	// within the limits of the conode
+	require.Nil(t, lib.SetFetchLimits(limits))
+	defer lib.SetFetchLimits(lib.DefaultFetchLimits)
+	require.Equal(t, defaultTimeout, protocolTimeout(nil))
+	require.Equal(t, defaultTimeout, protocolTimeout(&lib.FetchOptions{MaxSize: 10}))
+	require.Equal(t, time.Minute+defaultTimeout, protocolTimeout(&lib.FetchOptions{Timeout: time.Minute}))
+	require.Equal(t, 2*time.Minute+defaultTimeout, protocolTimeout(&lib.FetchOptions{Timeout: time.Hour}))
+}
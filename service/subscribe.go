@@ -0,0 +1,64 @@
+package service
+
+import (
+	"github.com/si-co/dpcc"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// subscriberBuffer is the number of events that wait for a slow subscriber
+// before new events are dropped for it
+const subscriberBuffer = 64
+
+// subscriber is a client streaming the events of the watches
+type subscriber struct {
+	req *dpcc.SubscribeRequest
+	out chan *dpcc.SubscribeResponse
+}
+
+// Subscribe streams the new events of the watches of the conode to the
+// client, until the client closes the connection
+func (s *Service) Subscribe(req *dpcc.SubscribeRequest) (chan *dpcc.SubscribeResponse, chan bool, error) {
+	sub := &subscriber{
+		req: req,
+		out: make(chan *dpcc.SubscribeResponse, subscriberBuffer),
+	}
+	// onet closes stop when the client leaves
+	stop := make(chan bool)
+
+	s.subscribersLock.Lock()
+	if s.subscribers == nil {
+		s.subscribers = make(map[*subscriber]bool)
+	}
+	s.subscribers[sub] = true
+	s.subscribersLock.Unlock()
+
+	go func() {
+		<-stop
+		s.subscribersLock.Lock()
+		delete(s.subscribers, sub)
+		close(sub.out)
+		s.subscribersLock.Unlock()
+	}()
+	return sub.out, stop, nil
+}
+
+// notify sends the events to the subscribers interested in them, without
+// waiting for the slow ones
+func (s *Service) notify(events []*dpcc.WatchEvent) {
+	s.subscribersLock.Lock()
+	defer s.subscribersLock.Unlock()
+	for sub := range s.subscribers {
+		for _, e := range events {
+			if (sub.req.WatchID != "" && sub.req.WatchID != e.WatchID) ||
+				(sub.req.URL != "" && sub.req.URL != e.URL) {
+				continue
+			}
+			select {
+			case sub.out <- &dpcc.SubscribeResponse{Event: e}:
+			default:
+				log.Warn(s.ServerIdentity(), "dropped event of watch", e.WatchID,
+					"for slow subscriber")
+			}
+		}
+	}
+}
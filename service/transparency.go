@@ -0,0 +1,300 @@
+package service
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/onet/v3/log"
+	"go.dedis.ch/onet/v3/network"
+)
+
+func init() {
+	network.RegisterMessages(&logSize{}, &logNode{}, &logLeaf{}, &logRun{})
+}
+
+// The transparency log is saved apart from the storage of the service, which
+// is saved on every run: every leaf and every root of a complete subtree is
+// saved once under its own key when it is appended, so that appending a run
+// and computing a proof only read and write a logarithmic number of records.
+// The data of the leaves are kept as well, so that the runs pruned from the
+// history can still be proven.
+
+// keys of the records of the transparency log
+var logSizeKey = []byte("tlog/size")
+
+func logNodeKey(level uint, index uint64) []byte {
+	return []byte(fmt.Sprintf("tlog/node/%d/%d", level, index))
+}
+
+func logLeafKey(index uint64) []byte {
+	return []byte(fmt.Sprintf("tlog/leaf/%d", index))
+}
+
+func logRunKey(ID string) []byte {
+	return []byte("tlog/run/" + ID)
+}
+
+// logSize is the number of leaves of the transparency log
+type logSize struct {
+	Size uint64
+}
+
+// logNode is the root of a complete subtree of the transparency log
+type logNode struct {
+	Hash []byte
+}
+
+// logLeaf is the data of a leaf of the transparency log and the ID of its run
+type logLeaf struct {
+	ID   string
+	Data []byte
+}
+
+// logRun is the index of the leaf of a run
+type logRun struct {
+	Index uint64
+}
+
+// transparencyLog is the transparency log of the runs led by the conode
+type transparencyLog struct {
+	s    *Service
+	tree *lib.MerkleTree
+	sync.Mutex
+}
+
+// Node loads the root of a complete subtree of the log, nil if not saved
+func (l *transparencyLog) Node(level uint, index uint64) ([]byte, error) {
+	msg, err := l.s.Load(logNodeKey(level, index))
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	n, ok := msg.(*logNode)
+	if !ok {
+		return nil, errors.New("data of wrong type")
+	}
+	return n.Hash, nil
+}
+
+// SetNode saves the root of a complete subtree of the log
+func (l *transparencyLog) SetNode(level uint, index uint64, hash []byte) error {
+	return l.s.Save(logNodeKey(level, index), &logNode{Hash: hash})
+}
+
+// leaf loads the data of the leaf at the index, nil if not saved
+func (l *transparencyLog) leaf(index uint64) (*logLeaf, error) {
+	msg, err := l.s.Load(logLeafKey(index))
+	if err != nil || msg == nil {
+		return nil, err
+	}
+	leaf, ok := msg.(*logLeaf)
+	if !ok {
+		return nil, errors.New("data of wrong type")
+	}
+	return leaf, nil
+}
+
+// runIndex loads the index of the leaf of a run, false if the run is not in
+// the log
+func (l *transparencyLog) runIndex(ID string) (uint64, bool, error) {
+	msg, err := l.s.Load(logRunKey(ID))
+	if err != nil || msg == nil {
+		return 0, false, err
+	}
+	r, ok := msg.(*logRun)
+	if !ok {
+		return 0, false, errors.New("data of wrong type")
+	}
+	return r.Index, true, nil
+}
+
+// append adds the data of a leaf to the log and returns its index. The size
+// of the log is saved last, so that a failed append is overwritten by the
+// next one. The log must be locked.
+func (l *transparencyLog) append(ID string, data []byte) (uint64, error) {
+	index := l.tree.Size
+	if err := l.s.Save(logLeafKey(index), &logLeaf{ID: ID, Data: data}); err != nil {
+		return 0, err
+	}
+	if err := l.s.Save(logRunKey(ID), &logRun{Index: index}); err != nil {
+		return 0, err
+	}
+	if err := l.tree.Append(lib.LeafHash(data)); err != nil {
+		return 0, err
+	}
+	if err := l.s.Save(logSizeKey, &logSize{Size: l.tree.Size}); err != nil {
+		l.tree.Size = index
+		return 0, err
+	}
+	return index, nil
+}
+
+// loadTransparencyLog loads the transparency log of the conode. The leaf
+// hashes kept in the storage by older versions are moved to the log, with
+// the data of the leaves of the runs still in the history.
+func (s *Service) loadTransparencyLog() error {
+	l := &transparencyLog{s: s}
+	l.tree = &lib.MerkleTree{Nodes: l}
+	msg, err := s.Load(logSizeKey)
+	if err != nil {
+		return err
+	}
+	if msg != nil {
+		size, ok := msg.(*logSize)
+		if !ok {
+			return errors.New("data of wrong type")
+		}
+		l.tree.Size = size.Size
+	}
+	s.tlog = l
+
+	if len(s.storage.Leaves) == 0 {
+		return nil
+	}
+	if l.tree.Size != 0 {
+		return errors.New("leaves both in the storage and in the transparency log")
+	}
+	runs := make(map[uint64]*dpcc.Run)
+	for _, run := range s.storage.Runs {
+		runs[run.LeafIndex] = run
+	}
+	for i, hash := range s.storage.Leaves {
+		index := uint64(i)
+		if run, ok := runs[index]; ok && bytes.Equal(hash, dpcc.RunLeaf(run)) {
+			err = s.Save(logLeafKey(index), &logLeaf{ID: run.ID, Data: dpcc.RunLeafData(run)})
+			if err == nil {
+				err = s.Save(logRunKey(run.ID), &logRun{Index: index})
+			}
+		}
+		if err == nil {
+			err = l.tree.Append(hash)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	if err := s.Save(logSizeKey, &logSize{Size: l.tree.Size}); err != nil {
+		return err
+	}
+	s.storage.Leaves = nil
+	s.save()
+	return nil
+}
+
+// appendLeaf adds the leaf of a run to the transparency log of the conode
+func (s *Service) appendLeaf(run *dpcc.Run) {
+	s.tlog.Lock()
+	defer s.tlog.Unlock()
+	index, err := s.tlog.append(run.ID, dpcc.RunLeafData(run))
+	if err != nil {
+		log.Error(s.ServerIdentity(), "couldn't add run", run.ID, "to the transparency log:", err)
+		return
+	}
+	run.LeafIndex = index
+}
+
+// treeHead returns the signed head of the tree of the first size leaves of
+// the transparency log, 0 for all of them. The log must be locked.
+func (s *Service) treeHead(size uint64) (*lib.TreeHead, error) {
+	if size == 0 {
+		size = s.tlog.tree.Size
+	}
+	if size > s.tlog.tree.Size {
+		return nil, errors.New("the transparency log is smaller than the requested size")
+	}
+	root, err := s.tlog.tree.Root(size)
+	if err != nil {
+		return nil, err
+	}
+	h := &lib.TreeHead{
+		Size:      size,
+		Timestamp: time.Now().Unix(),
+		Root:      root,
+		NodeKey:   lib.ServicePublic(s.ServerIdentity()),
+	}
+	if err := lib.SignTreeHead(s.ServerIdentity().ServicePrivate(dpcc.ServiceName), h); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// TreeHead sends the current signed head of the transparency log back to the
+// client
+func (s *Service) TreeHead(req *dpcc.TreeHeadRequest) (*dpcc.TreeHeadResponse, error) {
+	s.tlog.Lock()
+	defer s.tlog.Unlock()
+	h, err := s.treeHead(0)
+	if err != nil {
+		return nil, err
+	}
+	return &dpcc.TreeHeadResponse{Head: h}, nil
+}
+
+// InclusionProof sends a run led by the conode back to the client, with the
+// data of its leaf and its audit path in the tree of the requested size. The
+// run is left out if it has been pruned from the history.
+func (s *Service) InclusionProof(req *dpcc.InclusionProofRequest) (*dpcc.InclusionProofResponse, error) {
+	var run *dpcc.Run
+	s.storage.Lock()
+	if r, ok := s.storage.Runs[req.ID]; ok {
+		c := *r
+		run = &c
+	}
+	s.storage.Unlock()
+
+	s.tlog.Lock()
+	defer s.tlog.Unlock()
+	// runs recorded before the transparency log existed have no leaf
+	index, ok, err := s.tlog.runIndex(req.ID)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, errors.New("run " + req.ID + " is not in the transparency log")
+	}
+	leaf, err := s.tlog.leaf(index)
+	if err != nil {
+		return nil, err
+	}
+	if leaf == nil || leaf.ID != req.ID {
+		return nil, errors.New("missing leaf of run " + req.ID)
+	}
+	h, err := s.treeHead(req.Size)
+	if err != nil {
+		return nil, err
+	}
+	if index >= h.Size {
+		return nil, errors.New("run " + req.ID + " was added after the requested tree")
+	}
+	proof, err := s.tlog.tree.InclusionProof(index, h.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &dpcc.InclusionProofResponse{Run: run, Leaf: leaf.Data, Index: index,
+		Head: h, Proof: proof}, nil
+}
+
+// ConsistencyProof sends the proof that the tree of the first size is a prefix
+// of the tree of the second size back to the client, with the signed head of
+// the second tree
+func (s *Service) ConsistencyProof(req *dpcc.ConsistencyProofRequest) (*dpcc.ConsistencyProofResponse, error) {
+	s.tlog.Lock()
+	defer s.tlog.Unlock()
+
+	h, err := s.treeHead(req.Second)
+	if err != nil {
+		return nil, err
+	}
+	if req.First > h.Size {
+		return nil, errors.New("the first tree is larger than the second one")
+	}
+	proof, err := s.tlog.tree.ConsistencyProof(req.First, h.Size)
+	if err != nil {
+		return nil, err
+	}
+	return &dpcc.ConsistencyProofResponse{Head: h, Proof: proof}, nil
+}
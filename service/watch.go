@@ -0,0 +1,274 @@
+package service
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/si-co/dpcc"
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/kyber/v3"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// maxWatches bounds the number of watches of a conode
+const maxWatches = 100
+
+// minWatchInterval is the shortest time between two runs of a watch
+const minWatchInterval = time.Minute
+
+// maxWatchEvents bounds the number of events kept by a conode, the oldest
+// ones being removed first
+const maxWatchEvents = 1000
+
+// watchTick is the interval at which the conode looks for watches to run
+const watchTick = 10 * time.Second
+
+// WatchAdd registers a new watch, run by the conode as the leader of a hash
+// public protocol on the given schedule, on request of the operator, who
+// owns the watch
+func (s *Service) WatchAdd(req *dpcc.WatchAddRequest) (*dpcc.WatchAddResponse, error) {
+	payload, err := dpcc.WatchAddPayload(req)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.authorize(dpcc.ActionWatchAdd, payload, req.Operator); err != nil {
+		return nil, err
+	}
+	if req.URL == "" {
+		return nil, errors.New("please provide an URL")
+	}
+	if req.Roster == nil {
+		return nil, errors.New("please provide a roster")
+	}
+	if _, si := req.Roster.Search(s.ServerIdentity().ID); si == nil {
+		return nil, errors.New("conode not in the roster")
+	}
+	schedule, err := lib.ParseSchedule(req.Schedule)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Interval() < minWatchInterval {
+		return nil, errors.New("watches cannot run more often than every " + minWatchInterval.String())
+	}
+	next := schedule.Next(time.Now().UTC())
+	if next.IsZero() {
+		return nil, errors.New("the schedule never runs")
+	}
+	algorithms, err := lib.CheckAlgorithms(req.Algorithms)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &dpcc.Watch{
+		ID:         hex.EncodeToString(lib.GenNonce()[:16]),
+		Roster:     req.Roster,
+		URL:        req.URL,
+		Schedule:   req.Schedule,
+		Algorithms: algorithms,
+		Options:    req.Options,
+		Owner:      s.operator,
+		NextRun:    next.Unix(),
+	}
+	s.storage.Lock()
+	if len(s.storage.Watches) >= maxWatches {
+		s.storage.Unlock()
+		return nil, errors.New("too many watches on this conode")
+	}
+	if s.storage.Watches == nil {
+		s.storage.Watches = make(map[string]*dpcc.Watch)
+	}
+	s.storage.Watches[w.ID] = w
+	c := *w
+	s.storage.Unlock()
+	s.save()
+
+	s.startWatches()
+	log.Lvl2(s.ServerIdentity(), "watching", w.URL, "on schedule", w.Schedule)
+	return &dpcc.WatchAddResponse{Watch: &c}, nil
+}
+
+// WatchList sends the watches of the conode back to the client, ordered by
+// URL
+func (s *Service) WatchList(req *dpcc.WatchListRequest) (*dpcc.WatchListResponse, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	resp := &dpcc.WatchListResponse{}
+	for _, w := range s.storage.Watches {
+		c := *w
+		resp.Watches = append(resp.Watches, &c)
+	}
+	sort.Slice(resp.Watches, func(i, j int) bool {
+		a, b := resp.Watches[i], resp.Watches[j]
+		if a.URL != b.URL {
+			return a.URL < b.URL
+		}
+		return a.ID < b.ID
+	})
+	return resp, nil
+}
+
+// WatchRemove removes a watch of the conode, on request of its owner or of
+// the operator. Its events are kept.
+func (s *Service) WatchRemove(req *dpcc.WatchRemoveRequest) (*dpcc.WatchRemoveResponse, error) {
+	s.storage.Lock()
+	w, ok := s.storage.Watches[req.ID]
+	var owner kyber.Point
+	if ok {
+		owner = w.Owner
+	}
+	s.storage.Unlock()
+	if !ok {
+		return nil, errors.New("no watch with ID " + req.ID)
+	}
+	if _, err := s.authorizeKeys([]kyber.Point{owner, s.operator}, dpcc.ActionWatchRemove,
+		[]byte(req.ID), req.Operator); err != nil {
+		return nil, err
+	}
+
+	s.storage.Lock()
+	if _, ok := s.storage.Watches[req.ID]; !ok {
+		s.storage.Unlock()
+		return nil, errors.New("no watch with ID " + req.ID)
+	}
+	delete(s.storage.Watches, req.ID)
+	s.storage.Unlock()
+	s.save()
+	return &dpcc.WatchRemoveResponse{}, nil
+}
+
+// WatchEvents sends the events of the watches of the conode back to the
+// client, in chronological order
+func (s *Service) WatchEvents(req *dpcc.WatchEventsRequest) (*dpcc.WatchEventsResponse, error) {
+	s.storage.Lock()
+	defer s.storage.Unlock()
+	resp := &dpcc.WatchEventsResponse{}
+	for _, e := range s.storage.Events {
+		if (req.WatchID == "" || e.WatchID == req.WatchID) && e.Time >= req.Since {
+			resp.Events = append(resp.Events, e)
+		}
+	}
+	return resp, nil
+}
+
+// startWatches starts looking for watches to run, once per service
+func (s *Service) startWatches() {
+	s.watchOnce.Do(func() {
+		go func() {
+			for now := range time.NewTicker(watchTick).C {
+				s.runDueWatches(now)
+			}
+		}()
+	})
+}
+
+// runDueWatches runs the watches whose time has come, and schedules their
+// next run
+func (s *Service) runDueWatches(now time.Time) {
+	var due []*dpcc.Watch
+	s.storage.Lock()
+	for _, w := range s.storage.Watches {
+		if w.NextRun > now.Unix() {
+			continue
+		}
+		schedule, err := lib.ParseSchedule(w.Schedule)
+		if err != nil {
+			log.Error("invalid schedule of watch", w.ID, ":", err)
+			continue
+		}
+		// a watch that is late because the conode was down only runs
+		// once
+		w.NextRun = schedule.Next(now.UTC()).Unix()
+		c := *w
+		due = append(due, &c)
+	}
+	s.storage.Unlock()
+	if len(due) == 0 {
+		return
+	}
+	s.save()
+
+	for _, w := range due {
+		go s.runWatch(w)
+	}
+}
+
+// runWatch runs the hash public protocol for a watch and records an event if
+// the verdict of the conodes changed since the previous run
+func (s *Service) runWatch(watch *dpcc.Watch) {
+	_, run, err := s.hashPublic(&dpcc.HashPublicRequest{
+		Roster:     watch.Roster,
+		URL:        watch.URL,
+		Nonce:      lib.GenNonce(),
+		Algorithms: watch.Algorithms,
+		Options:    watch.Options,
+	})
+	now := time.Now().Unix()
+
+	s.storage.Lock()
+	w, ok := s.storage.Watches[watch.ID]
+	if !ok {
+		// the watch has been removed meanwhile
+		s.storage.Unlock()
+		return
+	}
+	first := w.LastRun == 0
+	w.LastRun = now
+	var events []*dpcc.WatchEvent
+	event := func(kind, runID, details string) {
+		events = append(events, &dpcc.WatchEvent{
+			WatchID: w.ID,
+			URL:     w.URL,
+			Time:    now,
+			Kind:    kind,
+			RunID:   runID,
+			Details: details,
+		})
+	}
+
+	switch {
+	case err != nil:
+		// the conodes couldn't fetch the resource or didn't answer
+		w.LastRunID = ""
+		if w.Reachable || first {
+			event(dpcc.EventUnreachable, "", err.Error())
+		}
+		w.Reachable = false
+		w.Agreed = false
+	default:
+		w.LastRunID = run.ID
+		w.Reachable = true
+		g := dpcc.AgreedGroup(run, len(w.Roster.List)-1, lib.PrimaryAlgorithm(w.Algorithms))
+		if g == nil {
+			if w.Agreed || first {
+				event(dpcc.EventDisagreement, run.ID, "no digest signed by two thirds of the "+
+					strconv.Itoa(len(run.Responses))+" conodes")
+			}
+			w.Agreed = false
+			break
+		}
+		if w.Digest != nil && !bytes.Equal(w.Digest, g.Digest) {
+			event(dpcc.EventContentChanged, run.ID, g.Algorithm+" hash changed from "+
+				base64.StdEncoding.EncodeToString(w.Digest)+" to "+
+				base64.StdEncoding.EncodeToString(g.Digest))
+		}
+		w.Digest = g.Digest
+		w.Agreed = true
+	}
+
+	s.storage.Events = append(s.storage.Events, events...)
+	if len(s.storage.Events) > maxWatchEvents {
+		s.storage.Events = s.storage.Events[len(s.storage.Events)-maxWatchEvents:]
+	}
+	s.storage.Unlock()
+	s.save()
+
+	for _, e := range events {
+		log.Lvl2(s.ServerIdentity(), "watch", e.WatchID, "of", e.URL, ":", e.Kind)
+	}
+	s.notify(events)
+}
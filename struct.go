@@ -1,6 +1,8 @@
 package dpcc
 
 import (
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/cothority/v3/byzcoin"
 	"go.dedis.ch/kyber/v3"
 	"go.dedis.ch/onet/v3"
 	"go.dedis.ch/onet/v3/network"
@@ -10,21 +12,51 @@ import (
 func init() {
 	network.RegisterMessages(HashPublicRequest{}, HashPublicResponse{})
 	network.RegisterMessages(HashPrivateRequest{}, HashPrivateResponse{})
+	network.RegisterMessages(DOMConsensusRequest{}, DOMConsensusResponse{})
+	network.RegisterMessages(DKGRequest{}, DKGResponse{})
+	network.RegisterMessages(HashEqualityRequest{}, HashEqualityResponse{})
+	network.RegisterMessages(HashShuffleRequest{}, HashShuffleResponse{})
+	network.RegisterMessages(HistoryRequest{}, HistoryResponse{})
+	network.RegisterMessages(HistoryRunRequest{}, HistoryRunResponse{})
+	network.RegisterMessages(AgreedHashRequest{}, AgreedHashResponse{})
+	network.RegisterMessages(WatchAddRequest{}, WatchAddResponse{})
+	network.RegisterMessages(WatchListRequest{}, WatchListResponse{})
+	network.RegisterMessages(WatchRemoveRequest{}, WatchRemoveResponse{})
+	network.RegisterMessages(WatchEventsRequest{}, WatchEventsResponse{})
+	network.RegisterMessages(SubscribeRequest{}, SubscribeResponse{})
+	network.RegisterMessages(LogProofRequest{}, LogProofResponse{})
+	network.RegisterMessages(LogEntry{}, Run{})
+	network.RegisterMessages(TreeHeadRequest{}, TreeHeadResponse{})
+	network.RegisterMessages(InclusionProofRequest{}, InclusionProofResponse{})
+	network.RegisterMessages(ConsistencyProofRequest{}, ConsistencyProofResponse{})
+	network.RegisterMessages(BlobRequest{}, BlobResponse{})
+	network.RegisterMessages(WARCRequest{}, WARCResponse{})
 }
 
 // HashPublicRequest is used by the client to send a request of a hash public
 // protocol to the leader of the roster
 type HashPublicRequest struct {
-	Roster *onet.Roster
-	URL    string
-	Nonce  []byte
+	Roster     *onet.Roster
+	URL        string
+	Nonce      []byte
+	Algorithms []string
+	Options    *lib.FetchOptions
+	// Diff asks the leader for a diff of the versions of the resource
+	// when the conodes disagree
+	Diff bool
+	// Archive asks the leader to keep the content of the resource if the
+	// conodes agree on its SHA-256 hash, and the content of its
+	// subresources as well if Subresources is set
+	Archive      bool
+	Subresources bool
 }
 
 // HashPublicSingleResponse is a helper for HashPublicResponse and stores the
 // response of a single worker in the roster for the hash public protocol
 type HashPublicSingleResponse struct {
 	PubliKey  kyber.Point
-	Hash      []byte
+	Digests   []*lib.Digest
+	Timestamp int64
 	Signature []byte
 }
 
@@ -32,6 +64,70 @@ type HashPublicSingleResponse struct {
 // of the hash public protocol to the client
 type HashPublicResponse struct {
 	Responses map[string]*HashPublicSingleResponse
+	Verdict   *Verdict
+	Diff      *DiffReport
+	// ID of the run in the history of the leader
+	RunID string
+	// content kept by the leader, if archiving was requested and the
+	// conodes agreed
+	Capture *Capture
+}
+
+// Verdict summarizes the agreement between the conodes of the roster
+type Verdict struct {
+	// conodes grouped by identical digests, for every algorithm
+	Groups []*DigestGroup
+	// pairwise similarity between conodes, for every similarity algorithm
+	Similarities []*SimilarityScore
+}
+
+// DigestGroup stores the conodes that computed the same digest
+type DigestGroup struct {
+	Algorithm string
+	Digest    []byte
+	Nodes     []string
+}
+
+// SimilarityScore stores the similarity between the resources seen by two
+// conodes, from 0 for completely different resources to 1 for resources that
+// are identical according to the similarity algorithm
+type SimilarityScore struct {
+	Algorithm string
+	NodeA     string
+	NodeB     string
+	Score     float64
+}
+
+// DiffReport shows how the versions of the resource seen by the groups of
+// conodes that computed the same hash differ
+type DiffReport struct {
+	// algorithm used to form the groups
+	Algorithm string
+	// versions signed by a representative of every group, the first one
+	// being the version of the largest group
+	Versions []*ContentVersion
+	// diffs between the first version and every other version
+	Diffs []*ContentDiff
+}
+
+// ContentVersion stores the canonicalized content of the resource signed by
+// the representative of a group
+type ContentVersion struct {
+	Node      string
+	PublicKey kyber.Point
+	Digest    *lib.Digest
+	Format    string
+	Content   []byte
+	Timestamp int64
+	Signature []byte
+}
+
+// ContentDiff stores the edits from the version of NodeA to the version of
+// NodeB
+type ContentDiff struct {
+	NodeA string
+	NodeB string
+	Edits []*lib.DiffEdit
 }
 
 // HashPrivateRequest is used by the client to send a request of a hash private
@@ -39,7 +135,10 @@ type HashPublicResponse struct {
 type HashPrivateRequest struct {
 	Roster           *onet.Roster
 	URL              string
-	ClientPublicKeys map[string]kyber.Point
+	Nonce            []byte
+	ClientPublicKeys map[string][]byte
+	Algorithms       []string
+	Options          *lib.FetchOptions
 }
 
 // HashPrivateSingleResponse is a helper for HashPrivateResponse and stores the
@@ -47,12 +146,524 @@ type HashPrivateRequest struct {
 type HashPrivateSingleResponse struct {
 	PublicKey     kyber.Point
 	EncryptedHash []byte
-	Nonce         []byte
+	Encapsulation []byte
+	Timestamp     int64
+	Signature     []byte
+}
+
+// HashPrivateDigests stores the digests of a single conode, once they have
+// been decrypted by the client
+type HashPrivateDigests struct {
+	Digests []*lib.Digest
 }
 
 // HashPrivateResponse is used by the leader of the protocol to send the
 // results of the hash private protocol back to the client
 type HashPrivateResponse struct {
-	Hashes    map[string][]byte
+	Digests   map[string]*HashPrivateDigests
 	Responses map[string]*HashPrivateSingleResponse
 }
+
+// DOMConsensusRequest is used by the client to ask the leader of the roster
+// for a collectively signed consensus version of an HTML page
+type DOMConsensusRequest struct {
+	Roster *onet.Roster
+	URL    string
+	Nonce  []byte
+	// number of conodes that must have observed a node of the page to
+	// keep it, 0 for two thirds of the conodes
+	Threshold int
+	Options   *lib.FetchOptions
+	Mode      ConsensusMode
+}
+
+// ConsensusMode is what the conodes send the leader of a DOM consensus
+type ConsensusMode int
+
+const (
+	// ConsensusPlain asks the conodes to send the hashes of the page
+	ConsensusPlain ConsensusMode = iota
+	// ConsensusPrivate asks the conodes to send masked Bloom filters of
+	// the page instead of its hashes, so that the leader does not learn
+	// the version seen by each conode
+	ConsensusPrivate
+	// ConsensusEncrypted asks the conodes to send signed Bloom filters
+	// encrypted under the distributed key of the roster, so that only the
+	// sum of the filters is ever decrypted
+	ConsensusEncrypted
+)
+
+// Valid tells if the mode is known
+func (m ConsensusMode) Valid() bool {
+	return m >= ConsensusPlain && m <= ConsensusEncrypted
+}
+
+// Flags returns the flags of the statement signed by the conodes in the mode
+func (m ConsensusMode) Flags() uint32 {
+	return lib.DOMConsensusFlags(m == ConsensusPrivate, m == ConsensusEncrypted)
+}
+
+// DOMConsensusResponse is used by the leader to send the consensus document
+// and its collective signature back to the client
+type DOMConsensusResponse struct {
+	Document  []byte
+	Signers   []kyber.Point
+	Timestamp int64
+	Signature []byte
+}
+
+// ActionRefreshDKG is the action of the operator requests renewing the
+// shares of the distributed key of a roster
+const ActionRefreshDKG = "refresh-dkg"
+
+// DKGRequest is used by the client to ask the leader of the roster for the
+// distributed key of the roster, which is generated if the roster has none
+// yet. The shares of an existing key are only renewed, or reshared with
+// another threshold, on request of the operator of the leader.
+type DKGRequest struct {
+	Roster *onet.Roster
+	// number of conodes needed to decrypt, 0 for two thirds of the
+	// conodes
+	Threshold int
+	// Refresh asks for new shares of the key even if the threshold did
+	// not change
+	Refresh bool
+	// Operators authorize the refresh on the conodes, with the payload of
+	// DKGPayload: every conode looks for a request signed by its operator
+	Operators []*lib.OperatorRequest
+}
+
+// DKGResponse is used by the leader to send the distributed key of the
+// roster back to the client
+type DKGResponse struct {
+	Public    kyber.Point
+	Threshold int
+}
+
+// HashEqualityRequest is used by the client to ask the leader of the roster
+// whether the conodes see the same version of a resource, without learning
+// their digests
+type HashEqualityRequest struct {
+	Roster    *onet.Roster
+	URL       string
+	Nonce     []byte
+	Algorithm string
+	Options   *lib.FetchOptions
+}
+
+// HashEqualityResponse is used by the leader to send the groups of conodes
+// that computed the same digest back to the client, with the transcript of
+// the test from which the client recomputes the groups
+type HashEqualityResponse struct {
+	// number of conodes that took part in the test
+	Nodes  int
+	Groups []*EqualityGroup
+	// commitments of the shares of the distributed key, signed by every
+	// conode of the roster, indexed by service public key
+	Commits       []kyber.Point
+	KeySignatures map[string][]byte
+	// ciphertexts of the digests signed with the nonce, blindings of the
+	// differences by every node and partial decryptions of their sums
+	Inputs    []*lib.SignedCiphertext
+	Blindings []*lib.Blinding
+	Partials  []*lib.PartialDecryption
+}
+
+// EqualityGroup is a set of conodes, identified by their public keys, that
+// computed the same digest
+type EqualityGroup struct {
+	Nodes []string
+}
+
+// HashShuffleRequest is used by the client to ask the leader of the roster
+// for the digests of a resource computed by the conodes, without learning
+// which conode computed which digest
+type HashShuffleRequest struct {
+	Roster    *onet.Roster
+	URL       string
+	Nonce     []byte
+	Algorithm string
+	Options   *lib.FetchOptions
+}
+
+// HashShuffleResponse is used by the leader to send the shuffled digests back
+// to the client, truncated to the bytes embedded in a point, together with
+// the encrypted digests and their shuffles so that the client can verify
+// them
+type HashShuffleResponse struct {
+	Algorithm string
+	Digests   [][]byte
+	DistKey   kyber.Point
+	// ciphertexts of the digests signed with the nonce, signed shuffles by
+	// every conode and partial decryptions of the output of the last one
+	Inputs   []*lib.SignedCiphertext
+	Steps    []*lib.ShuffleStep
+	Partials []*lib.PartialDecryption
+	// commitments of the shares of the distributed key, signed by every
+	// conode of the roster, indexed by service public key
+	Commits       []kyber.Point
+	KeySignatures map[string][]byte
+}
+
+// Run is the result of a protocol led by a conode, as kept in its history.
+// It holds everything needed to verify the statements of the conodes again.
+type Run struct {
+	ID  string
+	URL string
+	// Unix time in seconds at which the run finished
+	Time int64
+	// name of the protocol that was run
+	Mode  string
+	Nonce []byte
+	// number of conodes asked by the leader, the leader excluded
+	Nodes int
+	// signed responses of the conodes, indexed by their service public key
+	Responses map[string]*RunResponse
+	// verdict of the conodes, for hash public runs
+	Verdict *Verdict
+	// collectively signed consensus document, for DOM consensus runs
+	Document  []byte
+	Signers   []kyber.Point
+	Flags     uint32
+	Timestamp int64
+	Signature []byte
+	// key of the event of the run in the tamper-evident log of the conodes,
+	// once it has been logged
+	LogID []byte
+	// index of the leaf of the run in the transparency log of its leader
+	LeafIndex uint64
+	// content of the resource kept by the leader, for archived hash public
+	// runs
+	Capture *Capture
+}
+
+// Capture describes the content of a resource kept by the leader of a run in
+// which the conodes agreed on its SHA-256 hash. The contents are stored as
+// blobs keyed by their SHA-256 digest, so identical contents are kept once.
+type Capture struct {
+	// digest of the resource, signed by the conodes
+	Digest      []byte
+	ContentType string
+	Size        int64
+	// status line and header of the HTTP response
+	Status string
+	Header []byte
+	// subresources of an HTML page, fetched by the leader alone and
+	// therefore not attested by the other conodes
+	Resources []*CaptureResource
+}
+
+// CaptureResource is a subresource of a captured page
+type CaptureResource struct {
+	URL         string
+	ContentType string
+	Size        int64
+	Status      string
+	Header      []byte
+	// SHA-256 digest of the blob of the subresource
+	Digest []byte
+}
+
+// RunResponse is the signed response of a single conode in a run. The
+// digests of hash private runs are only known to the client, so only their
+// ciphertext is kept.
+type RunResponse struct {
+	PublicKey     kyber.Point
+	Digests       []*lib.Digest
+	EncryptedHash []byte
+	Encapsulation []byte
+	Timestamp     int64
+	Signature     []byte
+}
+
+// HistoryRequest is used by the client to list the runs for an URL led by a
+// conode
+type HistoryRequest struct {
+	URL string
+	// bounds of the time of the runs, as Unix times in seconds, 0 for no
+	// bound
+	Since int64
+	Until int64
+	// maximal number of runs, the most recent ones being kept, 0 for no
+	// limit
+	Limit int
+}
+
+// HistoryResponse lists the runs for an URL in chronological order
+type HistoryResponse struct {
+	Runs []*RunSummary
+}
+
+// RunSummary describes a run of the history without its responses
+type RunSummary struct {
+	ID   string
+	URL  string
+	Time int64
+	Mode string
+	// number of conodes that answered
+	Nodes int
+}
+
+// HistoryRunRequest is used by the client to fetch a run led by a conode
+type HistoryRunRequest struct {
+	ID string
+}
+
+// HistoryRunResponse contains the requested run
+type HistoryRunResponse struct {
+	Run *Run
+}
+
+// AgreedHashRequest is used by the client to ask a conode for the last digest
+// of an URL on which the conodes agreed at a given time
+type AgreedHashRequest struct {
+	URL string
+	// Unix time in seconds, 0 for now
+	Time int64
+	// digest algorithm, lib.DefaultAlgorithm if empty
+	Algorithm string
+}
+
+// AgreedHashResponse contains the last hash public run for the URL in which
+// the conodes agreed, and the group of conodes that agreed
+type AgreedHashResponse struct {
+	Run   *Run
+	Group *DigestGroup
+}
+
+// kinds of the events of a watch
+const (
+	// EventContentChanged is recorded when the conodes agree on another
+	// digest than in the previous run
+	EventContentChanged = "content changed"
+	// EventDisagreement is recorded when the conodes stop agreeing on the
+	// digest of the resource
+	EventDisagreement = "conodes started disagreeing"
+	// EventUnreachable is recorded when the conodes cannot fetch the
+	// resource any more
+	EventUnreachable = "site unreachable"
+)
+
+// Watch is a hash public protocol run on a schedule by a conode, which
+// records an event whenever the verdict of the conodes changes
+type Watch struct {
+	ID     string
+	Roster *onet.Roster
+	URL    string
+	// schedule of the runs, see lib.ParseSchedule
+	Schedule   string
+	Algorithms []string
+	Options    *lib.FetchOptions
+	// key of the operator who added the watch, who can remove it
+	Owner kyber.Point
+
+	// state of the watch, as Unix times in seconds
+	NextRun   int64
+	LastRun   int64
+	LastRunID string
+	// digest the conodes agreed on in the last run, for the primary
+	// algorithm
+	Digest []byte
+	// whether the conodes agreed and could fetch the resource in the last
+	// run
+	Agreed    bool
+	Reachable bool
+}
+
+// WatchEvent is a change of the verdict of the conodes on a watched URL
+type WatchEvent struct {
+	WatchID string
+	URL     string
+	Time    int64
+	Kind    string
+	// run of the history in which the change was seen, empty if the run
+	// failed
+	RunID   string
+	Details string
+}
+
+// actions of the operator requests managing the watches of a conode
+const (
+	ActionWatchAdd    = "watch-add"
+	ActionWatchRemove = "watch-remove"
+)
+
+// WatchAddRequest is used by the operator to ask a conode to watch an URL
+type WatchAddRequest struct {
+	Roster     *onet.Roster
+	URL        string
+	Schedule   string
+	Algorithms []string
+	Options    *lib.FetchOptions
+	// signature of the operator of the conode over WatchAddPayload
+	Operator *lib.OperatorRequest
+}
+
+// WatchAddResponse contains the new watch
+type WatchAddResponse struct {
+	Watch *Watch
+}
+
+// WatchListRequest is used by the client to list the watches of a conode
+type WatchListRequest struct {
+}
+
+// WatchListResponse lists the watches of a conode
+type WatchListResponse struct {
+	Watches []*Watch
+}
+
+// WatchRemoveRequest is used by the owner of a watch or the operator of the
+// conode to remove the watch
+type WatchRemoveRequest struct {
+	ID string
+	// signature over the ID of the watch
+	Operator *lib.OperatorRequest
+}
+
+// WatchRemoveResponse confirms the removal of a watch
+type WatchRemoveResponse struct {
+}
+
+// WatchEventsRequest is used by the client to list the events of the
+// watches of a conode, of all of them if WatchID is empty
+type WatchEventsRequest struct {
+	WatchID string
+	// Unix time in seconds of the oldest event, 0 for all of them
+	Since int64
+}
+
+// WatchEventsResponse lists events in chronological order
+type WatchEventsResponse struct {
+	Events []*WatchEvent
+}
+
+// SubscribeRequest is used by the client to receive the events of the
+// watches of a conode as they are recorded, only for the watch with WatchID
+// or for the URL if they are not empty
+type SubscribeRequest struct {
+	WatchID string
+	URL     string
+}
+
+// SubscribeResponse is streamed by the conode for every new event
+type SubscribeResponse struct {
+	Event *WatchEvent
+}
+
+// LogTopic is the topic of the events appended by the conodes to the
+// eventlog
+const LogTopic = "dpcc"
+
+// LogEntry is the collectively agreed result of a run, appended by its leader
+// to the tamper-evident log. Hash public entries hold the signed responses of
+// the conodes that agreed, DOM consensus entries the collective signature of
+// the consensus document, which is needed to verify it.
+type LogEntry struct {
+	RunID string
+	URL   string
+	Mode  string
+	// Unix time in seconds at which the run finished
+	Time  int64
+	Nonce []byte
+	// agreed digest, or SHA-256 hash of the consensus document
+	Algorithm string
+	Digest    []byte
+	// responses of the conodes that signed the agreed digest, indexed by
+	// their service public key
+	Responses map[string]*RunResponse
+	// collective signature of the consensus document
+	Signers   []kyber.Point
+	Flags     uint32
+	Timestamp int64
+	Signature []byte
+}
+
+// LogProofRequest is used by the client to get the proof that a run led by a
+// conode is in the tamper-evident log
+type LogProofRequest struct {
+	ID string
+}
+
+// LogProofResponse holds the run and the proof of its event in the ledger,
+// which the client verifies against the ID of the ledger it trusts
+type LogProofResponse struct {
+	Run   *Run
+	Proof *byzcoin.Proof
+}
+
+// TreeHeadRequest is used by the client to get the current signed head of the
+// transparency log of a conode
+type TreeHeadRequest struct {
+}
+
+// TreeHeadResponse holds the signed head of the transparency log
+type TreeHeadResponse struct {
+	Head *lib.TreeHead
+}
+
+// InclusionProofRequest is used by the client to get the proof that a run
+// led by a conode is in its transparency log
+type InclusionProofRequest struct {
+	ID string
+	// size of the tree of the proof, 0 for the current size
+	Size uint64
+}
+
+// InclusionProofResponse holds the run, nil if it has been pruned from the
+// history, the data and the index of its leaf, the signed head of the tree
+// of the requested size and the audit path of the leaf
+type InclusionProofResponse struct {
+	Run   *Run
+	Leaf  []byte
+	Index uint64
+	Head  *lib.TreeHead
+	Proof [][]byte
+}
+
+// ConsistencyProofRequest is used by the client to get the proof that the
+// transparency log of a conode only grew since a tree head it saw
+type ConsistencyProofRequest struct {
+	First uint64
+	// size of the second tree, 0 for the current size
+	Second uint64
+}
+
+// ConsistencyProofResponse holds the signed head of the second tree and the
+// proof that the first tree is a prefix of it
+type ConsistencyProofResponse struct {
+	Head  *lib.TreeHead
+	Proof [][]byte
+}
+
+// BlobRequest is used by the client to get an archived content from a conode,
+// chunk by chunk
+type BlobRequest struct {
+	// SHA-256 digest of the content
+	Digest []byte
+	// offset of the chunk in the content
+	Offset int64
+}
+
+// BlobResponse holds a chunk of the archived content and the size of the
+// content
+type BlobResponse struct {
+	Data []byte
+	Size int64
+}
+
+// WARCRequest is used by the client to export archived runs led by a conode
+// as a WARC file, either a single run or all the runs of an URL, page by page
+type WARCRequest struct {
+	ID  string
+	URL string
+	// ID of the last run of the previous page, empty for the first page
+	After string
+}
+
+// WARCResponse holds a page of the WARC file of the runs, and the ID of its
+// last run if more runs follow
+type WARCResponse struct {
+	Data []byte
+	Next string
+}
@@ -0,0 +1,76 @@
+package dpcc
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/onet/v3/log"
+)
+
+// NewVerdict groups the conodes that computed the same digests and computes
+// the pairwise similarity of the conodes for the similarity digests. The
+// client computes it again from the signed digests to check the verdict of
+// the leader.
+func NewVerdict(responses map[string]*HashPublicSingleResponse) *Verdict {
+	// iterate over the conodes always in the same order
+	nodes := make([]string, 0, len(responses))
+	for pk := range responses {
+		nodes = append(nodes, pk)
+	}
+	sort.Strings(nodes)
+
+	v := &Verdict{}
+	groups := make(map[string]*DigestGroup)
+	for _, pk := range nodes {
+		for _, d := range responses[pk].Digests {
+			key := d.Algorithm + "/" + string(d.Value)
+			g, ok := groups[key]
+			if !ok {
+				g = &DigestGroup{Algorithm: d.Algorithm, Digest: d.Value}
+				groups[key] = g
+				v.Groups = append(v.Groups, g)
+			}
+			g.Nodes = append(g.Nodes, pk)
+		}
+	}
+
+	// the largest groups first
+	sort.SliceStable(v.Groups, func(i, j int) bool {
+		a, b := v.Groups[i], v.Groups[j]
+		if a.Algorithm != b.Algorithm {
+			return a.Algorithm < b.Algorithm
+		}
+		if len(a.Nodes) != len(b.Nodes) {
+			return len(a.Nodes) > len(b.Nodes)
+		}
+		return bytes.Compare(a.Digest, b.Digest) < 0
+	})
+
+	for i, a := range nodes {
+		for _, b := range nodes[i+1:] {
+			for _, d := range responses[a].Digests {
+				if !lib.IsSimilarity(d.Algorithm) {
+					continue
+				}
+				other := lib.FindDigest(responses[b].Digests, d.Algorithm)
+				if other == nil {
+					continue
+				}
+				score, err := lib.Similarity(d.Algorithm, d.Value, other)
+				if err != nil {
+					log.Lvl2("couldn't compare", a, "and", b, ":", err)
+					continue
+				}
+				v.Similarities = append(v.Similarities, &SimilarityScore{
+					Algorithm: d.Algorithm,
+					NodeA:     a,
+					NodeB:     b,
+					Score:     score,
+				})
+			}
+		}
+	}
+
+	return v
+}
@@ -0,0 +1,262 @@
+package dpcc
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/url"
+	"time"
+
+	"github.com/si-co/dpcc/lib"
+	"go.dedis.ch/cothority/v3"
+	"go.dedis.ch/onet/v3"
+	"go.dedis.ch/onet/v3/network"
+)
+
+// fields and content types of the WARC records written for captures
+const (
+	// warcRunField links the records of a capture to its run
+	warcRunField = "DPCC-Run-ID"
+	// warcRunType is the content type of the metadata records holding the
+	// protobuf encoding of a run, which attests the capture
+	warcRunType         = "application/vnd.dpcc.run"
+	warcRequestType     = "application/http; msgtype=request"
+	warcResponseType    = "application/http; msgtype=response"
+	warcInfoType        = "application/warc-fields"
+	warcInfoDescription = "software: dpcc\r\nformat: WARC File Format 1.1\r\n"
+	warcDefaultStatus   = "HTTP/1.1 200 OK"
+)
+
+// WARCCapture is an archived run read from a WARC file, with the contents of
+// its resource and subresources indexed by URL
+type WARCCapture struct {
+	Run      *Run
+	Contents map[string][]byte
+}
+
+// WriteWARC writes archived runs as a WARC 1.1 file. For the resource and
+// every subresource of a run, it writes a request record and a response
+// record, and a metadata record holding the run follows the response of the
+// resource. blob returns the content with a SHA-256 digest.
+func WriteWARC(w io.Writer, runs []*Run, blob func(digest []byte) ([]byte, error)) error {
+	if err := WriteWARCInfo(w); err != nil {
+		return err
+	}
+	for _, run := range runs {
+		if err := WriteWARCRun(w, run, blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// WriteWARCInfo writes the warcinfo record starting a WARC file
+func WriteWARCInfo(w io.Writer) error {
+	info := lib.NewWARCRecord(lib.WARCInfo, time.Now(), []byte(warcInfoDescription))
+	info.Set("Content-Type", warcInfoType)
+	return lib.WriteWARCRecord(w, info)
+}
+
+// WriteWARCRun writes the records of an archived run, as WriteWARC
+func WriteWARCRun(w io.Writer, run *Run, blob func(digest []byte) ([]byte, error)) error {
+	c := run.Capture
+	if c == nil {
+		return errors.New("run " + run.ID + " has not been archived")
+	}
+	date := time.Unix(run.Time, 0)
+	response, err := writeWARCExchange(w, run.ID, date, run.URL, c.Status, c.Header,
+		c.ContentType, c.Digest, blob)
+	if err != nil {
+		return err
+	}
+	buf, err := network.Marshal(run)
+	if err != nil {
+		return err
+	}
+	meta := lib.NewWARCRecord(lib.WARCMetadata, date, buf)
+	meta.Set("WARC-Target-URI", run.URL)
+	meta.Set("WARC-Concurrent-To", response)
+	meta.Set("Content-Type", warcRunType)
+	meta.Set(warcRunField, run.ID)
+	if err := lib.WriteWARCRecord(w, meta); err != nil {
+		return err
+	}
+	for _, r := range c.Resources {
+		if _, err := writeWARCExchange(w, run.ID, date, r.URL, r.Status, r.Header,
+			r.ContentType, r.Digest, blob); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CopyWARC copies the records of a WARC file to a writer, which may compress
+// them. The warcinfo records are skipped unless info is set, so that the
+// pages of an export make a single file.
+func CopyWARC(w *lib.WARCWriter, rd io.Reader, info bool) error {
+	wr := lib.NewWARCReader(rd)
+	for {
+		r, err := wr.ReadRecord()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if !info && r.Get("WARC-Type") == lib.WARCInfo {
+			continue
+		}
+		if err := w.WriteRecord(r); err != nil {
+			return err
+		}
+	}
+}
+
+// writeWARCExchange writes the request and response records of a resource,
+// and returns the ID of the response record
+func writeWARCExchange(w io.Writer, runID string, date time.Time, URL, status string, header []byte,
+	contentType string, digest []byte, blob func([]byte) ([]byte, error)) (string, error) {
+	body, err := blob(digest)
+	if err != nil {
+		return "", err
+	}
+	u, err := url.Parse(URL)
+	if err != nil {
+		return "", err
+	}
+
+	// the captures made before the status and header were kept only have
+	// their content type
+	if status == "" {
+		status = warcDefaultStatus
+		header = []byte("Content-Type: " + contentType + "\r\n")
+	}
+	var msg bytes.Buffer
+	msg.WriteString(status + "\r\n")
+	msg.Write(header)
+	msg.WriteString("\r\n")
+	msg.Write(body)
+	response := lib.NewWARCRecord(lib.WARCResponse, date, msg.Bytes())
+	response.Set("WARC-Target-URI", URL)
+	response.Set("WARC-Payload-Digest", lib.WARCDigest(body))
+	response.Set("Content-Type", warcResponseType)
+	response.Set(warcRunField, runID)
+
+	req := "GET " + u.RequestURI() + " HTTP/1.1\r\nHost: " + u.Host + "\r\n\r\n"
+	request := lib.NewWARCRecord(lib.WARCRequest, date, []byte(req))
+	request.Set("WARC-Target-URI", URL)
+	request.Set("WARC-Concurrent-To", response.Get("WARC-Record-ID"))
+	request.Set("Content-Type", warcRequestType)
+	request.Set(warcRunField, runID)
+
+	if err := lib.WriteWARCRecord(w, request); err != nil {
+		return "", err
+	}
+	if err := lib.WriteWARCRecord(w, response); err != nil {
+		return "", err
+	}
+	return response.Get("WARC-Record-ID"), nil
+}
+
+// ReadWARC reads the archived runs of a WARC file written by WriteWARC, in
+// their order in the file, compressed with gzip or not. The records written
+// by other tools are skipped.
+func ReadWARC(rd io.Reader) ([]*WARCCapture, error) {
+	var captures []*WARCCapture
+	byRun := make(map[string]*WARCCapture)
+	capture := func(id string) *WARCCapture {
+		c, ok := byRun[id]
+		if !ok {
+			c = &WARCCapture{Contents: make(map[string][]byte)}
+			byRun[id] = c
+			captures = append(captures, c)
+		}
+		return c
+	}
+
+	wr := lib.NewWARCReader(rd)
+	for {
+		r, err := wr.ReadRecord()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		id := r.Get(warcRunField)
+		if id == "" {
+			continue
+		}
+		switch r.Get("WARC-Type") {
+		case lib.WARCResponse:
+			// the payload follows the status line and header of the
+			// HTTP response
+			i := bytes.Index(r.Content, []byte("\r\n\r\n"))
+			if i < 0 {
+				return nil, errors.New("invalid HTTP response of " + r.Get("WARC-Target-URI"))
+			}
+			capture(id).Contents[r.Get("WARC-Target-URI")] = r.Content[i+4:]
+		case lib.WARCMetadata:
+			if r.Get("Content-Type") != warcRunType {
+				continue
+			}
+			_, msg, err := network.Unmarshal(r.Content, cothority.Suite)
+			if err != nil {
+				return nil, err
+			}
+			run, ok := msg.(*Run)
+			if !ok || run.ID != id {
+				return nil, errors.New("invalid run in metadata record of " + id)
+			}
+			capture(id).Run = run
+		}
+	}
+
+	for _, c := range captures {
+		if c.Run == nil {
+			return nil, errors.New("WARC file with contents of an unknown run")
+		}
+	}
+	return captures, nil
+}
+
+// VerifyCapture checks an archived run read from a WARC file: the signatures
+// of the conodes of the roster, the content of the resource against the
+// SHA-256 hash they agreed on, and the contents of the subresources against
+// the digests of the capture
+func VerifyCapture(r *onet.Roster, c *WARCCapture) error {
+	run := c.Run
+	if err := VerifyRun(r, run); err != nil {
+		return err
+	}
+	if run.Capture == nil {
+		return errors.New("run " + run.ID + " has not been archived")
+	}
+	g := AgreedGroup(run, len(r.List)-1, lib.SHA256)
+	if g == nil || !bytes.Equal(g.Digest, run.Capture.Digest) {
+		return errors.New("the conodes didn't agree on the captured content of " + run.URL)
+	}
+	if err := verifyContent(c.Contents, run.URL, run.Capture.Digest); err != nil {
+		return err
+	}
+	for _, sr := range run.Capture.Resources {
+		if err := verifyContent(c.Contents, sr.URL, sr.Digest); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// verifyContent checks that the content of the URL has the SHA-256 digest
+func verifyContent(contents map[string][]byte, URL string, digest []byte) error {
+	data, ok := contents[URL]
+	if !ok {
+		return errors.New("missing content of " + URL)
+	}
+	h := sha256.Sum256(data)
+	if !bytes.Equal(h[:], digest) {
+		return errors.New("the content of " + URL + " doesn't match its digest")
+	}
+	return nil
+}